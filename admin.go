@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/panudetjt/assessment/admin"
+	"github.com/panudetjt/assessment/apikey"
+	"github.com/panudetjt/assessment/config"
+	"github.com/panudetjt/assessment/expense"
+	m "github.com/panudetjt/assessment/middleware"
+	"github.com/panudetjt/assessment/migration"
+)
+
+// errPrinted stops a command after --print-config without reporting an error.
+var errPrinted = errors.New("config printed")
+
+// loadConfig parses args for the named command. register adds the command's
+// own flags before the shared config flags are parsed.
+func loadConfig(name string, args []string, register func(fs *flag.FlagSet)) (*config.Config, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	if register != nil {
+		register(fs)
+	}
+	cfg, opts, err := config.Load(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	if opts.PrintConfig {
+		if err := config.Write(os.Stdout, cfg.Redacted()); err != nil {
+			return nil, err
+		}
+		return nil, errPrinted
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func openDB(cfg *config.Config) (*sql.DB, error) {
+	db, err := expense.InitDB("postgres", cfg.Database)
+	if err != nil {
+		return nil, err
+	}
+	if err := expense.Ping(context.Background(), db, cfg.Database.ConnectTimeout); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func migrate(args []string) error {
+	cfg, err := loadConfig("migrate", args, nil)
+	if err != nil {
+		return err
+	}
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	applied, err := migration.Up(context.Background(), db)
+	for _, m := range applied {
+		fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Println("database is up to date")
+	}
+	return nil
+}
+
+func seed(args []string) error {
+	var count, ledgerID int
+	var seed int64
+	cfg, err := loadConfig("seed", args, func(fs *flag.FlagSet) {
+		fs.IntVar(&count, "count", 100, "number of expenses to insert")
+		fs.IntVar(&ledgerID, "ledger", 0, "ledger to insert them into, so its members see them (default: outside ledgers, admin keys only)")
+		fs.Int64Var(&seed, "seed", 0, "random seed for repeatable data (default: current time)")
+	})
+	if err != nil {
+		return err
+	}
+	if count <= 0 {
+		return errors.New("--count must be positive")
+	}
+	if ledgerID < 0 {
+		return errors.New("--ledger must be a ledger id")
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	var ledger *int
+	if ledgerID > 0 {
+		ledger = &ledgerID
+	}
+
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := admin.Seed(context.Background(), db, count, rand.New(rand.NewSource(seed)), ledger); err != nil {
+		return err
+	}
+	fmt.Printf("inserted %d expenses (seed %d)\n", count, seed)
+	return nil
+}
+
+func export(args []string) error {
+	var out string
+	cfg, err := loadConfig("export", args, func(fs *flag.FlagSet) {
+		fs.StringVar(&out, "out", "-", "file to write, - for stdout")
+	})
+	if err != nil {
+		return err
+	}
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var w io.Writer = os.Stdout
+	var f *os.File
+	if out != "-" {
+		if f, err = os.Create(out); err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	n, err := admin.Export(context.Background(), db, w)
+	if err != nil {
+		return err
+	}
+	// Closing flushes the file, so a full disk may only show here.
+	if f != nil {
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("can't write %s: %w", out, err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "exported %d expenses\n", n)
+	return nil
+}
+
+func importExpenses(args []string) error {
+	var in string
+	var keepIDs bool
+	cfg, err := loadConfig("import", args, func(fs *flag.FlagSet) {
+		fs.StringVar(&in, "in", "-", "file to read, - for stdin")
+		fs.BoolVar(&keepIDs, "keep-ids", false, "keep the exported ids, e.g. to restore a backup into an empty table")
+	})
+	if err != nil {
+		return err
+	}
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var r io.Reader = os.Stdin
+	if in != "-" {
+		f, err := os.Open(in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	n, err := admin.Import(context.Background(), db, r, keepIDs)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported %d expenses\n", n)
+	return nil
+}
+
+func createToken(args []string) error {
+	var name, scopes string
+	cfg, err := loadConfig("create-token", args, func(fs *flag.FlagSet) {
+		fs.StringVar(&name, "name", "", "what the key is for, e.g. the client using it")
+		fs.StringVar(&scopes, "scopes", strings.Join(m.Scopes, ","), "comma separated scopes to grant")
+	})
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return errors.New("--name is required")
+	}
+
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	k, key, err := apikey.Create(context.Background(), db, name, strings.Split(scopes, ","))
+	if err != nil {
+		return err
+	}
+	fmt.Println(key)
+	fmt.Fprintf(os.Stderr, "created api key %d (%s); it is shown only once\n", k.ID, k.Prefix)
+	return nil
+}
+
+func checkConfig(args []string) error {
+	var skipDB bool
+	cfg, err := loadConfig("check-config", args, func(fs *flag.FlagSet) {
+		fs.BoolVar(&skipDB, "skip-db", false, "don't try to connect to the database")
+	})
+	if err != nil {
+		return err
+	}
+	if !skipDB {
+		db, err := openDB(cfg)
+		if err != nil {
+			return err
+		}
+		db.Close()
+	}
+	fmt.Println("config ok")
+	return nil
+}
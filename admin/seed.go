@@ -0,0 +1,135 @@
+// Package admin implements maintenance tasks run from the server binary's
+// subcommands. They talk to the database directly instead of through HTTP.
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/date"
+	"github.com/panudetjt/assessment/expense"
+)
+
+type fakeKind struct {
+	tags   []string
+	titles []string
+	notes  []string
+	min    float64
+	max    float64
+}
+
+var fakeKinds = []fakeKind{
+	{
+		tags:   []string{"food"},
+		titles: []string{"pad thai", "khao man gai", "som tam", "boat noodles", "green curry", "mango sticky rice"},
+		notes:  []string{"lunch with team", "street food", "dinner at night market", ""},
+		min:    40, max: 350,
+	},
+	{
+		tags:   []string{"food", "beverage"},
+		titles: []string{"strawberry smoothie", "thai milk tea", "iced americano", "coconut juice", "bubble tea"},
+		notes:  []string{"night market promotion discount 10 bath", "buy 1 get 1", ""},
+		min:    25, max: 180,
+	},
+	{
+		tags:   []string{"transport"},
+		titles: []string{"BTS ticket", "MRT ticket", "taxi", "motorbike taxi", "grab ride", "fuel"},
+		notes:  []string{"to office", "airport", "rainy day", ""},
+		min:    16, max: 900,
+	},
+	{
+		tags:   []string{"gadget", "shopping"},
+		titles: []string{"USB-C cable", "wireless earbuds", "phone case", "mechanical keyboard", "iPhone 14 Pro Max 1TB"},
+		notes:  []string{"birthday gift from my love", "replacement", "online sale", ""},
+		min:    150, max: 66900,
+	},
+	{
+		tags:   []string{"bills"},
+		titles: []string{"electricity bill", "water bill", "internet", "mobile plan", "condo fee"},
+		notes:  []string{"monthly", ""},
+		min:    200, max: 4500,
+	},
+	{
+		tags:   []string{"entertainment"},
+		titles: []string{"movie ticket", "concert", "netflix", "spotify", "bowling"},
+		notes:  []string{"weekend", "with friends", ""},
+		min:    99, max: 3500,
+	},
+}
+
+// Fake returns a plausible expense drawn from rng.
+func Fake(rng *rand.Rand) expense.Expense {
+	k := fakeKinds[rng.Intn(len(fakeKinds))]
+	// Skew toward cheaper purchases, as real spending is.
+	amount := k.min + (k.max-k.min)*math.Pow(rng.Float64(), 3)
+	return expense.Expense{
+		Title:  k.titles[rng.Intn(len(k.titles))],
+		Amount: math.Round(amount),
+		Note:   k.notes[rng.Intn(len(k.notes))],
+		Tags:   append([]string{}, k.tags...),
+	}
+}
+
+// Seed inserts n fake expenses into ledgerID, nil for outside ledgers, in one
+// transaction.
+func Seed(ctx context.Context, db *sql.DB, n int, rng *rand.Rand, ledgerID *int) error {
+	expenses := make([]expense.Expense, n)
+	for i := range expenses {
+		expenses[i] = Fake(rng)
+		expenses[i].LedgerID = ledgerID
+	}
+	return insert(ctx, db, expenses, false)
+}
+
+// insert stores expenses in one transaction, in the default currency, today
+// and as drafts unless they say otherwise. With keepIDs the ids are kept and
+// the id sequence is moved past the largest one.
+func insert(ctx context.Context, db *sql.DB, expenses []expense.Expense, keepIDs bool) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("can't begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := "INSERT INTO expenses (" + transferColumns + ") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)"
+	if keepIDs {
+		query = "INSERT INTO expenses (" + transferColumns + ", id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)"
+	}
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("can't prepare insert expense statment: %w", err)
+	}
+	defer stmt.Close()
+
+	today := date.Today()
+	for i, e := range expenses {
+		if e.Currency == "" {
+			e.Currency = expense.DefaultCurrency
+		}
+		if e.SpentOn.IsZero() {
+			e.SpentOn = today
+		}
+		if e.Status == "" {
+			e.Status = expense.StatusDraft
+		}
+		args := []any{e.Title, e.Amount, e.Note, pq.Array(e.Tags), e.LedgerID, e.Split, e.Currency, e.SpentOn,
+			e.MerchantID, e.PaymentMethodID, e.CategoryID, e.Status}
+		if keepIDs {
+			args = append(args, e.ID)
+		}
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			return fmt.Errorf("can't insert expense %d: %w", i+1, err)
+		}
+	}
+
+	if keepIDs {
+		if _, err := tx.ExecContext(ctx, "SELECT setval(pg_get_serial_sequence('expenses', 'id'), COALESCE(max(id), 1), max(id) IS NOT NULL) FROM expenses"); err != nil {
+			return fmt.Errorf("can't reset expense id sequence: %w", err)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,67 @@
+package admin
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFake(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 500; i++ {
+		e := Fake(rng)
+
+		assert.NotEmpty(t, e.Title)
+		assert.Greater(t, e.Amount, 0.0)
+		assert.NotEmpty(t, e.Tags)
+	}
+	assert.Equal(t, Fake(rand.New(rand.NewSource(7))), Fake(rand.New(rand.NewSource(7))))
+}
+
+func TestSeed(t *testing.T) {
+	t.Run("should insert expenses in one transaction", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		prep := mock.ExpectPrepare("INSERT INTO expenses \\(title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id, status\\) VALUES")
+		for i := 0; i < 3; i++ {
+			prep.ExpectExec().WillReturnResult(sqlmock.NewResult(int64(i+1), 1))
+		}
+		mock.ExpectCommit()
+
+		err := Seed(context.Background(), db, 3, rand.New(rand.NewSource(1)), nil)
+
+		assert.NoError(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should insert into the ledger given", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		ledgerID := 3
+		mock.ExpectBegin()
+		mock.ExpectPrepare("INSERT INTO expenses").ExpectExec().
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 3, nil, "THB", sqlmock.AnyArg(), nil, nil, nil, "draft").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		err := Seed(context.Background(), db, 1, rand.New(rand.NewSource(1)), &ledgerID)
+
+		assert.NoError(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should roll back when an insert fails", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectPrepare("INSERT INTO expenses").ExpectExec().WillReturnError(sqlmock.ErrCancelled)
+		mock.ExpectRollback()
+
+		err := Seed(context.Background(), db, 3, rand.New(rand.NewSource(1)), nil)
+
+		assert.ErrorContains(t, err, "can't insert expense 1")
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
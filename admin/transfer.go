@@ -0,0 +1,87 @@
+package admin
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/expense"
+)
+
+// transferColumns are the expense columns Export writes and insert stores,
+// all but id in the order of the insert's arguments.
+const transferColumns = "title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id, status"
+
+// Export writes every expense, but merged duplicates, as a JSON array ordered by id, streaming rows so
+// large tables don't have to fit in memory. It returns how many were written.
+func Export(ctx context.Context, db *sql.DB, w io.Writer) (int, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, "+transferColumns+" FROM expenses WHERE deleted_at IS NULL ORDER BY id")
+	if err != nil {
+		return 0, fmt.Errorf("can't query expenses: %w", err)
+	}
+	defer rows.Close()
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	n := 0
+	bw.WriteString("[\n")
+	for rows.Next() {
+		var e expense.Expense
+		if err := rows.Scan(&e.ID, &e.Title, &e.Amount, &e.Note, pq.Array(&e.Tags), &e.LedgerID, &e.Split, &e.Currency, &e.SpentOn,
+			&e.MerchantID, &e.PaymentMethodID, &e.CategoryID, &e.Status); err != nil {
+			return n, fmt.Errorf("can't scan expenses: %w", err)
+		}
+		if n > 0 {
+			bw.WriteString(",")
+		}
+		if err := enc.Encode(e); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, fmt.Errorf("can't iterate expenses: %w", err)
+	}
+	bw.WriteString("]\n")
+	return n, bw.Flush()
+}
+
+// Import reads a JSON array written by Export and inserts it in one
+// transaction, so a bad file leaves the table untouched. The expenses go
+// through the rules of their ledger first.
+//
+// Import restores data rather than entering it: it records no history, so
+// sends no webhooks or stream events, and neither checks closed periods nor
+// the workflow status, which it keeps as exported.
+func Import(ctx context.Context, db *sql.DB, r io.Reader, keepIDs bool) (int, error) {
+	var expenses []expense.Expense
+	if err := json.NewDecoder(r).Decode(&expenses); err != nil {
+		return 0, fmt.Errorf("parse import file: %w", err)
+	}
+	// Ledger ids start at 1, so 0 keys the rules outside ledgers.
+	rules := map[int]expense.Rules{}
+	for i := range expenses {
+		e := &expenses[i]
+		key := 0
+		if e.LedgerID != nil {
+			key = *e.LedgerID
+		}
+		rs, ok := rules[key]
+		if !ok {
+			var err error
+			if rs, err = expense.LoadRules(ctx, db, e.LedgerID); err != nil {
+				return 0, err
+			}
+			rules[key] = rs
+		}
+		rs.Apply(e)
+	}
+	if err := insert(ctx, db, expenses, keepIDs); err != nil {
+		return 0, err
+	}
+	return len(expenses), nil
+}
@@ -0,0 +1,140 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/date"
+	"github.com/panudetjt/assessment/expense"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExport(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	mock.ExpectQuery("SELECT id, title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id, status " +
+		"FROM expenses WHERE deleted_at IS NULL ORDER BY id").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "ledger_id", "split", "currency", "spent_on",
+			"merchant_id", "payment_method_id", "category_id", "status"}).
+			AddRow(1, "smoothie", 79, "", pq.Array([]string{"food"}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft").
+			AddRow(2, "taxi", 120, "airport", pq.Array([]string{"transport"}), 3, `{"method": "equal", "paid_by": "alice", "shares": [{"participant": "alice", "amount": 60}, {"participant": "bob", "amount": 60}]}`,
+				"JPY", "2023-01-03", 4, 5, 6, "approved"))
+	var buf bytes.Buffer
+
+	n, err := Export(context.Background(), db, &buf)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	var got []expense.Expense
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+	day := func(s string) date.Date { d, _ := date.Parse(s); return d }
+	id := func(v int) *int { return &v }
+	assert.Equal(t, []expense.Expense{
+		{ID: 1, Title: "smoothie", Amount: 79, Tags: []string{"food"}, Currency: "THB", SpentOn: day("2023-01-02"), Status: "draft"},
+		{ID: 2, Title: "taxi", Amount: 120, Note: "airport", Tags: []string{"transport"}, LedgerID: id(3),
+			Split:    &expense.Split{PaidBy: "alice", Method: "equal", Shares: []expense.Share{{Participant: "alice", Amount: 60}, {Participant: "bob", Amount: 60}}},
+			Currency: "JPY", SpentOn: day("2023-01-03"), MerchantID: id(4), PaymentMethodID: id(5), CategoryID: id(6), Status: "approved"},
+	}, got)
+}
+
+var ruleColumns = []string{"id", "ledger_id", "name", "position", "title_pattern", "note_pattern", "min_amount", "max_amount",
+	"merchant_id", "add_tags", "category_id", "set_note", "created_at"}
+
+func expectRules(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("FROM rules").WillReturnRows(sqlmock.NewRows(ruleColumns))
+}
+
+func TestImport(t *testing.T) {
+	file := `[{"id": 5, "title": "smoothie", "amount": 79, "note": "", "tags": ["food"]}]`
+
+	t.Run("should keep ids and move sequence", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		expectRules(mock)
+		mock.ExpectBegin()
+		mock.ExpectPrepare("INSERT INTO expenses \\(title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id, status, id\\)").
+			ExpectExec().
+			WithArgs("smoothie", 79.0, "", pq.Array([]string{"food"}), nil, nil, "THB", sqlmock.AnyArg(), nil, nil, nil, "draft", 5).
+			WillReturnResult(sqlmock.NewResult(5, 1))
+		mock.ExpectExec("SELECT setval\\(pg_get_serial_sequence\\('expenses', 'id'\\), COALESCE\\(max\\(id\\), 1\\), max\\(id\\) IS NOT NULL\\)").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		n, err := Import(context.Background(), db, strings.NewReader(file), true)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should assign new ids by default", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		expectRules(mock)
+		mock.ExpectBegin()
+		mock.ExpectPrepare("INSERT INTO expenses \\(title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id, status\\) VALUES \\(\\$1, (.+), \\$12\\)").
+			ExpectExec().
+			WithArgs("smoothie", 79.0, "", pq.Array([]string{"food"}), nil, nil, "THB", sqlmock.AnyArg(), nil, nil, nil, "draft").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		_, err := Import(context.Background(), db, strings.NewReader(file), false)
+
+		assert.NoError(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should apply the rules outside ledgers", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery("FROM rules WHERE ledger_id IS NOT DISTINCT FROM \\$1").WithArgs(nil).
+			WillReturnRows(sqlmock.NewRows(ruleColumns).
+				AddRow(1, nil, "Drinks", 0, "smoothie", "", nil, nil, nil, pq.Array([]string{"drink"}), 3, "", time.Now()))
+		mock.ExpectBegin()
+		mock.ExpectPrepare("INSERT INTO expenses").
+			ExpectExec().
+			WithArgs("smoothie", 79.0, "", pq.Array([]string{"food", "drink"}), nil, nil, "THB", sqlmock.AnyArg(), nil, nil, 3, "draft").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		_, err := Import(context.Background(), db, strings.NewReader(file), false)
+
+		assert.NoError(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should keep every column and apply the rules of each ledger", func(t *testing.T) {
+		file := `[{"id": 5, "title": "sushi", "amount": 3000, "note": "", "tags": [], "ledger_id": 3, "currency": "JPY",
+			"spent_on": "2023-01-03", "merchant_id": 4, "payment_method_id": 5, "status": "approved"},
+			{"id": 6, "title": "ramen", "amount": 900, "note": "", "tags": [], "ledger_id": 3}]`
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery("FROM rules WHERE ledger_id IS NOT DISTINCT FROM \\$1").WithArgs(3).
+			WillReturnRows(sqlmock.NewRows(ruleColumns).
+				AddRow(1, 3, "Japan", 0, "", "", nil, nil, nil, pq.Array([]string{"japan"}), nil, "", time.Now()))
+		mock.ExpectBegin()
+		prep := mock.ExpectPrepare("INSERT INTO expenses")
+		prep.ExpectExec().
+			WithArgs("sushi", 3000.0, "", pq.Array([]string{"japan"}), 3, nil, "JPY", "2023-01-03", 4, 5, nil, "approved").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		prep.ExpectExec().
+			WithArgs("ramen", 900.0, "", pq.Array([]string{"japan"}), 3, nil, "THB", sqlmock.AnyArg(), nil, nil, nil, "draft").
+			WillReturnResult(sqlmock.NewResult(2, 1))
+		mock.ExpectCommit()
+
+		_, err := Import(context.Background(), db, strings.NewReader(file), false)
+
+		assert.NoError(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should reject malformed file", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+
+		_, err := Import(context.Background(), db, strings.NewReader("{"), false)
+
+		assert.ErrorContains(t, err, "parse import file")
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/labstack/gommon/log"
+	"github.com/panudetjt/assessment/config"
+	"github.com/panudetjt/assessment/expense"
+	"github.com/panudetjt/assessment/openapi"
+	"github.com/panudetjt/assessment/problem"
+)
+
+const Version = "1.0.0"
+
+var logLevels = map[string]log.Lvl{
+	"debug": log.DEBUG,
+	"info":  log.INFO,
+	"warn":  log.WARN,
+	"error": log.ERROR,
+	"off":   log.OFF,
+}
+
+// New builds the HTTP server with its middleware and routes.
+func New(cfg *config.Config, eh *expense.Handler) *echo.Echo {
+	e := echo.New()
+	e.HTTPErrorHandler = problem.ErrorHandler
+	e.Logger.SetLevel(logLevels[cfg.LogLevel])
+	e.Server.ReadTimeout = cfg.Server.ReadTimeout
+	e.Server.WriteTimeout = cfg.Server.WriteTimeout
+	e.Server.ConnContext = expense.ConnContext
+	// Without a trusted proxy X-Forwarded-For is the client's to forge, and
+	// with it a fresh IP rate limit bucket per request.
+	e.IPExtractor = echo.ExtractIPDirect()
+	if cfg.RateLimit.TrustProxy {
+		e.IPExtractor = echo.ExtractIPFromXFFHeader()
+	}
+
+	e.Use(middleware.RequestID())
+	e.Use(middleware.Logger())
+	e.Use(middleware.Recover())
+	if len(cfg.CORS.AllowOrigins) > 0 {
+		e.Use(middleware.CORSWithConfig(middleware.CORSConfig{AllowOrigins: cfg.CORS.AllowOrigins}))
+	}
+
+	Routes(e, openapi.New("Expenses API", Version), cfg, eh)
+	return e
+}
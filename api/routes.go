@@ -0,0 +1,775 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/panudetjt/assessment/apikey"
+	"github.com/panudetjt/assessment/config"
+	"github.com/panudetjt/assessment/exchange"
+	"github.com/panudetjt/assessment/expense"
+	"github.com/panudetjt/assessment/health"
+	"github.com/panudetjt/assessment/ledger"
+	m "github.com/panudetjt/assessment/middleware"
+	"github.com/panudetjt/assessment/openapi"
+	"github.com/panudetjt/assessment/webhook"
+)
+
+// listFilters are the query parameters shared by the list and summary routes.
+var listFilters = []openapi.Param{
+	{Name: "tag", Type: "string", Description: "only expenses with this tag, repeat to require several"},
+	{Name: "min_amount", Type: "number", Description: "only expenses of at least this amount"},
+	{Name: "max_amount", Type: "number", Description: "only expenses of at most this amount"},
+	{Name: "title", Type: "string", Description: "only expenses whose title contains this text, case-insensitive"},
+	{Name: "ledger_id", Type: "integer", Description: "only expenses of this ledger"},
+	{Name: "merchant_id", Type: "integer", Description: "only expenses at this merchant"},
+	{Name: "payment_method_id", Type: "integer", Description: "only expenses paid with this payment method"},
+	{Name: "category_id", Type: "integer", Description: "only expenses in this category or its descendants"},
+	{Name: "status", Type: "string", Description: "only expenses in this reimbursement status"},
+}
+
+// ledgerID is the query parameter narrowing merchants, payment methods,
+// categories and rules to a ledger.
+var ledgerID = openapi.Param{Name: "ledger_id", Type: "integer", Description: "only those of this ledger"}
+
+// overrideReason is the query parameter with which admins change expenses of
+// closed accounting periods.
+var overrideReason = openapi.Param{Name: "override_reason", Type: "string", Description: "admin keys only, why an expense of a closed accounting period must change, kept for audit"}
+
+// convertTo is the query parameter converting the list and summary amounts.
+var convertTo = openapi.Param{Name: "convert_to", Type: "string", Description: "also convert amounts into this currency at the rate of the day each expense was spent"}
+
+// Routes registers every API route through spec so the OpenAPI document always
+// matches what the server actually serves.
+func Routes(e *echo.Echo, spec *openapi.Spec, cfg *config.Config, eh *expense.Handler) {
+	auth := m.Authorization(m.AnyOf(m.StaticKeys(cfg.Auth.Keys), apikey.Validator(eh.DB)))
+	limit, limitIP := rateLimits(cfg.RateLimit, eh)
+	// Every request counts against its IP, even one failing authentication.
+	e.Use(limitIP)
+	// scope authorizes the caller for scope, then limits it by its key.
+	scope := func(scope string) []echo.MiddlewareFunc {
+		return []echo.MiddlewareFunc{auth, m.RequireScope(scope), limit}
+	}
+	kh := &apikey.Handler{DB: eh.DB}
+	lh := &ledger.Handler{DB: eh.DB}
+	wh := &webhook.Handler{DB: eh.DB}
+	xh := &exchange.Handler{DB: eh.DB}
+
+	spec.Register(e, openapi.Operation{
+		Method:      http.MethodGet,
+		Path:        "/health",
+		Summary:     "Check that the server is up",
+		Tag:         "health",
+		Response:    "ok",
+		ContentType: echo.MIMETextPlain,
+	}, health.HealthHandler)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodGet,
+		Path:     "/openapi.json",
+		Summary:  "OpenAPI document of this API",
+		Tag:      "docs",
+		Response: map[string]any{},
+	}, spec.Handler)
+	spec.Register(e, openapi.Operation{
+		Method:      http.MethodGet,
+		Path:        "/docs",
+		Summary:     "Interactive API documentation",
+		Tag:         "docs",
+		Response:    "",
+		ContentType: echo.MIMETextHTML,
+	}, openapi.DocsHandler)
+
+	spec.Register(e, openapi.Operation{
+		Method:  http.MethodGet,
+		Path:    "/expenses",
+		Summary: "List expenses ordered by id",
+		Tag:     "expenses",
+		Scope:   m.ScopeExpensesRead,
+		Query: append([]openapi.Param{
+			{Name: "limit", Type: "integer", Description: "maximum number of expenses to return, 1 to 1000"},
+			{Name: "offset", Type: "integer", Description: "number of expenses to skip"},
+			convertTo,
+		}, listFilters...),
+		Response: []expense.Expense{},
+		Errors:   []int{http.StatusBadRequest, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, eh.GetAllExpenseHandler, scope(m.ScopeExpensesRead)...)
+	spec.Register(e, openapi.Operation{
+		Method:  http.MethodGet,
+		Path:    "/expenses/summary",
+		Summary: "Total the filtered expenses, overall and per tag",
+		Tag:     "expenses",
+		Scope:   m.ScopeReportsRead,
+		Query: append([]openapi.Param{
+			convertTo,
+			{Name: "group_by", Type: "string", Description: "also total per merchant, payment_method or category, rolled up the tree; repeatable"},
+		}, listFilters...),
+		Response: expense.Summary{},
+		Errors:   []int{http.StatusBadRequest, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, eh.SummaryHandler, scope(m.ScopeReportsRead)...)
+	spec.Register(e, openapi.Operation{
+		Method:  http.MethodGet,
+		Path:    "/expenses/search",
+		Summary: "Search expense titles and notes, best matches first",
+		Tag:     "expenses",
+		Scope:   m.ScopeExpensesRead,
+		Query: append([]openapi.Param{
+			{Name: "q", Type: "string", Description: "words to find, matching as prefixes, inside words or with typos"},
+			{Name: "limit", Type: "integer", Description: "maximum number of expenses to return, 1 to 1000"},
+			{Name: "offset", Type: "integer", Description: "number of expenses to skip"},
+		}, listFilters...),
+		Response: []expense.SearchResult{},
+		Errors:   []int{http.StatusBadRequest, http.StatusTooManyRequests},
+	}, eh.SearchHandler, scope(m.ScopeExpensesRead)...)
+	spec.Register(e, openapi.Operation{
+		Method:  http.MethodGet,
+		Path:    "/expenses/stream",
+		Summary: "Stream changes to the caller's expenses as Server-Sent Events",
+		Tag:     "expenses",
+		Scope:   m.ScopeExpensesRead,
+		Query: []openapi.Param{
+			{Name: "last_event_id", Type: "integer", Description: "replay events after this id, like the Last-Event-ID header"},
+		},
+		Response:    "",
+		ContentType: "text/event-stream",
+		Errors:      []int{http.StatusBadRequest, http.StatusTooManyRequests},
+	}, eh.StreamHandler, scope(m.ScopeExpensesRead)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodGet,
+		Path:     "/expenses/duplicates",
+		Summary:  "List the groups of expenses that may have been entered twice",
+		Tag:      "expenses",
+		Scope:    m.ScopeExpensesRead,
+		Query:    []openapi.Param{ledgerID},
+		Response: []expense.DuplicateGroup{},
+		Errors:   []int{http.StatusBadRequest, http.StatusTooManyRequests},
+	}, eh.DuplicatesHandler, scope(m.ScopeExpensesRead)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPost,
+		Path:     "/expenses/merge",
+		Summary:  "Merge the tags, note and attachments of a duplicate into another expense and delete it",
+		Tag:      "expenses",
+		Scope:    m.ScopeExpensesWrite,
+		Query:    []openapi.Param{overrideReason},
+		Request:  expense.ExpenseMerge{},
+		Response: expense.Expense{},
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, eh.MergeExpensesHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:  http.MethodPost,
+		Path:    "/expenses",
+		Summary: "Create an expense",
+		Tag:     "expenses",
+		Scope:   m.ScopeExpensesWrite,
+		Query: []openapi.Param{
+			{Name: "allow_duplicate", Type: "boolean", Description: "create the expense even if it may duplicate another"},
+			overrideReason,
+		},
+		Request:  expense.Expense{},
+		Response: expense.Expense{},
+		Status:   http.StatusCreated,
+		Errors:   []int{http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, eh.CreateExpensesHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodGet,
+		Path:     "/expenses/:id",
+		Summary:  "Get an expense by id",
+		Tag:      "expenses",
+		Scope:    m.ScopeExpensesRead,
+		Response: expense.Expense{},
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests},
+	}, eh.GetExpenseByIdHandler, scope(m.ScopeExpensesRead)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPut,
+		Path:     "/expenses/:id",
+		Summary:  "Update an expense",
+		Tag:      "expenses",
+		Scope:    m.ScopeExpensesWrite,
+		Query:    []openapi.Param{overrideReason},
+		Request:  expense.Expense{},
+		Response: expense.Expense{},
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, eh.UpdateExpensesHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:  http.MethodDelete,
+		Path:    "/expenses/:id",
+		Summary: "Delete an expense",
+		Tag:     "expenses",
+		Scope:   m.ScopeExpensesWrite,
+		Query:   []openapi.Param{overrideReason},
+		Status:  http.StatusNoContent,
+		Errors:  []int{http.StatusNotFound, http.StatusConflict, http.StatusTooManyRequests},
+	}, eh.DeleteExpenseHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodGet,
+		Path:     "/expenses/:id/history",
+		Summary:  "List the versions of an expense, oldest first",
+		Tag:      "expenses",
+		Scope:    m.ScopeExpensesRead,
+		Response: []expense.Event{},
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests},
+	}, eh.HistoryHandler, scope(m.ScopeExpensesRead)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPost,
+		Path:     "/expenses/:id/revert",
+		Summary:  "Restore an expense to an earlier version",
+		Tag:      "expenses",
+		Scope:    m.ScopeExpensesWrite,
+		Query:    []openapi.Param{overrideReason},
+		Request:  expense.RevertRequest{},
+		Response: expense.Expense{},
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, eh.RevertHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPost,
+		Path:     "/expenses/:id/submit",
+		Summary:  "Submit a draft or rejected expense for approval",
+		Tag:      "approvals",
+		Scope:    m.ScopeExpensesWrite,
+		Request:  expense.TransitionRequest{},
+		Response: expense.Expense{},
+		Errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, eh.SubmitExpenseHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPost,
+		Path:     "/expenses/:id/approve",
+		Summary:  "Approve a submitted expense, for approvers",
+		Tag:      "approvals",
+		Scope:    m.ScopeExpensesWrite,
+		Request:  expense.TransitionRequest{},
+		Response: expense.Expense{},
+		Errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, eh.ApproveExpenseHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPost,
+		Path:     "/expenses/:id/reject",
+		Summary:  "Reject a submitted expense, for approvers",
+		Tag:      "approvals",
+		Scope:    m.ScopeExpensesWrite,
+		Request:  expense.TransitionRequest{},
+		Response: expense.Expense{},
+		Errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, eh.RejectExpenseHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPost,
+		Path:     "/expenses/:id/reimburse",
+		Summary:  "Mark an approved expense reimbursed, for approvers",
+		Tag:      "approvals",
+		Scope:    m.ScopeExpensesWrite,
+		Request:  expense.TransitionRequest{},
+		Response: expense.Expense{},
+		Errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, eh.ReimburseExpenseHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodGet,
+		Path:     "/expenses/:id/transitions",
+		Summary:  "List the status changes of an expense, oldest first",
+		Tag:      "approvals",
+		Scope:    m.ScopeExpensesRead,
+		Response: []expense.Transition{},
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests},
+	}, eh.TransitionsHandler, scope(m.ScopeExpensesRead)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPost,
+		Path:     "/expenses/:id/attachments",
+		Summary:  "Attach a file such as a receipt, sent as the multipart field \"file\"",
+		Tag:      "attachments",
+		Scope:    m.ScopeExpensesWrite,
+		Response: expense.Attachment{},
+		Status:   http.StatusCreated,
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, eh.UploadAttachmentHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodGet,
+		Path:     "/expenses/:id/attachments",
+		Summary:  "List an expense's attachments",
+		Tag:      "attachments",
+		Scope:    m.ScopeExpensesRead,
+		Response: []expense.Attachment{},
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests},
+	}, eh.ListAttachmentsHandler, scope(m.ScopeExpensesRead)...)
+	spec.Register(e, openapi.Operation{
+		Method:      http.MethodGet,
+		Path:        "/expenses/:id/attachments/:attachment_id",
+		Summary:     "Download an attachment, with Range support",
+		Tag:         "attachments",
+		Scope:       m.ScopeExpensesRead,
+		Response:    "",
+		ContentType: echo.MIMEOctetStream,
+		Errors:      []int{http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests},
+	}, eh.DownloadAttachmentHandler, scope(m.ScopeExpensesRead)...)
+	spec.Register(e, openapi.Operation{
+		Method:  http.MethodDelete,
+		Path:    "/expenses/:id/attachments/:attachment_id",
+		Summary: "Delete an attachment and its content",
+		Tag:     "attachments",
+		Scope:   m.ScopeExpensesWrite,
+		Status:  http.StatusNoContent,
+		Errors:  []int{http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests},
+	}, eh.DeleteAttachmentHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPost,
+		Path:     "/ledgers",
+		Summary:  "Create a ledger owned by the caller",
+		Tag:      "ledgers",
+		Scope:    m.ScopeExpensesWrite,
+		Request:  ledger.CreateRequest{},
+		Response: ledger.Ledger{},
+		Status:   http.StatusCreated,
+		Errors:   []int{http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, lh.CreateHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodGet,
+		Path:     "/ledgers",
+		Summary:  "List the caller's ledgers with its role in each",
+		Tag:      "ledgers",
+		Scope:    m.ScopeExpensesRead,
+		Response: []ledger.Ledger{},
+		Errors:   []int{http.StatusTooManyRequests},
+	}, lh.ListHandler, scope(m.ScopeExpensesRead)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodGet,
+		Path:     "/ledgers/:id",
+		Summary:  "Get a ledger and its members",
+		Tag:      "ledgers",
+		Scope:    m.ScopeExpensesRead,
+		Response: ledger.Ledger{},
+		Errors:   []int{http.StatusNotFound, http.StatusTooManyRequests},
+	}, lh.GetHandler, scope(m.ScopeExpensesRead)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPost,
+		Path:     "/ledgers/:id/invitations",
+		Summary:  "Invite a member with a one-time code, owners only",
+		Tag:      "ledgers",
+		Scope:    m.ScopeExpensesWrite,
+		Request:  ledger.InviteRequest{},
+		Response: ledger.Invitation{},
+		Status:   http.StatusCreated,
+		Errors:   []int{http.StatusForbidden, http.StatusNotFound, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, lh.InviteHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPost,
+		Path:     "/invitations/accept",
+		Summary:  "Join a ledger with an invitation code",
+		Tag:      "ledgers",
+		Scope:    m.ScopeExpensesWrite,
+		Request:  ledger.AcceptRequest{},
+		Response: ledger.Ledger{},
+		Errors:   []int{http.StatusNotFound, http.StatusTooManyRequests},
+	}, lh.AcceptHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:  http.MethodDelete,
+		Path:    "/ledgers/:id/members/:subject",
+		Summary: "Remove a member, or leave the ledger",
+		Tag:     "ledgers",
+		Scope:   m.ScopeExpensesWrite,
+		Status:  http.StatusNoContent,
+		Errors:  []int{http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusTooManyRequests},
+	}, lh.RemoveMemberHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodGet,
+		Path:     "/ledgers/:id/balances",
+		Summary:  "Net balance per participant and the transfers that settle up",
+		Tag:      "ledgers",
+		Scope:    m.ScopeExpensesRead,
+		Response: expense.Balances{},
+		Errors:   []int{http.StatusNotFound, http.StatusTooManyRequests},
+	}, eh.BalancesHandler, scope(m.ScopeExpensesRead)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPost,
+		Path:     "/ledgers/:id/settlements",
+		Summary:  "Record a repayment between participants",
+		Tag:      "ledgers",
+		Scope:    m.ScopeExpensesWrite,
+		Request:  expense.Settlement{},
+		Response: expense.Settlement{},
+		Status:   http.StatusCreated,
+		Errors:   []int{http.StatusNotFound, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, eh.CreateSettlementHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodGet,
+		Path:     "/ledgers/:id/settlements",
+		Summary:  "List a ledger's repayments",
+		Tag:      "ledgers",
+		Scope:    m.ScopeExpensesRead,
+		Response: []expense.Settlement{},
+		Errors:   []int{http.StatusNotFound, http.StatusTooManyRequests},
+	}, eh.ListSettlementsHandler, scope(m.ScopeExpensesRead)...)
+	spec.Register(e, openapi.Operation{
+		Method:  http.MethodDelete,
+		Path:    "/ledgers/:id/settlements/:settlement_id",
+		Summary: "Delete a repayment recorded by mistake",
+		Tag:     "ledgers",
+		Scope:   m.ScopeExpensesWrite,
+		Status:  http.StatusNoContent,
+		Errors:  []int{http.StatusNotFound, http.StatusTooManyRequests},
+	}, eh.DeleteSettlementHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPost,
+		Path:     "/merchants",
+		Summary:  "Create a merchant in a ledger",
+		Tag:      "merchants",
+		Scope:    m.ScopeExpensesWrite,
+		Request:  expense.Merchant{},
+		Response: expense.Merchant{},
+		Status:   http.StatusCreated,
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, eh.CreateMerchantHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodGet,
+		Path:     "/merchants",
+		Summary:  "List the merchants of the caller's ledgers by name",
+		Tag:      "merchants",
+		Scope:    m.ScopeExpensesRead,
+		Query:    []openapi.Param{ledgerID},
+		Response: []expense.Merchant{},
+		Errors:   []int{http.StatusBadRequest, http.StatusTooManyRequests},
+	}, eh.ListMerchantsHandler, scope(m.ScopeExpensesRead)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPut,
+		Path:     "/merchants/:id",
+		Summary:  "Rename a merchant",
+		Tag:      "merchants",
+		Scope:    m.ScopeExpensesWrite,
+		Request:  expense.Merchant{},
+		Response: expense.Merchant{},
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, eh.UpdateMerchantHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:  http.MethodDelete,
+		Path:    "/merchants/:id",
+		Summary: "Delete a merchant no expense references",
+		Tag:     "merchants",
+		Scope:   m.ScopeExpensesWrite,
+		Status:  http.StatusNoContent,
+		Errors:  []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusTooManyRequests},
+	}, eh.DeleteMerchantHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPost,
+		Path:     "/payment-methods",
+		Summary:  "Create a payment method such as a credit card in a ledger",
+		Tag:      "payment methods",
+		Scope:    m.ScopeExpensesWrite,
+		Request:  expense.PaymentMethod{},
+		Response: expense.PaymentMethod{},
+		Status:   http.StatusCreated,
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, eh.CreatePaymentMethodHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodGet,
+		Path:     "/payment-methods",
+		Summary:  "List the payment methods of the caller's ledgers by name",
+		Tag:      "payment methods",
+		Scope:    m.ScopeExpensesRead,
+		Query:    []openapi.Param{ledgerID},
+		Response: []expense.PaymentMethod{},
+		Errors:   []int{http.StatusBadRequest, http.StatusTooManyRequests},
+	}, eh.ListPaymentMethodsHandler, scope(m.ScopeExpensesRead)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPut,
+		Path:     "/payment-methods/:id",
+		Summary:  "Update a payment method",
+		Tag:      "payment methods",
+		Scope:    m.ScopeExpensesWrite,
+		Request:  expense.PaymentMethod{},
+		Response: expense.PaymentMethod{},
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, eh.UpdatePaymentMethodHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:  http.MethodDelete,
+		Path:    "/payment-methods/:id",
+		Summary: "Delete a payment method no expense references",
+		Tag:     "payment methods",
+		Scope:   m.ScopeExpensesWrite,
+		Status:  http.StatusNoContent,
+		Errors:  []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusTooManyRequests},
+	}, eh.DeletePaymentMethodHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPost,
+		Path:     "/categories",
+		Summary:  "Create a category in a ledger, under parent_id when given",
+		Tag:      "categories",
+		Scope:    m.ScopeExpensesWrite,
+		Request:  expense.Category{},
+		Response: expense.Category{},
+		Status:   http.StatusCreated,
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, eh.CreateCategoryHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodGet,
+		Path:     "/categories",
+		Summary:  "List the categories of the caller's ledgers with their paths, depth-first",
+		Tag:      "categories",
+		Scope:    m.ScopeExpensesRead,
+		Query:    []openapi.Param{ledgerID},
+		Response: []expense.Category{},
+		Errors:   []int{http.StatusBadRequest, http.StatusTooManyRequests},
+	}, eh.ListCategoriesHandler, scope(m.ScopeExpensesRead)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPut,
+		Path:     "/categories/:id",
+		Summary:  "Rename a category or move it with its descendants under another parent",
+		Tag:      "categories",
+		Scope:    m.ScopeExpensesWrite,
+		Request:  expense.Category{},
+		Response: expense.Category{},
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, eh.UpdateCategoryHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPost,
+		Path:     "/categories/:id/merge",
+		Summary:  "Move a category's children and expenses into another category and delete it",
+		Tag:      "categories",
+		Scope:    m.ScopeExpensesWrite,
+		Query:    []openapi.Param{overrideReason},
+		Request:  expense.Merge{},
+		Response: expense.Category{},
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, eh.MergeCategoryHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:  http.MethodDelete,
+		Path:    "/categories/:id",
+		Summary: "Delete a category without children or expenses",
+		Tag:     "categories",
+		Scope:   m.ScopeExpensesWrite,
+		Status:  http.StatusNoContent,
+		Errors:  []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusTooManyRequests},
+	}, eh.DeleteCategoryHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPost,
+		Path:     "/rules",
+		Summary:  "Create a rule tagging and categorizing the expenses of a ledger as they are written",
+		Tag:      "rules",
+		Scope:    m.ScopeExpensesWrite,
+		Request:  expense.Rule{},
+		Response: expense.Rule{},
+		Status:   http.StatusCreated,
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, eh.CreateRuleHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodGet,
+		Path:     "/rules",
+		Summary:  "List the rules of the caller's ledgers in the order they run",
+		Tag:      "rules",
+		Scope:    m.ScopeExpensesRead,
+		Query:    []openapi.Param{ledgerID},
+		Response: []expense.Rule{},
+		Errors:   []int{http.StatusBadRequest, http.StatusTooManyRequests},
+	}, eh.ListRulesHandler, scope(m.ScopeExpensesRead)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPost,
+		Path:     "/rules/apply",
+		Summary:  "Run the rules of a ledger on its existing expenses, or preview the changes with dry_run",
+		Tag:      "rules",
+		Scope:    m.ScopeExpensesWrite,
+		Query:    []openapi.Param{overrideReason},
+		Request:  expense.ApplyRequest{},
+		Response: expense.ApplyResult{},
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, eh.ApplyRulesHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPut,
+		Path:     "/rules/:id",
+		Summary:  "Replace the name, position, conditions and actions of a rule",
+		Tag:      "rules",
+		Scope:    m.ScopeExpensesWrite,
+		Request:  expense.Rule{},
+		Response: expense.Rule{},
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, eh.UpdateRuleHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:  http.MethodDelete,
+		Path:    "/rules/:id",
+		Summary: "Delete a rule",
+		Tag:     "rules",
+		Scope:   m.ScopeExpensesWrite,
+		Status:  http.StatusNoContent,
+		Errors:  []int{http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests},
+	}, eh.DeleteRuleHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodGet,
+		Path:     "/accounting-periods",
+		Summary:  "List the accounting periods of the caller's ledgers, latest first",
+		Tag:      "accounting periods",
+		Scope:    m.ScopeExpensesRead,
+		Query:    []openapi.Param{ledgerID},
+		Response: []expense.Period{},
+		Errors:   []int{http.StatusBadRequest, http.StatusTooManyRequests},
+	}, eh.ListPeriodsHandler, scope(m.ScopeExpensesRead)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPost,
+		Path:     "/accounting-periods/close",
+		Summary:  "Close a month of a ledger, locking the expenses spent in it, for owners",
+		Tag:      "accounting periods",
+		Scope:    m.ScopeExpensesWrite,
+		Request:  expense.PeriodRequest{},
+		Response: expense.Period{},
+		Errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, eh.ClosePeriodHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPost,
+		Path:     "/accounting-periods/reopen",
+		Summary:  "Reopen a closed month of a ledger, for owners",
+		Tag:      "accounting periods",
+		Scope:    m.ScopeExpensesWrite,
+		Request:  expense.PeriodRequest{},
+		Response: expense.Period{},
+		Errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, eh.ReopenPeriodHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodGet,
+		Path:     "/accounting-periods/:id/overrides",
+		Summary:  "List the changes admins forced into an accounting period, for owners",
+		Tag:      "accounting periods",
+		Scope:    m.ScopeExpensesRead,
+		Response: []expense.PeriodOverride{},
+		Errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests},
+	}, eh.PeriodOverridesHandler, scope(m.ScopeExpensesRead)...)
+	spec.Register(e, openapi.Operation{
+		Method:  http.MethodPost,
+		Path:    "/reconciliations",
+		Summary: "Match the lines of a text/csv or application/x-ofx bank statement with a ledger's expenses",
+		Tag:     "reconciliation",
+		Scope:   m.ScopeExpensesRead,
+		Query: []openapi.Param{
+			{Name: "ledger_id", Type: "integer", Description: "the ledger to reconcile, required but for admin keys"},
+			{Name: "currency", Type: "string", Description: "the statement's 3-letter currency code, required; only expenses in it are matched"},
+			{Name: "window_days", Type: "integer", Description: "most days between a line and its expense, 3 by default"},
+			{Name: "min_similarity", Type: "number", Description: "least similarity of a line's description and its expense's title, from 0 to 1, 0.3 by default"},
+			{Name: "debits", Type: "string", Description: "the sign of a CSV statement's debits, negative by default or positive; the other lines are credits and skipped"},
+		},
+		Bodies:   []string{"text/csv", "application/x-ofx"},
+		Response: expense.Reconciliation{},
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, eh.ReconcileHandler, scope(m.ScopeExpensesRead)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPost,
+		Path:     "/reconciliations/confirm",
+		Summary:  "Confirm that statement lines paid expenses, so they aren't matched again",
+		Tag:      "reconciliation",
+		Scope:    m.ScopeExpensesWrite,
+		Request:  expense.ConfirmRequest{},
+		Response: expense.ConfirmResult{},
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, eh.ConfirmReconciliationHandler, scope(m.ScopeExpensesWrite)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPost,
+		Path:     "/exchange-rates",
+		Summary:  "Import exchange rates from a JSON array or a text/csv file",
+		Tag:      "exchange rates",
+		Scope:    m.ScopeAdmin,
+		Request:  []exchange.Rate{},
+		Response: exchange.ImportResult{},
+		Errors:   []int{http.StatusBadRequest, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, xh.ImportHandler, scope(m.ScopeAdmin)...)
+	spec.Register(e, openapi.Operation{
+		Method:  http.MethodGet,
+		Path:    "/exchange-rates",
+		Summary: "List exchange rates, newest first",
+		Tag:     "exchange rates",
+		Scope:   m.ScopeExpensesRead,
+		Query: []openapi.Param{
+			{Name: "base", Type: "string", Description: "only rates of this currency"},
+			{Name: "quote", Type: "string", Description: "only rates quoted in this currency"},
+			{Name: "from", Type: "string", Description: "only rates of this day or later, YYYY-MM-DD"},
+			{Name: "to", Type: "string", Description: "only rates of this day or earlier, YYYY-MM-DD"},
+		},
+		Response: []exchange.Rate{},
+		Errors:   []int{http.StatusBadRequest, http.StatusTooManyRequests},
+	}, xh.ListHandler, scope(m.ScopeExpensesRead)...)
+
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPost,
+		Path:     "/api-keys",
+		Summary:  "Create an API key, returned only in this response",
+		Tag:      "api-keys",
+		Scope:    m.ScopeAdmin,
+		Request:  apikey.CreateRequest{},
+		Response: apikey.Created{},
+		Status:   http.StatusCreated,
+		Errors:   []int{http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, kh.CreateHandler, scope(m.ScopeAdmin)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodGet,
+		Path:     "/api-keys",
+		Summary:  "List API keys without their secrets",
+		Tag:      "api-keys",
+		Scope:    m.ScopeAdmin,
+		Response: []apikey.Key{},
+		Errors:   []int{http.StatusTooManyRequests},
+	}, kh.ListHandler, scope(m.ScopeAdmin)...)
+	spec.Register(e, openapi.Operation{
+		Method:  http.MethodDelete,
+		Path:    "/api-keys/:id",
+		Summary: "Revoke an API key",
+		Tag:     "api-keys",
+		Scope:   m.ScopeAdmin,
+		Status:  http.StatusNoContent,
+		Errors:  []int{http.StatusNotFound, http.StatusTooManyRequests},
+	}, kh.RevokeHandler, scope(m.ScopeAdmin)...)
+
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodPost,
+		Path:     "/webhooks",
+		Summary:  "Subscribe a URL to expense events, the secret is returned only in this response",
+		Tag:      "webhooks",
+		Scope:    m.ScopeAdmin,
+		Request:  webhook.CreateRequest{},
+		Response: webhook.Created{},
+		Status:   http.StatusCreated,
+		Errors:   []int{http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, wh.CreateHandler, scope(m.ScopeAdmin)...)
+	spec.Register(e, openapi.Operation{
+		Method:   http.MethodGet,
+		Path:     "/webhooks",
+		Summary:  "List webhooks without their secrets",
+		Tag:      "webhooks",
+		Scope:    m.ScopeAdmin,
+		Response: []webhook.Webhook{},
+		Errors:   []int{http.StatusTooManyRequests},
+	}, wh.ListHandler, scope(m.ScopeAdmin)...)
+	spec.Register(e, openapi.Operation{
+		Method:  http.MethodDelete,
+		Path:    "/webhooks/:id",
+		Summary: "Delete a webhook and its pending deliveries",
+		Tag:     "webhooks",
+		Scope:   m.ScopeAdmin,
+		Status:  http.StatusNoContent,
+		Errors:  []int{http.StatusNotFound, http.StatusTooManyRequests},
+	}, wh.DeleteHandler, scope(m.ScopeAdmin)...)
+	spec.Register(e, openapi.Operation{
+		Method:  http.MethodGet,
+		Path:    "/webhooks/:id/deliveries",
+		Summary: "Delivery log of a webhook, newest first",
+		Tag:     "webhooks",
+		Scope:   m.ScopeAdmin,
+		Query: []openapi.Param{
+			{Name: "state", Type: "string", Description: "only deliveries in this state: pending, delivered or dead"},
+		},
+		Response: []webhook.Delivery{},
+		Errors:   []int{http.StatusNotFound, http.StatusTooManyRequests},
+	}, wh.DeliveriesHandler, scope(m.ScopeAdmin)...)
+	spec.Register(e, openapi.Operation{
+		Method:  http.MethodPost,
+		Path:    "/webhooks/:id/deliveries/:delivery_id/retry",
+		Summary: "Send a delivery again, such as a dead letter",
+		Tag:     "webhooks",
+		Scope:   m.ScopeAdmin,
+		Status:  http.StatusAccepted,
+		Errors:  []int{http.StatusNotFound, http.StatusTooManyRequests},
+	}, wh.RetryHandler, scope(m.ScopeAdmin)...)
+}
+
+// rateLimits returns the configured limiters, or pass-throughs when they are
+// off: the one by key, run after auth, and the one by IP, run before it.
+func rateLimits(cfg config.RateLimit, eh *expense.Handler) (echo.MiddlewareFunc, echo.MiddlewareFunc) {
+	if !cfg.Enabled {
+		pass := func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+		return pass, pass
+	}
+	var store m.RateLimitStore = m.NewMemoryStore()
+	if cfg.Store == "postgres" {
+		store = &m.PostgresStore{DB: eh.DB}
+	}
+	return m.RateLimit(m.RateLimitConfig{Store: store, Default: cfg.Default, Routes: cfg.Routes}),
+		m.IPRateLimit(store, cfg.IP, nil)
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/panudetjt/assessment/config"
+	"github.com/panudetjt/assessment/expense"
+	"github.com/panudetjt/assessment/openapi"
+	"github.com/panudetjt/assessment/problem"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoutesAreDocumented(t *testing.T) {
+	e := echo.New()
+	spec := openapi.New("Expenses API", "test")
+	cfg := config.Default()
+
+	Routes(e, spec, &cfg, &expense.Handler{})
+
+	assert.NotEmpty(t, e.Routes())
+	for _, r := range e.Routes() {
+		assert.True(t, spec.Has(r.Method, r.Path), "%s %s is not in the OpenAPI document", r.Method, r.Path)
+	}
+
+	paths := spec.Document()["paths"].(map[string]map[string]any)
+	assert.Contains(t, paths["/expenses/{id}"], "put")
+	assert.Contains(t, paths["/expenses/{id}/history"], "get")
+	assert.Contains(t, paths["/expenses/stream"], "get")
+	assert.Contains(t, paths["/merchants/{id}"], "delete")
+	assert.Contains(t, paths["/payment-methods"], "post")
+	assert.Contains(t, paths["/categories/{id}/merge"], "post")
+	assert.Contains(t, paths["/rules/apply"], "post")
+	assert.Contains(t, paths["/expenses/merge"], "post")
+	assert.Contains(t, paths["/expenses/{id}/approve"], "post")
+	assert.Contains(t, paths["/expenses/{id}/transitions"], "get")
+	assert.Contains(t, paths["/accounting-periods/close"], "post")
+	assert.Contains(t, paths["/reconciliations/confirm"], "post")
+	assert.Contains(t, paths, "/openapi.json")
+}
+
+func TestRoutesEnforceScopes(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	cfg := config.Default()
+	cfg.Auth.Keys = []string{"admin"}
+	cfg.RateLimit.Enabled = false
+	e := New(&cfg, &expense.Handler{DB: db})
+	key := "exp_0123456789ab_secret"
+	sum := sha256.Sum256([]byte(key))
+	mock.ExpectQuery("SELECT id, hash, scopes FROM api_keys").WithArgs("0123456789ab").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "hash", "scopes"}).AddRow(1, hex.EncodeToString(sum[:]), `{expenses:read}`))
+	mock.ExpectExec("UPDATE api_keys SET last_used_at").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	req := httptest.NewRequest(http.MethodDelete, "/expenses/1", nil)
+	req.Header.Set(echo.HeaderAuthorization, key)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), problem.CodeInsufficientScope)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	req = httptest.NewRequest(http.MethodGet, "/api-keys", nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestRoutesLimitByIPBeforeAuth(t *testing.T) {
+	cfg := config.Default()
+	cfg.Auth.Keys = []string{"admin"}
+	cfg.RateLimit.IP = config.Limit{Rate: 0.001, Burst: 2}
+	e := New(&cfg, &expense.Handler{})
+	serve := func(path, key, forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set(echo.HeaderAuthorization, key)
+		req.Header.Set(echo.HeaderXForwardedFor, forwardedFor)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, serve("/expenses", "guess-1", "1.1.1.1"))
+	assert.Equal(t, http.StatusOK, serve("/health", "", "2.2.2.2"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("/expenses", "guess-2", "3.3.3.3"))
+}
@@ -0,0 +1,59 @@
+package apikey
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerate(t *testing.T) {
+	key, prefix, err := generate()
+	assert.NoError(t, err)
+	other, _, _ := generate()
+
+	assert.True(t, strings.HasPrefix(key, "exp_"+prefix+"_"))
+	assert.Len(t, prefix, prefixLen)
+	assert.NotEqual(t, key, other)
+
+	got, ok := parse(key)
+	assert.True(t, ok)
+	assert.Equal(t, prefix, got)
+}
+
+func TestParse(t *testing.T) {
+	for _, key := range []string{"", "November 10, 2009", "exp_", "exp_0123456789ab", "exp_0123456789abXsecret"} {
+		_, ok := parse(key)
+		assert.False(t, ok, key)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	t.Run("should store the hash and return the key once", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		mock.ExpectQuery("INSERT INTO api_keys (.+) VALUES (.+) RETURNING id, created_at").
+			WithArgs("ci", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, now))
+
+		k, key, err := Create(context.Background(), db, "ci", []string{"expenses:read"})
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, k.ID)
+		assert.Equal(t, now, k.CreatedAt)
+		assert.Contains(t, key, k.Prefix)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should reject unknown scopes", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+
+		_, _, err := Create(context.Background(), db, "ci", []string{"admin"})
+
+		assert.ErrorContains(t, err, "unknown scope")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
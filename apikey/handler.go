@@ -0,0 +1,116 @@
+package apikey
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	m "github.com/panudetjt/assessment/middleware"
+	"github.com/panudetjt/assessment/problem"
+)
+
+type Handler struct {
+	DB *sql.DB
+}
+
+// CreateRequest is the body of POST /api-keys.
+type CreateRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// Created is a new key. Secret is shown only in this response.
+type Created struct {
+	Key
+	Secret string `json:"key"`
+}
+
+func (r CreateRequest) validate() []problem.FieldError {
+	var errs []problem.FieldError
+	if strings.TrimSpace(r.Name) == "" {
+		errs = append(errs, problem.FieldError{Field: "name", Message: "must not be empty"})
+	}
+	if len(r.Scopes) == 0 {
+		errs = append(errs, problem.FieldError{Field: "scopes", Message: "must not be empty"})
+	}
+	for _, s := range r.Scopes {
+		if !known(s) {
+			errs = append(errs, problem.FieldError{Field: "scopes", Message: fmt.Sprintf("unknown scope %q, use one of %s", s, strings.Join(m.Scopes, ", "))})
+			break
+		}
+	}
+	return errs
+}
+
+func known(scope string) bool {
+	for _, s := range m.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) CreateHandler(c echo.Context) error {
+	var r CreateRequest
+	if err := c.Bind(&r); err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "request body must be an api key JSON object")
+	}
+	if errs := r.validate(); errs != nil {
+		return problem.Validation(errs...)
+	}
+
+	k, key, err := Create(c.Request().Context(), h.DB, r.Name, r.Scopes)
+	if err != nil {
+		return problem.Internal(err)
+	}
+	return c.JSON(http.StatusCreated, Created{Key: k, Secret: key})
+}
+
+func (h *Handler) ListHandler(c echo.Context) error {
+	rows, err := h.DB.QueryContext(c.Request().Context(),
+		"SELECT id, name, prefix, scopes, created_at, last_used_at, revoked_at FROM api_keys ORDER BY id")
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't query api keys: %w", err))
+	}
+	defer rows.Close()
+
+	keys := []Key{}
+	for rows.Next() {
+		var k Key
+		err := rows.Scan(&k.ID, &k.Name, &k.Prefix, pq.Array(&k.Scopes), &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt)
+		if err != nil {
+			return problem.Internal(fmt.Errorf("can't scan api keys: %w", err))
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return problem.Internal(fmt.Errorf("can't iterate api keys: %w", err))
+	}
+	return c.JSON(http.StatusOK, keys)
+}
+
+func (h *Handler) RevokeHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidID, "api key id must be an integer")
+	}
+
+	result, err := h.DB.ExecContext(c.Request().Context(),
+		"UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL", id)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't revoke api key: %w", err))
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't read revoked rows: %w", err))
+	}
+	if n == 0 {
+		return problem.New(http.StatusNotFound, problem.CodeAPIKeyNotFound, fmt.Sprintf("api key %d does not exist or is revoked", id))
+	}
+	return c.NoContent(http.StatusNoContent)
+}
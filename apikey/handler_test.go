@@ -0,0 +1,100 @@
+package apikey
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/problem"
+	"github.com/panudetjt/assessment/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateHandler(t *testing.T) {
+	t.Run("should return 201 (Created) with the key", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/api-keys", strings.NewReader(`{"name": "ci", "scopes": ["expenses:read"]}`))
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery("INSERT INTO api_keys").
+			WithArgs("ci", sqlmock.AnyArg(), sqlmock.AnyArg(), pq.Array([]string{"expenses:read"})).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+		h := Handler{DB: db}
+
+		res.Serve(h.CreateHandler)
+		var got Created
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusCreated, res.Recorder.Code)
+		assert.Equal(t, 1, got.ID)
+		assert.Equal(t, "ci", got.Name)
+		assert.True(t, strings.HasPrefix(got.Secret, "exp_"+got.Prefix+"_"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 422 (UnprocessableEntity) when fields are invalid", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/api-keys", strings.NewReader(`{"name": "", "scopes": ["admin"]}`))
+		db, mock, _ := sqlmock.New()
+		h := Handler{DB: db}
+
+		res.Serve(h.CreateHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, res.Recorder.Code)
+		assert.Len(t, p.Errors, 2)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestListHandler(t *testing.T) {
+	t.Run("should return 200 (OK) without hashes", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/api-keys", nil)
+		db, mock, _ := sqlmock.New()
+		now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		mock.ExpectQuery("SELECT id, name, prefix, scopes, created_at, last_used_at, revoked_at FROM api_keys ORDER BY id").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "prefix", "scopes", "created_at", "last_used_at", "revoked_at"}).
+				AddRow(1, "ci", "0123456789ab", `{expenses:read}`, now, now, driver.Value(nil)))
+		h := Handler{DB: db}
+
+		res.Serve(h.ListHandler)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.NotContains(t, res.Recorder.Body.String(), "hash")
+		var got []Key
+		res.Decode(&got)
+		assert.Equal(t, []Key{{ID: 1, Name: "ci", Prefix: "0123456789ab", Scopes: []string{"expenses:read"}, CreatedAt: now, LastUsedAt: &now}}, got)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestRevokeHandler(t *testing.T) {
+	revoke := func(rows int64) *util.Response {
+		res := util.RequestE(http.MethodDelete, "/api-keys/1", nil)
+		res.Context.SetParamNames("id")
+		res.Context.SetParamValues("1")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectExec("UPDATE api_keys SET revoked_at = now\\(\\) WHERE id = \\$1 AND revoked_at IS NULL").
+			WithArgs(1).WillReturnResult(sqlmock.NewResult(0, rows))
+		h := Handler{DB: db}
+
+		res.Serve(h.RevokeHandler)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		return res
+	}
+
+	t.Run("should return 204 (NoContent) when revoked", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, revoke(1).Recorder.Code)
+	})
+
+	t.Run("should return 404 (NotFound) when missing or already revoked", func(t *testing.T) {
+		res := revoke(0)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusNotFound, res.Recorder.Code)
+		assert.Equal(t, problem.CodeAPIKeyNotFound, p.Code)
+	})
+}
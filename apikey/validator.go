@@ -0,0 +1,56 @@
+package apikey
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	m "github.com/panudetjt/assessment/middleware"
+	"github.com/panudetjt/assessment/problem"
+)
+
+// Validator accepts unrevoked keys from the api_keys table and grants their
+// scopes. Headers that don't look like an API key are rejected without a
+// query, so it can be combined with m.StaticKeys through m.AnyOf.
+func Validator(db *sql.DB) m.AuthorizationValidator {
+	return func(s string, c echo.Context) (bool, error) {
+		prefix, ok := parse(s)
+		if !ok {
+			return false, nil
+		}
+
+		ctx := c.Request().Context()
+		var (
+			id     int
+			stored string
+			scopes []string
+		)
+		err := db.QueryRowContext(ctx,
+			"SELECT id, hash, scopes FROM api_keys WHERE prefix = $1 AND revoked_at IS NULL", prefix,
+		).Scan(&id, &stored, pq.Array(&scopes))
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		if err != nil {
+			return false, problem.Internal(fmt.Errorf("can't query api key: %w", err))
+		}
+		if subtle.ConstantTimeCompare([]byte(hash(s)), []byte(stored)) != 1 {
+			return false, nil
+		}
+
+		// Only write once a minute per key so busy keys don't turn every
+		// read into a write.
+		_, err = db.ExecContext(ctx,
+			"UPDATE api_keys SET last_used_at = now() WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')", id)
+		if err != nil {
+			c.Logger().Error("can't update api key last_used_at: ", err)
+		}
+
+		m.SetSubject(c, "apikey:"+strconv.Itoa(id))
+		m.SetScopes(c, scopes)
+		return true, nil
+	}
+}
@@ -0,0 +1,84 @@
+package apikey
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	m "github.com/panudetjt/assessment/middleware"
+	"github.com/panudetjt/assessment/problem"
+	"github.com/panudetjt/assessment/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidator(t *testing.T) {
+	key, prefix, _ := generate()
+	query := "SELECT id, hash, scopes FROM api_keys WHERE prefix = \\$1 AND revoked_at IS NULL"
+
+	t.Run("should accept a stored key and grant its scopes", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses", nil)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery(query).WithArgs(prefix).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "hash", "scopes"}).AddRow(7, hash(key), `{expenses:read}`))
+		mock.ExpectExec("UPDATE api_keys SET last_used_at = now\\(\\)").WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		ok, err := Validator(db)(key, res.Context)
+
+		assert.True(t, ok)
+		assert.NoError(t, err)
+		assert.Equal(t, "apikey:7", m.Subject(res.Context))
+		assert.True(t, m.HasScope(res.Context, m.ScopeExpensesRead))
+		assert.False(t, m.HasScope(res.Context, m.ScopeExpensesWrite))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should reject a key whose secret doesn't match", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses", nil)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery(query).WithArgs(prefix).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "hash", "scopes"}).AddRow(7, hash("other"), `{expenses:read}`))
+
+		ok, err := Validator(db)(key, res.Context)
+
+		assert.False(t, ok)
+		assert.NoError(t, err)
+		assert.Empty(t, m.Subject(res.Context))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should reject unknown or revoked keys", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses", nil)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery(query).WithArgs(prefix).WillReturnRows(sqlmock.NewRows([]string{"id", "hash", "scopes"}))
+
+		ok, err := Validator(db)(key, res.Context)
+
+		assert.False(t, ok)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should not query for headers that aren't api keys", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses", nil)
+		db, mock, _ := sqlmock.New()
+
+		ok, err := Validator(db)("November 10, 2009", res.Context)
+
+		assert.False(t, ok)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return an internal problem when the lookup fails", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses", nil)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery(query).WillReturnError(errors.New("db down"))
+
+		_, err := Validator(db)(key, res.Context)
+
+		var p *problem.Problem
+		assert.ErrorAs(t, err, &p)
+		assert.Equal(t, http.StatusInternalServerError, p.Status)
+	})
+}
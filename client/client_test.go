@@ -0,0 +1,270 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/api"
+	"github.com/panudetjt/assessment/config"
+	"github.com/panudetjt/assessment/date"
+	"github.com/panudetjt/assessment/expense"
+	"github.com/panudetjt/assessment/problem"
+	"github.com/stretchr/testify/assert"
+)
+
+const token = "November 10, 2009"
+
+var columns = []string{"id", "title", "amount", "note", "tags", "ledger_id", "split", "currency", "spent_on", "merchant_id", "payment_method_id", "category_id", "status"}
+
+// newServer serves the real handlers backed by sqlmock.
+func newServer(t *testing.T) (*Client, sqlmock.Sqlmock) {
+	db, mock, _ := sqlmock.New()
+	cfg := config.Default()
+	cfg.LogLevel = "off"
+	cfg.Auth.Keys = []string{token}
+	e := api.New(&cfg, &expense.Handler{DB: db})
+	e.Logger.SetOutput(io.Discard)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	return New(srv.URL, WithToken(token), WithRetries(0, 0)), mock
+}
+
+func TestExpenses(t *testing.T) {
+	ctx := context.Background()
+	smoothie := Expense{Title: "strawberry smoothie", Amount: 79, Note: "night market", Tags: []string{"food"}}
+
+	t.Run("should create expense", func(t *testing.T) {
+		c, mock := newServer(t)
+		mock.ExpectBegin()
+		mock.ExpectExec("SELECT pg_advisory_xact_lock_shared").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("FROM accounting_periods").WillReturnRows(sqlmock.NewRows(nil))
+		mock.ExpectQuery("FROM rules").WillReturnRows(sqlmock.NewRows(nil))
+		mock.ExpectQuery("INSERT INTO expenses (.+) VALUES (.+) RETURNING id").
+			WithArgs(smoothie.Title, smoothie.Amount, smoothie.Note, pq.Array(smoothie.Tags), nil, nil, "THB", date.Today(), nil, nil, nil).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery("INSERT INTO expense_events").WillReturnRows(sqlmock.NewRows([]string{"version", "created_at"}).AddRow(1, time.Now()))
+		mock.ExpectExec("INSERT INTO webhook_outbox").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		got, err := c.CreateExpense(ctx, smoothie)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, got.ID)
+		assert.Equal(t, smoothie.Title, got.Title)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return validation error", func(t *testing.T) {
+		c, mock := newServer(t)
+
+		_, err := c.CreateExpense(ctx, Expense{})
+
+		var apiErr *Error
+		assert.ErrorIs(t, err, ErrValidation)
+		assert.True(t, errors.As(err, &apiErr))
+		assert.Equal(t, http.StatusUnprocessableEntity, apiErr.StatusCode)
+		assert.NotEmpty(t, apiErr.Problem.Errors)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should get expense", func(t *testing.T) {
+		c, mock := newServer(t)
+		mock.ExpectPrepare("SELECT (.+) FROM expenses WHERE id = \\$1").
+			ExpectQuery().
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(1, smoothie.Title, smoothie.Amount, smoothie.Note, pq.Array(smoothie.Tags), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft"))
+
+		got, err := c.GetExpense(ctx, 1)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, got.ID)
+		assert.Equal(t, smoothie.Tags, got.Tags)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return not found error", func(t *testing.T) {
+		c, mock := newServer(t)
+		mock.ExpectPrepare("SELECT (.+) FROM expenses WHERE id = \\$1").
+			ExpectQuery().
+			WillReturnRows(sqlmock.NewRows(columns))
+
+		_, err := c.GetExpense(ctx, 9)
+
+		var apiErr *Error
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.True(t, errors.As(err, &apiErr))
+		assert.Equal(t, problem.CodeExpenseNotFound, apiErr.Problem.Code)
+	})
+
+	t.Run("should update expense", func(t *testing.T) {
+		c, mock := newServer(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM expenses WHERE id = (.+) FOR UPDATE").
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(1, smoothie.Title, 69, smoothie.Note, pq.Array(smoothie.Tags), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft"))
+		mock.ExpectExec("SELECT pg_advisory_xact_lock_shared").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("FROM accounting_periods").WillReturnRows(sqlmock.NewRows(nil))
+		mock.ExpectQuery("FROM rules").WillReturnRows(sqlmock.NewRows(nil))
+		mock.ExpectQuery("UPDATE expenses").
+			WithArgs(1, smoothie.Title, smoothie.Amount, smoothie.Note, pq.Array(smoothie.Tags), nil, "THB", "2023-01-02", nil, nil, nil).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(1, smoothie.Title, smoothie.Amount, smoothie.Note, pq.Array(smoothie.Tags), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft"))
+		mock.ExpectQuery("INSERT INTO expense_events").WillReturnRows(sqlmock.NewRows([]string{"version", "created_at"}).AddRow(1, time.Now()))
+		mock.ExpectExec("INSERT INTO webhook_outbox").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		got, err := c.UpdateExpense(ctx, 1, smoothie)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, got.ID)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should delete expense with token", func(t *testing.T) {
+		c, mock := newServer(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery("DELETE FROM expenses").
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "ledger_id", "split", "currency", "spent_on", "merchant_id", "payment_method_id", "category_id", "status"}).AddRow(1, "rent", 100, "", nil, nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft"))
+		mock.ExpectExec("SELECT pg_advisory_xact_lock_shared").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("FROM accounting_periods").WillReturnRows(sqlmock.NewRows(nil))
+		mock.ExpectQuery("INSERT INTO expense_events").WillReturnRows(sqlmock.NewRows([]string{"version", "created_at"}).AddRow(1, time.Now()))
+		mock.ExpectExec("INSERT INTO webhook_outbox").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := c.DeleteExpense(ctx, 1)
+
+		assert.NoError(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return unauthorized error for wrong token", func(t *testing.T) {
+		c, _ := newServer(t)
+		c.token = "wrong"
+
+		err := c.DeleteExpense(ctx, 1)
+
+		assert.ErrorIs(t, err, ErrUnauthorized)
+	})
+
+	t.Run("should send filters and return summary", func(t *testing.T) {
+		c, mock := newServer(t)
+		min := 10.0
+		mock.ExpectPrepare("SELECT count")
+		mock.ExpectPrepare("SELECT t.tag")
+		mock.ExpectQuery("SELECT count").
+			WithArgs(pq.Array([]string{"food"}), min).
+			WillReturnRows(sqlmock.NewRows([]string{"count", "sum", "min", "max"}).AddRow(1, 79, "THB", "THB"))
+		mock.ExpectQuery("SELECT t.tag").
+			WithArgs(pq.Array([]string{"food"}), min).
+			WillReturnRows(sqlmock.NewRows([]string{"tag", "count", "sum"}).AddRow("food", 1, 79))
+
+		got, err := c.Summary(ctx, Filter{Tags: []string{"food"}, MinAmount: &min})
+
+		assert.NoError(t, err)
+		assert.Equal(t, Summary{Currency: "THB", Count: 1, Total: 79, ByTag: []TagSummary{{Tag: "food", Count: 1, Total: 79}}}, got)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should iterate over pages", func(t *testing.T) {
+		c, mock := newServer(t)
+		mock.ExpectPrepare("SELECT (.+) FROM expenses WHERE deleted_at IS NULL ORDER BY id").
+			ExpectQuery().
+			WithArgs(2, 0).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(1, "a", 1, "", pq.Array([]string{}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft").
+				AddRow(2, "b", 2, "", pq.Array([]string{}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft"))
+		mock.ExpectQuery("SELECT (.+) FROM expenses WHERE deleted_at IS NULL ORDER BY id").
+			WithArgs(2, 2).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(3, "c", 3, "", pq.Array([]string{}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft"))
+
+		all, err := c.ListExpenses(ctx, ListOptions{PageSize: 2}).All()
+
+		assert.NoError(t, err)
+		assert.Len(t, all, 3)
+		assert.Equal(t, 3, all[2].ID)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestRetry(t *testing.T) {
+	ctx := context.Background()
+	flaky := func(status, failures int) (*httptest.Server, *int32) {
+		var calls int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) <= int32(failures) {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(status)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			io.WriteString(w, `{"id": 1}`)
+		}))
+		t.Cleanup(srv.Close)
+		return srv, &calls
+	}
+
+	t.Run("should retry idempotent request on 5xx", func(t *testing.T) {
+		srv, calls := flaky(http.StatusServiceUnavailable, 2)
+		c := New(srv.URL, WithRetries(3, time.Millisecond))
+
+		got, err := c.GetExpense(ctx, 1)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, got.ID)
+		assert.EqualValues(t, 3, *calls)
+	})
+
+	t.Run("should not retry create on 5xx", func(t *testing.T) {
+		srv, calls := flaky(http.StatusInternalServerError, 1)
+		c := New(srv.URL, WithRetries(3, time.Millisecond))
+
+		_, err := c.CreateExpense(ctx, Expense{Title: "a", Amount: 1})
+
+		assert.Error(t, err)
+		assert.EqualValues(t, 1, *calls)
+	})
+
+	t.Run("should retry create on 429", func(t *testing.T) {
+		srv, calls := flaky(http.StatusTooManyRequests, 1)
+		c := New(srv.URL, WithRetries(3, time.Millisecond))
+
+		_, err := c.CreateExpense(ctx, Expense{Title: "a", Amount: 1})
+
+		assert.NoError(t, err)
+		assert.EqualValues(t, 2, *calls)
+	})
+
+	t.Run("should give up after max retries", func(t *testing.T) {
+		srv, calls := flaky(http.StatusTooManyRequests, 10)
+		c := New(srv.URL, WithRetries(2, time.Millisecond))
+
+		_, err := c.GetExpense(ctx, 1)
+
+		assert.ErrorIs(t, err, ErrRateLimited)
+		assert.EqualValues(t, 3, *calls)
+	})
+
+	t.Run("should stop when context is cancelled", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer srv.Close()
+		c := New(srv.URL, WithRetries(5, time.Hour))
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+
+		_, err := c.GetExpense(ctx, 1)
+
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
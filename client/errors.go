@@ -0,0 +1,64 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/panudetjt/assessment/problem"
+)
+
+// Sentinel errors matched by errors.Is against an *Error.
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrValidation   = errors.New("validation failed")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrDuplicate    = errors.New("possible duplicate")
+	ErrNotEditable  = errors.New("expense not editable")
+	ErrPeriodClosed = errors.New("period closed")
+)
+
+// Error is returned for every non-2xx response. Problem holds the decoded
+// problem+json body when the server sent one.
+type Error struct {
+	StatusCode int
+	Problem    problem.Problem
+}
+
+func (e *Error) Error() string {
+	if e.Problem.Code != "" {
+		return fmt.Sprintf("expenses api: %d %s: %s", e.StatusCode, e.Problem.Code, e.Problem.Detail)
+	}
+	return fmt.Sprintf("expenses api: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrValidation:
+		return e.Problem.Code == problem.CodeValidationFailed
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrDuplicate:
+		return e.Problem.Code == problem.CodePossibleDuplicate
+	case ErrNotEditable:
+		return e.Problem.Code == problem.CodeNotEditable
+	case ErrPeriodClosed:
+		return e.Problem.Code == problem.CodePeriodClosed
+	}
+	return false
+}
+
+func decodeError(res *http.Response) *Error {
+	defer res.Body.Close()
+	e := &Error{StatusCode: res.StatusCode}
+	b, _ := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	_ = json.Unmarshal(b, &e.Problem)
+	return e
+}
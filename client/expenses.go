@@ -0,0 +1,182 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/panudetjt/assessment/expense"
+)
+
+type (
+	Expense    = expense.Expense
+	Summary    = expense.Summary
+	TagSummary = expense.TagSummary
+)
+
+const defaultPageSize = 100
+
+func (c *Client) CreateExpense(ctx context.Context, e Expense) (Expense, error) {
+	var out Expense
+	err := c.do(ctx, http.MethodPost, "/expenses", e, &out)
+	return out, err
+}
+
+func (c *Client) GetExpense(ctx context.Context, id int) (Expense, error) {
+	var out Expense
+	err := c.do(ctx, http.MethodGet, "/expenses/"+strconv.Itoa(id), nil, &out)
+	return out, err
+}
+
+func (c *Client) UpdateExpense(ctx context.Context, id int, e Expense) (Expense, error) {
+	var out Expense
+	err := c.do(ctx, http.MethodPut, "/expenses/"+strconv.Itoa(id), e, &out)
+	return out, err
+}
+
+func (c *Client) DeleteExpense(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, "/expenses/"+strconv.Itoa(id), nil, nil)
+}
+
+// Filter narrows ListExpenses and Summary. Zero fields are ignored.
+type Filter struct {
+	Tags      []string
+	MinAmount *float64
+	MaxAmount *float64
+	Title     string
+	// ConvertTo reports amounts in this currency as well, at the rate of the
+	// day each expense was spent.
+	ConvertTo string
+	// MerchantID and PaymentMethodID narrow to one merchant or payment method,
+	// and CategoryID to a category and its descendants.
+	MerchantID      int
+	PaymentMethodID int
+	CategoryID      int
+	// Status narrows to one reimbursement status, such as "submitted".
+	Status string
+}
+
+func (f Filter) values() url.Values {
+	q := url.Values{}
+	for _, t := range f.Tags {
+		q.Add("tag", t)
+	}
+	if f.MinAmount != nil {
+		q.Set("min_amount", strconv.FormatFloat(*f.MinAmount, 'f', -1, 64))
+	}
+	if f.MaxAmount != nil {
+		q.Set("max_amount", strconv.FormatFloat(*f.MaxAmount, 'f', -1, 64))
+	}
+	if f.Title != "" {
+		q.Set("title", f.Title)
+	}
+	if f.ConvertTo != "" {
+		q.Set("convert_to", f.ConvertTo)
+	}
+	if f.MerchantID != 0 {
+		q.Set("merchant_id", strconv.Itoa(f.MerchantID))
+	}
+	if f.PaymentMethodID != 0 {
+		q.Set("payment_method_id", strconv.Itoa(f.PaymentMethodID))
+	}
+	if f.CategoryID != 0 {
+		q.Set("category_id", strconv.Itoa(f.CategoryID))
+	}
+	if f.Status != "" {
+		q.Set("status", f.Status)
+	}
+	return q
+}
+
+// Summary totals the expenses matching f.
+func (c *Client) Summary(ctx context.Context, f Filter) (Summary, error) {
+	var out Summary
+	path := "/expenses/summary"
+	if q := f.values().Encode(); q != "" {
+		path += "?" + q
+	}
+	err := c.do(ctx, http.MethodGet, path, nil, &out)
+	return out, err
+}
+
+// ListOptions controls ListExpenses. The zero value lists everything in pages
+// of 100.
+type ListOptions struct {
+	Filter
+	PageSize int
+}
+
+// ListExpenses returns an iterator that fetches pages lazily:
+//
+//	it := c.ListExpenses(ctx, client.ListOptions{})
+//	for it.Next() {
+//		e := it.Expense()
+//	}
+//	if err := it.Err(); err != nil { ... }
+func (c *Client) ListExpenses(ctx context.Context, opts ListOptions) *ExpenseIterator {
+	if opts.PageSize <= 0 {
+		opts.PageSize = defaultPageSize
+	}
+	return &ExpenseIterator{c: c, ctx: ctx, opts: opts, i: -1}
+}
+
+// ExpenseIterator walks the expenses one page at a time.
+type ExpenseIterator struct {
+	c      *Client
+	ctx    context.Context
+	opts   ListOptions
+	offset int
+	page   []Expense
+	i      int
+	done   bool
+	err    error
+}
+
+// Next advances to the next expense, fetching a new page when needed. It
+// returns false when there are no more expenses or an error occurred.
+func (it *ExpenseIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	it.i++
+	if it.i < len(it.page) {
+		return true
+	}
+	if it.done {
+		return false
+	}
+
+	q := it.opts.values()
+	q.Set("limit", strconv.Itoa(it.opts.PageSize))
+	q.Set("offset", strconv.Itoa(it.offset))
+
+	var page []Expense
+	if err := it.c.do(it.ctx, http.MethodGet, "/expenses?"+q.Encode(), nil, &page); err != nil {
+		it.err = fmt.Errorf("list expenses: %w", err)
+		return false
+	}
+	it.page, it.i = page, 0
+	it.offset += len(page)
+	it.done = len(page) < it.opts.PageSize
+	return len(page) > 0
+}
+
+// Expense returns the current expense.
+func (it *ExpenseIterator) Expense() Expense {
+	return it.page[it.i]
+}
+
+func (it *ExpenseIterator) Err() error {
+	return it.err
+}
+
+// All drains the iterator into a slice.
+func (it *ExpenseIterator) All() ([]Expense, error) {
+	var all []Expense
+	for it.Next() {
+		all = append(all, it.Expense())
+	}
+	return all, it.Err()
+}
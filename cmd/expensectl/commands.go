@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/panudetjt/assessment/client"
+	"github.com/panudetjt/assessment/date"
+)
+
+func newFlagSet(name string, e env) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	return fs
+}
+
+// parseID parses the single positional expense id of get and edit, allowing
+// flags after it.
+func parseID(fs *flag.FlagSet, args []string) (int, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return 0, fmt.Errorf("usage: expensectl %s <id> [flags]", fs.Name())
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid id %q", args[0])
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return 0, err
+	}
+	if fs.NArg() > 0 {
+		return 0, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	return id, nil
+}
+
+func addCommand(ctx context.Context, c *client.Client, args []string, e env) error {
+	fs := newFlagSet("add", e)
+	var ex client.Expense
+	var tags stringsFlag
+	fs.StringVar(&ex.Title, "title", "", "title (required)")
+	fs.Float64Var(&ex.Amount, "amount", 0, "amount (required)")
+	fs.StringVar(&ex.Note, "note", "", "note")
+	fs.Var(&tags, "tag", "tag, repeat for several")
+	fs.StringVar(&ex.Currency, "currency", "", "currency code, THB when not given")
+	spentOn := fs.String("date", "", "day spent as YYYY-MM-DD, today when not given")
+	format := fs.String("o", "table", "output format: table, json or csv")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	ex.Tags = tags
+	if *spentOn != "" {
+		d, err := date.Parse(*spentOn)
+		if err != nil {
+			return fmt.Errorf("invalid --date: %w", err)
+		}
+		ex.SpentOn = d
+	}
+
+	created, err := c.CreateExpense(ctx, ex)
+	if err != nil {
+		return err
+	}
+	return writeExpenses(e.stdout, *format, []client.Expense{created})
+}
+
+func getCommand(ctx context.Context, c *client.Client, args []string, e env) error {
+	fs := newFlagSet("get", e)
+	format := fs.String("o", "table", "output format: table, json or csv")
+	id, err := parseID(fs, args)
+	if err != nil {
+		return err
+	}
+
+	ex, err := c.GetExpense(ctx, id)
+	if err != nil {
+		return err
+	}
+	return writeExpenses(e.stdout, *format, []client.Expense{ex})
+}
+
+// editCommand changes only the fields whose flags are given.
+func editCommand(ctx context.Context, c *client.Client, args []string, e env) error {
+	fs := newFlagSet("edit", e)
+	title := fs.String("title", "", "new title")
+	amount := fs.Float64("amount", 0, "new amount")
+	note := fs.String("note", "", "new note")
+	currency := fs.String("currency", "", "new currency code")
+	spentOn := fs.String("date", "", "new day spent as YYYY-MM-DD")
+	var tags stringsFlag
+	fs.Var(&tags, "tag", "tag, repeat for several; replaces all tags")
+	clearTags := fs.Bool("clear-tags", false, "remove all tags")
+	format := fs.String("o", "table", "output format: table, json or csv")
+	id, err := parseID(fs, args)
+	if err != nil {
+		return err
+	}
+
+	ex, err := c.GetExpense(ctx, id)
+	if err != nil {
+		return err
+	}
+	var day date.Date
+	if *spentOn != "" {
+		if day, err = date.Parse(*spentOn); err != nil {
+			return fmt.Errorf("invalid --date: %w", err)
+		}
+	}
+	changed := false
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "title":
+			ex.Title = *title
+		case "amount":
+			ex.Amount = *amount
+		case "note":
+			ex.Note = *note
+		case "currency":
+			ex.Currency = *currency
+		case "date":
+			ex.SpentOn = day
+		case "tag":
+			ex.Tags = tags
+		case "clear-tags":
+			if *clearTags {
+				ex.Tags = []string{}
+			}
+		default:
+			return
+		}
+		changed = true
+	})
+	if !changed {
+		return errors.New("nothing to change, pass at least one of --title, --amount, --note, --currency, --date, --tag or --clear-tags")
+	}
+
+	updated, err := c.UpdateExpense(ctx, id, ex)
+	if err != nil {
+		return err
+	}
+	return writeExpenses(e.stdout, *format, []client.Expense{updated})
+}
+
+// filterFlags registers the list filters on fs.
+func filterFlags(fs *flag.FlagSet) func() (client.Filter, error) {
+	var tags stringsFlag
+	fs.Var(&tags, "tag", "only expenses with this tag, repeat to require several")
+	min := fs.String("min", "", "minimum amount")
+	max := fs.String("max", "", "maximum amount")
+	title := fs.String("title", "", "title contains, case-insensitive")
+	convertTo := fs.String("convert-to", "", "also convert amounts into this currency")
+
+	return func() (client.Filter, error) {
+		f := client.Filter{Tags: tags, Title: *title, ConvertTo: *convertTo}
+		for _, a := range []struct {
+			name string
+			v    string
+			dst  **float64
+		}{{"min", *min, &f.MinAmount}, {"max", *max, &f.MaxAmount}} {
+			if a.v == "" {
+				continue
+			}
+			n, err := strconv.ParseFloat(a.v, 64)
+			if err != nil {
+				return f, fmt.Errorf("invalid --%s %q", a.name, a.v)
+			}
+			*a.dst = &n
+		}
+		return f, nil
+	}
+}
+
+func lsCommand(ctx context.Context, c *client.Client, args []string, e env) error {
+	fs := newFlagSet("ls", e)
+	filter := filterFlags(fs)
+	limit := fs.Int("limit", 0, "show at most this many expenses (0 is all)")
+	format := fs.String("o", "table", "output format: table, json or csv")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	f, err := filter()
+	if err != nil {
+		return err
+	}
+
+	opts := client.ListOptions{Filter: f}
+	if *limit > 0 && *limit < 100 {
+		opts.PageSize = *limit
+	}
+	it := c.ListExpenses(ctx, opts)
+	expenses := []client.Expense{}
+	for it.Next() {
+		expenses = append(expenses, it.Expense())
+		if *limit > 0 && len(expenses) == *limit {
+			break
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	return writeExpenses(e.stdout, *format, expenses)
+}
+
+func summaryCommand(ctx context.Context, c *client.Client, args []string, e env) error {
+	fs := newFlagSet("summary", e)
+	filter := filterFlags(fs)
+	format := fs.String("o", "table", "output format: table or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	f, err := filter()
+	if err != nil {
+		return err
+	}
+
+	s, err := c.Summary(ctx, f)
+	if err != nil {
+		return err
+	}
+	return writeSummary(e.stdout, *format, s)
+}
+
+// importCommand creates every expense in a CSV or JSON file, "-" for stdin.
+// It stops at the first failure and reports how many were created.
+func importCommand(ctx context.Context, c *client.Client, args []string, e env) error {
+	fs := newFlagSet("import", e)
+	format := fs.String("format", "", "file format: csv or json (default from the file extension)")
+	dryRun := fs.Bool("dry-run", false, "parse and print the expenses without creating them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: expensectl import [--format csv|json] [--dry-run] <file|->")
+	}
+
+	path := fs.Arg(0)
+	var r io.Reader = e.stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	}
+
+	expenses, err := readExpenses(r, *format)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		return writeExpenses(e.stdout, "table", expenses)
+	}
+
+	for i, ex := range expenses {
+		if _, err := c.CreateExpense(ctx, ex); err != nil {
+			return fmt.Errorf("expense %d (%q): %w; %d of %d imported", i+1, ex.Title, err, i, len(expenses))
+		}
+	}
+	fmt.Fprintf(e.stdout, "imported %d expenses\n", len(expenses))
+	return nil
+}
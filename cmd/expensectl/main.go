@@ -0,0 +1,131 @@
+// Command expensectl manages expenses from the terminal through the expenses
+// API.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/panudetjt/assessment/client"
+)
+
+const usage = `usage: expensectl [--profile name] [--host url] [--token token] <command> [flags]
+
+commands:
+  add       create an expense
+  get       show an expense
+  edit      change fields of an expense
+  ls        list expenses
+  import    create expenses from a CSV or JSON file
+  summary   total expenses overall and per tag
+  profile   manage saved host and token profiles
+
+The host and token come from, in increasing order of precedence, the active
+profile, the HOST and AUTH_TOKEN environment variables and the flags.
+`
+
+// env is the process environment, swapped out by tests.
+type env struct {
+	stdin      io.Reader
+	stdout     io.Writer
+	stderr     io.Writer
+	getenv     func(string) string
+	configPath string
+}
+
+func main() {
+	path, err := defaultConfigPath()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "expensectl:", err)
+		os.Exit(1)
+	}
+	if p := os.Getenv("EXPENSECTL_CONFIG"); p != "" {
+		path = p
+	}
+
+	os.Exit(run(context.Background(), os.Args[1:], env{
+		stdin:      os.Stdin,
+		stdout:     os.Stdout,
+		stderr:     os.Stderr,
+		getenv:     os.Getenv,
+		configPath: path,
+	}))
+}
+
+func run(ctx context.Context, args []string, e env) int {
+	fs := flag.NewFlagSet("expensectl", flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	fs.Usage = func() { fmt.Fprint(e.stderr, usage) }
+	profileName := fs.String("profile", "", "profile to use instead of the current one")
+	host := fs.String("host", "", "API base URL")
+	token := fs.String("token", "", "authorization token")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	cmd, rest := fs.Arg(0), fs.Args()[1:]
+	if cmd == "profile" {
+		return exit(e, profileCommand(rest, e))
+	}
+
+	p, err := resolveProfile(e, *profileName, *host, *token)
+	if err != nil {
+		return exit(e, err)
+	}
+	c := client.New(p.Host, client.WithToken(p.Token))
+
+	commands := map[string]func(context.Context, *client.Client, []string, env) error{
+		"add":     addCommand,
+		"get":     getCommand,
+		"edit":    editCommand,
+		"ls":      lsCommand,
+		"import":  importCommand,
+		"summary": summaryCommand,
+	}
+	f, ok := commands[cmd]
+	if !ok {
+		fmt.Fprintf(e.stderr, "expensectl: unknown command %q\n\n", cmd)
+		fs.Usage()
+		return 2
+	}
+	return exit(e, f(ctx, c, rest, e))
+}
+
+func exit(e env, err error) int {
+	if err == nil {
+		return 0
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return 2
+	}
+
+	var apiErr *client.Error
+	if errors.As(err, &apiErr) {
+		fmt.Fprintln(e.stderr, "expensectl:", apiErr)
+		for _, fe := range apiErr.Problem.Errors {
+			fmt.Fprintf(e.stderr, "  %s: %s\n", fe.Field, fe.Message)
+		}
+		return 1
+	}
+	fmt.Fprintln(e.stderr, "expensectl:", err)
+	return 1
+}
+
+// stringsFlag is a repeatable string flag.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string { return strings.Join(*s, ",") }
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/api"
+	"github.com/panudetjt/assessment/config"
+	"github.com/panudetjt/assessment/date"
+	"github.com/panudetjt/assessment/expense"
+	"github.com/stretchr/testify/assert"
+)
+
+const token = "November 10, 2009"
+
+var columns = []string{"id", "title", "amount", "note", "tags", "ledger_id", "split", "currency", "spent_on", "merchant_id", "payment_method_id", "category_id", "status"}
+
+type harness struct {
+	env    env
+	mock   sqlmock.Sqlmock
+	host   string
+	stdout *bytes.Buffer
+	stderr *bytes.Buffer
+}
+
+func newHarness(t *testing.T) *harness {
+	db, mock, _ := sqlmock.New()
+	cfg := config.Default()
+	cfg.LogLevel = "off"
+	cfg.Auth.Keys = []string{token}
+	e := api.New(&cfg, &expense.Handler{DB: db})
+	e.Logger.SetOutput(io.Discard)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+
+	h := &harness{mock: mock, host: srv.URL, stdout: &bytes.Buffer{}, stderr: &bytes.Buffer{}}
+	h.env = env{
+		stdin:      strings.NewReader(""),
+		stdout:     h.stdout,
+		stderr:     h.stderr,
+		getenv:     func(string) string { return "" },
+		configPath: filepath.Join(t.TempDir(), "config.yaml"),
+	}
+	return h
+}
+
+func (h *harness) run(args ...string) int {
+	h.stdout.Reset()
+	h.stderr.Reset()
+	return run(context.Background(), append([]string{"--host", h.host, "--token", token}, args...), h.env)
+}
+
+func TestCommands(t *testing.T) {
+	t.Run("add prints created expense", func(t *testing.T) {
+		h := newHarness(t)
+		h.mock.ExpectBegin()
+		h.mock.ExpectExec("SELECT pg_advisory_xact_lock_shared").WillReturnResult(sqlmock.NewResult(0, 0))
+		h.mock.ExpectQuery("FROM accounting_periods").WillReturnRows(sqlmock.NewRows(nil))
+		h.mock.ExpectQuery("FROM rules").WillReturnRows(sqlmock.NewRows(nil))
+		h.mock.ExpectQuery("INSERT INTO expenses").
+			WithArgs("smoothie", 79.0, "", pq.Array([]string{"food", "beverage"}), nil, nil, "THB", date.Today(), nil, nil, nil).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+		h.mock.ExpectQuery("INSERT INTO expense_events").WillReturnRows(sqlmock.NewRows([]string{"version", "created_at"}).AddRow(1, time.Now()))
+		h.mock.ExpectExec("INSERT INTO webhook_outbox").WillReturnResult(sqlmock.NewResult(0, 1))
+		h.mock.ExpectCommit()
+
+		code := h.run("add", "--title", "smoothie", "--amount", "79", "--tag", "food", "--tag", "beverage")
+
+		assert.Equal(t, 0, code, h.stderr.String())
+		assert.Contains(t, h.stdout.String(), "7   smoothie  79.00")
+		assert.Nil(t, h.mock.ExpectationsWereMet())
+	})
+
+	t.Run("add reports validation errors", func(t *testing.T) {
+		h := newHarness(t)
+
+		code := h.run("add", "--title", "smoothie")
+
+		assert.Equal(t, 1, code)
+		assert.Contains(t, h.stderr.String(), "validation_failed")
+		assert.Contains(t, h.stderr.String(), "amount: must be greater than 0")
+	})
+
+	t.Run("edit changes only given fields", func(t *testing.T) {
+		h := newHarness(t)
+		h.mock.ExpectPrepare("SELECT (.+) FROM expenses WHERE id").
+			ExpectQuery().
+			WithArgs(3).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(3, "smoothie", 79, "night market", pq.Array([]string{"food"}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft"))
+		h.mock.ExpectBegin()
+		h.mock.ExpectQuery("SELECT (.+) FROM expenses WHERE id = (.+) FOR UPDATE").
+			WithArgs(3).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(3, "smoothie", 79, "night market", pq.Array([]string{"food"}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft"))
+		h.mock.ExpectExec("SELECT pg_advisory_xact_lock_shared").WillReturnResult(sqlmock.NewResult(0, 0))
+		h.mock.ExpectQuery("FROM accounting_periods").WillReturnRows(sqlmock.NewRows(nil))
+		h.mock.ExpectQuery("FROM rules").WillReturnRows(sqlmock.NewRows(nil))
+		h.mock.ExpectQuery("UPDATE expenses").
+			WithArgs(3, "smoothie", 89.0, "night market", pq.Array([]string{"food"}), nil, "THB", "2023-01-02", nil, nil, nil).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(3, "smoothie", 89, "night market", pq.Array([]string{"food"}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft"))
+		h.mock.ExpectQuery("INSERT INTO expense_events").WillReturnRows(sqlmock.NewRows([]string{"version", "created_at"}).AddRow(1, time.Now()))
+		h.mock.ExpectExec("INSERT INTO webhook_outbox").WillReturnResult(sqlmock.NewResult(0, 1))
+		h.mock.ExpectCommit()
+
+		code := h.run("edit", "3", "--amount", "89", "-o", "json")
+
+		assert.Equal(t, 0, code, h.stderr.String())
+		assert.Contains(t, h.stdout.String(), `"amount": 89`)
+		assert.Nil(t, h.mock.ExpectationsWereMet())
+	})
+
+	t.Run("get reports missing expense", func(t *testing.T) {
+		h := newHarness(t)
+		h.mock.ExpectPrepare("SELECT (.+) FROM expenses WHERE id").
+			ExpectQuery().
+			WillReturnRows(sqlmock.NewRows(columns))
+
+		code := h.run("get", "42")
+
+		assert.Equal(t, 1, code)
+		assert.Contains(t, h.stderr.String(), "expense_not_found")
+	})
+
+	t.Run("ls sends filters and prints csv", func(t *testing.T) {
+		h := newHarness(t)
+		h.mock.ExpectPrepare("SELECT (.+) FROM expenses WHERE deleted_at IS NULL AND tags @> \\$1 AND amount >= \\$2 ORDER BY id").
+			ExpectQuery().
+			WithArgs(pq.Array([]string{"food"}), 50.0, 100, 0).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(1, "smoothie", 79, "a, b", pq.Array([]string{"food", "beverage"}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft"))
+
+		code := h.run("ls", "--tag", "food", "--min", "50", "-o", "csv")
+
+		assert.Equal(t, 0, code, h.stderr.String())
+		assert.Equal(t, "id,title,amount,note,tags\n1,smoothie,79,\"a, b\",food;beverage\n", h.stdout.String())
+		assert.Nil(t, h.mock.ExpectationsWereMet())
+	})
+
+	t.Run("import creates expenses from csv", func(t *testing.T) {
+		h := newHarness(t)
+		h.env.stdin = strings.NewReader("title,amount,tags\nsmoothie,79,food;beverage\ntaxi,120,\n")
+		h.mock.ExpectBegin()
+		h.mock.ExpectExec("SELECT pg_advisory_xact_lock_shared").WillReturnResult(sqlmock.NewResult(0, 0))
+		h.mock.ExpectQuery("FROM accounting_periods").WillReturnRows(sqlmock.NewRows(nil))
+		h.mock.ExpectQuery("FROM rules").WillReturnRows(sqlmock.NewRows(nil))
+		h.mock.ExpectQuery("INSERT INTO expenses").
+			WithArgs("smoothie", 79.0, "", pq.Array([]string{"food", "beverage"}), nil, nil, "THB", date.Today(), nil, nil, nil).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		h.mock.ExpectQuery("INSERT INTO expense_events").WillReturnRows(sqlmock.NewRows([]string{"version", "created_at"}).AddRow(1, time.Now()))
+		h.mock.ExpectExec("INSERT INTO webhook_outbox").WillReturnResult(sqlmock.NewResult(0, 1))
+		h.mock.ExpectCommit()
+		h.mock.ExpectBegin()
+		h.mock.ExpectExec("SELECT pg_advisory_xact_lock_shared").WillReturnResult(sqlmock.NewResult(0, 0))
+		h.mock.ExpectQuery("FROM accounting_periods").WillReturnRows(sqlmock.NewRows(nil))
+		h.mock.ExpectQuery("FROM rules").WillReturnRows(sqlmock.NewRows(nil))
+		h.mock.ExpectQuery("INSERT INTO expenses").
+			WithArgs("taxi", 120.0, "", pq.Array([]string{}), nil, nil, "THB", date.Today(), nil, nil, nil).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+		h.mock.ExpectQuery("INSERT INTO expense_events").WillReturnRows(sqlmock.NewRows([]string{"version", "created_at"}).AddRow(1, time.Now()))
+		h.mock.ExpectExec("INSERT INTO webhook_outbox").WillReturnResult(sqlmock.NewResult(0, 1))
+		h.mock.ExpectCommit()
+
+		code := h.run("import", "--format", "csv", "-")
+
+		assert.Equal(t, 0, code, h.stderr.String())
+		assert.Equal(t, "imported 2 expenses\n", h.stdout.String())
+		assert.Nil(t, h.mock.ExpectationsWereMet())
+	})
+
+	t.Run("summary prints totals", func(t *testing.T) {
+		h := newHarness(t)
+		h.mock.ExpectPrepare("SELECT count")
+		h.mock.ExpectPrepare("SELECT t.tag")
+		h.mock.ExpectQuery("SELECT count").WillReturnRows(sqlmock.NewRows([]string{"count", "sum", "min", "max"}).AddRow(2, 199, "THB", "THB"))
+		h.mock.ExpectQuery("SELECT t.tag").WillReturnRows(sqlmock.NewRows([]string{"tag", "count", "sum"}).AddRow("food", 1, 79))
+
+		code := h.run("summary")
+
+		assert.Equal(t, 0, code, h.stderr.String())
+		assert.Equal(t, "TAG    COUNT  TOTAL THB\nfood   1      79.00\n(all)  2      199.00\n", h.stdout.String())
+	})
+
+	t.Run("unknown command", func(t *testing.T) {
+		h := newHarness(t)
+
+		assert.Equal(t, 2, h.run("rm"))
+	})
+}
+
+func TestProfiles(t *testing.T) {
+	h := newHarness(t)
+	e := h.env
+
+	assert.Equal(t, 0, run(context.Background(), []string{"profile", "set", "--host", "http://prod", "--token", "secret"}, e))
+	assert.Equal(t, 0, run(context.Background(), []string{"profile", "set", "--name", "local", "--host", "http://localhost"}, e))
+
+	info, err := os.Stat(e.configPath)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	p, err := resolveProfile(e, "", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, profile{Host: "http://prod", Token: "secret"}, p)
+
+	assert.Equal(t, 0, run(context.Background(), []string{"profile", "use", "local"}, e))
+	p, _ = resolveProfile(e, "", "", "")
+	assert.Equal(t, "http://localhost", p.Host)
+
+	e.getenv = func(k string) string {
+		return map[string]string{"HOST": "http://env", "AUTH_TOKEN": "env-token"}[k]
+	}
+	p, _ = resolveProfile(e, "default", "", "flag-token")
+	assert.Equal(t, profile{Host: "http://env", Token: "flag-token"}, p)
+
+	_, err = resolveProfile(e, "missing", "", "")
+	assert.Error(t, err)
+}
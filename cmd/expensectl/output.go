@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/panudetjt/assessment/client"
+)
+
+var csvHeader = []string{"id", "title", "amount", "note", "tags"}
+
+// tagSeparator joins tags inside a single CSV cell.
+const tagSeparator = ";"
+
+func formatAmount(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+func writeExpenses(w io.Writer, format string, expenses []client.Expense) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(expenses)
+
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(csvHeader)
+		for _, e := range expenses {
+			cw.Write([]string{strconv.Itoa(e.ID), e.Title, strconv.FormatFloat(e.Amount, 'f', -1, 64), e.Note, strings.Join(e.Tags, tagSeparator)})
+		}
+		cw.Flush()
+		return cw.Error()
+
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tTITLE\tAMOUNT\tNOTE\tTAGS")
+		for _, e := range expenses {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", e.ID, e.Title, formatAmount(e.Amount), e.Note, strings.Join(e.Tags, ", "))
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown output format %q, use table, json or csv", format)
+}
+
+func writeSummary(w io.Writer, format string, s client.Summary) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		total := "TOTAL"
+		if s.Currency != "" {
+			total += " " + s.Currency
+		}
+		fmt.Fprintln(tw, "TAG\tCOUNT\t"+total)
+		for _, t := range s.ByTag {
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", t.Tag, t.Count, formatAmount(t.Total))
+		}
+		fmt.Fprintf(tw, "(all)\t%d\t%s\n", s.Count, formatAmount(s.Total))
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown output format %q, use table or json", format)
+}
+
+// readExpenses parses the JSON array or CSV produced by `ls -o json|csv`. The
+// CSV id column is optional and ignored.
+func readExpenses(r io.Reader, format string) ([]client.Expense, error) {
+	switch format {
+	case "json":
+		var expenses []client.Expense
+		if err := json.NewDecoder(r).Decode(&expenses); err != nil {
+			return nil, fmt.Errorf("parse json: %w", err)
+		}
+		return expenses, nil
+
+	case "csv":
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		records, err := cr.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("parse csv: %w", err)
+		}
+		if len(records) == 0 {
+			return nil, errors.New("parse csv: missing header")
+		}
+
+		col := map[string]int{}
+		for i, name := range records[0] {
+			col[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+		for _, required := range []string{"title", "amount"} {
+			if _, ok := col[required]; !ok {
+				return nil, fmt.Errorf("parse csv: missing %q column", required)
+			}
+		}
+		field := func(rec []string, name string) string {
+			if i, ok := col[name]; ok && i < len(rec) {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		}
+
+		expenses := make([]client.Expense, 0, len(records)-1)
+		for n, rec := range records[1:] {
+			amount, err := strconv.ParseFloat(field(rec, "amount"), 64)
+			if err != nil {
+				return nil, fmt.Errorf("parse csv: line %d: invalid amount %q", n+2, field(rec, "amount"))
+			}
+			e := client.Expense{Title: field(rec, "title"), Amount: amount, Note: field(rec, "note"), Tags: []string{}}
+			if tags := field(rec, "tags"); tags != "" {
+				for _, t := range strings.Split(tags, tagSeparator) {
+					e.Tags = append(e.Tags, strings.TrimSpace(t))
+				}
+			}
+			expenses = append(expenses, e)
+		}
+		return expenses, nil
+	}
+	return nil, fmt.Errorf("unknown import format %q, use csv or json", format)
+}
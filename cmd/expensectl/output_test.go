@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/panudetjt/assessment/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadExpenses(t *testing.T) {
+	t.Run("json", func(t *testing.T) {
+		got, err := readExpenses(strings.NewReader(`[{"id": 9, "title": "taxi", "amount": 120, "tags": ["travel"]}]`), "json")
+
+		assert.NoError(t, err)
+		assert.Equal(t, []client.Expense{{ID: 9, Title: "taxi", Amount: 120, Tags: []string{"travel"}}}, got)
+	})
+
+	t.Run("csv round trips ls output", func(t *testing.T) {
+		var b strings.Builder
+		in := []client.Expense{{ID: 1, Title: "smoothie", Amount: 79.5, Note: "a, b", Tags: []string{"food", "beverage"}}}
+		assert.NoError(t, writeExpenses(&b, "csv", in))
+
+		got, err := readExpenses(strings.NewReader(b.String()), "csv")
+
+		assert.NoError(t, err)
+		in[0].ID = 0
+		assert.Equal(t, in, got)
+	})
+
+	t.Run("csv without amount column", func(t *testing.T) {
+		_, err := readExpenses(strings.NewReader("title\ntaxi\n"), "csv")
+
+		assert.ErrorContains(t, err, `missing "amount" column`)
+	})
+
+	t.Run("csv with invalid amount", func(t *testing.T) {
+		_, err := readExpenses(strings.NewReader("title,amount\ntaxi,lots\n"), "csv")
+
+		assert.ErrorContains(t, err, "line 2")
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		_, err := readExpenses(strings.NewReader(""), "xml")
+
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultHost = "http://localhost:2565"
+
+type profile struct {
+	Host  string `yaml:"host"`
+	Token string `yaml:"token,omitempty"`
+}
+
+type profiles struct {
+	Current  string             `yaml:"current"`
+	Profiles map[string]profile `yaml:"profiles"`
+}
+
+func defaultConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "expensectl", "config.yaml"), nil
+}
+
+func loadProfiles(path string) (*profiles, error) {
+	ps := &profiles{Current: "default", Profiles: map[string]profile{}}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ps, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(b, ps); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if ps.Profiles == nil {
+		ps.Profiles = map[string]profile{}
+	}
+	return ps, nil
+}
+
+// save writes the profiles readable only by the user since they hold tokens.
+func (ps *profiles) save(path string) error {
+	b, err := yaml.Marshal(ps)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o600)
+}
+
+// resolveProfile layers the HOST and AUTH_TOKEN environment variables and the
+// global flags over the selected profile.
+func resolveProfile(e env, name, host, token string) (profile, error) {
+	ps, err := loadProfiles(e.configPath)
+	if err != nil {
+		return profile{}, err
+	}
+	if name == "" {
+		name = ps.Current
+	}
+	p, ok := ps.Profiles[name]
+	if !ok && name != ps.Current {
+		return profile{}, fmt.Errorf("profile %q does not exist", name)
+	}
+
+	if v := e.getenv("HOST"); v != "" {
+		p.Host = v
+	}
+	if v := e.getenv("AUTH_TOKEN"); v != "" {
+		p.Token = v
+	}
+	if host != "" {
+		p.Host = host
+	}
+	if token != "" {
+		p.Token = token
+	}
+	if p.Host == "" {
+		p.Host = defaultHost
+	}
+	return p, nil
+}
+
+func profileCommand(args []string, e env) error {
+	if len(args) == 0 {
+		return errors.New("usage: expensectl profile <set|use|ls|rm> [flags]")
+	}
+
+	ps, err := loadProfiles(e.configPath)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "set":
+		fs := flag.NewFlagSet("profile set", flag.ContinueOnError)
+		fs.SetOutput(e.stderr)
+		name := fs.String("name", ps.Current, "profile name")
+		host := fs.String("host", "", "API base URL")
+		token := fs.String("token", "", "authorization token")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		p := ps.Profiles[*name]
+		if *host != "" {
+			p.Host = *host
+		}
+		if *token != "" {
+			p.Token = *token
+		}
+		ps.Profiles[*name] = p
+		if len(ps.Profiles) == 1 {
+			ps.Current = *name
+		}
+		return ps.save(e.configPath)
+
+	case "use":
+		if len(args) != 2 {
+			return errors.New("usage: expensectl profile use <name>")
+		}
+		if _, ok := ps.Profiles[args[1]]; !ok {
+			return fmt.Errorf("profile %q does not exist", args[1])
+		}
+		ps.Current = args[1]
+		return ps.save(e.configPath)
+
+	case "ls":
+		names := make([]string, 0, len(ps.Profiles))
+		for n := range ps.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			mark := " "
+			if n == ps.Current {
+				mark = "*"
+			}
+			fmt.Fprintf(e.stdout, "%s %s\t%s\n", mark, n, ps.Profiles[n].Host)
+		}
+		return nil
+
+	case "rm":
+		if len(args) != 2 {
+			return errors.New("usage: expensectl profile rm <name>")
+		}
+		delete(ps.Profiles, args[1])
+		return ps.save(e.configPath)
+	}
+	return fmt.Errorf("unknown profile command %q", args[0])
+}
@@ -0,0 +1,323 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const redacted = "[REDACTED]"
+
+type Config struct {
+	Port        string      `yaml:"port" toml:"port"`
+	LogLevel    string      `yaml:"log_level" toml:"log_level"`
+	Database    Database    `yaml:"database" toml:"database"`
+	Server      Server      `yaml:"server" toml:"server"`
+	Auth        Auth        `yaml:"auth" toml:"auth"`
+	CORS        CORS        `yaml:"cors" toml:"cors"`
+	RateLimit   RateLimit   `yaml:"rate_limit" toml:"rate_limit"`
+	Storage     Storage     `yaml:"storage" toml:"storage"`
+	Attachments Attachments `yaml:"attachments" toml:"attachments"`
+	Webhooks    Webhooks    `yaml:"webhooks" toml:"webhooks"`
+	Duplicates  Duplicates  `yaml:"duplicates" toml:"duplicates"`
+}
+
+type Database struct {
+	URL             string        `yaml:"url" toml:"url"`
+	MaxOpenConns    int           `yaml:"max_open_conns" toml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" toml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+	ConnectTimeout  time.Duration `yaml:"connect_timeout" toml:"connect_timeout"`
+	AutoMigrate     bool          `yaml:"auto_migrate" toml:"auto_migrate"`
+}
+
+type Server struct {
+	ReadTimeout     time.Duration `yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout" toml:"write_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	// StreamTimeout ends event streams, which the write timeout doesn't.
+	StreamTimeout time.Duration `yaml:"stream_timeout" toml:"stream_timeout"`
+}
+
+type Auth struct {
+	Keys []string `yaml:"keys" toml:"keys"`
+}
+
+type CORS struct {
+	AllowOrigins []string `yaml:"allow_origins" toml:"allow_origins"`
+}
+
+// RateLimit configures per-client token buckets. Routes overrides Default for
+// routes keyed as "METHOD /path", e.g. "POST /expenses". IP limits every
+// request of a client IP, before authentication. TrustProxy takes the client
+// IP from X-Forwarded-For, which only a proxy in front of the server may set.
+type RateLimit struct {
+	Enabled    bool             `yaml:"enabled" toml:"enabled"`
+	Store      string           `yaml:"store" toml:"store"`
+	Default    Limit            `yaml:"default" toml:"default"`
+	Routes     map[string]Limit `yaml:"routes" toml:"routes"`
+	IP         Limit            `yaml:"ip" toml:"ip"`
+	TrustProxy bool             `yaml:"trust_proxy" toml:"trust_proxy"`
+}
+
+// Limit is a token bucket refilled at Rate tokens per second up to Burst.
+type Limit struct {
+	Rate  float64 `yaml:"rate" toml:"rate"`
+	Burst int     `yaml:"burst" toml:"burst"`
+}
+
+// Storage selects where attachment blobs are kept: Dir for the local driver,
+// S3 for any S3-compatible service.
+type Storage struct {
+	Driver string `yaml:"driver" toml:"driver"`
+	Dir    string `yaml:"dir" toml:"dir"`
+	S3     S3     `yaml:"s3" toml:"s3"`
+}
+
+type S3 struct {
+	Endpoint  string `yaml:"endpoint" toml:"endpoint"`
+	Bucket    string `yaml:"bucket" toml:"bucket"`
+	Region    string `yaml:"region" toml:"region"`
+	AccessKey string `yaml:"access_key" toml:"access_key"`
+	SecretKey string `yaml:"secret_key" toml:"secret_key"`
+}
+
+// Attachments limits uploads. Types are matched against the content type
+// sniffed from the file, not the one the client claims.
+type Attachments struct {
+	MaxSize int      `yaml:"max_size" toml:"max_size"`
+	Types   []string `yaml:"types" toml:"types"`
+}
+
+// Webhooks configures the dispatcher that delivers expense events. A failed
+// delivery is retried after Backoff, doubling each time, until MaxAttempts.
+type Webhooks struct {
+	Enabled      bool          `yaml:"enabled" toml:"enabled"`
+	PollInterval time.Duration `yaml:"poll_interval" toml:"poll_interval"`
+	Timeout      time.Duration `yaml:"timeout" toml:"timeout"`
+	MaxAttempts  int           `yaml:"max_attempts" toml:"max_attempts"`
+	Backoff      time.Duration `yaml:"backoff" toml:"backoff"`
+}
+
+// Duplicates configures how expenses entered twice are found: the same amount
+// and currency, spent at most WindowDays apart, with titles at least
+// Similarity alike, from 0 to 1. Warn rejects a new expense with such a
+// duplicate unless the caller allows it.
+type Duplicates struct {
+	Warn       bool    `yaml:"warn" toml:"warn"`
+	WindowDays int     `yaml:"window_days" toml:"window_days"`
+	Similarity float64 `yaml:"similarity" toml:"similarity"`
+}
+
+var rateLimitStores = []string{"memory", "postgres"}
+
+var storageDrivers = []string{"local", "s3"}
+
+var logLevels = []string{"debug", "info", "warn", "error", "off"}
+
+// Default returns the configuration used when no other source sets a value.
+func Default() Config {
+	return Config{
+		Port:     ":2565",
+		LogLevel: "info",
+		Database: Database{
+			MaxOpenConns:    25,
+			MaxIdleConns:    25,
+			ConnMaxLifetime: 5 * time.Minute,
+			ConnectTimeout:  30 * time.Second,
+			AutoMigrate:     true,
+		},
+		RateLimit: RateLimit{
+			Enabled: true,
+			Store:   "memory",
+			Default: Limit{Rate: 10, Burst: 20},
+			IP:      Limit{Rate: 50, Burst: 100},
+		},
+		Storage: Storage{
+			Driver: "local",
+			Dir:    "data/attachments",
+			S3:     S3{Region: "us-east-1"},
+		},
+		Attachments: Attachments{
+			MaxSize: 10 << 20,
+			Types:   []string{"image/jpeg", "image/png", "image/gif", "image/webp", "application/pdf"},
+		},
+		Webhooks: Webhooks{
+			Enabled:      true,
+			PollInterval: time.Second,
+			Timeout:      10 * time.Second,
+			MaxAttempts:  8,
+			Backoff:      30 * time.Second,
+		},
+		Duplicates: Duplicates{
+			Warn:       true,
+			WindowDays: 3,
+			Similarity: 0.7,
+		},
+		Server: Server{
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    10 * time.Second,
+			ShutdownTimeout: 10 * time.Second,
+			StreamTimeout:   30 * time.Minute,
+		},
+	}
+}
+
+// Validate reports every invalid setting at once so a misconfigured server
+// fails at startup instead of on the first request.
+func (c Config) Validate() error {
+	var errs []string
+
+	if _, _, err := net.SplitHostPort(c.Port); err != nil {
+		errs = append(errs, fmt.Sprintf("port %q must be in the form [host]:port", c.Port))
+	}
+	if !contains(logLevels, c.LogLevel) {
+		errs = append(errs, fmt.Sprintf("log_level %q must be one of %s", c.LogLevel, strings.Join(logLevels, ", ")))
+	}
+	if c.Database.URL == "" {
+		errs = append(errs, "database.url is required")
+	}
+	if c.Database.MaxOpenConns < 0 {
+		errs = append(errs, "database.max_open_conns must not be negative")
+	}
+	if c.Database.MaxIdleConns < 0 {
+		errs = append(errs, "database.max_idle_conns must not be negative")
+	}
+	if c.Database.MaxOpenConns > 0 && c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		errs = append(errs, "database.max_idle_conns must not exceed database.max_open_conns")
+	}
+	if c.Database.ConnMaxLifetime < 0 {
+		errs = append(errs, "database.conn_max_lifetime must not be negative")
+	}
+	if c.Database.ConnectTimeout <= 0 {
+		errs = append(errs, "database.connect_timeout must be positive")
+	}
+	if c.Server.ReadTimeout < 0 || c.Server.WriteTimeout < 0 || c.Server.ShutdownTimeout < 0 || c.Server.StreamTimeout < 0 {
+		errs = append(errs, "server timeouts must not be negative")
+	}
+	if len(c.Auth.Keys) == 0 {
+		errs = append(errs, "auth.keys must contain at least one key")
+	}
+	for _, k := range c.Auth.Keys {
+		if k == "" {
+			errs = append(errs, "auth.keys must not contain an empty key")
+			break
+		}
+	}
+
+	if c.RateLimit.Enabled {
+		if !contains(rateLimitStores, c.RateLimit.Store) {
+			errs = append(errs, fmt.Sprintf("rate_limit.store %q must be one of %s", c.RateLimit.Store, strings.Join(rateLimitStores, ", ")))
+		}
+		if !c.RateLimit.Default.valid() {
+			errs = append(errs, "rate_limit.default must have a positive rate and burst")
+		}
+		if !c.RateLimit.IP.valid() {
+			errs = append(errs, "rate_limit.ip must have a positive rate and burst")
+		}
+		for route, l := range c.RateLimit.Routes {
+			if !l.valid() {
+				errs = append(errs, fmt.Sprintf("rate_limit.routes[%q] must have a positive rate and burst", route))
+			}
+		}
+	}
+
+	switch c.Storage.Driver {
+	case "local":
+		if c.Storage.Dir == "" {
+			errs = append(errs, "storage.dir is required for the local driver")
+		}
+	case "s3":
+		s3 := c.Storage.S3
+		if s3.Endpoint == "" || s3.Bucket == "" || s3.Region == "" || s3.AccessKey == "" || s3.SecretKey == "" {
+			errs = append(errs, "storage.s3 needs endpoint, bucket, region, access_key and secret_key")
+		}
+		if _, err := url.Parse(s3.Endpoint); err != nil {
+			errs = append(errs, fmt.Sprintf("storage.s3.endpoint %q is not a URL", s3.Endpoint))
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("storage.driver %q must be one of %s", c.Storage.Driver, strings.Join(storageDrivers, ", ")))
+	}
+	if c.Attachments.MaxSize <= 0 {
+		errs = append(errs, "attachments.max_size must be positive")
+	}
+	if len(c.Attachments.Types) == 0 {
+		errs = append(errs, "attachments.types must contain at least one content type")
+	}
+
+	if c.Webhooks.Enabled {
+		if c.Webhooks.PollInterval <= 0 || c.Webhooks.Timeout <= 0 || c.Webhooks.Backoff <= 0 {
+			errs = append(errs, "webhooks.poll_interval, timeout and backoff must be positive")
+		}
+		if c.Webhooks.MaxAttempts < 1 {
+			errs = append(errs, "webhooks.max_attempts must be at least 1")
+		}
+	}
+
+	if c.Duplicates.WindowDays < 0 {
+		errs = append(errs, "duplicates.window_days must not be negative")
+	}
+	if c.Duplicates.Similarity <= 0 || c.Duplicates.Similarity > 1 {
+		errs = append(errs, "duplicates.similarity must be above 0 and at most 1")
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errors.New("invalid config: " + strings.Join(errs, "; "))
+}
+
+// Redacted returns a copy of c that is safe to print or log.
+func (c Config) Redacted() Config {
+	c.Database.URL = redactDSN(c.Database.URL)
+
+	keys := make([]string, len(c.Auth.Keys))
+	for i := range keys {
+		keys[i] = redacted
+	}
+	c.Auth.Keys = keys
+	if c.Storage.S3.SecretKey != "" {
+		c.Storage.S3.SecretKey = redacted
+	}
+	return c
+}
+
+// dsnPassword matches the password settings of a key=value connection
+// string, their value bare or single-quoted.
+var dsnPassword = regexp.MustCompile(`(?i)\b(password|sslpassword)\s*=\s*('(?:[^'\\]|\\.)*'|\S*)`)
+
+// redactDSN masks the passwords of a connection string, a URL or key=value
+// settings, in the userinfo and in the password and sslpassword parameters.
+func redactDSN(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err != nil || u.Scheme == "" {
+		return dsnPassword.ReplaceAllString(dsn, "$1=xxxxx")
+	}
+	q := u.Query()
+	for k := range q {
+		if l := strings.ToLower(k); l == "password" || l == "sslpassword" {
+			q[k] = []string{"xxxxx"}
+		}
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = q.Encode()
+	}
+	return u.Redacted()
+}
+
+func (l Limit) valid() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,293 @@
+package config
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Options are command-line switches that control loading rather than the
+// server itself.
+type Options struct {
+	File        string
+	PrintConfig bool
+}
+
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	set   func(c *Config, v string) error
+}
+
+var settings = []setting{
+	{"PORT", "port", "address the API listens on, e.g. :2565", func(c *Config, v string) error {
+		c.Port = v
+		return nil
+	}},
+	{"LOG_LEVEL", "log-level", "log level: debug, info, warn, error or off", func(c *Config, v string) error {
+		c.LogLevel = strings.ToLower(v)
+		return nil
+	}},
+	{"DATABASE_URL", "database-url", "PostgreSQL connection URL", func(c *Config, v string) error {
+		c.Database.URL = v
+		return nil
+	}},
+	{"DB_MAX_OPEN_CONNS", "db-max-open-conns", "maximum open database connections (0 is unlimited)", func(c *Config, v string) error {
+		return setInt(&c.Database.MaxOpenConns, v)
+	}},
+	{"DB_MAX_IDLE_CONNS", "db-max-idle-conns", "maximum idle database connections", func(c *Config, v string) error {
+		return setInt(&c.Database.MaxIdleConns, v)
+	}},
+	{"DB_CONN_MAX_LIFETIME", "db-conn-max-lifetime", "maximum lifetime of a database connection", func(c *Config, v string) error {
+		return setDuration(&c.Database.ConnMaxLifetime, v)
+	}},
+	{"DB_CONNECT_TIMEOUT", "db-connect-timeout", "how long to retry the database at startup", func(c *Config, v string) error {
+		return setDuration(&c.Database.ConnectTimeout, v)
+	}},
+	{"DB_AUTO_MIGRATE", "db-auto-migrate", "apply pending migrations when the server starts", func(c *Config, v string) error {
+		return setBool(&c.Database.AutoMigrate, v)
+	}},
+	{"READ_TIMEOUT", "read-timeout", "HTTP server read timeout", func(c *Config, v string) error {
+		return setDuration(&c.Server.ReadTimeout, v)
+	}},
+	{"WRITE_TIMEOUT", "write-timeout", "HTTP server write timeout", func(c *Config, v string) error {
+		return setDuration(&c.Server.WriteTimeout, v)
+	}},
+	{"SHUTDOWN_TIMEOUT", "shutdown-timeout", "graceful shutdown timeout", func(c *Config, v string) error {
+		return setDuration(&c.Server.ShutdownTimeout, v)
+	}},
+	{"STREAM_TIMEOUT", "stream-timeout", "how long an event stream lasts before its client reconnects", func(c *Config, v string) error {
+		return setDuration(&c.Server.StreamTimeout, v)
+	}},
+	{"AUTH_KEYS", "auth-keys", "comma separated authorization keys, quote keys that contain commas", func(c *Config, v string) error {
+		return setList(&c.Auth.Keys, v)
+	}},
+	{"RATE_LIMIT_ENABLED", "rate-limit-enabled", "limit requests per client", func(c *Config, v string) error {
+		return setBool(&c.RateLimit.Enabled, v)
+	}},
+	{"RATE_LIMIT_STORE", "rate-limit-store", "rate limit storage: memory or postgres", func(c *Config, v string) error {
+		c.RateLimit.Store = strings.ToLower(v)
+		return nil
+	}},
+	{"RATE_LIMIT_RATE", "rate-limit-rate", "default requests per second per client", func(c *Config, v string) error {
+		return setFloat(&c.RateLimit.Default.Rate, v)
+	}},
+	{"RATE_LIMIT_BURST", "rate-limit-burst", "default burst size per client", func(c *Config, v string) error {
+		return setInt(&c.RateLimit.Default.Burst, v)
+	}},
+	{"RATE_LIMIT_IP_RATE", "rate-limit-ip-rate", "requests per second per client IP, before authentication", func(c *Config, v string) error {
+		return setFloat(&c.RateLimit.IP.Rate, v)
+	}},
+	{"RATE_LIMIT_IP_BURST", "rate-limit-ip-burst", "burst size per client IP, before authentication", func(c *Config, v string) error {
+		return setInt(&c.RateLimit.IP.Burst, v)
+	}},
+	{"RATE_LIMIT_TRUST_PROXY", "rate-limit-trust-proxy", "take the client IP from X-Forwarded-For", func(c *Config, v string) error {
+		return setBool(&c.RateLimit.TrustProxy, v)
+	}},
+	{"STORAGE_DRIVER", "storage-driver", "attachment storage: local or s3", func(c *Config, v string) error {
+		c.Storage.Driver = strings.ToLower(v)
+		return nil
+	}},
+	{"STORAGE_DIR", "storage-dir", "directory of the local attachment storage", func(c *Config, v string) error {
+		c.Storage.Dir = v
+		return nil
+	}},
+	{"S3_ENDPOINT", "s3-endpoint", "S3-compatible endpoint URL, e.g. https://s3.us-east-1.amazonaws.com", func(c *Config, v string) error {
+		c.Storage.S3.Endpoint = v
+		return nil
+	}},
+	{"S3_BUCKET", "s3-bucket", "S3 bucket for attachments", func(c *Config, v string) error {
+		c.Storage.S3.Bucket = v
+		return nil
+	}},
+	{"S3_REGION", "s3-region", "S3 region used to sign requests", func(c *Config, v string) error {
+		c.Storage.S3.Region = v
+		return nil
+	}},
+	{"S3_ACCESS_KEY", "s3-access-key", "S3 access key id", func(c *Config, v string) error {
+		c.Storage.S3.AccessKey = v
+		return nil
+	}},
+	{"S3_SECRET_KEY", "s3-secret-key", "S3 secret access key", func(c *Config, v string) error {
+		c.Storage.S3.SecretKey = v
+		return nil
+	}},
+	{"ATTACHMENT_MAX_SIZE", "attachment-max-size", "largest attachment upload in bytes", func(c *Config, v string) error {
+		return setInt(&c.Attachments.MaxSize, v)
+	}},
+	{"ATTACHMENT_TYPES", "attachment-types", "comma separated content types allowed for attachments", func(c *Config, v string) error {
+		return setList(&c.Attachments.Types, v)
+	}},
+	{"WEBHOOKS_ENABLED", "webhooks-enabled", "deliver webhooks from this instance", func(c *Config, v string) error {
+		return setBool(&c.Webhooks.Enabled, v)
+	}},
+	{"WEBHOOK_POLL_INTERVAL", "webhook-poll-interval", "how often the dispatcher looks for due deliveries", func(c *Config, v string) error {
+		return setDuration(&c.Webhooks.PollInterval, v)
+	}},
+	{"WEBHOOK_TIMEOUT", "webhook-timeout", "timeout of one webhook delivery", func(c *Config, v string) error {
+		return setDuration(&c.Webhooks.Timeout, v)
+	}},
+	{"WEBHOOK_MAX_ATTEMPTS", "webhook-max-attempts", "deliveries before a webhook event is dead-lettered", func(c *Config, v string) error {
+		return setInt(&c.Webhooks.MaxAttempts, v)
+	}},
+	{"WEBHOOK_BACKOFF", "webhook-backoff", "delay before the first retry, doubled for each further retry", func(c *Config, v string) error {
+		return setDuration(&c.Webhooks.Backoff, v)
+	}},
+	{"DUPLICATES_WARN", "duplicates-warn", "reject new expenses that may duplicate one unless allow_duplicate is set", func(c *Config, v string) error {
+		return setBool(&c.Duplicates.Warn, v)
+	}},
+	{"DUPLICATE_WINDOW_DAYS", "duplicate-window-days", "most days apart two duplicate expenses are spent", func(c *Config, v string) error {
+		return setInt(&c.Duplicates.WindowDays, v)
+	}},
+	{"DUPLICATE_SIMILARITY", "duplicate-similarity", "least title similarity of two duplicate expenses, from 0 to 1", func(c *Config, v string) error {
+		return setFloat(&c.Duplicates.Similarity, v)
+	}},
+	{"CORS_ALLOW_ORIGINS", "cors-allow-origins", "comma separated origins allowed by CORS", func(c *Config, v string) error {
+		return setList(&c.CORS.AllowOrigins, v)
+	}},
+}
+
+// Load builds the configuration from, in increasing order of precedence,
+// defaults, the config file, environment variables and command-line flags.
+// The file is taken from --config or CONFIG_FILE. The config flags are added to
+// fs, so callers can register their own flags on it first.
+func Load(fs *flag.FlagSet, args []string) (*Config, Options, error) {
+	return load(fs, args, os.LookupEnv)
+}
+
+func load(fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) (*Config, Options, error) {
+	var opts Options
+	type flagValue struct {
+		s setting
+		v string
+	}
+	var flags []flagValue
+
+	fs.StringVar(&opts.File, "config", "", "path to a YAML or TOML config file")
+	fs.BoolVar(&opts.PrintConfig, "print-config", false, "print the effective config with secrets redacted and exit")
+	for _, s := range settings {
+		s := s
+		fs.Func(s.flag, s.usage+" (env "+s.env+")", func(v string) error {
+			flags = append(flags, flagValue{s, v})
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, opts, err
+	}
+	if opts.File == "" {
+		opts.File, _ = lookupEnv("CONFIG_FILE")
+	}
+
+	cfg := Default()
+	if opts.File != "" {
+		if err := loadFile(&cfg, opts.File); err != nil {
+			return nil, opts, err
+		}
+	}
+	for _, s := range settings {
+		if v, ok := lookupEnv(s.env); ok {
+			if err := s.set(&cfg, v); err != nil {
+				return nil, opts, fmt.Errorf("env %s: %w", s.env, err)
+			}
+		}
+	}
+	for _, f := range flags {
+		if err := f.s.set(&cfg, f.v); err != nil {
+			return nil, opts, fmt.Errorf("flag --%s: %w", f.s.flag, err)
+		}
+	}
+
+	return &cfg, opts, nil
+}
+
+func loadFile(cfg *Config, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, cfg)
+	case ".toml":
+		err = toml.Unmarshal(b, cfg)
+	default:
+		return fmt.Errorf("unsupported config file extension %q, use .yaml, .yml or .toml", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Write prints cfg as YAML, the same shape accepted by the config file.
+func Write(w io.Writer, cfg Config) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(cfg); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func setInt(dst *int, v string) error {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("invalid integer %q", v)
+	}
+	*dst = n
+	return nil
+}
+
+func setFloat(dst *float64, v string) error {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return fmt.Errorf("invalid number %q", v)
+	}
+	*dst = f
+	return nil
+}
+
+func setBool(dst *bool, v string) error {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("invalid boolean %q", v)
+	}
+	*dst = b
+	return nil
+}
+
+func setDuration(dst *time.Duration, v string) error {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", v)
+	}
+	*dst = d
+	return nil
+}
+
+func setList(dst *[]string, v string) error {
+	if strings.TrimSpace(v) == "" {
+		*dst = nil
+		return nil
+	}
+	r := csv.NewReader(strings.NewReader(v))
+	r.TrimLeadingSpace = true
+	list, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("invalid list %q", v)
+	}
+	*dst = list
+	return nil
+}
@@ -0,0 +1,106 @@
+// Package date provides a calendar day without a time or location, for
+// Postgres DATE columns and YYYY-MM-DD JSON fields.
+package date
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Layout is the ISO 8601 form of a Date.
+const Layout = "2006-01-02"
+
+// Date is a calendar day. The zero Date is unset: it encodes as JSON null and
+// is stored as NULL.
+type Date struct {
+	t time.Time
+}
+
+// Of returns the day of t in t's location.
+func Of(t time.Time) Date {
+	y, m, d := t.Date()
+	return Date{time.Date(y, m, d, 0, 0, 0, 0, time.UTC)}
+}
+
+// Today returns the current day in the local time zone.
+func Today() Date {
+	return Of(time.Now())
+}
+
+// Parse reads a YYYY-MM-DD day.
+func Parse(s string) (Date, error) {
+	t, err := time.Parse(Layout, s)
+	if err != nil {
+		return Date{}, fmt.Errorf("%q is not a YYYY-MM-DD date", s)
+	}
+	return Date{t}, nil
+}
+
+func (d Date) IsZero() bool {
+	return d.t.IsZero()
+}
+
+func (d Date) Time() time.Time {
+	return d.t
+}
+
+func (d Date) String() string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.t.Format(Layout)
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	if d.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(d.String())
+}
+
+func (d *Date) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*d = Date{}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("date must be a YYYY-MM-DD string")
+	}
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+// Scan reads a DATE column.
+func (d *Date) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*d = Date{}
+	case time.Time:
+		*d = Of(v)
+	case []byte:
+		return d.Scan(string(v))
+	case string:
+		p, err := Parse(v)
+		if err != nil {
+			return err
+		}
+		*d = p
+	default:
+		return fmt.Errorf("can't scan %T into a date", src)
+	}
+	return nil
+}
+
+func (d Date) Value() (driver.Value, error) {
+	if d.IsZero() {
+		return nil, nil
+	}
+	return d.String(), nil
+}
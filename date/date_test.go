@@ -0,0 +1,51 @@
+package date
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDate(t *testing.T) {
+	t.Run("should round-trip through JSON", func(t *testing.T) {
+		var v struct {
+			On   Date `json:"on"`
+			Till Date `json:"till"`
+		}
+
+		err := json.Unmarshal([]byte(`{"on": "2023-01-02", "till": null}`), &v)
+		b, _ := json.Marshal(v)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "2023-01-02", v.On.String())
+		assert.True(t, v.Till.IsZero())
+		assert.JSONEq(t, `{"on": "2023-01-02", "till": null}`, string(b))
+	})
+
+	t.Run("should reject other layouts", func(t *testing.T) {
+		var d Date
+
+		assert.Error(t, json.Unmarshal([]byte(`"02/01/2023"`), &d))
+		assert.Error(t, json.Unmarshal([]byte(`20230102`), &d))
+	})
+
+	t.Run("should scan the day of a timestamp in its location", func(t *testing.T) {
+		var d Date
+		bangkok := time.FixedZone("ICT", 7*60*60)
+
+		err := d.Scan(time.Date(2023, 1, 2, 23, 30, 0, 0, bangkok))
+		v, _ := d.Value()
+
+		assert.NoError(t, err)
+		assert.Equal(t, "2023-01-02", v)
+	})
+
+	t.Run("should store the zero date as NULL", func(t *testing.T) {
+		v, err := Date{}.Value()
+
+		assert.NoError(t, err)
+		assert.Nil(t, v)
+	})
+}
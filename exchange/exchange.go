@@ -0,0 +1,119 @@
+// Package exchange keeps the daily exchange rates used to report expenses
+// recorded in several currencies in a single one.
+package exchange
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/panudetjt/assessment/date"
+	"github.com/panudetjt/assessment/problem"
+)
+
+// Rate is the price of one unit of Base in Quote on Date.
+type Rate struct {
+	Date  date.Date `json:"date"`
+	Base  string    `json:"base"`
+	Quote string    `json:"quote"`
+	Rate  float64   `json:"rate"`
+}
+
+// ValidCurrency reports whether code has the shape of an ISO 4217 code:
+// three upper-case letters.
+func ValidCurrency(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// validate reports every invalid field of r, named after field.
+func (r Rate) validate(field string) []problem.FieldError {
+	var errs []problem.FieldError
+	if r.Date.IsZero() {
+		errs = append(errs, problem.FieldError{Field: field + ".date", Message: "is required"})
+	}
+	if !ValidCurrency(r.Base) {
+		errs = append(errs, problem.FieldError{Field: field + ".base", Message: "must be a 3-letter currency code"})
+	}
+	if !ValidCurrency(r.Quote) {
+		errs = append(errs, problem.FieldError{Field: field + ".quote", Message: "must be a 3-letter currency code"})
+	} else if r.Quote == r.Base {
+		errs = append(errs, problem.FieldError{Field: field + ".quote", Message: "must differ from base"})
+	}
+	if !(r.Rate > 0) || math.IsInf(r.Rate, 0) {
+		errs = append(errs, problem.FieldError{Field: field + ".rate", Message: "must be greater than 0"})
+	}
+	return errs
+}
+
+// normalize upper-cases the currency codes of r.
+func (r *Rate) normalize() {
+	r.Base = strings.ToUpper(strings.TrimSpace(r.Base))
+	r.Quote = strings.ToUpper(strings.TrimSpace(r.Quote))
+}
+
+var csvColumns = []string{"date", "base", "quote", "rate"}
+
+// ParseCSV reads rates from CSV with a date, base, quote and rate header, in
+// any order; other columns are ignored.
+func ParseCSV(r io.Reader) ([]Rate, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("the file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	index := map[string]int{}
+	for i, h := range header {
+		index[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, c := range csvColumns {
+		if _, ok := index[c]; !ok {
+			return nil, fmt.Errorf("the header has no %s column", c)
+		}
+	}
+
+	var rates []Rate
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return rates, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var rt Rate
+		if rt.Date, err = date.Parse(strings.TrimSpace(rec[index["date"]])); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if rt.Rate, err = strconv.ParseFloat(strings.TrimSpace(rec[index["rate"]]), 64); err != nil {
+			return nil, fmt.Errorf("line %d: rate must be a number", line)
+		}
+		rt.Base, rt.Quote = rec[index["base"]], rec[index["quote"]]
+		rates = append(rates, rt)
+	}
+}
+
+// ParseJSON reads rates from a JSON array of rates.
+func ParseJSON(r io.Reader) ([]Rate, error) {
+	var rates []Rate
+	if err := json.NewDecoder(r).Decode(&rates); err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
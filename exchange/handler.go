@@ -0,0 +1,145 @@
+package exchange
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/date"
+	"github.com/panudetjt/assessment/problem"
+)
+
+type Handler struct {
+	DB *sql.DB
+}
+
+// ImportResult is the response of POST /exchange-rates.
+type ImportResult struct {
+	Imported int `json:"imported"`
+}
+
+const (
+	maxImport   = 10000
+	maxListSize = 1000
+)
+
+// ImportHandler stores the rates of a CSV or JSON rate file, replacing the
+// rate of a day already known. Rates are global, so only admins import them.
+func (h *Handler) ImportHandler(c echo.Context) error {
+	ct, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	var parse func(io.Reader) ([]Rate, error)
+	switch ct {
+	case "text/csv":
+		parse = ParseCSV
+	case echo.MIMEApplicationJSON:
+		parse = ParseJSON
+	default:
+		return problem.New(http.StatusUnsupportedMediaType, problem.CodeUnsupportedMedia, "exchange rates must be text/csv or application/json")
+	}
+	rates, err := parse(c.Request().Body)
+	if err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "can't read exchange rates: "+err.Error())
+	}
+	if len(rates) == 0 {
+		return problem.Validation(problem.FieldError{Field: "rates", Message: "must not be empty"})
+	}
+	if len(rates) > maxImport {
+		return problem.Validation(problem.FieldError{Field: "rates", Message: fmt.Sprintf("must be at most %d per import", maxImport)})
+	}
+
+	// A day's rate given twice keeps the last one: Postgres rejects an upsert
+	// touching the same row twice.
+	type key struct {
+		date        date.Date
+		base, quote string
+	}
+	seen := map[key]int{}
+	var errs []problem.FieldError
+	var dates, bases, quotes []string
+	var values []float64
+	for i := range rates {
+		r := &rates[i]
+		r.normalize()
+		errs = append(errs, r.validate(fmt.Sprintf("rates[%d]", i))...)
+		k := key{r.Date, r.Base, r.Quote}
+		if j, ok := seen[k]; ok {
+			values[j] = r.Rate
+			continue
+		}
+		seen[k] = len(dates)
+		dates, bases, quotes, values = append(dates, r.Date.String()), append(bases, r.Base), append(quotes, r.Quote), append(values, r.Rate)
+	}
+	if errs != nil {
+		return problem.Validation(errs...)
+	}
+
+	_, err = h.DB.ExecContext(c.Request().Context(),
+		"INSERT INTO exchange_rates (date, base, quote, rate) SELECT * FROM unnest($1::date[], $2::text[], $3::text[], $4::float8[]) "+
+			"ON CONFLICT (date, base, quote) DO UPDATE SET rate = EXCLUDED.rate",
+		pq.Array(dates), pq.Array(bases), pq.Array(quotes), pq.Array(values))
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't import exchange rates: %w", err))
+	}
+	return c.JSON(http.StatusOK, ImportResult{Imported: len(dates)})
+}
+
+// ListHandler lists rates newest first, filtered by base, quote and a from
+// and to day, both inclusive.
+func (h *Handler) ListHandler(c echo.Context) error {
+	var conds []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	for _, col := range []string{"base", "quote"} {
+		v := strings.ToUpper(c.QueryParam(col))
+		if v == "" {
+			continue
+		}
+		if !ValidCurrency(v) {
+			return problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, col+" must be a 3-letter currency code")
+		}
+		conds = append(conds, col+" = "+arg(v))
+	}
+	for _, p := range []struct{ name, op string }{{"from", ">="}, {"to", "<="}} {
+		v := c.QueryParam(p.name)
+		if v == "" {
+			continue
+		}
+		d, err := date.Parse(v)
+		if err != nil {
+			return problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, p.name+" must be a YYYY-MM-DD date")
+		}
+		conds = append(conds, "date "+p.op+" "+arg(d))
+	}
+	query := "SELECT date, base, quote, rate FROM exchange_rates"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY date DESC, base, quote LIMIT %d", maxListSize)
+
+	rows, err := h.DB.QueryContext(c.Request().Context(), query, args...)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't query exchange rates: %w", err))
+	}
+	defer rows.Close()
+	rates := []Rate{}
+	for rows.Next() {
+		var r Rate
+		if err := rows.Scan(&r.Date, &r.Base, &r.Quote, &r.Rate); err != nil {
+			return problem.Internal(fmt.Errorf("can't scan exchange rate: %w", err))
+		}
+		rates = append(rates, r)
+	}
+	if err := rows.Err(); err != nil {
+		return problem.Internal(fmt.Errorf("can't iterate exchange rates: %w", err))
+	}
+	return c.JSON(http.StatusOK, rates)
+}
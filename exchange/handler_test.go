@@ -0,0 +1,123 @@
+package exchange
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/problem"
+	"github.com/panudetjt/assessment/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func importRequest(contentType, body string) *util.Response {
+	res := util.RequestE(http.MethodPost, "/exchange-rates", strings.NewReader(body))
+	res.Context.Request().Header.Set(echo.HeaderContentType, contentType)
+	return res
+}
+
+func TestImportHandler(t *testing.T) {
+	t.Run("should upsert the rates of a CSV file, keeping the last of a day", func(t *testing.T) {
+		res := importRequest("text/csv; charset=utf-8", "base,quote,date,rate,source\njpy,thb,2023-01-02,0.26,bot\nUSD,THB,2023-01-02,34.5,bot\nJPY,THB,2023-01-02,0.27,bot\n")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectExec("INSERT INTO exchange_rates \\(date, base, quote, rate\\) SELECT \\* FROM unnest(.+) ON CONFLICT \\(date, base, quote\\) DO UPDATE").
+			WithArgs(pq.Array([]string{"2023-01-02", "2023-01-02"}), pq.Array([]string{"JPY", "USD"}), pq.Array([]string{"THB", "THB"}), pq.Array([]float64{0.27, 34.5})).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		h := Handler{DB: db}
+
+		res.Serve(h.ImportHandler)
+		var got ImportResult
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Equal(t, 2, got.Imported)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should import a JSON array", func(t *testing.T) {
+		res := importRequest(echo.MIMEApplicationJSON, `[{"date": "2023-01-02", "base": "USD", "quote": "THB", "rate": 34.5}]`)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectExec("INSERT INTO exchange_rates").WillReturnResult(sqlmock.NewResult(0, 1))
+		h := Handler{DB: db}
+
+		res.Serve(h.ImportHandler)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 422 (UnprocessableEntity) naming every invalid rate", func(t *testing.T) {
+		res := importRequest(echo.MIMEApplicationJSON, `[{"date": "2023-01-02", "base": "USD", "quote": "usd", "rate": 1}, {"base": "Baht", "quote": "THB", "rate": 0}]`)
+		db, mock, _ := sqlmock.New()
+		h := Handler{DB: db}
+
+		res.Serve(h.ImportHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, res.Recorder.Code)
+		fields := make([]string, len(p.Errors))
+		for i, e := range p.Errors {
+			fields[i] = e.Field
+		}
+		assert.Equal(t, []string{"rates[0].quote", "rates[1].date", "rates[1].base", "rates[1].rate"}, fields)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 400 (BadRequest) when the CSV header lacks a column", func(t *testing.T) {
+		res := importRequest("text/csv", "date,base,rate\n2023-01-02,USD,34.5\n")
+		h := Handler{}
+
+		res.Serve(h.ImportHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusBadRequest, res.Recorder.Code)
+		assert.Contains(t, p.Detail, "no quote column")
+	})
+
+	t.Run("should return 415 (UnsupportedMediaType) for other files", func(t *testing.T) {
+		res := importRequest("application/xml", "<rates/>")
+		h := Handler{}
+
+		res.Serve(h.ImportHandler)
+
+		assert.Equal(t, http.StatusUnsupportedMediaType, res.Recorder.Code)
+	})
+}
+
+func TestListHandler(t *testing.T) {
+	t.Run("should filter by currencies and days", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/exchange-rates?base=usd&from=2023-01-01&to=2023-01-31", nil)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery("SELECT date, base, quote, rate FROM exchange_rates WHERE base = \\$1 AND date >= \\$2 AND date <= \\$3 ORDER BY date DESC").
+			WithArgs("USD", "2023-01-01", "2023-01-31").
+			WillReturnRows(sqlmock.NewRows([]string{"date", "base", "quote", "rate"}).AddRow("2023-01-02", "USD", "THB", 34.5))
+		h := Handler{DB: db}
+
+		res.Serve(h.ListHandler)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.JSONEq(t, `[{"date": "2023-01-02", "base": "USD", "quote": "THB", "rate": 34.5}]`, res.Recorder.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 400 (BadRequest) for an invalid day", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/exchange-rates?from=yesterday", nil)
+		h := Handler{}
+
+		res.Serve(h.ListHandler)
+
+		assert.Equal(t, http.StatusBadRequest, res.Recorder.Code)
+	})
+}
+
+func TestValidCurrency(t *testing.T) {
+	assert.True(t, ValidCurrency("THB"))
+	assert.False(t, ValidCurrency("thb"))
+	assert.False(t, ValidCurrency("BAHT"))
+	assert.False(t, ValidCurrency("฿"))
+}
@@ -0,0 +1,87 @@
+package expense
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/panudetjt/assessment/ledger"
+	m "github.com/panudetjt/assessment/middleware"
+	"github.com/panudetjt/assessment/problem"
+)
+
+// access fails unless the caller has at least min in the ledger of expense
+// id. Callers outside the ledger get 404 so they can't probe for ids. Admins
+// pass without a query.
+func (h *Handler) access(c echo.Context, id int, min ledger.Role) error {
+	if ledger.IsAdmin(c) {
+		return nil
+	}
+	stmt, err := h.prepare("SELECT m.role FROM expenses e JOIN ledger_members m ON m.ledger_id = e.ledger_id WHERE e.id = $1 AND m.subject = $2")
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't prepare expense role statment: %w", err))
+	}
+
+	ctx := c.Request().Context()
+	var role ledger.Role
+	err = retryRead(ctx, func() error {
+		return stmt.QueryRowContext(ctx, id, m.Subject(c)).Scan(&role)
+	})
+	if err != nil && err != sql.ErrNoRows {
+		return problem.Internal(fmt.Errorf("can't query expense role: %w", err))
+	}
+	return ledger.Require(role, min, func() error { return expenseNotFound(id) })
+}
+
+// accessLedger fails unless the caller has at least min in ledger id.
+func (h *Handler) accessLedger(c echo.Context, id int, min ledger.Role) error {
+	if ledger.IsAdmin(c) {
+		return nil
+	}
+	role, err := ledger.RoleOf(c.Request().Context(), h.DB, id, m.Subject(c))
+	if err != nil {
+		return problem.Internal(err)
+	}
+	return ledger.Require(role, min, func() error {
+		return problem.New(http.StatusNotFound, problem.CodeLedgerNotFound, fmt.Sprintf("ledger %d does not exist", id))
+	})
+}
+
+// accessNew fails unless the caller may add to ledger id, or keep the new
+// resource outside any ledger when id is nil, which only admins may.
+func (h *Handler) accessNew(c echo.Context, id *int) error {
+	switch {
+	case id != nil:
+		return h.accessLedger(c, *id, ledger.Editor)
+	case !ledger.IsAdmin(c):
+		return problem.Validation(problem.FieldError{Field: "ledger_id", Message: "is required"})
+	}
+	return nil
+}
+
+// accessRow is access for the other ledger-scoped tables, merchants and
+// payment_methods, answering missing when the caller isn't a member.
+func (h *Handler) accessRow(c echo.Context, table string, id int, min ledger.Role, missing func() error) error {
+	if ledger.IsAdmin(c) {
+		return nil
+	}
+	stmt, err := h.prepare("SELECT m.role FROM " + table + " x JOIN ledger_members m ON m.ledger_id = x.ledger_id WHERE x.id = $1 AND m.subject = $2")
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't prepare %s role statment: %w", table, err))
+	}
+
+	ctx := c.Request().Context()
+	var role ledger.Role
+	err = retryRead(ctx, func() error {
+		return stmt.QueryRowContext(ctx, id, m.Subject(c)).Scan(&role)
+	})
+	if err != nil && err != sql.ErrNoRows {
+		return problem.Internal(fmt.Errorf("can't query %s role: %w", table, err))
+	}
+	return ledger.Require(role, min, missing)
+}
+
+func expenseNotFound(id int) error {
+	return problem.New(http.StatusNotFound, problem.CodeExpenseNotFound, fmt.Sprintf("expense %d does not exist", id))
+}
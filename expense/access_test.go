@@ -0,0 +1,144 @@
+package expense
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/date"
+	m "github.com/panudetjt/assessment/middleware"
+	"github.com/panudetjt/assessment/problem"
+	"github.com/panudetjt/assessment/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// asMember makes res a request from a non-admin key with subject.
+func asMember(res *util.Response, subject string) *util.Response {
+	m.SetSubject(res.Context, subject)
+	m.SetScopes(res.Context, m.Scopes)
+	return res
+}
+
+func TestLedgerAccess(t *testing.T) {
+	roleQuery := "SELECT m.role FROM expenses e JOIN ledger_members m ON m.ledger_id = e.ledger_id WHERE e.id = \\$1 AND m.subject = \\$2"
+	byID := func(method string, body io.Reader) *util.Response {
+		res := util.RequestE(method, "/expenses/1", body)
+		res.Context.SetParamNames("id")
+		res.Context.SetParamValues("1")
+		return asMember(res, "apikey:2")
+	}
+
+	t.Run("should return 404 (NotFound) when the caller isn't in the expense's ledger", func(t *testing.T) {
+		res := byID(http.MethodGet, nil)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectPrepare(roleQuery).ExpectQuery().WithArgs(1, "apikey:2").WillReturnRows(sqlmock.NewRows([]string{"role"}))
+		h := Handler{DB: db}
+
+		res.Serve(h.GetExpenseByIdHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusNotFound, res.Recorder.Code)
+		assert.Equal(t, problem.CodeExpenseNotFound, p.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should let viewers read", func(t *testing.T) {
+		res := byID(http.MethodGet, nil)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectPrepare(roleQuery).ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("viewer"))
+		mock.ExpectPrepare("SELECT id, title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id, status FROM expenses WHERE id = \\$1").ExpectQuery().
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "ledger_id", "split", "currency", "spent_on", "merchant_id", "payment_method_id", "category_id", "status"}).
+				AddRow(1, "rent", 100, "", pq.Array([]string{}), 3, nil, "THB", "2023-01-02", nil, nil, nil, "draft"))
+		h := Handler{DB: db}
+
+		res.Serve(h.GetExpenseByIdHandler)
+		var e Expense
+		res.Decode(&e)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Equal(t, 3, *e.LedgerID)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 403 (Forbidden) when a viewer deletes", func(t *testing.T) {
+		res := byID(http.MethodDelete, nil)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectPrepare(roleQuery).ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("viewer"))
+		h := Handler{DB: db}
+
+		res.Serve(h.DeleteExpenseHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusForbidden, res.Recorder.Code)
+		assert.Equal(t, problem.CodeInsufficientRole, p.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 403 (Forbidden) when a viewer updates", func(t *testing.T) {
+		res := byID(http.MethodPut, strings.NewReader(`{"title": "rent", "amount": 100}`))
+		db, mock, _ := sqlmock.New()
+		mock.ExpectPrepare(roleQuery).ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("viewer"))
+		h := Handler{DB: db}
+
+		res.Serve(h.UpdateExpensesHandler)
+
+		assert.Equal(t, http.StatusForbidden, res.Recorder.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should require a ledger when a member creates", func(t *testing.T) {
+		res := asMember(util.RequestE(http.MethodPost, "/expenses", strings.NewReader(`{"title": "rent", "amount": 100}`)), "apikey:2")
+		db, mock, _ := sqlmock.New()
+		h := Handler{DB: db}
+
+		res.Serve(h.CreateExpensesHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, res.Recorder.Code)
+		assert.Equal(t, "ledger_id", p.Errors[0].Field)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should let editors create in their ledger", func(t *testing.T) {
+		res := asMember(util.RequestE(http.MethodPost, "/expenses", strings.NewReader(`{"title": "rent", "amount": 100, "ledger_id": 3}`)), "apikey:2")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery("SELECT role FROM ledger_members WHERE ledger_id = \\$1 AND subject = \\$2").WithArgs(3, "apikey:2").
+			WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("editor"))
+		mock.ExpectBegin()
+		expectPeriods(mock)
+		expectRules(mock)
+		mock.ExpectQuery("INSERT INTO expenses").WithArgs("rent", 100.0, "", pq.Array([]string(nil)), 3, nil, "THB", date.Today(), nil, nil, nil).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+		mock.ExpectQuery("INSERT INTO expense_events").WithArgs(9, ActionCreated, "apikey:2", "", sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"version", "created_at"}).AddRow(1, time.Now()))
+		mock.ExpectExec("INSERT INTO webhook_outbox").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		h := Handler{DB: db}
+
+		res.Serve(h.CreateExpensesHandler)
+
+		assert.Equal(t, http.StatusCreated, res.Recorder.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should only list expenses of the caller's ledgers", func(t *testing.T) {
+		res := asMember(util.RequestE(http.MethodGet, "/expenses?ledger_id=3", nil), "apikey:2")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectPrepare("SELECT id, title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id, status FROM expenses WHERE ledger_id IN \\(SELECT ledger_id FROM ledger_members WHERE subject = \\$1\\) AND ledger_id = \\$2 AND deleted_at IS NULL ORDER BY id").
+			ExpectQuery().WithArgs("apikey:2", int64(3), sqlmock.AnyArg(), 0).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "ledger_id", "split", "currency", "spent_on", "merchant_id", "payment_method_id", "category_id", "status"}))
+		h := Handler{DB: db}
+
+		res.Serve(h.GetAllExpenseHandler)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
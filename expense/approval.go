@@ -0,0 +1,203 @@
+package expense
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/panudetjt/assessment/ledger"
+	m "github.com/panudetjt/assessment/middleware"
+	"github.com/panudetjt/assessment/problem"
+)
+
+// Statuses of an expense in the reimbursement workflow. A draft is submitted,
+// then approved or rejected by an approver; approved expenses are reimbursed
+// and rejected ones may be edited and submitted again.
+const (
+	StatusDraft      = "draft"
+	StatusSubmitted  = "submitted"
+	StatusApproved   = "approved"
+	StatusRejected   = "rejected"
+	StatusReimbursed = "reimbursed"
+)
+
+// statuses are the valid statuses, in workflow order.
+var statuses = []string{StatusDraft, StatusSubmitted, StatusApproved, StatusRejected, StatusReimbursed}
+
+// Transition is one status change of an expense, who made it and why.
+type Transition struct {
+	ID        int       `json:"id"`
+	ExpenseID int       `json:"expense_id"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Actor     string    `json:"actor"`
+	Comment   string    `json:"comment"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TransitionRequest is the body of the transition endpoints.
+type TransitionRequest struct {
+	Comment string `json:"comment"`
+}
+
+// maxComment bounds the length of a transition comment.
+const maxComment = 1000
+
+// step moves an expense in one of the statuses from to to, for callers with
+// at least role in its ledger. A step that notBySubmitter can't be taken by
+// whoever last submitted the expense.
+type step struct {
+	from           []string
+	to             string
+	role           ledger.Role
+	notBySubmitter bool
+}
+
+var (
+	submit    = step{from: []string{StatusDraft, StatusRejected}, to: StatusSubmitted, role: ledger.Editor}
+	approve   = step{from: []string{StatusSubmitted}, to: StatusApproved, role: ledger.Approver, notBySubmitter: true}
+	reject    = step{from: []string{StatusSubmitted}, to: StatusRejected, role: ledger.Approver}
+	reimburse = step{from: []string{StatusApproved}, to: StatusReimbursed, role: ledger.Approver, notBySubmitter: true}
+)
+
+// editable reports whether an expense in status may still be changed: once
+// submitted it is frozen until rejected.
+func editable(status string) bool {
+	return status == StatusDraft || status == StatusRejected
+}
+
+func notEditable(e Expense) error {
+	return problem.New(http.StatusConflict, problem.CodeNotEditable,
+		fmt.Sprintf("expense %d is %s, only draft or rejected expenses can be changed", e.ID, e.Status))
+}
+
+func (h *Handler) SubmitExpenseHandler(c echo.Context) error {
+	return h.transition(c, submit)
+}
+
+func (h *Handler) ApproveExpenseHandler(c echo.Context) error {
+	return h.transition(c, approve)
+}
+
+func (h *Handler) RejectExpenseHandler(c echo.Context) error {
+	return h.transition(c, reject)
+}
+
+func (h *Handler) ReimburseExpenseHandler(c echo.Context) error {
+	return h.transition(c, reimburse)
+}
+
+// transition moves an expense through s, recording the transition and a new
+// version of the expense.
+func (h *Handler) transition(c echo.Context, s step) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidID, "expense id must be an integer")
+	}
+	var r TransitionRequest
+	if err := c.Bind(&r); err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "request body must be a transition JSON object")
+	}
+	r.Comment = strings.TrimSpace(r.Comment)
+	if len(r.Comment) > maxComment {
+		return problem.Validation(problem.FieldError{Field: "comment", Message: fmt.Sprintf("must be at most %d characters", maxComment)})
+	}
+	if err := h.access(c, id, s.role); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	var e Expense
+	err = h.inTx(c, func(tx *sql.Tx) error {
+		var before Expense
+		err := tx.QueryRowContext(ctx, "SELECT "+columns+" FROM expenses WHERE id = $1 AND "+live+" FOR UPDATE", id).Scan(before.fields()...)
+		if err == sql.ErrNoRows {
+			return expenseNotFound(id)
+		}
+		if err != nil {
+			return problem.Internal(fmt.Errorf("can't lock expense: %w", err))
+		}
+		if !contains(s.from, before.Status) {
+			return problem.New(http.StatusConflict, problem.CodeInvalidTransition,
+				fmt.Sprintf("expense %d is %s, only %s expenses can be %s", id, before.Status, strings.Join(s.from, " or "), s.to))
+		}
+		if s.notBySubmitter {
+			var submitter string
+			err := tx.QueryRowContext(ctx,
+				"SELECT actor FROM expense_transitions WHERE expense_id = $1 AND to_status = $2 ORDER BY id DESC LIMIT 1", id, StatusSubmitted).Scan(&submitter)
+			if err != nil && err != sql.ErrNoRows {
+				return problem.Internal(fmt.Errorf("can't query submitter: %w", err))
+			}
+			if submitter == m.Subject(c) {
+				return problem.New(http.StatusForbidden, problem.CodeSelfApproval,
+					fmt.Sprintf("expense %d was submitted by the caller, another approver must move it to %s", id, s.to))
+			}
+		}
+		err = tx.QueryRowContext(ctx, "UPDATE expenses SET status = $2 WHERE id = $1 RETURNING "+columns, id, s.to).Scan(e.fields()...)
+		if err != nil {
+			return problem.Internal(fmt.Errorf("can't update expense status: %w", err))
+		}
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO expense_transitions (expense_id, from_status, to_status, actor, comment) VALUES ($1, $2, $3, $4, $5)",
+			id, before.Status, s.to, m.Subject(c), r.Comment)
+		if err != nil {
+			return problem.Internal(fmt.Errorf("can't insert transition: %w", err))
+		}
+		return record(c, tx, id, ActionUpdated, &before, &e)
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, e)
+}
+
+// TransitionsHandler lists the status changes of an expense, oldest first.
+func (h *Handler) TransitionsHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidID, "expense id must be an integer")
+	}
+	if err := h.access(c, id, ledger.Viewer); err != nil {
+		return err
+	}
+
+	stmt, err := h.prepare("SELECT id, expense_id, from_status, to_status, actor, comment, created_at FROM expense_transitions WHERE expense_id = $1 ORDER BY id")
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't prepare transitions statment: %w", err))
+	}
+	ctx := c.Request().Context()
+	var ts []Transition
+	err = retryRead(ctx, func() error {
+		ts = []Transition{}
+		rows, err := stmt.QueryContext(ctx, id)
+		if err != nil {
+			return fmt.Errorf("can't query transitions: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var t Transition
+			if err := rows.Scan(&t.ID, &t.ExpenseID, &t.From, &t.To, &t.Actor, &t.Comment, &t.CreatedAt); err != nil {
+				return fmt.Errorf("can't scan transition: %w", err)
+			}
+			ts = append(ts, t)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return problem.Internal(err)
+	}
+	return c.JSON(http.StatusOK, ts)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package expense
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	m "github.com/panudetjt/assessment/middleware"
+	"github.com/panudetjt/assessment/problem"
+	"github.com/panudetjt/assessment/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApprovals(t *testing.T) {
+	request := func(method, path, body string) *util.Response {
+		res := util.RequestE(method, path, strings.NewReader(body))
+		res.Context.SetParamNames("id")
+		res.Context.SetParamValues("1")
+		return res
+	}
+	row := func(status string) *sqlmock.Rows {
+		return sqlmock.NewRows(expenseColumns).AddRow(1, "hotel", 2400, "", pq.Array([]string{"work"}), 3, nil, "THB", "2023-01-02", nil, nil, nil, status)
+	}
+
+	t.Run("should submit a draft and record who did it and why", func(t *testing.T) {
+		res := request(http.MethodPost, "/expenses/1/submit", `{"comment": "conference in Chiang Mai"}`)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockExpense).WithArgs(1).WillReturnRows(row(StatusDraft))
+		mock.ExpectQuery("UPDATE expenses SET status = \\$2 WHERE id = \\$1 RETURNING "+columns).WithArgs(1, StatusSubmitted).
+			WillReturnRows(row(StatusSubmitted))
+		mock.ExpectExec("INSERT INTO expense_transitions \\(expense_id, from_status, to_status, actor, comment\\)").
+			WithArgs(1, StatusDraft, StatusSubmitted, sqlmock.AnyArg(), "conference in Chiang Mai").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		expectEvent(mock, ActionUpdated)
+		h := Handler{DB: db}
+
+		res.Serve(h.SubmitExpenseHandler)
+		var got Expense
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Equal(t, StatusSubmitted, got.Status)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 409 (Conflict) approving an expense not submitted", func(t *testing.T) {
+		res := request(http.MethodPost, "/expenses/1/approve", `{}`)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockExpense).WithArgs(1).WillReturnRows(row(StatusDraft))
+		mock.ExpectRollback()
+		h := Handler{DB: db}
+
+		res.Serve(h.ApproveExpenseHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusConflict, res.Recorder.Code)
+		assert.Equal(t, problem.CodeInvalidTransition, p.Code)
+		assert.Equal(t, "expense 1 is draft, only submitted expenses can be approved", p.Detail)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should approve an expense someone else submitted", func(t *testing.T) {
+		res := request(http.MethodPost, "/expenses/1/approve", `{}`)
+		m.SetSubject(res.Context, "apikey:5")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockExpense).WithArgs(1).WillReturnRows(row(StatusSubmitted))
+		mock.ExpectQuery("SELECT actor FROM expense_transitions WHERE expense_id = \\$1 AND to_status = \\$2 ORDER BY id DESC LIMIT 1").WithArgs(1, StatusSubmitted).
+			WillReturnRows(sqlmock.NewRows([]string{"actor"}).AddRow("apikey:2"))
+		mock.ExpectQuery("UPDATE expenses SET status = \\$2").WithArgs(1, StatusApproved).WillReturnRows(row(StatusApproved))
+		mock.ExpectExec("INSERT INTO expense_transitions").WithArgs(1, StatusSubmitted, StatusApproved, "apikey:5", "").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		expectEvent(mock, ActionUpdated)
+		h := Handler{DB: db}
+
+		res.Serve(h.ApproveExpenseHandler)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	for name, s := range map[string]struct {
+		handler func(*Handler) echo.HandlerFunc
+		status  string
+	}{
+		"approves":   {func(h *Handler) echo.HandlerFunc { return h.ApproveExpenseHandler }, StatusSubmitted},
+		"reimburses": {func(h *Handler) echo.HandlerFunc { return h.ReimburseExpenseHandler }, StatusApproved},
+	} {
+		t.Run("should return 403 (Forbidden) when the submitter "+name+" their own expense", func(t *testing.T) {
+			res := request(http.MethodPost, "/expenses/1/transition", `{}`)
+			m.SetSubject(res.Context, "apikey:2")
+			db, mock, _ := sqlmock.New()
+			mock.ExpectBegin()
+			mock.ExpectQuery(lockExpense).WithArgs(1).WillReturnRows(row(s.status))
+			mock.ExpectQuery("SELECT actor FROM expense_transitions").WithArgs(1, StatusSubmitted).
+				WillReturnRows(sqlmock.NewRows([]string{"actor"}).AddRow("apikey:2"))
+			mock.ExpectRollback()
+			h := &Handler{DB: db}
+
+			res.Serve(s.handler(h))
+			var p problem.Problem
+			res.Decode(&p)
+
+			assert.Equal(t, http.StatusForbidden, res.Recorder.Code)
+			assert.Equal(t, problem.CodeSelfApproval, p.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+
+	t.Run("should return 403 (Forbidden) when an editor approves", func(t *testing.T) {
+		res := asMember(request(http.MethodPost, "/expenses/1/approve", `{}`), "apikey:2")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectPrepare("SELECT m.role FROM expenses e JOIN ledger_members m").ExpectQuery().WithArgs(1, "apikey:2").
+			WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("editor"))
+		h := Handler{DB: db}
+
+		res.Serve(h.ApproveExpenseHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusForbidden, res.Recorder.Code)
+		assert.Equal(t, problem.CodeInsufficientRole, p.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 409 (Conflict) updating a submitted expense", func(t *testing.T) {
+		res := request(http.MethodPut, "/expenses/1", `{"title": "hotel", "amount": 3000}`)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockExpense).WithArgs(1).WillReturnRows(row(StatusSubmitted))
+		mock.ExpectRollback()
+		h := Handler{DB: db}
+
+		res.Serve(h.UpdateExpensesHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusConflict, res.Recorder.Code)
+		assert.Equal(t, problem.CodeNotEditable, p.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should list the transitions oldest first", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses/1/transitions", nil)
+		res.Context.SetParamNames("id")
+		res.Context.SetParamValues("1")
+		db, mock, _ := sqlmock.New()
+		now := time.Date(2023, 1, 3, 9, 0, 0, 0, time.UTC)
+		mock.ExpectPrepare("SELECT id, expense_id, from_status, to_status, actor, comment, created_at FROM expense_transitions WHERE expense_id = \\$1 ORDER BY id").
+			ExpectQuery().WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "expense_id", "from_status", "to_status", "actor", "comment", "created_at"}).
+				AddRow(1, 1, StatusDraft, StatusSubmitted, "apikey:2", "", now).
+				AddRow(2, 1, StatusSubmitted, StatusRejected, "apikey:5", "missing receipt", now))
+		h := Handler{DB: db}
+
+		res.Serve(h.TransitionsHandler)
+		var got []Transition
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Len(t, got, 2)
+		assert.Equal(t, "apikey:5", got[1].Actor)
+		assert.Equal(t, "missing receipt", got[1].Comment)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,286 @@
+package expense
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/ledger"
+	"github.com/panudetjt/assessment/problem"
+)
+
+// Attachment is a file such as a receipt scan kept with an expense. Its
+// content lives in the blob store; the row records where and its checksum.
+type Attachment struct {
+	ID          int       `json:"id"`
+	ExpenseID   int       `json:"expense_id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	key string
+}
+
+// AttachmentLimits bounds uploads. Types lists the allowed content types,
+// matched against the type sniffed from the content.
+type AttachmentLimits struct {
+	MaxSize int64
+	Types   []string
+}
+
+func (l AttachmentLimits) allows(contentType string) bool {
+	for _, t := range l.Types {
+		if t == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// multipartMemory is how much of an upload is buffered in memory before the
+// rest spills to a temporary file.
+const multipartMemory = 1 << 20
+
+// multipartOverhead leaves room for the boundaries and part headers around
+// the file so a file of exactly MaxSize is accepted.
+const multipartOverhead = 64 << 10
+
+const attachmentColumns = "id, expense_id, filename, content_type, size, sha256, created_at"
+
+func (a *Attachment) fields() []any {
+	return []any{&a.ID, &a.ExpenseID, &a.Filename, &a.ContentType, &a.Size, &a.SHA256, &a.CreatedAt}
+}
+
+func (h *Handler) UploadAttachmentHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidID, "expense id must be an integer")
+	}
+	if err := h.access(c, id, ledger.Editor); err != nil {
+		return err
+	}
+
+	req := c.Request()
+	tooLarge := problem.New(http.StatusRequestEntityTooLarge, problem.CodeAttachmentTooLarge, fmt.Sprintf("attachments must not exceed %d bytes", h.Limits.MaxSize))
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, h.Limits.MaxSize+multipartOverhead)
+	err = req.ParseMultipartForm(multipartMemory)
+	var maxBytes *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytes):
+		return tooLarge
+	case errors.Is(err, http.ErrNotMultipart):
+		return problem.New(http.StatusUnsupportedMediaType, problem.CodeUnsupportedMedia, "attachments must be uploaded as multipart/form-data")
+	case err != nil:
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "request body must be a multipart form")
+	}
+	defer req.MultipartForm.RemoveAll()
+
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return problem.Validation(problem.FieldError{Field: "file", Message: "is required"})
+	}
+	if fh.Size > h.Limits.MaxSize {
+		return tooLarge
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't open uploaded file: %w", err))
+	}
+	defer f.Close()
+
+	contentType, err := sniff(f)
+	if err != nil {
+		return problem.Internal(err)
+	}
+	if !h.Limits.allows(contentType) {
+		return problem.New(http.StatusUnsupportedMediaType, problem.CodeUnsupportedMedia, fmt.Sprintf("attachments of type %s are not allowed", contentType))
+	}
+
+	a := Attachment{ExpenseID: id, Filename: fh.Filename, ContentType: contentType, Size: fh.Size}
+	if a.key, err = blobKey(id); err != nil {
+		return problem.Internal(err)
+	}
+	sum := sha256.New()
+	ctx := req.Context()
+	if err := h.Blobs.Put(ctx, a.key, io.TeeReader(f, sum), a.Size, a.ContentType); err != nil {
+		return problem.Internal(fmt.Errorf("can't store attachment: %w", err))
+	}
+	a.SHA256 = hex.EncodeToString(sum.Sum(nil))
+
+	err = h.DB.QueryRowContext(ctx,
+		"INSERT INTO attachments (expense_id, filename, content_type, size, sha256, storage_key) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at",
+		a.ExpenseID, a.Filename, a.ContentType, a.Size, a.SHA256, a.key,
+	).Scan(&a.ID, &a.CreatedAt)
+	if err != nil {
+		if err := h.Blobs.Delete(ctx, a.key); err != nil {
+			c.Logger().Error(err)
+		}
+		if pe, ok := err.(*pq.Error); ok && pe.Code == "23503" {
+			return expenseNotFound(id)
+		}
+		return problem.Internal(fmt.Errorf("can't insert attachment: %w", err))
+	}
+	return c.JSON(http.StatusCreated, a)
+}
+
+func (h *Handler) ListAttachmentsHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidID, "expense id must be an integer")
+	}
+	if err := h.access(c, id, ledger.Viewer); err != nil {
+		return err
+	}
+
+	stmt, err := h.prepare("SELECT " + attachmentColumns + " FROM attachments WHERE expense_id = $1 ORDER BY id")
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't prepare attachments statment: %w", err))
+	}
+	ctx := c.Request().Context()
+	var attachments []Attachment
+	err = retryRead(ctx, func() error {
+		attachments = []Attachment{}
+		rows, err := stmt.QueryContext(ctx, id)
+		if err != nil {
+			return fmt.Errorf("can't query attachments: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var a Attachment
+			if err := rows.Scan(a.fields()...); err != nil {
+				return fmt.Errorf("can't scan attachments: %w", err)
+			}
+			attachments = append(attachments, a)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return problem.Internal(err)
+	}
+	return c.JSON(http.StatusOK, attachments)
+}
+
+// DownloadAttachmentHandler serves the content with its stored type and
+// supports Range and conditional requests through http.ServeContent.
+func (h *Handler) DownloadAttachmentHandler(c echo.Context) error {
+	id, aid, err := attachmentIDs(c)
+	if err != nil {
+		return err
+	}
+	if err := h.access(c, id, ledger.Viewer); err != nil {
+		return err
+	}
+
+	stmt, err := h.prepare("SELECT " + attachmentColumns + ", storage_key FROM attachments WHERE id = $1 AND expense_id = $2")
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't prepare attachment statment: %w", err))
+	}
+	ctx := c.Request().Context()
+	var a Attachment
+	err = retryRead(ctx, func() error {
+		return stmt.QueryRowContext(ctx, aid, id).Scan(append(a.fields(), &a.key)...)
+	})
+	if err == sql.ErrNoRows {
+		return attachmentNotFound(aid, id)
+	}
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't query attachment: %w", err))
+	}
+
+	blob, err := h.Blobs.Open(ctx, a.key)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't open attachment %d: %w", a.ID, err))
+	}
+	defer blob.Close()
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, a.ContentType)
+	header.Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename}))
+	header.Set("ETag", `"`+a.SHA256+`"`)
+	header.Set(echo.HeaderXContentTypeOptions, "nosniff")
+	http.ServeContent(c.Response(), c.Request(), "", a.CreatedAt, blob)
+	return nil
+}
+
+func (h *Handler) DeleteAttachmentHandler(c echo.Context) error {
+	id, aid, err := attachmentIDs(c)
+	if err != nil {
+		return err
+	}
+	if err := h.access(c, id, ledger.Editor); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	var key string
+	err = h.DB.QueryRowContext(ctx, "DELETE FROM attachments WHERE id = $1 AND expense_id = $2 RETURNING storage_key", aid, id).Scan(&key)
+	if err == sql.ErrNoRows {
+		return attachmentNotFound(aid, id)
+	}
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't delete attachment: %w", err))
+	}
+	h.deleteBlobs(c, key)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// deleteBlobs removes the content of deleted attachments. The rows are gone
+// already, so a failure only leaves an orphaned blob and is logged.
+func (h *Handler) deleteBlobs(c echo.Context, keys ...string) {
+	for _, key := range keys {
+		if err := h.Blobs.Delete(c.Request().Context(), key); err != nil {
+			c.Logger().Error(fmt.Errorf("can't delete blob %s: %w", key, err))
+		}
+	}
+}
+
+func attachmentIDs(c echo.Context) (int, int, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return 0, 0, problem.New(http.StatusBadRequest, problem.CodeInvalidID, "expense id must be an integer")
+	}
+	aid, err := strconv.Atoi(c.Param("attachment_id"))
+	if err != nil {
+		return 0, 0, problem.New(http.StatusBadRequest, problem.CodeInvalidID, "attachment id must be an integer")
+	}
+	return id, aid, nil
+}
+
+// sniff detects the content type from the first bytes of f, ignoring what
+// the client claims, then rewinds f.
+func sniff(f io.ReadSeeker) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", fmt.Errorf("can't read uploaded file: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("can't rewind uploaded file: %w", err)
+	}
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	return contentType, nil
+}
+
+func blobKey(expenseID int) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("can't generate attachment key: %w", err)
+	}
+	return fmt.Sprintf("attachments/%d/%s", expenseID, hex.EncodeToString(b)), nil
+}
+
+func attachmentNotFound(id, expenseID int) error {
+	return problem.New(http.StatusNotFound, problem.CodeAttachmentNotFound, fmt.Sprintf("attachment %d does not exist on expense %d", id, expenseID))
+}
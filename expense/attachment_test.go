@@ -0,0 +1,210 @@
+package expense
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/problem"
+	"github.com/panudetjt/assessment/storage"
+	"github.com/panudetjt/assessment/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// png is the smallest content http.DetectContentType reports as image/png.
+var png = []byte("\x89PNG\r\n\x1a\nreceipt")
+
+func sha(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+var limits = AttachmentLimits{MaxSize: 1 << 10, Types: []string{"image/png"}}
+
+func attachmentRequest(method, url string, body io.Reader, params ...string) *util.Response {
+	res := util.RequestE(method, url, body)
+	res.Context.SetParamNames("id", "attachment_id")
+	res.Context.SetParamValues(params...)
+	return res
+}
+
+func upload(content []byte, id string) *util.Response {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, _ := w.CreateFormFile("file", "receipt.png")
+	part.Write(content)
+	w.Close()
+	res := attachmentRequest(http.MethodPost, "/expenses/"+id+"/attachments", &body, id)
+	res.Context.Request().Header.Set(echo.HeaderContentType, w.FormDataContentType())
+	return res
+}
+
+func TestUploadAttachmentHandler(t *testing.T) {
+	t.Run("should return 201 (Created) and store the content with its checksum", func(t *testing.T) {
+		res := upload(png, "1")
+		db, mock, _ := sqlmock.New()
+		blobs, _ := storage.NewLocal(t.TempDir())
+		now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		mock.ExpectQuery("INSERT INTO attachments").
+			WithArgs(1, "receipt.png", "image/png", int64(len(png)), sha(png), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(4, now))
+		h := Handler{DB: db, Blobs: blobs, Limits: limits}
+
+		res.Serve(h.UploadAttachmentHandler)
+		var got Attachment
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusCreated, res.Recorder.Code)
+		assert.Equal(t, Attachment{ID: 4, ExpenseID: 1, Filename: "receipt.png", ContentType: "image/png", Size: int64(len(png)), SHA256: sha(png), CreatedAt: now}, got)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 413 (RequestEntityTooLarge) over the size limit", func(t *testing.T) {
+		res := upload(append(png, make([]byte, 1<<10)...), "1")
+		db, mock, _ := sqlmock.New()
+		h := Handler{DB: db, Limits: limits}
+
+		res.Serve(h.UploadAttachmentHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, res.Recorder.Code)
+		assert.Equal(t, problem.CodeAttachmentTooLarge, p.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 415 (UnsupportedMediaType) for disallowed content", func(t *testing.T) {
+		res := upload([]byte("%PDF-1.4 not allowed here"), "1")
+		db, mock, _ := sqlmock.New()
+		h := Handler{DB: db, Limits: limits}
+
+		res.Serve(h.UploadAttachmentHandler)
+
+		assert.Equal(t, http.StatusUnsupportedMediaType, res.Recorder.Code)
+		assert.Contains(t, res.Recorder.Body.String(), "application/pdf")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 415 (UnsupportedMediaType) for JSON bodies", func(t *testing.T) {
+		res := attachmentRequest(http.MethodPost, "/expenses/1/attachments", strings.NewReader("{}"), "1")
+		h := Handler{Limits: limits}
+
+		res.Serve(h.UploadAttachmentHandler)
+
+		assert.Equal(t, http.StatusUnsupportedMediaType, res.Recorder.Code)
+	})
+
+	t.Run("should return 404 (NotFound) and drop the blob when the expense is missing", func(t *testing.T) {
+		res := upload(png, "9")
+		db, mock, _ := sqlmock.New()
+		dir := t.TempDir()
+		blobs, _ := storage.NewLocal(dir)
+		mock.ExpectQuery("INSERT INTO attachments").WillReturnError(&pq.Error{Code: "23503"})
+		h := Handler{DB: db, Blobs: blobs, Limits: limits}
+
+		res.Serve(h.UploadAttachmentHandler)
+
+		assert.Equal(t, http.StatusNotFound, res.Recorder.Code)
+		left, _ := os.ReadDir(filepath.Join(dir, "attachments", "9"))
+		assert.Empty(t, left)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDownloadAttachmentHandler(t *testing.T) {
+	setup := func(t *testing.T) (Handler, sqlmock.Sqlmock) {
+		db, mock, _ := sqlmock.New()
+		blobs, _ := storage.NewLocal(t.TempDir())
+		blobs.Put(context.Background(), "attachments/1/k", bytes.NewReader(png), int64(len(png)), "image/png")
+		return Handler{DB: db, Blobs: blobs}, mock
+	}
+	row := func(mock sqlmock.Sqlmock) {
+		mock.ExpectPrepare("SELECT .+, storage_key FROM attachments WHERE id = \\$1 AND expense_id = \\$2").
+			ExpectQuery().WithArgs(4, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "expense_id", "filename", "content_type", "size", "sha256", "created_at", "storage_key"}).
+				AddRow(4, 1, "receipt.png", "image/png", len(png), sha(png), time.Now(), "attachments/1/k"))
+	}
+
+	t.Run("should serve the content with its type and name", func(t *testing.T) {
+		res := attachmentRequest(http.MethodGet, "/expenses/1/attachments/4", nil, "1", "4")
+		h, mock := setup(t)
+		row(mock)
+
+		res.Serve(h.DownloadAttachmentHandler)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Equal(t, "image/png", res.Recorder.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, `attachment; filename=receipt.png`, res.Recorder.Header().Get(echo.HeaderContentDisposition))
+		assert.Equal(t, png, res.Recorder.Body.Bytes())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 206 (PartialContent) for a range", func(t *testing.T) {
+		res := attachmentRequest(http.MethodGet, "/expenses/1/attachments/4", nil, "1", "4")
+		res.Context.Request().Header.Set("Range", "bytes=8-")
+		h, mock := setup(t)
+		row(mock)
+
+		res.Serve(h.DownloadAttachmentHandler)
+
+		assert.Equal(t, http.StatusPartialContent, res.Recorder.Code)
+		assert.Equal(t, "receipt", res.Recorder.Body.String())
+	})
+
+	t.Run("should return 404 (NotFound) for an attachment of another expense", func(t *testing.T) {
+		res := attachmentRequest(http.MethodGet, "/expenses/2/attachments/4", nil, "2", "4")
+		h, mock := setup(t)
+		mock.ExpectPrepare("SELECT .+ FROM attachments").ExpectQuery().WithArgs(4, 2).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		res.Serve(h.DownloadAttachmentHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusNotFound, res.Recorder.Code)
+		assert.Equal(t, problem.CodeAttachmentNotFound, p.Code)
+	})
+}
+
+func TestDeleteAttachmentHandler(t *testing.T) {
+	t.Run("should return 204 (NoContent) and delete the blob", func(t *testing.T) {
+		res := attachmentRequest(http.MethodDelete, "/expenses/1/attachments/4", nil, "1", "4")
+		db, mock, _ := sqlmock.New()
+		blobs, _ := storage.NewLocal(t.TempDir())
+		blobs.Put(context.Background(), "attachments/1/k", bytes.NewReader(png), int64(len(png)), "image/png")
+		mock.ExpectQuery("DELETE FROM attachments WHERE id = \\$1 AND expense_id = \\$2 RETURNING storage_key").WithArgs(4, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"storage_key"}).AddRow("attachments/1/k"))
+		h := Handler{DB: db, Blobs: blobs}
+
+		res.Serve(h.DeleteAttachmentHandler)
+		_, err := blobs.Open(context.Background(), "attachments/1/k")
+
+		assert.Equal(t, http.StatusNoContent, res.Recorder.Code)
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 404 (NotFound) when nothing is deleted", func(t *testing.T) {
+		res := attachmentRequest(http.MethodDelete, "/expenses/1/attachments/4", nil, "1", "4")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery("DELETE FROM attachments").WillReturnRows(sqlmock.NewRows([]string{"storage_key"}))
+		h := Handler{DB: db}
+
+		res.Serve(h.DeleteAttachmentHandler)
+
+		assert.Equal(t, http.StatusNotFound, res.Recorder.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,176 @@
+package expense
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/labstack/echo/v4"
+	"github.com/panudetjt/assessment/ledger"
+	"github.com/panudetjt/assessment/problem"
+)
+
+// Balance is a participant's net position in a currency: positive when they
+// are owed, negative when they owe.
+type Balance struct {
+	Participant string  `json:"participant"`
+	Currency    string  `json:"currency"`
+	Net         float64 `json:"net"`
+}
+
+// Transfer is one repayment that settles up part of the ledger.
+type Transfer struct {
+	From     string  `json:"from"`
+	To       string  `json:"to"`
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+}
+
+type Balances struct {
+	Balances  []Balance  `json:"balances"`
+	Transfers []Transfer `json:"transfers"`
+}
+
+// BalancesHandler nets every split expense and settlement of a ledger and
+// suggests the transfers that settle it up. Currencies aren't converted: each
+// is netted and settled on its own.
+func (h *Handler) BalancesHandler(c echo.Context) error {
+	id, err := ledger.ID(c)
+	if err != nil {
+		return err
+	}
+	if _, err := ledger.Authorize(c, h.DB, id, ledger.Viewer); err != nil {
+		return err
+	}
+
+	splits, err := h.prepare("SELECT split, currency FROM expenses WHERE ledger_id = $1 AND split IS NOT NULL AND " + live)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't prepare ledger splits statment: %w", err))
+	}
+	settled, err := h.prepare("SELECT from_participant, to_participant, amount, currency FROM settlements WHERE ledger_id = $1")
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't prepare ledger settlements statment: %w", err))
+	}
+
+	ctx := c.Request().Context()
+	// net holds the cents of each participant by currency.
+	var net map[string]map[string]int64
+	err = retryRead(ctx, func() error {
+		net = map[string]map[string]int64{}
+		in := func(currency string) map[string]int64 {
+			if net[currency] == nil {
+				net[currency] = map[string]int64{}
+			}
+			return net[currency]
+		}
+		rows, err := splits.QueryContext(ctx, id)
+		if err != nil {
+			return fmt.Errorf("can't query ledger splits: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var s Split
+			var currency string
+			if err := rows.Scan(&s, &currency); err != nil {
+				return fmt.Errorf("can't scan ledger splits: %w", err)
+			}
+			n := in(currency)
+			for _, sh := range s.Shares {
+				n[s.PaidBy] += cents(sh.Amount)
+				n[sh.Participant] -= cents(sh.Amount)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("can't iterate ledger splits: %w", err)
+		}
+
+		rows, err = settled.QueryContext(ctx, id)
+		if err != nil {
+			return fmt.Errorf("can't query ledger settlements: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var t Transfer
+			if err := rows.Scan(&t.From, &t.To, &t.Amount, &t.Currency); err != nil {
+				return fmt.Errorf("can't scan ledger settlements: %w", err)
+			}
+			n := in(t.Currency)
+			n[t.From] += cents(t.Amount)
+			n[t.To] -= cents(t.Amount)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return problem.Internal(err)
+	}
+
+	currencies := make([]string, 0, len(net))
+	for currency := range net {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+
+	b := Balances{Balances: []Balance{}, Transfers: []Transfer{}}
+	for _, currency := range currencies {
+		var balances []Balance
+		for p, n := range net[currency] {
+			if n != 0 {
+				balances = append(balances, Balance{Participant: p, Currency: currency, Net: float64(n) / 100})
+			}
+		}
+		sort.Slice(balances, func(i, j int) bool { return balances[i].Participant < balances[j].Participant })
+		b.Balances = append(b.Balances, balances...)
+		for _, t := range settle(net[currency]) {
+			t.Currency = currency
+			b.Transfers = append(b.Transfers, t)
+		}
+	}
+	return c.JSON(http.StatusOK, b)
+}
+
+// settle pairs the largest debtor with the largest creditor until everyone is
+// even. Each transfer clears at least one participant, so there are never
+// more than participants-1 transfers.
+func settle(net map[string]int64) []Transfer {
+	type position struct {
+		who    string
+		amount int64
+	}
+	var owed, owing []position
+	for p, n := range net {
+		switch {
+		case n > 0:
+			owed = append(owed, position{p, n})
+		case n < 0:
+			owing = append(owing, position{p, -n})
+		}
+	}
+	byAmount := func(ps []position) {
+		sort.Slice(ps, func(i, j int) bool {
+			if ps[i].amount != ps[j].amount {
+				return ps[i].amount > ps[j].amount
+			}
+			return ps[i].who < ps[j].who
+		})
+	}
+
+	transfers := []Transfer{}
+	for len(owed) > 0 && len(owing) > 0 {
+		byAmount(owed)
+		byAmount(owing)
+		amount := owed[0].amount
+		if owing[0].amount < amount {
+			amount = owing[0].amount
+		}
+		transfers = append(transfers, Transfer{From: owing[0].who, To: owed[0].who, Amount: float64(amount) / 100})
+		owed[0].amount -= amount
+		owing[0].amount -= amount
+		if owed[0].amount == 0 {
+			owed = owed[1:]
+		}
+		if owing[0].amount == 0 {
+			owing = owing[1:]
+		}
+	}
+	return transfers
+}
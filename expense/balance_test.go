@@ -0,0 +1,80 @@
+package expense
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/panudetjt/assessment/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettle(t *testing.T) {
+	got := settle(map[string]int64{"alice": 9000, "bob": -3000, "carol": -6000, "dave": 0})
+
+	assert.Equal(t, []Transfer{{From: "carol", To: "alice", Amount: 60}, {From: "bob", To: "alice", Amount: 30}}, got)
+	assert.Empty(t, settle(map[string]int64{"alice": 0}))
+}
+
+func TestBalancesHandler(t *testing.T) {
+	t.Run("should net splits and settlements", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/ledgers/3/balances", nil)
+		res.Context.SetParamNames("id")
+		res.Context.SetParamValues("3")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery("SELECT EXISTS").WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		splits := mock.ExpectPrepare("SELECT split, currency FROM expenses WHERE ledger_id = \\$1 AND split IS NOT NULL")
+		settled := mock.ExpectPrepare("SELECT from_participant, to_participant, amount, currency FROM settlements WHERE ledger_id = \\$1")
+		splits.ExpectQuery().WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"split", "currency"}).
+			AddRow(`{"method": "equal", "paid_by": "alice", "shares": [{"participant": "alice", "amount": 30}, {"participant": "bob", "amount": 30}, {"participant": "carol", "amount": 30}]}`, "THB"))
+		settled.ExpectQuery().WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"from", "to", "amount", "currency"}).AddRow("bob", "alice", 10, "THB"))
+		h := Handler{DB: db}
+
+		res.Serve(h.BalancesHandler)
+		var got Balances
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Equal(t, []Balance{{"alice", "THB", 50}, {"bob", "THB", -20}, {"carol", "THB", -30}}, got.Balances)
+		assert.Equal(t, []Transfer{{"carol", "alice", 30, "THB"}, {"bob", "alice", 20, "THB"}}, got.Transfers)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should keep currencies apart", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/ledgers/3/balances", nil)
+		res.Context.SetParamNames("id")
+		res.Context.SetParamValues("3")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery("SELECT EXISTS").WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		splits := mock.ExpectPrepare("FROM expenses")
+		settled := mock.ExpectPrepare("FROM settlements")
+		splits.ExpectQuery().WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"split", "currency"}).
+			AddRow(`{"method": "equal", "paid_by": "alice", "shares": [{"participant": "alice", "amount": 100}, {"participant": "bob", "amount": 100}]}`, "THB").
+			AddRow(`{"method": "equal", "paid_by": "bob", "shares": [{"participant": "alice", "amount": 100}, {"participant": "bob", "amount": 100}]}`, "JPY"))
+		settled.ExpectQuery().WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"from", "to", "amount", "currency"}))
+		h := Handler{DB: db}
+
+		res.Serve(h.BalancesHandler)
+		var got Balances
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Equal(t, []Balance{{"alice", "JPY", -100}, {"bob", "JPY", 100}, {"alice", "THB", 100}, {"bob", "THB", -100}}, got.Balances)
+		assert.Equal(t, []Transfer{{"alice", "bob", 100, "JPY"}, {"bob", "alice", 100, "THB"}}, got.Transfers)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 404 (NotFound) to non-members", func(t *testing.T) {
+		res := asMember(util.RequestE(http.MethodGet, "/ledgers/3/balances", nil), "apikey:2")
+		res.Context.SetParamNames("id")
+		res.Context.SetParamValues("3")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery("SELECT role FROM ledger_members").WillReturnRows(sqlmock.NewRows([]string{"role"}))
+		h := Handler{DB: db}
+
+		res.Serve(h.BalancesHandler)
+
+		assert.Equal(t, http.StatusNotFound, res.Recorder.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,344 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/ledger"
+	"github.com/panudetjt/assessment/problem"
+)
+
+// Category is a node of a ledger's category tree, e.g. Coffee under Dining
+// out under Food. Path joins the names from the root down.
+type Category struct {
+	ID        int       `json:"id"`
+	LedgerID  *int      `json:"ledger_id,omitempty"`
+	ParentID  *int      `json:"parent_id,omitempty"`
+	Name      string    `json:"name"`
+	Path      string    `json:"path"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Merge is the body of POST /categories/:id/merge.
+type Merge struct {
+	Into int `json:"into"`
+}
+
+// categoryTree selects every category with its path. Conditions on its
+// columns follow it as a WHERE clause.
+const categoryTree = `WITH RECURSIVE tree AS (
+	SELECT id, ledger_id, parent_id, name, name AS path, created_at FROM categories WHERE parent_id IS NULL
+	UNION ALL
+	SELECT c.id, c.ledger_id, c.parent_id, c.name, tree.path || ' > ' || c.name, c.created_at FROM categories c JOIN tree ON c.parent_id = tree.id
+) SELECT id, ledger_id, parent_id, name, path, created_at FROM tree`
+
+// subtree selects the ids of the category at the placeholder and of all its
+// descendants.
+func subtree(placeholder string) string {
+	return "WITH RECURSIVE sub AS (SELECT id FROM categories WHERE id = " + placeholder +
+		" UNION ALL SELECT c.id FROM categories c JOIN sub ON c.parent_id = sub.id) SELECT id FROM sub"
+}
+
+// categoryLock is the class of the advisory locks serializing the changes to
+// a ledger's tree, so concurrent moves can't make a cycle.
+const categoryLock = 0x63617465
+
+func (ct *Category) fields() []any {
+	return []any{&ct.ID, &ct.LedgerID, &ct.ParentID, &ct.Name, &ct.Path, &ct.CreatedAt}
+}
+
+func (h *Handler) CreateCategoryHandler(c echo.Context) error {
+	var ct Category
+	if err := c.Bind(&ct); err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "request body must be a category JSON object")
+	}
+	ct.Name = strings.TrimSpace(ct.Name)
+	if errs := validName(ct.Name); errs != nil {
+		return problem.Validation(errs...)
+	}
+	if err := h.accessNew(c, ct.LedgerID); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	err := h.inTx(c, func(tx *sql.Tx) error {
+		if err := lockTree(ctx, tx, ct.LedgerID); err != nil {
+			return err
+		}
+		if ct.ParentID != nil {
+			if errs, err := checkParent(ctx, tx, ct.LedgerID, 0, *ct.ParentID); err != nil || errs != nil {
+				return refProblem(errs, err)
+			}
+		}
+		var id int
+		err := tx.QueryRowContext(ctx, "INSERT INTO categories (ledger_id, parent_id, name) VALUES ($1, $2, $3) RETURNING id", ct.LedgerID, ct.ParentID, ct.Name).Scan(&id)
+		if err != nil {
+			return writeError("category", ct.Name, err)
+		}
+		return getCategory(ctx, tx, id, &ct)
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, ct)
+}
+
+// ListCategoriesHandler lists the categories of the caller's ledgers, or of
+// ledger_id only, depth-first by path.
+func (h *Handler) ListCategoriesHandler(c echo.Context) error {
+	f, err := ledgerFilter(c)
+	if err != nil {
+		return err
+	}
+	stmt, err := h.prepare(categoryTree + f.where() + " ORDER BY ledger_id, lower(path), id")
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't prepare categories statment: %w", err))
+	}
+
+	ctx := c.Request().Context()
+	var categories []Category
+	err = retryRead(ctx, func() error {
+		categories = []Category{}
+		rows, err := stmt.QueryContext(ctx, f.args...)
+		if err != nil {
+			return fmt.Errorf("can't query categories: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var ct Category
+			if err := rows.Scan(ct.fields()...); err != nil {
+				return fmt.Errorf("can't scan categories: %w", err)
+			}
+			categories = append(categories, ct)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return problem.Internal(err)
+	}
+	return c.JSON(http.StatusOK, categories)
+}
+
+// UpdateCategoryHandler renames a category and moves it, with its
+// descendants, under parent_id, or to the root when parent_id is left out.
+func (h *Handler) UpdateCategoryHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidID, "category id must be an integer")
+	}
+	var ct Category
+	if err := c.Bind(&ct); err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "request body must be a category JSON object")
+	}
+	ct.Name = strings.TrimSpace(ct.Name)
+	if errs := validName(ct.Name); errs != nil {
+		return problem.Validation(errs...)
+	}
+	if err := h.accessRow(c, "categories", id, ledger.Editor, func() error { return categoryNotFound(id) }); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	err = h.inTx(c, func(tx *sql.Tx) error {
+		ledgerID, err := lockCategory(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if ct.ParentID != nil {
+			if errs, err := checkParent(ctx, tx, ledgerID, id, *ct.ParentID); err != nil || errs != nil {
+				return refProblem(errs, err)
+			}
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE categories SET name = $2, parent_id = $3 WHERE id = $1", id, ct.Name, ct.ParentID)
+		if err != nil {
+			return writeError("category", ct.Name, err)
+		}
+		return getCategory(ctx, tx, id, &ct)
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, ct)
+}
+
+// MergeCategoryHandler merges a category into another of its ledger: its
+// children move under the other one, its rules and expenses are
+// recategorized, each expense recorded as a new version, and it is deleted.
+// It fails while one of the expenses is no longer editable, or is in a closed
+// period unless override_reason overrides it.
+func (h *Handler) MergeCategoryHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidID, "category id must be an integer")
+	}
+	var mg Merge
+	if err := c.Bind(&mg); err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "request body must be a merge JSON object")
+	}
+	if mg.Into <= 0 {
+		return problem.Validation(problem.FieldError{Field: "into", Message: "must be a category id"})
+	}
+	if err := h.accessRow(c, "categories", id, ledger.Editor, func() error { return categoryNotFound(id) }); err != nil {
+		return err
+	}
+	reason, err := overrideReason(c)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	var into Category
+	err = h.inTx(c, func(tx *sql.Tx) error {
+		ledgerID, err := lockCategory(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		errs, err := checkParent(ctx, tx, ledgerID, id, mg.Into)
+		for i := range errs {
+			errs[i].Field = "into"
+		}
+		if err != nil || errs != nil {
+			return refProblem(errs, err)
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE categories SET parent_id = $2 WHERE parent_id = $1", id, mg.Into)
+		if pe, ok := err.(*pq.Error); ok && pe.Code == "23505" {
+			return problem.New(http.StatusConflict, problem.CodeNameTaken, fmt.Sprintf("categories %d and %d have children of the same name, rename one first", id, mg.Into))
+		}
+		if err != nil {
+			return problem.Internal(fmt.Errorf("can't move merged children: %w", err))
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE rules SET category_id = $2 WHERE category_id = $1", id, mg.Into); err != nil {
+			return problem.Internal(fmt.Errorf("can't move merged rules: %w", err))
+		}
+		// Merged duplicates move too, but have no history to add to.
+		if _, err := tx.ExecContext(ctx, "UPDATE expenses SET category_id = $2 WHERE category_id = $1 AND deleted_at IS NOT NULL", id, mg.Into); err != nil {
+			return problem.Internal(fmt.Errorf("can't recategorize merged expenses: %w", err))
+		}
+		var moved []Expense
+		err = func() error {
+			rows, err := tx.QueryContext(ctx, "UPDATE expenses SET category_id = $2 WHERE category_id = $1 AND "+live+" RETURNING "+columns, id, mg.Into)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var e Expense
+				if err := rows.Scan(e.fields()...); err != nil {
+					return err
+				}
+				moved = append(moved, e)
+			}
+			return rows.Err()
+		}()
+		if err != nil {
+			return problem.Internal(fmt.Errorf("can't recategorize expenses: %w", err))
+		}
+		for i := range moved {
+			if !editable(moved[i].Status) {
+				return notEditable(moved[i])
+			}
+			closed, err := checkPeriods(ctx, tx, moved[i].LedgerID, reason, moved[i].SpentOn)
+			if err != nil {
+				return err
+			}
+			if err := auditOverrides(c, tx, closed, moved[i].ID, ActionUpdated, reason); err != nil {
+				return err
+			}
+			before := moved[i]
+			before.CategoryID = &id
+			if err := record(c, tx, moved[i].ID, ActionUpdated, &before, &moved[i]); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM categories WHERE id = $1", id); err != nil {
+			return problem.Internal(fmt.Errorf("can't delete merged category: %w", err))
+		}
+		return getCategory(ctx, tx, mg.Into, &into)
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, into)
+}
+
+// DeleteCategoryHandler deletes a category without children or expenses.
+func (h *Handler) DeleteCategoryHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidID, "category id must be an integer")
+	}
+	if err := h.accessRow(c, "categories", id, ledger.Editor, func() error { return categoryNotFound(id) }); err != nil {
+		return err
+	}
+	return h.deleteRow(c, "categories", "category", id, categoryNotFound)
+}
+
+func categoryNotFound(id int) error {
+	return problem.New(http.StatusNotFound, problem.CodeCategoryNotFound, fmt.Sprintf("category %d does not exist", id))
+}
+
+func lockTree(ctx context.Context, tx *sql.Tx, ledgerID *int) error {
+	var key int
+	if ledgerID != nil {
+		key = *ledgerID
+	}
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1, $2)", categoryLock, key); err != nil {
+		return problem.Internal(fmt.Errorf("can't lock categories: %w", err))
+	}
+	return nil
+}
+
+// lockCategory locks the tree category id is in and returns its ledger.
+func lockCategory(ctx context.Context, tx *sql.Tx, id int) (*int, error) {
+	var ledgerID *int
+	err := tx.QueryRowContext(ctx, "SELECT ledger_id FROM categories WHERE id = $1", id).Scan(&ledgerID)
+	if err == sql.ErrNoRows {
+		return nil, categoryNotFound(id)
+	}
+	if err != nil {
+		return nil, problem.Internal(fmt.Errorf("can't query category: %w", err))
+	}
+	return ledgerID, lockTree(ctx, tx, ledgerID)
+}
+
+// checkParent reports whether parent can be the parent of category id in
+// ledgerID: it must be in the same ledger and outside the subtree of id. id
+// is 0 for a new category.
+func checkParent(ctx context.Context, tx *sql.Tx, ledgerID *int, id, parent int) ([]problem.FieldError, error) {
+	var exists, inside bool
+	err := tx.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM categories WHERE id = $1 AND ledger_id IS NOT DISTINCT FROM $2), $1 IN ("+subtree("$3")+")",
+		parent, ledgerID, id,
+	).Scan(&exists, &inside)
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("can't check parent category: %w", err)
+	case !exists:
+		return []problem.FieldError{{Field: "parent_id", Message: "does not exist in the category's ledger"}}, nil
+	case inside:
+		return []problem.FieldError{{Field: "parent_id", Message: "must not be the category or one of its descendants"}}, nil
+	}
+	return nil, nil
+}
+
+func refProblem(errs []problem.FieldError, err error) error {
+	if err != nil {
+		return problem.Internal(err)
+	}
+	return problem.Validation(errs...)
+}
+
+func getCategory(ctx context.Context, tx *sql.Tx, id int, ct *Category) error {
+	if err := tx.QueryRowContext(ctx, categoryTree+" WHERE id = $1", id).Scan(ct.fields()...); err != nil {
+		return problem.Internal(fmt.Errorf("can't query category: %w", err))
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package expense
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/problem"
+	"github.com/panudetjt/assessment/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCategories(t *testing.T) {
+	now := time.Date(2023, 1, 2, 9, 0, 0, 0, time.UTC)
+	categoryColumns := []string{"id", "ledger_id", "parent_id", "name", "path", "created_at"}
+	byID := func(method, path, body string) *util.Response {
+		res := util.RequestE(method, path, strings.NewReader(body))
+		res.Context.SetParamNames("id")
+		res.Context.SetParamValues("2")
+		return res
+	}
+
+	t.Run("should create a category under its parent", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/categories", strings.NewReader(`{"name": "Coffee", "parent_id": 2}`))
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectExec("SELECT pg_advisory_xact_lock\\(\\$1, \\$2\\)").WithArgs(categoryLock, 0).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM categories WHERE id = \\$1 AND ledger_id IS NOT DISTINCT FROM \\$2\\), \\$1 IN \\(WITH RECURSIVE sub").
+			WithArgs(2, nil, 0).
+			WillReturnRows(sqlmock.NewRows([]string{"exists", "inside"}).AddRow(true, false))
+		mock.ExpectQuery("INSERT INTO categories \\(ledger_id, parent_id, name\\) VALUES \\(\\$1, \\$2, \\$3\\) RETURNING id").WithArgs(nil, 2, "Coffee").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+		mock.ExpectQuery("WITH RECURSIVE tree AS (.+) SELECT id, ledger_id, parent_id, name, path, created_at FROM tree WHERE id = \\$1").WithArgs(3).
+			WillReturnRows(sqlmock.NewRows(categoryColumns).AddRow(3, nil, 2, "Coffee", "Food > Dining out > Coffee", now))
+		mock.ExpectCommit()
+		h := Handler{DB: db}
+
+		res.Serve(h.CreateCategoryHandler)
+		var got Category
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusCreated, res.Recorder.Code)
+		assert.Equal(t, "Food > Dining out > Coffee", got.Path)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should list the caller's categories depth-first", func(t *testing.T) {
+		res := asMember(util.RequestE(http.MethodGet, "/categories?ledger_id=3", nil), "apikey:2")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectPrepare("FROM tree WHERE ledger_id IN \\(SELECT ledger_id FROM ledger_members WHERE subject = \\$1\\) AND ledger_id = \\$2 ORDER BY ledger_id, lower\\(path\\), id").
+			ExpectQuery().WithArgs("apikey:2", int64(3)).
+			WillReturnRows(sqlmock.NewRows(categoryColumns).
+				AddRow(1, 3, nil, "Food", "Food", now).
+				AddRow(2, 3, 1, "Dining out", "Food > Dining out", now))
+		h := Handler{DB: db}
+
+		res.Serve(h.ListCategoriesHandler)
+		var got []Category
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Equal(t, 1, *got[1].ParentID)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 422 (UnprocessableEntity) moving a category under its descendant", func(t *testing.T) {
+		res := byID(http.MethodPut, "/categories/2", `{"name": "Dining out", "parent_id": 3}`)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT ledger_id FROM categories WHERE id = \\$1").WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"ledger_id"}).AddRow(nil))
+		mock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT EXISTS").WithArgs(3, nil, 2).
+			WillReturnRows(sqlmock.NewRows([]string{"exists", "inside"}).AddRow(true, true))
+		mock.ExpectRollback()
+		h := Handler{DB: db}
+
+		res.Serve(h.UpdateCategoryHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, res.Recorder.Code)
+		assert.Equal(t, "parent_id", p.Errors[0].Field)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should merge children and expenses into another category", func(t *testing.T) {
+		res := byID(http.MethodPost, "/categories/2/merge", `{"into": 5}`)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT ledger_id FROM categories WHERE id = \\$1").WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"ledger_id"}).AddRow(nil))
+		mock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT EXISTS").WithArgs(5, nil, 2).
+			WillReturnRows(sqlmock.NewRows([]string{"exists", "inside"}).AddRow(true, false))
+		mock.ExpectExec("UPDATE categories SET parent_id = \\$2 WHERE parent_id = \\$1").WithArgs(2, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE rules SET category_id = \\$2 WHERE category_id = \\$1").WithArgs(2, 5).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("UPDATE expenses SET category_id = \\$2 WHERE category_id = \\$1 AND deleted_at IS NOT NULL").WithArgs(2, 5).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("UPDATE expenses SET category_id = \\$2 WHERE category_id = \\$1 AND deleted_at IS NULL RETURNING").WithArgs(2, 5).
+			WillReturnRows(sqlmock.NewRows(expenseColumns).AddRow(1, "latte", 90, "", pq.Array([]string{}), nil, nil, "THB", "2023-01-02", nil, nil, 5, "draft"))
+		expectPeriods(mock)
+		mock.ExpectQuery("INSERT INTO expense_events").
+			WithArgs(1, ActionUpdated, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"version", "created_at"}).AddRow(2, now))
+		mock.ExpectExec("INSERT INTO webhook_outbox").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("DELETE FROM categories WHERE id = \\$1").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("FROM tree WHERE id = \\$1").WithArgs(5).
+			WillReturnRows(sqlmock.NewRows(categoryColumns).AddRow(5, nil, nil, "Drinks", "Drinks", now))
+		mock.ExpectCommit()
+		h := Handler{DB: db}
+
+		res.Serve(h.MergeCategoryHandler)
+		var got Category
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Equal(t, 5, got.ID)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 409 (Conflict) recategorizing a submitted expense", func(t *testing.T) {
+		res := byID(http.MethodPost, "/categories/2/merge", `{"into": 5}`)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT ledger_id").WillReturnRows(sqlmock.NewRows([]string{"ledger_id"}).AddRow(nil))
+		mock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT EXISTS").WillReturnRows(sqlmock.NewRows([]string{"exists", "inside"}).AddRow(true, false))
+		mock.ExpectExec("UPDATE categories SET parent_id").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("UPDATE rules SET category_id").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("UPDATE expenses SET category_id (.+) deleted_at IS NOT NULL").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("UPDATE expenses SET category_id (.+) deleted_at IS NULL RETURNING").
+			WillReturnRows(sqlmock.NewRows(expenseColumns).AddRow(1, "latte", 90, "", pq.Array([]string{}), nil, nil, "THB", "2023-01-02", nil, nil, 5, "submitted"))
+		mock.ExpectRollback()
+		h := Handler{DB: db}
+
+		res.Serve(h.MergeCategoryHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusConflict, res.Recorder.Code)
+		assert.Equal(t, problem.CodeNotEditable, p.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 409 (Conflict) merging children of the same name", func(t *testing.T) {
+		res := byID(http.MethodPost, "/categories/2/merge", `{"into": 5}`)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT ledger_id").WillReturnRows(sqlmock.NewRows([]string{"ledger_id"}).AddRow(nil))
+		mock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT EXISTS").WillReturnRows(sqlmock.NewRows([]string{"exists", "inside"}).AddRow(true, false))
+		mock.ExpectExec("UPDATE categories").WillReturnError(&pq.Error{Code: "23505"})
+		mock.ExpectRollback()
+		h := Handler{DB: db}
+
+		res.Serve(h.MergeCategoryHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusConflict, res.Recorder.Code)
+		assert.Equal(t, problem.CodeNameTaken, p.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should roll category totals up to the ancestors", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses/summary?group_by=category", nil)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectPrepare("SELECT count")
+		mock.ExpectPrepare("SELECT t.tag")
+		mock.ExpectPrepare("WITH RECURSIVE up AS \\(SELECT id, id AS ancestor FROM categories (.+) " +
+			"FROM \\(SELECT category_id AS group_id, amount AS amount FROM expenses WHERE deleted_at IS NULL\\) AS e LEFT JOIN up ON up.id = e.group_id LEFT JOIN categories AS g ON g.id = up.ancestor")
+		mock.ExpectQuery("SELECT count").WillReturnRows(sqlmock.NewRows([]string{"count", "sum", "min", "max"}).AddRow(2, 150, "THB", "THB"))
+		mock.ExpectQuery("SELECT t.tag").WillReturnRows(sqlmock.NewRows([]string{"tag", "count", "sum"}))
+		mock.ExpectQuery("WITH RECURSIVE up").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "parent_id", "name", "count", "sum"}).
+				AddRow(1, nil, "Food", 2, 150).
+				AddRow(3, 1, "Coffee", 1, 90))
+		h := Handler{DB: db}
+
+		res.Serve(h.SummaryHandler)
+		var s Summary
+		res.Decode(&s)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		food, coffee := 1, 3
+		assert.Equal(t, []GroupSummary{{&food, nil, "Food", 2, 150}, {&coffee, &food, "Coffee", 1, 90}}, s.ByCategory)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should filter expenses by a category and its descendants", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses?category_id=1", nil)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectPrepare("FROM expenses WHERE deleted_at IS NULL AND category_id IN \\(WITH RECURSIVE sub AS \\(SELECT id FROM categories WHERE id = \\$1 UNION ALL (.+)\\) ORDER BY id").
+			ExpectQuery().WithArgs(int64(1), nil, int64(0)).
+			WillReturnRows(sqlmock.NewRows(expenseColumns))
+		h := Handler{DB: db}
+
+		res.Serve(h.GetAllExpenseHandler)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
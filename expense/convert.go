@@ -0,0 +1,72 @@
+package expense
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/panudetjt/assessment/date"
+	"github.com/panudetjt/assessment/exchange"
+	"github.com/panudetjt/assessment/problem"
+)
+
+// Conversion is an expense's amount in another currency at the rate of the
+// day it was spent.
+type Conversion struct {
+	Currency string  `json:"currency"`
+	Rate     float64 `json:"rate"`
+	Amount   float64 `json:"amount"`
+}
+
+// maxMissingRates bounds the missing rates named in an error.
+const maxMissingRates = 5
+
+// parseConvert reads the optional convert_to currency of the list and
+// summary endpoints.
+func parseConvert(c echo.Context) (string, error) {
+	to := strings.ToUpper(c.QueryParam("convert_to"))
+	if to != "" && !exchange.ValidCurrency(to) {
+		return "", problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, "convert_to must be a 3-letter currency code")
+	}
+	return to, nil
+}
+
+// rate returns the SQL expression of the rate converting an expense's amount
+// into currency to on the day it was spent: 1 for the same currency, the
+// rate quoted in to, or the inverse of the rate of to quoted in the expense's
+// currency. It is NULL when neither rate is known.
+func (f *filter) rate(to string) string {
+	p := f.arg(to)
+	return fmt.Sprintf("CASE WHEN currency = %[1]s THEN 1 ELSE coalesce("+
+		"(SELECT rate FROM exchange_rates WHERE date = spent_on AND base = currency AND quote = %[1]s), "+
+		"(SELECT 1 / rate FROM exchange_rates WHERE date = spent_on AND base = %[1]s AND quote = currency)) END", p)
+}
+
+// missingRate is a currency and day without a rate to convert with.
+type missingRate struct {
+	currency string
+	on       date.Date
+}
+
+func rateNotFound(to string, missing []missingRate) error {
+	days := make([]string, len(missing))
+	for i, m := range missing {
+		days[i] = m.currency + " on " + m.on.String()
+	}
+	return problem.New(http.StatusUnprocessableEntity, problem.CodeRateNotFound,
+		fmt.Sprintf("no exchange rate to %s for %s", to, strings.Join(days, ", ")))
+}
+
+// addMissing adds m to missing unless it is already there or missing is full.
+func addMissing(missing []missingRate, m missingRate) []missingRate {
+	if len(missing) == maxMissingRates {
+		return missing
+	}
+	for _, x := range missing {
+		if x == m {
+			return missing
+		}
+	}
+	return append(missing, m)
+}
@@ -0,0 +1,125 @@
+package expense
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/problem"
+	"github.com/panudetjt/assessment/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvert(t *testing.T) {
+	rateOf := "CASE WHEN currency = \\$1 THEN 1 ELSE coalesce\\(" +
+		"\\(SELECT rate FROM exchange_rates WHERE date = spent_on AND base = currency AND quote = \\$1\\), " +
+		"\\(SELECT 1 / rate FROM exchange_rates WHERE date = spent_on AND base = \\$1 AND quote = currency\\)\\) END"
+	rateColumns := append(append([]string{}, expenseColumns...), "rate")
+
+	t.Run("should list expenses with their amount in convert_to", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses?convert_to=thb", nil)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectPrepare("SELECT id, title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id, status, "+rateOf+" FROM expenses WHERE deleted_at IS NULL ORDER BY id LIMIT \\$2 OFFSET \\$3").
+			ExpectQuery().
+			WithArgs("THB", nil, int64(0)).
+			WillReturnRows(sqlmock.NewRows(rateColumns).
+				AddRow(1, "ramen", 1200, "", pq.Array([]string{}), nil, nil, "JPY", "2023-01-02", nil, nil, nil, "draft", 0.25).
+				AddRow(2, "taxi", 120, "", pq.Array([]string{}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft", 1))
+		h := Handler{DB: db}
+
+		res.Serve(h.GetAllExpenseHandler)
+		var got []Expense
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Equal(t, &Conversion{Currency: "THB", Rate: 0.25, Amount: 300}, got[0].Converted)
+		assert.Equal(t, 1200.0, got[0].Amount)
+		assert.Equal(t, &Conversion{Currency: "THB", Rate: 1, Amount: 120}, got[1].Converted)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 422 (UnprocessableEntity) naming the missing rates of the page", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses?convert_to=THB", nil)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectPrepare("FROM expenses").ExpectQuery().
+			WillReturnRows(sqlmock.NewRows(rateColumns).
+				AddRow(1, "ramen", 1200, "", pq.Array([]string{}), nil, nil, "JPY", "2023-01-02", nil, nil, nil, "draft", nil).
+				AddRow(2, "sushi", 3000, "", pq.Array([]string{}), nil, nil, "JPY", "2023-01-02", nil, nil, nil, "draft", nil).
+				AddRow(3, "taxi", 20, "", pq.Array([]string{}), nil, nil, "USD", "2023-01-03", nil, nil, nil, "draft", nil))
+		h := Handler{DB: db}
+
+		res.Serve(h.GetAllExpenseHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, res.Recorder.Code)
+		assert.Equal(t, problem.CodeRateNotFound, p.Code)
+		assert.Equal(t, "no exchange rate to THB for JPY on 2023-01-02, USD on 2023-01-03", p.Detail)
+	})
+
+	t.Run("should total converted amounts", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses/summary?convert_to=THB&tag=food", nil)
+		db, mock, _ := sqlmock.New()
+		rateOf := strings.ReplaceAll(rateOf, "\\$1", "\\$2")
+		mock.ExpectPrepare("SELECT DISTINCT currency, spent_on FROM expenses WHERE deleted_at IS NULL AND tags @> \\$1 AND \\("+rateOf+"\\) IS NULL ORDER BY spent_on, currency LIMIT 5").
+			ExpectQuery().
+			WithArgs(pq.Array([]string{"food"}), "THB").
+			WillReturnRows(sqlmock.NewRows([]string{"currency", "spent_on"}))
+		mock.ExpectPrepare("SELECT count\\(\\*\\), COALESCE\\(sum\\(amount \\* " + rateOf + "\\), 0\\), min\\(currency\\), max\\(currency\\) FROM expenses WHERE deleted_at IS NULL AND tags @> \\$1")
+		mock.ExpectPrepare("SELECT t.tag, count\\(\\*\\), sum\\(amount \\* (.+)\\) FROM expenses, unnest\\(tags\\)")
+		mock.ExpectQuery("SELECT count").WithArgs(pq.Array([]string{"food"}), "THB").
+			WillReturnRows(sqlmock.NewRows([]string{"count", "sum", "min", "max"}).AddRow(2, 420, "THB", "THB"))
+		mock.ExpectQuery("SELECT t.tag").WithArgs(pq.Array([]string{"food"}), "THB").
+			WillReturnRows(sqlmock.NewRows([]string{"tag", "count", "sum"}).AddRow("food", 2, 420))
+		h := Handler{DB: db}
+
+		res.Serve(h.SummaryHandler)
+		var s Summary
+		res.Decode(&s)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Equal(t, Summary{Currency: "THB", Count: 2, Total: 420, ByTag: []TagSummary{{"food", 2, 420}}}, s)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should not total when a rate is missing", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses/summary?convert_to=THB", nil)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectPrepare("SELECT DISTINCT currency, spent_on").ExpectQuery().
+			WillReturnRows(sqlmock.NewRows([]string{"currency", "spent_on"}).AddRow("JPY", "2023-01-02"))
+		h := Handler{DB: db}
+
+		res.Serve(h.SummaryHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, res.Recorder.Code)
+		assert.Equal(t, "no exchange rate to THB for JPY on 2023-01-02", p.Detail)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 400 (BadRequest) for an invalid convert_to", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses?convert_to=baht", nil)
+		h := Handler{}
+
+		res.Serve(h.GetAllExpenseHandler)
+
+		assert.Equal(t, http.StatusBadRequest, res.Recorder.Code)
+	})
+
+	t.Run("should return 422 (UnprocessableEntity) for an invalid currency", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/expenses", strings.NewReader(`{"title": "ramen", "amount": 1200, "currency": "baht"}`))
+		db, mock, _ := sqlmock.New()
+		h := Handler{DB: db}
+
+		res.Serve(h.CreateExpensesHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, res.Recorder.Code)
+		assert.Equal(t, "currency", p.Errors[0].Field)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -1,27 +1,83 @@
 package expense
 
 import (
+	"database/sql"
+	"fmt"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
 	"github.com/lib/pq"
-	"github.com/panudetjt/assessment/util"
+	"github.com/panudetjt/assessment/date"
+	"github.com/panudetjt/assessment/problem"
 )
 
 func (h *Handler) CreateExpensesHandler(c echo.Context) error {
 	var e Expense
 	err := c.Bind(&e)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, util.Error{Message: err.Error()})
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "request body must be an expense JSON object")
+	}
+	e.normalize()
+	if errs := e.validate(); errs != nil {
+		return problem.Validation(errs...)
+	}
+	if e.Currency == "" {
+		e.Currency = DefaultCurrency
+	}
+	if e.SpentOn.IsZero() {
+		e.SpentOn = date.Today()
+	}
+	e.Status = StatusDraft
+	if err := h.accessNew(c, e.LedgerID); err != nil {
+		return err
+	}
+	allowDuplicate, err := queryBool(c, "allow_duplicate")
+	if err != nil {
+		return err
+	}
+	reason, err := overrideReason(c)
+	if err != nil {
+		return err
 	}
 
-	row := h.DB.QueryRow(
-		"INSERT INTO expenses (title, amount, note, tags) VALUES ($1, $2, $3, $4) RETURNING id",
-		e.Title, e.Amount, e.Note, pq.Array(e.Tags),
-	)
-	err = row.Scan(&e.ID)
+	ctx := c.Request().Context()
+	err = h.inTx(c, func(tx *sql.Tx) error {
+		closed, err := checkPeriods(ctx, tx, e.LedgerID, reason, e.SpentOn)
+		if err != nil {
+			return err
+		}
+		if err := applyRules(ctx, tx, e.LedgerID, &e); err != nil {
+			return err
+		}
+		errs, err := checkRefs(ctx, tx, e.LedgerID, &e)
+		if err != nil {
+			return problem.Internal(err)
+		}
+		if errs != nil {
+			return problem.Validation(errs...)
+		}
+		if h.Duplicates.Warn && !allowDuplicate {
+			if err := h.checkDuplicates(ctx, tx, &e); err != nil {
+				return err
+			}
+		}
+		err = tx.QueryRowContext(ctx,
+			"INSERT INTO expenses (title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id",
+			e.Title, e.Amount, e.Note, pq.Array(e.Tags), e.LedgerID, e.Split, e.Currency, e.SpentOn, e.MerchantID, e.PaymentMethodID, e.CategoryID,
+		).Scan(&e.ID)
+		if pe, ok := err.(*pq.Error); ok && pe.Code == "23503" {
+			return problem.Validation(problem.FieldError{Field: "ledger_id", Message: "does not exist"})
+		}
+		if err != nil {
+			return problem.Internal(fmt.Errorf("can't insert expense: %w", err))
+		}
+		if err := auditOverrides(c, tx, closed, e.ID, ActionCreated, reason); err != nil {
+			return err
+		}
+		return record(c, tx, e.ID, ActionCreated, nil, &e)
+	})
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, util.Error{Message: err.Error()})
+		return err
 	}
 
 	return c.JSON(http.StatusCreated, e)
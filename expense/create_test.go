@@ -8,6 +8,8 @@ import (
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/date"
+	"github.com/panudetjt/assessment/problem"
 	"github.com/panudetjt/assessment/util"
 	"github.com/stretchr/testify/assert"
 )
@@ -23,13 +25,17 @@ func TestCreateExpenseHandler(t *testing.T) {
 		b, _ := json.Marshal(e)
 		res := util.RequestE(http.MethodPost, "/expenses", strings.NewReader(string(b)))
 		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		expectPeriods(mock)
+		expectRules(mock)
 		mock.ExpectQuery("INSERT INTO expenses (.+) VALUES (.+) RETURNING id").
-			WithArgs(e.Title, e.Amount, e.Note, pq.Array(e.Tags)).
+			WithArgs(e.Title, e.Amount, e.Note, pq.Array(e.Tags), nil, nil, "THB", date.Today(), nil, nil, nil).
 			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1"))
+		expectEvent(mock, ActionCreated)
 		handler := Handler{DB: db}
-		e.ID = 1
+		e.ID, e.Currency, e.SpentOn, e.Status = 1, DefaultCurrency, date.Today(), StatusDraft
 
-		handler.CreateExpensesHandler(res.Context)
+		res.Serve(handler.CreateExpensesHandler)
 		var ee Expense
 		res.Decode(&ee)
 
@@ -43,13 +49,32 @@ func TestCreateExpenseHandler(t *testing.T) {
 		db, mock, _ := sqlmock.New()
 		handler := Handler{DB: db}
 
-		handler.CreateExpensesHandler(res.Context)
-		var e util.Error
+		res.Serve(handler.CreateExpensesHandler)
+		var e problem.Problem
 		res.Decode(&e)
 
 		assert.Equal(t, http.StatusBadRequest, res.Recorder.Code)
+		assert.Equal(t, problem.ContentType, res.Recorder.Header().Get("Content-Type"))
 		assert.Nil(t, mock.ExpectationsWereMet())
-		assert.NotEmpty(t, e.Message)
+		assert.Equal(t, problem.CodeInvalidBody, e.Code)
+	})
+	t.Run("should return 422 (UnprocessableEntity) when fields are invalid", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/expenses", strings.NewReader(`{"title": " ", "amount": -1, "tags": [""]}`))
+		db, mock, _ := sqlmock.New()
+		handler := Handler{DB: db}
+
+		res.Serve(handler.CreateExpensesHandler)
+		var e problem.Problem
+		res.Decode(&e)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, res.Recorder.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+		assert.Equal(t, problem.CodeValidationFailed, e.Code)
+		assert.Equal(t, []problem.FieldError{
+			{Field: "title", Message: "must not be empty"},
+			{Field: "amount", Message: "must be greater than 0"},
+			{Field: "tags", Message: "must not contain empty tags"},
+		}, e.Errors)
 	})
 	t.Run("should return 500 (InternalServerError) when database error", func(t *testing.T) {
 		e := Expense{
@@ -61,17 +86,63 @@ func TestCreateExpenseHandler(t *testing.T) {
 		b, _ := json.Marshal(e)
 		res := util.RequestE(http.MethodPost, "/expenses", strings.NewReader(string(b)))
 		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		expectPeriods(mock)
+		expectRules(mock)
 		mock.ExpectQuery("INSERT INTO expenses (.+) VALUES (.+) RETURNING id").
-			WithArgs(e.Title, e.Amount, e.Note, pq.Array(e.Tags)).
+			WithArgs(e.Title, e.Amount, e.Note, pq.Array(e.Tags), nil, nil, "THB", date.Today(), nil, nil, nil).
 			WillReturnError(&pq.Error{})
+		mock.ExpectRollback()
 		handler := Handler{DB: db}
 
-		handler.CreateExpensesHandler(res.Context)
-		var err util.Error
+		res.Serve(handler.CreateExpensesHandler)
+		var err problem.Problem
 		res.Decode(&err)
 
 		assert.Equal(t, http.StatusInternalServerError, res.Recorder.Code)
 		assert.Nil(t, mock.ExpectationsWereMet())
-		assert.NotEmpty(t, err.Message)
+		assert.Equal(t, problem.CodeInternal, err.Code)
+		assert.NotContains(t, res.Recorder.Body.String(), "pq:")
+	})
+
+	t.Run("should store the split with computed amounts", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/expenses", strings.NewReader(
+			`{"title": "dinner", "amount": 100, "split": {"method": "equal", "paid_by": "alice", "shares": [{"participant": "alice"}, {"participant": "bob"}, {"participant": "carol"}]}}`))
+		db, mock, _ := sqlmock.New()
+		split := &Split{Method: SplitEqual, PaidBy: "alice", Shares: []Share{
+			{Participant: "alice", Amount: 33.34}, {Participant: "bob", Amount: 33.33}, {Participant: "carol", Amount: 33.33},
+		}}
+		stored, _ := split.Value()
+		mock.ExpectBegin()
+		expectPeriods(mock)
+		expectRules(mock)
+		mock.ExpectQuery("INSERT INTO expenses").
+			WithArgs("dinner", 100.0, "", pq.Array([]string(nil)), nil, stored, "THB", date.Today(), nil, nil, nil).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		expectEvent(mock, ActionCreated)
+		handler := Handler{DB: db}
+
+		res.Serve(handler.CreateExpensesHandler)
+		var got Expense
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusCreated, res.Recorder.Code)
+		assert.Equal(t, split, got.Split)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 422 (UnprocessableEntity) when exact split doesn't add up", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/expenses", strings.NewReader(
+			`{"title": "dinner", "amount": 100, "split": {"method": "exact", "paid_by": "alice", "shares": [{"participant": "bob", "value": 40}]}}`))
+		db, mock, _ := sqlmock.New()
+		handler := Handler{DB: db}
+
+		res.Serve(handler.CreateExpensesHandler)
+		var e problem.Problem
+		res.Decode(&e)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, res.Recorder.Code)
+		assert.Equal(t, "split.shares", e.Errors[0].Field)
+		assert.Nil(t, mock.ExpectationsWereMet())
 	})
 }
@@ -1,20 +1,56 @@
 package expense
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"os"
+	"time"
 
 	_ "github.com/lib/pq"
+	"github.com/panudetjt/assessment/config"
 )
 
-func InitDB(driver string) (*sql.DB, error) {
-	url := os.Getenv("DATABASE_URL")
+var (
+	pingBackoff    = 100 * time.Millisecond
+	pingMaxBackoff = 5 * time.Second
+)
 
-	db, err := sql.Open(driver, url)
+func InitDB(driver string, cfg config.Database) (*sql.DB, error) {
+	db, err := sql.Open(driver, cfg.URL)
 	if err != nil {
 		return nil, fmt.Errorf("connect to database error: %s", err)
 	}
 
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+
 	return db, nil
 }
+
+// Ping waits until the database answers, retrying with exponential backoff
+// until timeout so the server doesn't start against a database that is still
+// booting.
+func Ping(ctx context.Context, db *sql.DB, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	backoff := pingBackoff
+	for {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("database not ready after %s: %w", timeout, err)
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > pingMaxBackoff {
+			backoff = pingMaxBackoff
+		}
+	}
+}
@@ -1,24 +1,55 @@
 package expense
 
 import (
-	"os"
+	"context"
+	"errors"
 	"testing"
+	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/panudetjt/assessment/config"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestInitDB(t *testing.T) {
-	os.Setenv("DATABASE_URL", "url")
 	t.Run("can connect", func(t *testing.T) {
-		db, err := InitDB("postgres")
+		db, err := InitDB("postgres", config.Database{URL: "url", MaxOpenConns: 7})
 
 		assert.Nil(t, err)
 		assert.NotNil(t, db)
+		assert.Equal(t, 7, db.Stats().MaxOpenConnections)
 	})
 	t.Run("cannot connect", func(t *testing.T) {
-		db, err := InitDB("invalid")
+		db, err := InitDB("invalid", config.Database{URL: "url"})
 
 		assert.NotNil(t, err)
 		assert.Nil(t, db)
 	})
 }
+
+func TestPing(t *testing.T) {
+	pingBackoff = time.Millisecond
+
+	t.Run("should retry until database is ready", func(t *testing.T) {
+		db, mock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+		mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+		mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+		mock.ExpectPing()
+
+		err := Ping(context.Background(), db, time.Second)
+
+		assert.NoError(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should give up after timeout", func(t *testing.T) {
+		db, mock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+		for i := 0; i < 100; i++ {
+			mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+		}
+
+		err := Ping(context.Background(), db, 20*time.Millisecond)
+
+		assert.ErrorContains(t, err, "database not ready")
+	})
+}
@@ -0,0 +1,88 @@
+package expense
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/panudetjt/assessment/ledger"
+	"github.com/panudetjt/assessment/problem"
+)
+
+func (h *Handler) DeleteExpenseHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidID, "expense id must be an integer")
+	}
+	if err := h.access(c, id, ledger.Editor); err != nil {
+		return err
+	}
+	reason, err := overrideReason(c)
+	if err != nil {
+		return err
+	}
+
+	keys, err := h.attachmentKeys(c, id)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	err = h.inTx(c, func(tx *sql.Tx) error {
+		var before Expense
+		err := tx.QueryRowContext(ctx, "DELETE FROM expenses WHERE id = $1 AND "+live+" RETURNING "+columns, id).Scan(before.fields()...)
+		if err == sql.ErrNoRows {
+			return expenseNotFound(id)
+		}
+		if err != nil {
+			return problem.Internal(fmt.Errorf("can't execute delete expense statment: %w", err))
+		}
+		// Only the deleted row tells its status and day; a submitted expense
+		// or a closed period rolls it back.
+		if !editable(before.Status) {
+			return notEditable(before)
+		}
+		closed, err := checkPeriods(ctx, tx, before.LedgerID, reason, before.SpentOn)
+		if err != nil {
+			return err
+		}
+		if err := auditOverrides(c, tx, closed, id, ActionDeleted, reason); err != nil {
+			return err
+		}
+		return record(c, tx, id, ActionDeleted, &before, nil)
+	})
+	if err != nil {
+		return err
+	}
+	h.deleteBlobs(c, keys...)
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// attachmentKeys returns the blob keys of the expense's attachments, whose
+// rows are deleted with it by cascade. Without a blob store there is nothing
+// to clean up.
+func (h *Handler) attachmentKeys(c echo.Context, id int) ([]string, error) {
+	if h.Blobs == nil {
+		return nil, nil
+	}
+	rows, err := h.DB.QueryContext(c.Request().Context(), "SELECT storage_key FROM attachments WHERE expense_id = $1", id)
+	if err != nil {
+		return nil, problem.Internal(fmt.Errorf("can't query attachment keys: %w", err))
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, problem.Internal(fmt.Errorf("can't scan attachment keys: %w", err))
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, problem.Internal(fmt.Errorf("can't read attachment keys: %w", err))
+	}
+	return keys, nil
+}
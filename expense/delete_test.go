@@ -0,0 +1,128 @@
+package expense
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/problem"
+	"github.com/panudetjt/assessment/storage"
+	"github.com/panudetjt/assessment/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeleteExpenseHandler(t *testing.T) {
+	request := func(id string) *util.Response {
+		res := util.RequestE(http.MethodDelete, "/expenses/"+id, nil)
+		res.Context.SetPath("/expenses/:id")
+		res.Context.SetParamNames("id")
+		res.Context.SetParamValues(id)
+		return res
+	}
+
+	t.Run("should return 204 (NoContent) when expense is deleted", func(t *testing.T) {
+		res := request("1")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("DELETE FROM expenses WHERE id = \\$1 AND deleted_at IS NULL RETURNING").
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(expenseColumns).AddRow(1, "rent", 100, "", nil, nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft"))
+		expectPeriods(mock)
+		expectEvent(mock, ActionDeleted)
+		handler := Handler{DB: db}
+
+		res.Serve(handler.DeleteExpenseHandler)
+
+		assert.Equal(t, http.StatusNoContent, res.Recorder.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 409 (Conflict) deleting a submitted expense", func(t *testing.T) {
+		res := request("1")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("DELETE FROM expenses").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(expenseColumns).AddRow(1, "rent", 100, "", nil, nil, nil, "THB", "2023-01-02", nil, nil, nil, "submitted"))
+		mock.ExpectRollback()
+		handler := Handler{DB: db}
+
+		res.Serve(handler.DeleteExpenseHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusConflict, res.Recorder.Code)
+		assert.Equal(t, problem.CodeNotEditable, p.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should delete the blobs of the expense's attachments", func(t *testing.T) {
+		res := request("1")
+		db, mock, _ := sqlmock.New()
+		blobs, _ := storage.NewLocal(t.TempDir())
+		blobs.Put(context.Background(), "attachments/1/k", strings.NewReader("x"), 1, "image/png")
+		mock.ExpectQuery("SELECT storage_key FROM attachments WHERE expense_id = \\$1").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"storage_key"}).AddRow("attachments/1/k"))
+		mock.ExpectBegin()
+		mock.ExpectQuery("DELETE FROM expenses WHERE id = \\$1 AND deleted_at IS NULL RETURNING").
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(expenseColumns).AddRow(1, "rent", 100, "", nil, nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft"))
+		expectPeriods(mock)
+		expectEvent(mock, ActionDeleted)
+		handler := Handler{DB: db, Blobs: blobs}
+
+		res.Serve(handler.DeleteExpenseHandler)
+		_, err := blobs.Open(context.Background(), "attachments/1/k")
+
+		assert.Equal(t, http.StatusNoContent, res.Recorder.Code)
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 404 (NotFound) when no expense is deleted", func(t *testing.T) {
+		res := request("1")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("DELETE FROM expenses WHERE id = \\$1 AND deleted_at IS NULL RETURNING").
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(expenseColumns))
+		mock.ExpectRollback()
+		handler := Handler{DB: db}
+
+		res.Serve(handler.DeleteExpenseHandler)
+		var e problem.Problem
+		res.Decode(&e)
+
+		assert.Equal(t, http.StatusNotFound, res.Recorder.Code)
+		assert.Equal(t, problem.CodeExpenseNotFound, e.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 400 (BadRequest) when id is invalid", func(t *testing.T) {
+		res := request("abc")
+		db, mock, _ := sqlmock.New()
+		handler := Handler{DB: db}
+
+		res.Serve(handler.DeleteExpenseHandler)
+
+		assert.Equal(t, http.StatusBadRequest, res.Recorder.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 500 (InternalServerError) when cannot execute delete", func(t *testing.T) {
+		res := request("1")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("DELETE FROM expenses WHERE id = \\$1 AND deleted_at IS NULL RETURNING").
+			WillReturnError(&pq.Error{})
+		mock.ExpectRollback()
+		handler := Handler{DB: db}
+
+		res.Serve(handler.DeleteExpenseHandler)
+
+		assert.Equal(t, http.StatusInternalServerError, res.Recorder.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
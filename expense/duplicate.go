@@ -0,0 +1,298 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/ledger"
+	"github.com/panudetjt/assessment/problem"
+)
+
+// DuplicatePolicy decides when two expenses may be the same one entered
+// twice: they are in the same ledger, have the same amount and currency, are
+// spent at most WindowDays apart and have titles at least Similarity alike,
+// as pg_trgm measures it from 0 to 1. Warn rejects new expenses with a
+// duplicate unless the caller allows it.
+type DuplicatePolicy struct {
+	Warn       bool
+	WindowDays int
+	Similarity float64
+}
+
+// DuplicateGroup is a set of expenses that may all be the same one.
+type DuplicateGroup struct {
+	Expenses []Expense `json:"expenses"`
+}
+
+// ExpenseMerge is the body of POST /expenses/merge.
+type ExpenseMerge struct {
+	Keep    int `json:"keep"`
+	Discard int `json:"discard"`
+}
+
+// maxDuplicates bounds the ids listed when a new expense is rejected.
+const maxDuplicates = 5
+
+// checkDuplicates rejects e when an expense may already record it.
+func (h *Handler) checkDuplicates(ctx context.Context, tx *sql.Tx, e *Expense) error {
+	rows, err := tx.QueryContext(ctx,
+		"SELECT id FROM expenses WHERE "+live+" AND ledger_id IS NOT DISTINCT FROM $1 AND amount = $2 AND currency = $3 "+
+			"AND spent_on BETWEEN $4::date - $5::integer AND $4::date + $5::integer AND similarity(title, $6) >= $7 ORDER BY id LIMIT "+strconv.Itoa(maxDuplicates),
+		e.LedgerID, e.Amount, e.Currency, e.SpentOn, h.Duplicates.WindowDays, e.Title, h.Duplicates.Similarity)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't query duplicates: %w", err))
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return problem.Internal(fmt.Errorf("can't scan duplicates: %w", err))
+		}
+		ids = append(ids, strconv.Itoa(id))
+	}
+	if err := rows.Err(); err != nil {
+		return problem.Internal(fmt.Errorf("can't query duplicates: %w", err))
+	}
+	if ids == nil {
+		return nil
+	}
+	return problem.New(http.StatusConflict, problem.CodePossibleDuplicate,
+		fmt.Sprintf("the expense may duplicate expenses %s, create it with allow_duplicate=true if it doesn't", strings.Join(ids, ", ")))
+}
+
+// prefixed qualifies columns with a table alias.
+func prefixed(alias string) string {
+	return alias + "." + strings.ReplaceAll(columns, ", ", ", "+alias+".")
+}
+
+// DuplicatesHandler lists the groups of expenses of the caller's ledgers, or
+// of ledger_id only, that may be duplicates of each other.
+func (h *Handler) DuplicatesHandler(c echo.Context) error {
+	f, err := ledgerFilter(c)
+	if err != nil {
+		return err
+	}
+	f.conds = append(f.conds, live)
+	window, similarity := f.arg(h.Duplicates.WindowDays), f.arg(h.Duplicates.Similarity)
+	stmt, err := h.prepare("SELECT " + prefixed("a") + ", " + prefixed("b") + " FROM (SELECT " + columns + " FROM expenses" + f.where() + ") AS a " +
+		"JOIN expenses AS b ON b.id > a.id AND b." + live + " AND b.ledger_id IS NOT DISTINCT FROM a.ledger_id AND b.amount = a.amount AND b.currency = a.currency " +
+		"AND b.spent_on BETWEEN a.spent_on - " + window + "::integer AND a.spent_on + " + window + "::integer AND similarity(a.title, b.title) >= " + similarity +
+		" ORDER BY a.id, b.id")
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't prepare duplicates statment: %w", err))
+	}
+
+	ctx := c.Request().Context()
+	var groups []DuplicateGroup
+	err = retryRead(ctx, func() error {
+		rows, err := stmt.QueryContext(ctx, f.args...)
+		if err != nil {
+			return fmt.Errorf("can't query duplicates: %w", err)
+		}
+		defer rows.Close()
+		var pairs [][2]Expense
+		for rows.Next() {
+			var a, b Expense
+			if err := rows.Scan(append(a.fields(), b.fields()...)...); err != nil {
+				return fmt.Errorf("can't scan duplicates: %w", err)
+			}
+			pairs = append(pairs, [2]Expense{a, b})
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		groups = groupDuplicates(pairs)
+		return nil
+	})
+	if err != nil {
+		return problem.Internal(err)
+	}
+	return c.JSON(http.StatusOK, groups)
+}
+
+// groupDuplicates joins the pairs sharing an expense into groups, ordered by
+// their first expense and each ordered by id.
+func groupDuplicates(pairs [][2]Expense) []DuplicateGroup {
+	parent := map[int]int{}
+	var root func(id int) int
+	root = func(id int) int {
+		if p, ok := parent[id]; ok && p != id {
+			parent[id] = root(p)
+			return parent[id]
+		}
+		parent[id] = id
+		return id
+	}
+	expenses := map[int]Expense{}
+	for _, p := range pairs {
+		a, b := root(p[0].ID), root(p[1].ID)
+		if a > b {
+			a, b = b, a
+		}
+		parent[b] = a
+		expenses[p[0].ID], expenses[p[1].ID] = p[0], p[1]
+	}
+
+	ids := make([]int, 0, len(expenses))
+	for id := range expenses {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	groups := []DuplicateGroup{}
+	index := map[int]int{}
+	for _, id := range ids {
+		r := root(id)
+		i, ok := index[r]
+		if !ok {
+			i = len(groups)
+			index[r] = i
+			groups = append(groups, DuplicateGroup{})
+		}
+		groups[i].Expenses = append(groups[i].Expenses, expenses[id])
+	}
+	return groups
+}
+
+// MergeExpensesHandler merges a duplicate into the expense kept: the kept
+// one gains the tags it misses, its note, and its attachments, and the
+// duplicate is soft-deleted. Both are recorded in their history, and both must
+// still be editable and outside closed periods, unless override_reason
+// overrides them.
+func (h *Handler) MergeExpensesHandler(c echo.Context) error {
+	var mg ExpenseMerge
+	if err := c.Bind(&mg); err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "request body must be a merge JSON object")
+	}
+	var errs []problem.FieldError
+	if mg.Keep <= 0 {
+		errs = append(errs, problem.FieldError{Field: "keep", Message: "must be an expense id"})
+	}
+	if mg.Discard <= 0 {
+		errs = append(errs, problem.FieldError{Field: "discard", Message: "must be an expense id"})
+	} else if mg.Discard == mg.Keep {
+		errs = append(errs, problem.FieldError{Field: "discard", Message: "must not be the expense kept"})
+	}
+	if errs != nil {
+		return problem.Validation(errs...)
+	}
+	for _, id := range []int{mg.Keep, mg.Discard} {
+		if err := h.access(c, id, ledger.Editor); err != nil {
+			return err
+		}
+	}
+	reason, err := overrideReason(c)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	var kept Expense
+	err = h.inTx(c, func(tx *sql.Tx) error {
+		locked := map[int]Expense{}
+		err := func() error {
+			rows, err := tx.QueryContext(ctx, "SELECT "+columns+" FROM expenses WHERE id IN ($1, $2) AND "+live+" ORDER BY id FOR UPDATE", mg.Keep, mg.Discard)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var e Expense
+				if err := rows.Scan(e.fields()...); err != nil {
+					return err
+				}
+				locked[e.ID] = e
+			}
+			return rows.Err()
+		}()
+		if err != nil {
+			return problem.Internal(fmt.Errorf("can't lock expenses: %w", err))
+		}
+		before, ok := locked[mg.Keep]
+		if !ok {
+			return expenseNotFound(mg.Keep)
+		}
+		discarded, ok := locked[mg.Discard]
+		if !ok {
+			return expenseNotFound(mg.Discard)
+		}
+		if !sameLedger(before.LedgerID, discarded.LedgerID) {
+			return problem.Validation(problem.FieldError{Field: "discard", Message: "must be in the ledger of the expense kept"})
+		}
+		for _, e := range []Expense{before, discarded} {
+			if !editable(e.Status) {
+				return notEditable(e)
+			}
+		}
+		keptClosed, err := checkPeriods(ctx, tx, before.LedgerID, reason, before.SpentOn)
+		if err != nil {
+			return err
+		}
+		discardedClosed, err := checkPeriods(ctx, tx, discarded.LedgerID, reason, discarded.SpentOn)
+		if err != nil {
+			return err
+		}
+
+		kept = before
+		kept.Tags = append([]string{}, before.Tags...)
+		for _, t := range discarded.Tags {
+			if !hasTag(kept.Tags, t) {
+				kept.Tags = append(kept.Tags, t)
+			}
+		}
+		switch {
+		case kept.Note == "":
+			kept.Note = discarded.Note
+		case discarded.Note != "" && discarded.Note != kept.Note:
+			kept.Note += "\n" + discarded.Note
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE expenses SET tags = $2, note = $3 WHERE id = $1", kept.ID, pq.Array(kept.Tags), kept.Note); err != nil {
+			return problem.Internal(fmt.Errorf("can't update kept expense: %w", err))
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE attachments SET expense_id = $2 WHERE expense_id = $1", discarded.ID, kept.ID); err != nil {
+			return problem.Internal(fmt.Errorf("can't move attachments: %w", err))
+		}
+		// The bank statement line the duplicate was reconciled with paid the
+		// expense kept. An expense is reconciled with a single line.
+		_, err = tx.ExecContext(ctx, "UPDATE reconciled_lines SET expense_id = $2 WHERE expense_id = $1", discarded.ID, kept.ID)
+		if pe, ok := err.(*pq.Error); ok && pe.Code == "23505" {
+			return problem.New(http.StatusConflict, problem.CodeAlreadyReconciled,
+				fmt.Sprintf("expenses %d and %d are both reconciled, with different statement lines", kept.ID, discarded.ID))
+		}
+		if err != nil {
+			return problem.Internal(fmt.Errorf("can't move reconciled line: %w", err))
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE expenses SET deleted_at = now(), merged_into = $2 WHERE id = $1", discarded.ID, kept.ID); err != nil {
+			return problem.Internal(fmt.Errorf("can't delete merged expense: %w", err))
+		}
+		if err := auditOverrides(c, tx, keptClosed, kept.ID, ActionUpdated, reason); err != nil {
+			return err
+		}
+		if err := auditOverrides(c, tx, discardedClosed, discarded.ID, ActionDeleted, reason); err != nil {
+			return err
+		}
+		if err := record(c, tx, kept.ID, ActionUpdated, &before, &kept); err != nil {
+			return err
+		}
+		return record(c, tx, discarded.ID, ActionDeleted, &discarded, nil)
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, kept)
+}
+
+func sameLedger(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
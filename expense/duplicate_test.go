@@ -0,0 +1,209 @@
+package expense
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/date"
+	"github.com/panudetjt/assessment/problem"
+	"github.com/panudetjt/assessment/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDuplicates(t *testing.T) {
+	policy := DuplicatePolicy{Warn: true, WindowDays: 3, Similarity: 0.7}
+
+	t.Run("should return 409 (Conflict) creating a possible duplicate", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/expenses", strings.NewReader(`{"title": "Grab taxi", "amount": 120}`))
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		expectPeriods(mock)
+		expectRules(mock)
+		mock.ExpectQuery("SELECT id FROM expenses WHERE deleted_at IS NULL AND ledger_id IS NOT DISTINCT FROM \\$1 AND amount = \\$2 AND currency = \\$3 "+
+			"AND spent_on BETWEEN \\$4::date - \\$5::integer AND \\$4::date \\+ \\$5::integer AND similarity\\(title, \\$6\\) >= \\$7 ORDER BY id LIMIT 5").
+			WithArgs(nil, 120.0, "THB", date.Today(), 3, "Grab taxi", 0.7).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4).AddRow(9))
+		mock.ExpectRollback()
+		h := Handler{DB: db, Duplicates: policy}
+
+		res.Serve(h.CreateExpensesHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusConflict, res.Recorder.Code)
+		assert.Equal(t, problem.CodePossibleDuplicate, p.Code)
+		assert.Contains(t, p.Detail, "expenses 4, 9")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should create a possible duplicate when allowed", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/expenses?allow_duplicate=true", strings.NewReader(`{"title": "Grab taxi", "amount": 120}`))
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		expectPeriods(mock)
+		expectRules(mock)
+		mock.ExpectQuery("INSERT INTO expenses").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+		expectEvent(mock, ActionCreated)
+		h := Handler{DB: db, Duplicates: policy}
+
+		res.Serve(h.CreateExpensesHandler)
+
+		assert.Equal(t, http.StatusCreated, res.Recorder.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should group the pairs of duplicates", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses/duplicates", nil)
+		db, mock, _ := sqlmock.New()
+		row := func(id int, title string) []driver.Value {
+			return []driver.Value{id, title, 120, "", pq.Array([]string{}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft"}
+		}
+		mock.ExpectPrepare("SELECT a.id, a.title, (.+), b.status FROM \\(SELECT (.+) FROM expenses WHERE deleted_at IS NULL\\) AS a "+
+			"JOIN expenses AS b ON b.id > a.id AND b.deleted_at IS NULL (.+) AND similarity\\(a.title, b.title\\) >= \\$2 ORDER BY a.id, b.id").
+			ExpectQuery().WithArgs(3, 0.7).
+			WillReturnRows(sqlmock.NewRows(append(expenseColumns, expenseColumns...)).
+				AddRow(append(row(1, "Grab taxi"), row(3, "grab taxi")...)...).
+				AddRow(append(row(2, "Bolt ride"), row(5, "Bolt ride!")...)...).
+				AddRow(append(row(3, "grab taxi"), row(4, "Grab Taxi")...)...))
+		h := Handler{DB: db, Duplicates: policy}
+
+		res.Serve(h.DuplicatesHandler)
+		var got []DuplicateGroup
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		ids := [][]int{}
+		for _, g := range got {
+			var group []int
+			for _, e := range g.Expenses {
+				group = append(group, e.ID)
+			}
+			ids = append(ids, group)
+		}
+		assert.Equal(t, [][]int{{1, 3, 4}, {2, 5}}, ids)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should merge the tags and notes and soft-delete the duplicate", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/expenses/merge", strings.NewReader(`{"keep": 1, "discard": 3}`))
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM expenses WHERE id IN \\(\\$1, \\$2\\) AND deleted_at IS NULL ORDER BY id FOR UPDATE").WithArgs(1, 3).
+			WillReturnRows(sqlmock.NewRows(expenseColumns).
+				AddRow(1, "Grab taxi", 120, "airport", pq.Array([]string{"transport"}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft").
+				AddRow(3, "grab taxi", 120, "with Ann", pq.Array([]string{"transport", "work"}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft"))
+		expectPeriods(mock)
+		expectPeriods(mock)
+		mock.ExpectExec("UPDATE expenses SET tags = \\$2, note = \\$3 WHERE id = \\$1").
+			WithArgs(1, pq.Array([]string{"transport", "work"}), "airport\nwith Ann").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE attachments SET expense_id = \\$2 WHERE expense_id = \\$1").WithArgs(3, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("UPDATE reconciled_lines SET expense_id = \\$2 WHERE expense_id = \\$1").WithArgs(3, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE expenses SET deleted_at = now\\(\\), merged_into = \\$2 WHERE id = \\$1").WithArgs(3, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("INSERT INTO expense_events").
+			WithArgs(1, ActionUpdated, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"version", "created_at"}).AddRow(2, time.Now()))
+		mock.ExpectExec("INSERT INTO webhook_outbox").WillReturnResult(sqlmock.NewResult(0, 1))
+		expectEvent(mock, ActionDeleted)
+		h := Handler{DB: db}
+
+		res.Serve(h.MergeExpensesHandler)
+		var got Expense
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Equal(t, []string{"transport", "work"}, got.Tags)
+		assert.Equal(t, "airport\nwith Ann", got.Note)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 409 (Conflict) when both expenses are reconciled", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/expenses/merge", strings.NewReader(`{"keep": 1, "discard": 3}`))
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM expenses WHERE id IN").
+			WillReturnRows(sqlmock.NewRows(expenseColumns).
+				AddRow(1, "Grab taxi", 120, "", pq.Array([]string{}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft").
+				AddRow(3, "grab taxi", 120, "", pq.Array([]string{}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft"))
+		expectPeriods(mock)
+		expectPeriods(mock)
+		mock.ExpectExec("UPDATE expenses SET tags").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE attachments").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("UPDATE reconciled_lines").WillReturnError(&pq.Error{Code: "23505"})
+		mock.ExpectRollback()
+		h := Handler{DB: db}
+
+		res.Serve(h.MergeExpensesHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusConflict, res.Recorder.Code)
+		assert.Equal(t, problem.CodeAlreadyReconciled, p.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 422 (UnprocessableEntity) merging expenses of different ledgers", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/expenses/merge", strings.NewReader(`{"keep": 1, "discard": 3}`))
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM expenses WHERE id IN").
+			WillReturnRows(sqlmock.NewRows(expenseColumns).
+				AddRow(1, "Grab taxi", 120, "", pq.Array([]string{}), 2, nil, "THB", "2023-01-02", nil, nil, nil, "draft").
+				AddRow(3, "grab taxi", 120, "", pq.Array([]string{}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft"))
+		mock.ExpectRollback()
+		h := Handler{DB: db}
+
+		res.Serve(h.MergeExpensesHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, res.Recorder.Code)
+		assert.Equal(t, "discard", p.Errors[0].Field)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 409 (Conflict) merging an approved expense", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/expenses/merge", strings.NewReader(`{"keep": 1, "discard": 3}`))
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM expenses WHERE id IN").
+			WillReturnRows(sqlmock.NewRows(expenseColumns).
+				AddRow(1, "Grab taxi", 120, "", pq.Array([]string{}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft").
+				AddRow(3, "grab taxi", 120, "", pq.Array([]string{}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "approved"))
+		mock.ExpectRollback()
+		h := Handler{DB: db}
+
+		res.Serve(h.MergeExpensesHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusConflict, res.Recorder.Code)
+		assert.Equal(t, problem.CodeNotEditable, p.Code)
+		assert.Equal(t, "expense 3 is approved, only draft or rejected expenses can be changed", p.Detail)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 404 (NotFound) when the duplicate was already merged", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/expenses/merge", strings.NewReader(`{"keep": 1, "discard": 3}`))
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM expenses WHERE id IN").
+			WillReturnRows(sqlmock.NewRows(expenseColumns).
+				AddRow(1, "Grab taxi", 120, "", pq.Array([]string{}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft"))
+		mock.ExpectRollback()
+		h := Handler{DB: db}
+
+		res.Serve(h.MergeExpensesHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusNotFound, res.Recorder.Code)
+		assert.Equal(t, "expense 3 does not exist", p.Detail)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -2,6 +2,12 @@ package expense
 
 import (
 	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/date"
+	"github.com/panudetjt/assessment/storage"
 )
 
 type Expense struct {
@@ -10,8 +16,57 @@ type Expense struct {
 	Amount float64  `json:"amount"`
 	Note   string   `json:"note"`
 	Tags   []string `json:"tags"`
+	// LedgerID is the shared ledger the expense belongs to. Only admin keys
+	// may keep expenses outside a ledger.
+	LedgerID *int `json:"ledger_id,omitempty"`
+	// Split shares the expense between participants, nil when unsplit.
+	Split *Split `json:"split,omitempty"`
+	// Currency is the ISO 4217 code of Amount, DefaultCurrency when not
+	// given, and SpentOn the day the money was spent, today when not given.
+	Currency string    `json:"currency"`
+	SpentOn  date.Date `json:"spent_on"`
+	// MerchantID and PaymentMethodID optionally reference a merchant and a
+	// payment method of the expense's ledger.
+	MerchantID      *int `json:"merchant_id,omitempty"`
+	PaymentMethodID *int `json:"payment_method_id,omitempty"`
+	// CategoryID optionally places the expense in the ledger's category tree.
+	CategoryID *int `json:"category_id,omitempty"`
+	// Status is where the expense is in the reimbursement workflow. Only the
+	// transition endpoints change it.
+	Status string `json:"status"`
+	// Converted is Amount in the currency asked for with convert_to.
+	Converted *Conversion `json:"converted,omitempty"`
+}
+
+// DefaultCurrency is the currency of expenses created without one.
+const DefaultCurrency = "THB"
+
+// columns are the expense columns in the order fields returns them, for
+// SELECT and RETURNING clauses.
+const columns = "id, title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id, status"
+
+// live is the condition excluding the expenses merged into another, which are
+// only kept for their history.
+const live = "deleted_at IS NULL"
+
+// fields returns scan destinations for columns.
+func (e *Expense) fields() []any {
+	return []any{&e.ID, &e.Title, &e.Amount, &e.Note, pq.Array(&e.Tags), &e.LedgerID, &e.Split, &e.Currency, &e.SpentOn, &e.MerchantID, &e.PaymentMethodID, &e.CategoryID, &e.Status}
 }
 
 type Handler struct {
 	DB *sql.DB
+	// Blobs stores attachment contents and Limits bounds their uploads.
+	Blobs  storage.BlobStore
+	Limits AttachmentLimits
+	// Stream fans expense events out to GET /expenses/stream.
+	Stream *Broker
+	// StreamTimeout is how long a stream lasts before its client has to
+	// reconnect, zero for as long as the client stays.
+	StreamTimeout time.Duration
+	// Duplicates decides which expenses may have been entered twice.
+	Duplicates DuplicatePolicy
+
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
 }
@@ -4,13 +4,20 @@ package expense
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/panudetjt/assessment/config"
+	"github.com/panudetjt/assessment/date"
 	"github.com/panudetjt/assessment/util"
 	"github.com/stretchr/testify/assert"
 )
@@ -98,6 +105,400 @@ func TestUpdate(t *testing.T) {
 	assert.Equal(t, []string{"beverage"}, got.Tags)
 }
 
+func TestHistory(t *testing.T) {
+	e := seedExpense(t)
+	b, _ := json.Marshal(Expense{Title: e.Title, Amount: 89, Note: e.Note, Tags: e.Tags})
+	util.Request(http.MethodPut, util.Uri("expenses/"+fmt.Sprint(e.ID)), strings.NewReader(string(b)))
+
+	var reverted Expense
+	res := util.Request(http.MethodPost, util.Uri("expenses/"+fmt.Sprint(e.ID), "revert"), strings.NewReader(`{"version": 1}`))
+	err := res.Decode(&reverted)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, e, reverted)
+
+	var events []Event
+	res = util.Request(http.MethodGet, util.Uri("expenses/"+fmt.Sprint(e.ID), "history"), nil)
+	err = res.Decode(&events)
+
+	assert.Nil(t, err)
+	assert.Len(t, events, 3)
+	assert.Equal(t, []string{ActionCreated, ActionUpdated, ActionReverted}, []string{events[0].Action, events[1].Action, events[2].Action})
+	assert.JSONEq(t, "89", string(events[1].Changes["amount"].After))
+}
+
+func TestSearch(t *testing.T) {
+	e := seedExpense(t)
+
+	for _, q := range []string{"straw nigh", "strawbery", "berry"} {
+		t.Run("find expense by "+q, func(t *testing.T) {
+			var got []SearchResult
+			res := util.Request(http.MethodGet, util.Uri("expenses", "search?q="+url.QueryEscape(q)+"&tag=food"), nil)
+			err := res.Decode(&got)
+
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			ids := make([]int, len(got))
+			for i, r := range got {
+				ids[i] = r.ID
+			}
+			assert.Contains(t, ids, e.ID)
+		})
+	}
+}
+
+func TestConvertTo(t *testing.T) {
+	tag := fmt.Sprintf("trip-%d", time.Now().UnixNano())
+	body := fmt.Sprintf(`{"title": "ramen", "amount": 1200, "currency": "JPY", "spent_on": "2023-01-02", "tags": [%q]}`, tag)
+	res := util.Request(http.MethodPost, util.Uri("expenses"), strings.NewReader(body))
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	res = util.Request(http.MethodPost, util.Uri("exchange-rates"), strings.NewReader(`[{"date": "2023-01-02", "base": "JPY", "quote": "THB", "rate": 0.25}]`))
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	var s Summary
+	res = util.Request(http.MethodGet, util.Uri("expenses", "summary?convert_to=THB&tag="+tag), nil)
+	err := res.Decode(&s)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, 300.0, s.Total)
+
+	res = util.Request(http.MethodGet, util.Uri("expenses", "summary?convert_to=USD&tag="+tag), nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, res.StatusCode)
+}
+
+func TestGroupByMerchant(t *testing.T) {
+	var mc Merchant
+	res := util.Request(http.MethodPost, util.Uri("merchants"), strings.NewReader(fmt.Sprintf(`{"name": "market-%d"}`, time.Now().UnixNano())))
+	err := res.Decode(&mc)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	tag := fmt.Sprintf("shop-%d", time.Now().UnixNano())
+	for _, body := range []string{
+		fmt.Sprintf(`{"title": "rice", "amount": 60, "tags": [%q], "merchant_id": %d}`, tag, mc.ID),
+		fmt.Sprintf(`{"title": "eggs", "amount": 40, "tags": [%q], "merchant_id": %d}`, tag, mc.ID),
+		fmt.Sprintf(`{"title": "tip", "amount": 20, "tags": [%q]}`, tag),
+	} {
+		res := util.Request(http.MethodPost, util.Uri("expenses"), strings.NewReader(body))
+		assert.Equal(t, http.StatusCreated, res.StatusCode)
+	}
+
+	var s Summary
+	res = util.Request(http.MethodGet, util.Uri("expenses", "summary?group_by=merchant&tag="+tag), nil)
+	err = res.Decode(&s)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, []GroupSummary{{&mc.ID, nil, mc.Name, 2, 100}, {nil, nil, "", 1, 20}}, s.ByMerchant)
+
+	res = util.Request(http.MethodDelete, util.Uri("merchants", fmt.Sprint(mc.ID)), nil)
+	assert.Equal(t, http.StatusConflict, res.StatusCode)
+}
+
+func TestCategoryRollup(t *testing.T) {
+	createCategory := func(body string) Category {
+		var ct Category
+		res := util.Request(http.MethodPost, util.Uri("categories"), strings.NewReader(body))
+		err := res.Decode(&ct)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusCreated, res.StatusCode)
+		return ct
+	}
+	food := createCategory(fmt.Sprintf(`{"name": "food-%d"}`, time.Now().UnixNano()))
+	coffee := createCategory(fmt.Sprintf(`{"name": "Coffee", "parent_id": %d}`, food.ID))
+	assert.Equal(t, food.Name+" > Coffee", coffee.Path)
+	for _, body := range []string{
+		fmt.Sprintf(`{"title": "latte", "amount": 90, "category_id": %d}`, coffee.ID),
+		fmt.Sprintf(`{"title": "rice", "amount": 60, "category_id": %d}`, food.ID),
+	} {
+		res := util.Request(http.MethodPost, util.Uri("expenses"), strings.NewReader(body))
+		assert.Equal(t, http.StatusCreated, res.StatusCode)
+	}
+
+	var s Summary
+	res := util.Request(http.MethodGet, util.Uri("expenses", fmt.Sprintf("summary?group_by=category&category_id=%d", food.ID)), nil)
+	err := res.Decode(&s)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, []GroupSummary{
+		{&food.ID, nil, food.Name, 2, 150},
+		{&coffee.ID, &food.ID, "Coffee", 1, 90},
+	}, s.ByCategory)
+
+	res = util.Request(http.MethodPut, util.Uri("categories", fmt.Sprint(food.ID)), strings.NewReader(fmt.Sprintf(`{"name": %q, "parent_id": %d}`, food.Name, coffee.ID)))
+	assert.Equal(t, http.StatusUnprocessableEntity, res.StatusCode)
+}
+
+func TestRuleTagsNewExpenses(t *testing.T) {
+	word := fmt.Sprintf("kopi%d", time.Now().UnixNano())
+	var r Rule
+	res := util.Request(http.MethodPost, util.Uri("rules"), strings.NewReader(fmt.Sprintf(`{"name": %q, "conditions": {"title": %q}, "actions": {"add_tags": ["coffee"]}}`, word, word)))
+	assert.Nil(t, res.Decode(&r))
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	defer util.Request(http.MethodDelete, util.Uri("rules", fmt.Sprint(r.ID)), nil)
+
+	var e Expense
+	res = util.Request(http.MethodPost, util.Uri("expenses"), strings.NewReader(fmt.Sprintf(`{"title": "%s O", "amount": 25, "tags": ["food"]}`, strings.ToUpper(word))))
+	err := res.Decode(&e)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Equal(t, []string{"food", "coffee"}, e.Tags)
+}
+
+func TestMergeDuplicates(t *testing.T) {
+	title := fmt.Sprintf("taxi %d", time.Now().UnixNano())
+	create := func(body string) Expense {
+		var e Expense
+		res := util.Request(http.MethodPost, util.Uri("expenses"), strings.NewReader(body))
+		assert.Nil(t, res.Decode(&e))
+		assert.Equal(t, http.StatusCreated, res.StatusCode)
+		return e
+	}
+	keep := create(fmt.Sprintf(`{"title": %q, "amount": 317, "tags": ["transport"]}`, title))
+	discard := create(fmt.Sprintf(`{"title": "%s!", "amount": 317, "note": "airport", "tags": ["work"]}`, strings.ToUpper(title)))
+
+	var groups []DuplicateGroup
+	res := util.Request(http.MethodGet, util.Uri("expenses", "duplicates"), nil)
+	assert.Nil(t, res.Decode(&groups))
+	found := false
+	for _, g := range groups {
+		for _, e := range g.Expenses {
+			found = found || e.ID == discard.ID
+		}
+	}
+	assert.True(t, found, "the duplicate should be listed")
+
+	var merged Expense
+	res = util.Request(http.MethodPost, util.Uri("expenses", "merge"), strings.NewReader(fmt.Sprintf(`{"keep": %d, "discard": %d}`, keep.ID, discard.ID)))
+	err := res.Decode(&merged)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, []string{"transport", "work"}, merged.Tags)
+	assert.Equal(t, "airport", merged.Note)
+	res = util.Request(http.MethodGet, util.Uri("expenses", fmt.Sprint(discard.ID)), nil)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestApprovalWorkflow(t *testing.T) {
+	e := seedExpense(t)
+	id := fmt.Sprint(e.ID)
+	moveAs := func(token, action, body string) *util.HttpResponse {
+		req, _ := http.NewRequest(http.MethodPost, util.Uri("expenses/"+id, action), strings.NewReader(body))
+		req.Header.Add("Authorization", token)
+		req.Header.Add("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		return &util.HttpResponse{Response: resp, Error: err}
+	}
+	move := func(token, action, body string) Expense {
+		var got Expense
+		res := moveAs(token, action, body)
+		assert.Nil(t, res.Decode(&got))
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		return got
+	}
+	submitter, approver := os.Getenv("AUTH_TOKEN"), os.Getenv("APPROVER_TOKEN")
+
+	assert.Equal(t, StatusSubmitted, move(submitter, "submit", `{"comment": "team dinner"}`).Status)
+	res := util.Request(http.MethodPut, util.Uri("expenses/"+id), strings.NewReader(`{"title": "smoothie", "amount": 99}`))
+	assert.Equal(t, http.StatusConflict, res.StatusCode)
+	assert.Equal(t, http.StatusForbidden, moveAs(submitter, "approve", `{}`).StatusCode)
+	assert.Equal(t, StatusApproved, move(approver, "approve", `{}`).Status)
+	assert.Equal(t, http.StatusForbidden, moveAs(submitter, "reimburse", `{}`).StatusCode)
+	assert.Equal(t, StatusReimbursed, move(approver, "reimburse", `{"comment": "paid with March salary"}`).Status)
+
+	var ts []Transition
+	res = util.Request(http.MethodGet, util.Uri("expenses/"+id, "transitions"), nil)
+	err := res.Decode(&ts)
+
+	assert.Nil(t, err)
+	assert.Len(t, ts, 3)
+	assert.Equal(t, "team dinner", ts[0].Comment)
+	assert.Equal(t, []string{StatusSubmitted, StatusApproved, StatusReimbursed}, []string{ts[0].To, ts[1].To, ts[2].To})
+}
+
+func TestClosedPeriod(t *testing.T) {
+	var e Expense
+	res := util.Request(http.MethodPost, util.Uri("expenses"), strings.NewReader(`{"title": "rent", "amount": 9000, "spent_on": "2001-01-15"}`))
+	assert.Nil(t, res.Decode(&e))
+	period := func(action string) int {
+		return util.Request(http.MethodPost, util.Uri("accounting-periods", action), strings.NewReader(`{"month": "2001-01"}`)).StatusCode
+	}
+	assert.Equal(t, http.StatusOK, period("close"))
+	defer period("reopen")
+
+	update := func(query string) int {
+		return util.Request(http.MethodPut, util.Uri("expenses/"+fmt.Sprint(e.ID))+query, strings.NewReader(`{"title": "rent", "amount": 9500}`)).StatusCode
+	}
+	assert.Equal(t, http.StatusConflict, update(""))
+	assert.Equal(t, http.StatusOK, update("?override_reason=late+invoice"))
+
+	var periods []Period
+	res = util.Request(http.MethodGet, util.Uri("accounting-periods"), nil)
+	assert.Nil(t, res.Decode(&periods))
+	var overrides []PeriodOverride
+	for _, p := range periods {
+		if p.Month == "2001-01" && p.LedgerID == nil {
+			res = util.Request(http.MethodGet, util.Uri("accounting-periods", fmt.Sprint(p.ID), "overrides"), nil)
+			assert.Nil(t, res.Decode(&overrides))
+		}
+	}
+	assert.NotEmpty(t, overrides)
+	assert.Equal(t, "late invoice", overrides[len(overrides)-1].Reason)
+}
+
+func TestClosePeriodRace(t *testing.T) {
+	db, err := InitDB("postgres", config.Database{URL: os.Getenv("DATABASE_URL"), MaxOpenConns: 5, MaxIdleConns: 5, ConnMaxLifetime: time.Minute})
+	if err != nil {
+		t.Fatal("can't open database:", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	month := time.Date(3000+int(time.Now().UnixNano()%5000), time.January, 1, 0, 0, 0, 0, time.UTC)
+	closePeriod := func(month time.Time) <-chan int {
+		status := make(chan int, 1)
+		go func() {
+			body := strings.NewReader(fmt.Sprintf(`{"month": %q}`, month.Format(monthLayout)))
+			status <- util.Request(http.MethodPost, util.Uri("accounting-periods", "close"), body).StatusCode
+		}()
+		return status
+	}
+
+	// A write that found the month open holds its close back until it ends.
+	write, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal("can't begin:", err)
+	}
+	closed, err := closedPeriods(ctx, write, nil, date.Of(month))
+	assert.NoError(t, err)
+	assert.Empty(t, closed)
+	status := closePeriod(month)
+	select {
+	case <-status:
+		t.Fatal("the month closed under a write that found it open")
+	case <-time.After(200 * time.Millisecond):
+	}
+	assert.NoError(t, write.Commit())
+	assert.Equal(t, http.StatusOK, <-status)
+
+	// A write that checks while the month is being closed finds it closed.
+	next := month.AddDate(0, 1, 0)
+	closing, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal("can't begin:", err)
+	}
+	assert.NoError(t, lockPeriods(ctx, closing, nil, "pg_advisory_xact_lock"))
+	_, err = closing.ExecContext(ctx, "INSERT INTO accounting_periods (month, closed_by) VALUES ($1, 'test')", next)
+	assert.NoError(t, err)
+	found := make(chan []Period, 1)
+	go func() {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			found <- nil
+			return
+		}
+		defer tx.Rollback()
+		closed, _ := closedPeriods(ctx, tx, nil, date.Of(next))
+		found <- closed
+	}()
+	select {
+	case <-found:
+		t.Fatal("the periods were checked while the month was being closed")
+	case <-time.After(200 * time.Millisecond):
+	}
+	assert.NoError(t, closing.Commit())
+	assert.Len(t, <-found, 1)
+}
+
+func TestReconcileStatement(t *testing.T) {
+	run := time.Now().UnixNano()
+	var e Expense
+	res := util.Request(http.MethodPost, util.Uri("expenses"), strings.NewReader(fmt.Sprintf(`{"title": "bookshop %d", "amount": 1234.56, "spent_on": "2002-03-10"}`, run)))
+	assert.Nil(t, res.Decode(&e))
+
+	reconcile := func() Reconciliation {
+		csv := fmt.Sprintf("id,date,description,amount\nline-%d,2002-03-11,BOOKSHOP %d BANGKOK,-1234.56\n", run, run)
+		req, _ := http.NewRequest(http.MethodPost, util.Uri("reconciliations")+"?currency=THB", strings.NewReader(csv))
+		req.Header.Add("Authorization", os.Getenv("AUTH_TOKEN"))
+		req.Header.Add("Content-Type", "text/csv")
+		resp, err := http.DefaultClient.Do(req)
+		var rec Reconciliation
+		assert.Nil(t, (&util.HttpResponse{Response: resp, Error: err}).Decode(&rec))
+		return rec
+	}
+	rec := reconcile()
+	assert.Len(t, rec.Matched, 1)
+	assert.Equal(t, e.ID, rec.Matched[0].Expense.ID)
+
+	body, _ := json.Marshal(ConfirmRequest{Matches: []ConfirmMatch{{Line: rec.Matched[0].Line, ExpenseID: e.ID}}})
+	res = util.Request(http.MethodPost, util.Uri("reconciliations", "confirm"), bytes.NewReader(body))
+	assert.Nil(t, res.Error)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	rec = reconcile()
+	assert.Empty(t, rec.Matched)
+	assert.Len(t, rec.Confirmed, 1)
+	assert.Equal(t, e.ID, rec.Confirmed[0].Expense.ID)
+}
+
+func TestConnectionPoolUnderLoad(t *testing.T) {
+	const maxOpen, workers, requests = 5, 50, 500
+	db, err := InitDB("postgres", config.Database{URL: os.Getenv("DATABASE_URL"), MaxOpenConns: maxOpen, MaxIdleConns: maxOpen, ConnMaxLifetime: time.Minute})
+	if err != nil {
+		t.Fatal("can't open database:", err)
+	}
+	defer db.Close()
+	seedExpense(t)
+
+	// One request on its own tells which statements a request prepares.
+	warm := &Handler{DB: db}
+	defer warm.Close()
+	res := util.RequestE(http.MethodGet, "/expenses", nil)
+	res.Serve(warm.GetAllExpenseHandler)
+	assert.Equal(t, http.StatusOK, res.Recorder.Code)
+
+	// Every other request is cancelled mid-flight, so failed queries must
+	// give their connection back as well as the ones that succeed.
+	h := &Handler{DB: db}
+	defer h.Close()
+	var failed int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < requests; i += workers {
+				res := util.RequestE(http.MethodGet, "/expenses", nil)
+				if i%2 == 1 {
+					ctx, cancel := context.WithCancel(context.Background())
+					cancel()
+					res.Context.SetRequest(res.Context.Request().WithContext(ctx))
+					res.Serve(h.GetAllExpenseHandler)
+					continue
+				}
+				res.Serve(h.GetAllExpenseHandler)
+				if res.Recorder.Code != http.StatusOK {
+					atomic.AddInt64(&failed, 1)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	assert.Zero(t, failed)
+	assert.Equal(t, 0, db.Stats().InUse, "connections leaked")
+	// Concurrent requests share the statements a lone request prepares
+	// instead of each preparing its own.
+	assert.Equal(t, len(warm.stmts), len(h.stmts))
+	// The pool still serves requests after the cancellations.
+	res = util.RequestE(http.MethodGet, "/expenses", nil)
+	res.Serve(h.GetAllExpenseHandler)
+	assert.Equal(t, http.StatusOK, res.Recorder.Code)
+}
+
 func seedExpense(t *testing.T) Expense {
 	body := bytes.NewBufferString(`{
 		"title": "strawberry smoothie",
@@ -0,0 +1,142 @@
+package expense
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/ledger"
+	m "github.com/panudetjt/assessment/middleware"
+	"github.com/panudetjt/assessment/problem"
+)
+
+// filter accumulates SQL conditions and their positional arguments from the
+// list query string. Conditions only ever reference placeholders, never
+// user input.
+type filter struct {
+	conds []string
+	args  []any
+}
+
+// arg appends v and returns its placeholder.
+func (f *filter) arg(v any) string {
+	f.args = append(f.args, v)
+	return "$" + strconv.Itoa(len(f.args))
+}
+
+func (f *filter) where() string {
+	if len(f.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(f.conds, " AND ")
+}
+
+// parseFilter reads the list filters shared by the list and summary endpoints:
+// tag (repeatable, all must match), min_amount, max_amount, title
+// (case-insensitive substring), ledger_id, merchant_id, payment_method_id,
+// status and category_id, which includes the descendants of the category. Callers other
+// than admins only ever see expenses of ledgers they are a member of, and
+// nobody sees the duplicates merged into another expense.
+func parseFilter(c echo.Context) (*filter, error) {
+	f, err := ledgerFilter(c)
+	if err != nil {
+		return nil, err
+	}
+	f.conds = append(f.conds, live)
+	category, err := queryInt(c, "category_id", 1, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+	if category.Valid {
+		f.conds = append(f.conds, "category_id IN ("+subtree(f.arg(category.Int64))+")")
+	}
+	for _, name := range []string{"merchant_id", "payment_method_id"} {
+		id, err := queryInt(c, name, 1, math.MaxInt32)
+		if err != nil {
+			return nil, err
+		}
+		if id.Valid {
+			f.conds = append(f.conds, name+" = "+f.arg(id.Int64))
+		}
+	}
+
+	if tags := c.QueryParams()["tag"]; len(tags) > 0 {
+		f.conds = append(f.conds, "tags @> "+f.arg(pq.Array(tags)))
+	}
+	for _, p := range []struct{ name, op string }{{"min_amount", ">="}, {"max_amount", "<="}} {
+		v := c.QueryParam(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, p.name+" must be a number")
+		}
+		f.conds = append(f.conds, "amount "+p.op+" "+f.arg(n))
+	}
+	if title := c.QueryParam("title"); title != "" {
+		f.conds = append(f.conds, "title ILIKE "+f.arg("%"+escapeLike(title)+"%"))
+	}
+	if status := c.QueryParam("status"); status != "" {
+		if !contains(statuses, status) {
+			return nil, problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, "status must be one of "+strings.Join(statuses, ", "))
+		}
+		f.conds = append(f.conds, "status = "+f.arg(status))
+	}
+
+	return f, nil
+}
+
+// ledgerFilter scopes a list of expenses, merchants or payment methods to the
+// caller's ledgers, or to ledger_id only.
+func ledgerFilter(c echo.Context) (*filter, error) {
+	f := &filter{}
+	if !ledger.IsAdmin(c) {
+		f.conds = append(f.conds, "ledger_id IN (SELECT ledger_id FROM ledger_members WHERE subject = "+f.arg(m.Subject(c))+")")
+	}
+	id, err := queryInt(c, "ledger_id", 1, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+	if id.Valid {
+		f.conds = append(f.conds, "ledger_id = "+f.arg(id.Int64))
+	}
+	return f, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// queryBool parses an optional boolean query parameter, false when absent.
+func queryBool(c echo.Context, name string) (bool, error) {
+	v := c.QueryParam(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, name+" must be true or false")
+	}
+	return b, nil
+}
+
+// queryInt parses an optional integer query parameter within [min, max]. An
+// absent parameter is returned as NULL.
+func queryInt(c echo.Context, name string, min, max int64) (sql.NullInt64, error) {
+	v := c.QueryParam(name)
+	if v == "" {
+		return sql.NullInt64{}, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < min || n > max {
+		return sql.NullInt64{}, problem.New(http.StatusBadRequest, problem.CodeInvalidQuery,
+			fmt.Sprintf("%s must be an integer between %d and %d", name, min, max))
+	}
+	return sql.NullInt64{Int64: n, Valid: true}, nil
+}
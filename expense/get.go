@@ -1,52 +1,132 @@
 package expense
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/labstack/echo/v4"
-	"github.com/lib/pq"
-	"github.com/panudetjt/assessment/util"
+	"github.com/panudetjt/assessment/ledger"
+	"github.com/panudetjt/assessment/problem"
 )
 
 func (h *Handler) GetExpenseByIdHandler(c echo.Context) error {
-	id := c.Param("id")
-	stmt, err := h.DB.Prepare("SELECT id, title, amount, note, tags FROM expenses WHERE id = $1")
+	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, util.Error{Message: "can't prepare query user statment:" + err.Error()})
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidID, "expense id must be an integer")
 	}
-	row := stmt.QueryRow(id)
+	if err := h.access(c, id, ledger.Viewer); err != nil {
+		return err
+	}
+	stmt, err := h.prepare("SELECT " + columns + " FROM expenses WHERE id = $1 AND " + live)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't prepare query expense statment: %w", err))
+	}
+	ctx := c.Request().Context()
 	ep := Expense{}
-	err = row.Scan(&ep.ID, &ep.Title, &ep.Amount, &ep.Note, pq.Array(&ep.Tags))
+	err = retryRead(ctx, func() error {
+		row := stmt.QueryRowContext(ctx, id)
+		return row.Scan(ep.fields()...)
+	})
 	switch err {
 	case sql.ErrNoRows:
-		return c.JSON(http.StatusNotFound, util.Error{Message: "expense not found"})
+		return expenseNotFound(id)
 	case nil:
 		return c.JSON(http.StatusOK, ep)
 	default:
-		return c.JSON(http.StatusInternalServerError, util.Error{Message: "can't scan expense:" + err.Error()})
+		return problem.Internal(fmt.Errorf("can't scan expense: %w", err))
 	}
 }
 
+const maxPageSize = 1000
+
+// GetAllExpenseHandler lists the expenses matching the list filters. With
+// convert_to every expense also carries its amount in that currency, and the
+// list fails when a rate of one of them is missing.
 func (h *Handler) GetAllExpenseHandler(c echo.Context) error {
-	stmt, err := h.DB.Prepare("SELECT id, title, amount, note, tags FROM expenses")
+	f, err := parseFilter(c)
+	if err != nil {
+		return err
+	}
+	to, err := parseConvert(c)
+	if err != nil {
+		return err
+	}
+	limit, err := queryInt(c, "limit", 1, maxPageSize)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, util.Error{Message: "can't prepare query expenses statment:" + err.Error()})
+		return err
 	}
-	rows, err := stmt.Query()
+	offset, err := queryInt(c, "offset", 0, math.MaxInt32)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, util.Error{Message: "can't query expenses:" + err.Error()})
+		return err
 	}
 
+	query := "SELECT " + columns
+	if to != "" {
+		query += ", " + f.rate(to)
+	}
+	query += " FROM expenses" + f.where() +
+		fmt.Sprintf(" ORDER BY id LIMIT %s OFFSET %s", f.arg(limit), f.arg(offset.Int64))
+	stmt, err := h.prepare(query)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't prepare query expenses statment: %w", err))
+	}
+
+	ctx := c.Request().Context()
 	var expenses []Expense
+	err = retryRead(ctx, func() error {
+		expenses = nil
+		return queryExpenses(ctx, stmt, &expenses, to, f.args...)
+	})
+	if err != nil {
+		return problem.Internal(err)
+	}
+
+	if to != "" {
+		var missing []missingRate
+		for _, e := range expenses {
+			if e.Converted == nil {
+				missing = addMissing(missing, missingRate{e.Currency, e.SpentOn})
+			}
+		}
+		if missing != nil {
+			return rateNotFound(to, missing)
+		}
+	}
+
+	return c.JSON(http.StatusOK, expenses)
+}
+
+// queryExpenses scans the expenses stmt returns. With a currency to convert
+// to, stmt also returns the rate of each, NULL when it is missing.
+func queryExpenses(ctx context.Context, stmt *sql.Stmt, expenses *[]Expense, to string, args ...any) error {
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return fmt.Errorf("can't query expenses: %w", err)
+	}
+	defer rows.Close()
+
 	for rows.Next() {
 		var ep Expense
-		err = rows.Scan(&ep.ID, &ep.Title, &ep.Amount, &ep.Note, pq.Array(&ep.Tags))
+		var rate sql.NullFloat64
+		dest := ep.fields()
+		if to != "" {
+			dest = append(dest, &rate)
+		}
+		err = rows.Scan(dest...)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, util.Error{Message: "can't scan expenses:" + err.Error()})
+			return fmt.Errorf("can't scan expenses: %w", err)
+		}
+		if rate.Valid {
+			ep.Converted = &Conversion{Currency: to, Rate: rate.Float64, Amount: ep.Amount * rate.Float64}
 		}
-		expenses = append(expenses, ep)
+		*expenses = append(*expenses, ep)
 	}
-
-	return c.JSON(http.StatusOK, expenses)
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("can't iterate expenses: %w", err)
+	}
+	return nil
 }
@@ -5,9 +5,11 @@ import (
 	"errors"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/problem"
 	"github.com/panudetjt/assessment/util"
 	"github.com/stretchr/testify/assert"
 )
@@ -18,16 +20,16 @@ func TestGetExpenseByIdHandler(t *testing.T) {
 		res.Context.SetPath("/expenses/:id")
 		res.Context.SetParamNames("id")
 		res.Context.SetParamValues("1")
-		mockRows := sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).
-			AddRow("1", "test-title", "123", "test-note", pq.Array([]string{"test-tags"}))
+		mockRows := sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "ledger_id", "split", "currency", "spent_on", "merchant_id", "payment_method_id", "category_id", "status"}).
+			AddRow("1", "test-title", "123", "test-note", pq.Array([]string{"test-tags"}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft")
 		db, mock, _ := sqlmock.New()
-		mock.ExpectPrepare("SELECT id, title, amount, note, tags FROM expenses WHERE id = ?").
+		mock.ExpectPrepare("SELECT id, title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id, status FROM expenses WHERE id = ?").
 			ExpectQuery().
-			WithArgs("1").
+			WithArgs(1).
 			WillReturnRows(mockRows)
 		handler := Handler{DB: db}
 
-		handler.GetExpenseByIdHandler(res.Context)
+		res.Serve(handler.GetExpenseByIdHandler)
 
 		assert.Equal(t, "1", res.Context.Param("id"))
 		assert.Equal(t, http.StatusOK, res.Recorder.Code)
@@ -40,16 +42,37 @@ func TestGetExpenseByIdHandler(t *testing.T) {
 		res.Context.SetParamNames("id")
 		res.Context.SetParamValues("0")
 		db, mock, _ := sqlmock.New()
-		mock.ExpectPrepare("SELECT id, title, amount, note, tags FROM expenses WHERE id = ?").
+		mock.ExpectPrepare("SELECT id, title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id, status FROM expenses WHERE id = ?").
 			ExpectQuery().
-			WithArgs("0").
+			WithArgs(0).
 			WillReturnError(sql.ErrNoRows)
 		handler := Handler{DB: db}
 
-		handler.GetExpenseByIdHandler(res.Context)
+		res.Serve(handler.GetExpenseByIdHandler)
+		var e problem.Problem
+		res.Decode(&e)
 
 		assert.Equal(t, http.StatusNotFound, res.Recorder.Code)
 		assert.Nil(t, mock.ExpectationsWereMet())
+		assert.Equal(t, problem.CodeExpenseNotFound, e.Code)
+		assert.Equal(t, "/expenses/0", e.Instance)
+	})
+
+	t.Run("should return 400 (BadRequest) when id is invalid", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses/abc", nil)
+		res.Context.SetPath("/expenses/:id")
+		res.Context.SetParamNames("id")
+		res.Context.SetParamValues("abc")
+		db, mock, _ := sqlmock.New()
+		handler := Handler{DB: db}
+
+		res.Serve(handler.GetExpenseByIdHandler)
+		var e problem.Problem
+		res.Decode(&e)
+
+		assert.Equal(t, http.StatusBadRequest, res.Recorder.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+		assert.Equal(t, problem.CodeInvalidID, e.Code)
 	})
 
 	t.Run("should return 500 (InternalServerError) when cannot prepare", func(t *testing.T) {
@@ -61,12 +84,12 @@ func TestGetExpenseByIdHandler(t *testing.T) {
 		db, mock, _ := sqlmock.New()
 		handler := Handler{DB: db}
 
-		handler.GetExpenseByIdHandler(res.Context)
-		var e util.Error
+		res.Serve(handler.GetExpenseByIdHandler)
+		var e problem.Problem
 		res.Decode(&e)
 
 		assert.Equal(t, http.StatusInternalServerError, res.Recorder.Code)
-		assert.NotEmpty(t, e.Message)
+		assert.NotEmpty(t, e.Code)
 		assert.Nil(t, mock.ExpectationsWereMet())
 	})
 	t.Run("should return 500 (InternalServerError) when cannot scan", func(t *testing.T) {
@@ -76,17 +99,17 @@ func TestGetExpenseByIdHandler(t *testing.T) {
 		res.Context.SetParamValues("0")
 
 		db, mock, _ := sqlmock.New()
-		mock.ExpectPrepare("SELECT id, title, amount, note, tags FROM expenses WHERE id = ?").
+		mock.ExpectPrepare("SELECT id, title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id, status FROM expenses WHERE id = ?").
 			ExpectQuery().
-			WithArgs("0")
+			WithArgs(0)
 		handler := Handler{DB: db}
 
-		handler.GetExpenseByIdHandler(res.Context)
-		var e util.Error
+		res.Serve(handler.GetExpenseByIdHandler)
+		var e problem.Problem
 		res.Decode(&e)
 
 		assert.Equal(t, http.StatusInternalServerError, res.Recorder.Code)
-		assert.NotEmpty(t, e.Message)
+		assert.NotEmpty(t, e.Code)
 		assert.Nil(t, mock.ExpectationsWereMet())
 	})
 }
@@ -96,12 +119,12 @@ func TestAllExpenseHandler(t *testing.T) {
 		p := prepare()
 		res := p.res
 		mock := p.mock
-		mock.ExpectPrepare("SELECT id, title, amount, note, tags FROM expenses").
+		mock.ExpectPrepare("SELECT id, title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id, status FROM expenses").
 			ExpectQuery().
 			WillReturnRows(p.mockRows)
 		handler := Handler{DB: p.db}
 
-		handler.GetAllExpenseHandler(res.Context)
+		res.Serve(handler.GetAllExpenseHandler)
 		var es []Expense
 		res.Decode(&es)
 
@@ -111,16 +134,83 @@ func TestAllExpenseHandler(t *testing.T) {
 		assert.Equal(t, p.expenses, es)
 	})
 
+	t.Run("should return 200 (OK) with requested page", func(t *testing.T) {
+		p := prepare()
+		res := util.RequestE(http.MethodGet, "/expenses?limit=2&offset=4", nil)
+		mock := p.mock
+		mock.ExpectPrepare("SELECT id, title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id, status FROM expenses WHERE deleted_at IS NULL ORDER BY id LIMIT \\$1 OFFSET \\$2").
+			ExpectQuery().
+			WithArgs(sql.NullInt64{Int64: 2, Valid: true}, 4).
+			WillReturnRows(p.mockRows)
+		handler := Handler{DB: p.db}
+
+		res.Serve(handler.GetAllExpenseHandler)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 200 (OK) with filters applied", func(t *testing.T) {
+		p := prepare()
+		res := util.RequestE(http.MethodGet, "/expenses?tag=food&tag=beverage&max_amount=100&title=50%25", nil)
+		mock := p.mock
+		mock.ExpectPrepare("SELECT id, title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id, status FROM expenses WHERE deleted_at IS NULL AND tags @> \\$1 AND amount <= \\$2 AND title ILIKE \\$3 ORDER BY id LIMIT \\$4 OFFSET \\$5").
+			ExpectQuery().
+			WithArgs(pq.Array([]string{"food", "beverage"}), 100.0, "%50\\%%", sql.NullInt64{}, 0).
+			WillReturnRows(p.mockRows)
+		handler := Handler{DB: p.db}
+
+		res.Serve(handler.GetAllExpenseHandler)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 400 (BadRequest) when limit is out of range", func(t *testing.T) {
+		p := prepare()
+		res := util.RequestE(http.MethodGet, "/expenses?limit=0", nil)
+		handler := Handler{DB: p.db}
+
+		res.Serve(handler.GetAllExpenseHandler)
+		var e problem.Problem
+		res.Decode(&e)
+
+		assert.Equal(t, http.StatusBadRequest, res.Recorder.Code)
+		assert.Equal(t, problem.CodeInvalidQuery, e.Code)
+		assert.Nil(t, p.mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 200 (OK) when first query hits admin shutdown", func(t *testing.T) {
+		readRetryDelay = time.Millisecond
+		p := prepare()
+		res := p.res
+		mock := p.mock
+		mock.ExpectPrepare("SELECT id, title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id, status FROM expenses").
+			ExpectQuery().
+			WillReturnError(&pq.Error{Code: "57P01"})
+		mock.ExpectQuery("SELECT id, title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id, status FROM expenses").
+			WillReturnRows(p.mockRows)
+		handler := Handler{DB: p.db}
+
+		res.Serve(handler.GetAllExpenseHandler)
+		var es []Expense
+		res.Decode(&es)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+		assert.Equal(t, p.expenses, es)
+	})
+
 	t.Run("should return 200 (OK) even no row in database", func(t *testing.T) {
 		p := prepare()
 		res := p.res
 		mock := p.mock
-		mock.ExpectPrepare("SELECT id, title, amount, note, tags FROM expenses").
+		mock.ExpectPrepare("SELECT id, title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id, status FROM expenses").
 			ExpectQuery().
-			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}))
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "ledger_id", "split", "currency", "spent_on", "merchant_id", "payment_method_id", "category_id", "status"}))
 		handler := Handler{DB: p.db}
 
-		handler.GetAllExpenseHandler(res.Context)
+		res.Serve(handler.GetAllExpenseHandler)
 		var es []Expense
 		res.Decode(&es)
 
@@ -133,54 +223,78 @@ func TestAllExpenseHandler(t *testing.T) {
 		p := prepare()
 		res := p.res
 		mock := p.mock
-		mock.ExpectPrepare("SELECT id, title, amount, note, tags FROM expenses").
+		mock.ExpectPrepare("SELECT id, title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id, status FROM expenses").
 			WillReturnError(errors.New("error"))
 		handler := Handler{DB: p.db}
 
-		handler.GetAllExpenseHandler(res.Context)
-		var e util.Error
+		res.Serve(handler.GetAllExpenseHandler)
+		var e problem.Problem
 		res.Decode(&e)
 
 		assert.Equal(t, http.StatusInternalServerError, res.Recorder.Code)
-		assert.NotEmpty(t, e.Message)
+		assert.NotEmpty(t, e.Code)
 		assert.Nil(t, mock.ExpectationsWereMet())
 	})
 	t.Run("should return 500 (InternalServerError) when cannot execute SELECT", func(t *testing.T) {
 		p := prepare()
 		res := p.res
 		mock := p.mock
-		mock.ExpectPrepare("SELECT id, title, amount, note, tags FROM expenses").
+		mock.ExpectPrepare("SELECT id, title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id, status FROM expenses").
 			ExpectQuery().
 			WillReturnError(&pq.Error{})
 		handler := Handler{DB: p.db}
 
-		handler.GetAllExpenseHandler(res.Context)
-		var e util.Error
+		res.Serve(handler.GetAllExpenseHandler)
+		var e problem.Problem
 		res.Decode(&e)
 
 		assert.Equal(t, http.StatusInternalServerError, res.Recorder.Code)
-		assert.NotEmpty(t, e.Message)
+		assert.NotEmpty(t, e.Code)
 		assert.Nil(t, mock.ExpectationsWereMet())
 	})
 	t.Run("should return 500 (InternalServerError) when cannot SELECT scan", func(t *testing.T) {
 		p := prepare()
 		res := p.res
 		mock := p.mock
-		mock.ExpectPrepare("SELECT id, title, amount, note, tags FROM expenses").
+		mock.ExpectPrepare("SELECT id, title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id, status FROM expenses").
 			ExpectQuery().
 			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 		handler := Handler{DB: p.db}
 
-		handler.GetAllExpenseHandler(res.Context)
-		var e util.Error
+		res.Serve(handler.GetAllExpenseHandler)
+		var e problem.Problem
 		res.Decode(&e)
 
 		assert.Equal(t, http.StatusInternalServerError, res.Recorder.Code)
-		assert.NotEmpty(t, e.Message)
+		assert.NotEmpty(t, e.Code)
 		assert.Nil(t, mock.ExpectationsWereMet())
 	})
 }
 
+func TestAllExpenseHandlerLoad(t *testing.T) {
+	const requests = 200
+	db, mock, _ := sqlmock.New()
+	mock.ExpectPrepare("SELECT id, title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id, status FROM expenses")
+	for i := 0; i < requests; i++ {
+		mock.ExpectQuery("SELECT id, title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id, status FROM expenses").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "ledger_id", "split", "currency", "spent_on", "merchant_id", "payment_method_id", "category_id", "status"}).
+				AddRow(1, "test-title", 123, "test-note", pq.Array([]string{"test-tags"}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft")).
+			RowsWillBeClosed()
+	}
+	handler := Handler{DB: db}
+
+	for i := 0; i < requests; i++ {
+		res := util.RequestE(http.MethodGet, "/expenses", nil)
+		res.Serve(handler.GetAllExpenseHandler)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		stats := db.Stats()
+		assert.Equal(t, 0, stats.InUse)
+		assert.Equal(t, 1, stats.OpenConnections)
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
 type prepared struct {
 	res      *util.Response
 	mockRows *sqlmock.Rows
@@ -192,25 +306,29 @@ type prepared struct {
 func prepare() prepared {
 	es := []Expense{
 		{
-			ID:     1,
-			Title:  "test-title-1",
-			Amount: 123,
-			Note:   "test-note-2",
-			Tags:   []string{"test-tags-3"},
+			ID:       1,
+			Title:    "test-title-1",
+			Amount:   123,
+			Note:     "test-note-2",
+			Tags:     []string{"test-tags-3"},
+			Currency: "THB",
+			SpentOn:  day,
 		},
 		{
-			ID:     2,
-			Title:  "test-title-1",
-			Amount: 456,
-			Note:   "test-note-2",
-			Tags:   []string{"test-tags-3"},
+			ID:       2,
+			Title:    "test-title-1",
+			Amount:   456,
+			Note:     "test-note-2",
+			Tags:     []string{"test-tags-3"},
+			Currency: "THB",
+			SpentOn:  day,
 		},
 	}
 	res := util.RequestE(http.MethodGet, "/expenses", nil)
 	res.Context.SetPath("/expenses")
-	mockRows := sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"})
+	mockRows := sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "ledger_id", "split", "currency", "spent_on", "merchant_id", "payment_method_id", "category_id", "status"})
 	for _, e := range es {
-		mockRows.AddRow(e.ID, e.Title, e.Amount, e.Note, pq.Array(e.Tags))
+		mockRows.AddRow(e.ID, e.Title, e.Amount, e.Note, pq.Array(e.Tags), nil, nil, e.Currency, e.SpentOn.String(), nil, nil, nil, e.Status)
 	}
 	db, mock, _ := sqlmock.New()
 	return prepared{res, mockRows, db, mock, es}
@@ -0,0 +1,307 @@
+package expense
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/ledger"
+	m "github.com/panudetjt/assessment/middleware"
+	"github.com/panudetjt/assessment/problem"
+	"github.com/panudetjt/assessment/webhook"
+)
+
+// Actions recorded in the history of an expense.
+const (
+	ActionCreated  = "created"
+	ActionUpdated  = "updated"
+	ActionDeleted  = "deleted"
+	ActionReverted = "reverted"
+)
+
+// Event is one version of an expense. Expense is its state after the change,
+// or the last state for a deletion, and Changes holds the fields that differ
+// from the previous version.
+type Event struct {
+	Version   int               `json:"version"`
+	Action    string            `json:"action"`
+	Actor     string            `json:"actor"`
+	RequestID string            `json:"request_id,omitempty"`
+	Changes   map[string]Change `json:"changes"`
+	Expense   Expense           `json:"expense"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// Change holds a field's JSON value before and after an event, null when the
+// expense didn't exist.
+type Change struct {
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+type RevertRequest struct {
+	Version int `json:"version"`
+}
+
+// inTx runs fn in a transaction, committing when it returns nil. fn returns
+// problems as the handlers do.
+func (h *Handler) inTx(c echo.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := h.DB.BeginTx(c.Request().Context(), nil)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't begin transaction: %w", err))
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return problem.Internal(fmt.Errorf("can't commit transaction: %w", err))
+	}
+	return nil
+}
+
+// record appends the next version of expense id to its history and enqueues
+// its webhook event, both within tx. before is nil for a creation and after is
+// nil for a deletion. An update that changes nothing is not recorded.
+func record(c echo.Context, tx *sql.Tx, id int, action string, before, after *Expense) error {
+	changes, err := diff(before, after)
+	if err != nil {
+		return problem.Internal(err)
+	}
+	if len(changes) == 0 && action == ActionUpdated {
+		return nil
+	}
+	snapshot := after
+	if snapshot == nil {
+		snapshot = before
+	}
+	cj, err := json.Marshal(changes)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't encode changes: %w", err))
+	}
+	sj, err := json.Marshal(snapshot)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't encode snapshot: %w", err))
+	}
+
+	ctx := c.Request().Context()
+	ev := Event{Action: action, Actor: m.Subject(c), RequestID: requestID(c), Changes: changes, Expense: *snapshot}
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO expense_events (expense_id, version, action, actor, request_id, changes, snapshot)
+		SELECT $1, COALESCE(MAX(version), 0) + 1, $2, $3, $4, $5, $6 FROM expense_events WHERE expense_id = $1
+		RETURNING version, created_at`,
+		id, action, ev.Actor, ev.RequestID, cj, sj,
+	).Scan(&ev.Version, &ev.CreatedAt)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't record expense event: %w", err))
+	}
+	if err := webhook.Enqueue(ctx, tx, webhookEvents[action], ev); err != nil {
+		return problem.Internal(err)
+	}
+	return nil
+}
+
+// webhookEvents maps history actions to the webhook event they notify.
+var webhookEvents = map[string]string{
+	ActionCreated:  webhook.ExpenseCreated,
+	ActionUpdated:  webhook.ExpenseUpdated,
+	ActionReverted: webhook.ExpenseUpdated,
+	ActionDeleted:  webhook.ExpenseDeleted,
+}
+
+// diff compares the JSON form of every field but id, so it covers new fields
+// without change.
+func diff(before, after *Expense) (map[string]Change, error) {
+	b, err := jsonFields(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := jsonFields(after)
+	if err != nil {
+		return nil, err
+	}
+	changes := map[string]Change{}
+	for _, fields := range []map[string]json.RawMessage{b, a} {
+		for k := range fields {
+			if _, done := changes[k]; done || k == "id" {
+				continue
+			}
+			if ch := (Change{Before: orNull(b[k]), After: orNull(a[k])}); !bytes.Equal(ch.Before, ch.After) {
+				changes[k] = ch
+			}
+		}
+	}
+	return changes, nil
+}
+
+func jsonFields(e *Expense) (map[string]json.RawMessage, error) {
+	fields := map[string]json.RawMessage{}
+	if e == nil {
+		return fields, nil
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("can't encode expense: %w", err)
+	}
+	return fields, json.Unmarshal(b, &fields)
+}
+
+func orNull(v json.RawMessage) json.RawMessage {
+	if v == nil {
+		return json.RawMessage("null")
+	}
+	return v
+}
+
+// eventColumns are the expense_events columns scanEvent reads. id orders
+// events across expenses, version within one.
+const eventColumns = "id, version, action, actor, request_id, changes, snapshot, created_at"
+
+func scanEvent(row interface{ Scan(...any) error }) (int64, Event, error) {
+	var id int64
+	var ev Event
+	var changes, snapshot []byte
+	if err := row.Scan(&id, &ev.Version, &ev.Action, &ev.Actor, &ev.RequestID, &changes, &snapshot, &ev.CreatedAt); err != nil {
+		return 0, ev, fmt.Errorf("can't scan expense event: %w", err)
+	}
+	if err := json.Unmarshal(changes, &ev.Changes); err != nil {
+		return 0, ev, fmt.Errorf("can't decode changes of expense event %d: %w", id, err)
+	}
+	if err := json.Unmarshal(snapshot, &ev.Expense); err != nil {
+		return 0, ev, fmt.Errorf("can't decode snapshot of expense event %d: %w", id, err)
+	}
+	return id, ev, nil
+}
+
+// requestID is the id the RequestID middleware put on the response.
+func requestID(c echo.Context) string {
+	if id := c.Response().Header().Get(echo.HeaderXRequestID); id != "" {
+		return id
+	}
+	return c.Request().Header.Get(echo.HeaderXRequestID)
+}
+
+func (h *Handler) HistoryHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidID, "expense id must be an integer")
+	}
+	if err := h.access(c, id, ledger.Viewer); err != nil {
+		return err
+	}
+
+	stmt, err := h.prepare("SELECT " + eventColumns + " FROM expense_events WHERE expense_id = $1 ORDER BY version")
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't prepare history statment: %w", err))
+	}
+	ctx := c.Request().Context()
+	var events []Event
+	err = retryRead(ctx, func() error {
+		events = []Event{}
+		rows, err := stmt.QueryContext(ctx, id)
+		if err != nil {
+			return fmt.Errorf("can't query history: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			_, ev, err := scanEvent(rows)
+			if err != nil {
+				return err
+			}
+			events = append(events, ev)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return problem.Internal(err)
+	}
+	return c.JSON(http.StatusOK, events)
+}
+
+// RevertHandler restores the title, amount, note, tags, split, currency and
+// day an expense had at a version, recording the revert as a new version.
+func (h *Handler) RevertHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidID, "expense id must be an integer")
+	}
+	var r RevertRequest
+	if err := c.Bind(&r); err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "request body must be a revert JSON object")
+	}
+	if r.Version < 1 {
+		return problem.Validation(problem.FieldError{Field: "version", Message: "must be at least 1"})
+	}
+	if err := h.access(c, id, ledger.Editor); err != nil {
+		return err
+	}
+	reason, err := overrideReason(c)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	var e Expense
+	err = h.inTx(c, func(tx *sql.Tx) error {
+		var snapshot []byte
+		err := tx.QueryRowContext(ctx, "SELECT snapshot FROM expense_events WHERE expense_id = $1 AND version = $2", id, r.Version).Scan(&snapshot)
+		if err == sql.ErrNoRows {
+			return problem.New(http.StatusNotFound, problem.CodeVersionNotFound, fmt.Sprintf("expense %d has no version %d", id, r.Version))
+		}
+		if err != nil {
+			return problem.Internal(fmt.Errorf("can't query expense version: %w", err))
+		}
+		var target Expense
+		if err := json.Unmarshal(snapshot, &target); err != nil {
+			return problem.Internal(fmt.Errorf("can't decode expense version: %w", err))
+		}
+
+		var before Expense
+		err = tx.QueryRowContext(ctx, "SELECT "+columns+" FROM expenses WHERE id = $1 AND "+live+" FOR UPDATE", id).Scan(before.fields()...)
+		if err == sql.ErrNoRows {
+			return expenseNotFound(id)
+		}
+		if err != nil {
+			return problem.Internal(fmt.Errorf("can't lock expense: %w", err))
+		}
+		if !editable(before.Status) {
+			return notEditable(before)
+		}
+		// Versions from before expenses had a currency keep the current one.
+		target.inherit(before)
+		closed, err := checkPeriods(ctx, tx, before.LedgerID, reason, before.SpentOn, target.SpentOn)
+		if err != nil {
+			return err
+		}
+		// The merchant, payment method or category of the version may be gone
+		// since.
+		errs, err := checkRefs(ctx, tx, before.LedgerID, &target)
+		if err != nil {
+			return problem.Internal(err)
+		}
+		if errs != nil {
+			return problem.Validation(errs...)
+		}
+		err = tx.QueryRowContext(ctx,
+			"UPDATE expenses SET title = $2, amount = $3, note = $4, tags = $5, split = $6, currency = $7, spent_on = $8, merchant_id = $9, payment_method_id = $10, category_id = $11 WHERE id = $1 RETURNING "+columns,
+			id, target.Title, target.Amount, target.Note, pq.Array(target.Tags), target.Split, target.Currency, target.SpentOn, target.MerchantID, target.PaymentMethodID, target.CategoryID,
+		).Scan(e.fields()...)
+		if err != nil {
+			return problem.Internal(fmt.Errorf("can't revert expense: %w", err))
+		}
+		if err := auditOverrides(c, tx, closed, id, ActionReverted, reason); err != nil {
+			return err
+		}
+		return record(c, tx, id, ActionReverted, &before, &e)
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, e)
+}
@@ -0,0 +1,183 @@
+package expense
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/date"
+	"github.com/panudetjt/assessment/problem"
+	"github.com/panudetjt/assessment/util"
+	"github.com/stretchr/testify/assert"
+)
+
+var periodNames = []string{"id", "ledger_id", "month", "closed", "closed_by", "closed_at", "reopened_by", "reopened_at"}
+
+var expenseColumns = []string{"id", "title", "amount", "note", "tags", "ledger_id", "split", "currency", "spent_on", "merchant_id", "payment_method_id", "category_id", "status"}
+
+// day is the spent_on of the mocked expenses.
+var day, _ = date.Parse("2023-01-02")
+
+var eventRow = []string{"id", "version", "action", "actor", "request_id", "changes", "snapshot", "created_at"}
+
+const lockExpense = "SELECT id, title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id, status FROM expenses WHERE id = \\$1 AND deleted_at IS NULL FOR UPDATE"
+
+// expectEvent expects the history event, webhook event and commit that follow
+// a change.
+func expectEvent(mock sqlmock.Sqlmock, action string) {
+	mock.ExpectQuery("INSERT INTO expense_events").
+		WithArgs(sqlmock.AnyArg(), action, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "created_at"}).AddRow(1, time.Now()))
+	mock.ExpectExec("INSERT INTO webhook_outbox").
+		WithArgs(webhookEvents[action], sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+// expectRules expects the write to load the rules of its ledger, with none
+// defined.
+func expectRules(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("SELECT " + ruleColumns + " FROM rules WHERE ledger_id IS NOT DISTINCT FROM \\$1 ORDER BY position, id").
+		WillReturnRows(sqlmock.NewRows(strings.Split(ruleColumns, ", ")))
+}
+
+// expectPeriods expects the write to look for closed periods, finding none.
+func expectPeriods(mock sqlmock.Sqlmock) {
+	mock.ExpectExec("SELECT pg_advisory_xact_lock_shared\\(\\$1, \\$2\\)").WithArgs(periodLock, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT " + regexp.QuoteMeta(periodColumns) + " FROM accounting_periods WHERE ledger_id IS NOT DISTINCT FROM \\$1 AND closed AND month = ANY\\(\\$2::date\\[\\]\\) ORDER BY month").
+		WillReturnRows(sqlmock.NewRows(periodNames))
+}
+
+func TestDiff(t *testing.T) {
+	t.Run("should list only the fields that changed", func(t *testing.T) {
+		before := &Expense{ID: 1, Title: "rent", Amount: 100, Tags: []string{"home"}}
+		after := &Expense{ID: 1, Title: "rent", Amount: 120, Tags: []string{"home"}, Note: "raised"}
+
+		changes, err := diff(before, after)
+
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]Change{
+			"amount": {Before: json.RawMessage("100"), After: json.RawMessage("120")},
+			"note":   {Before: json.RawMessage(`""`), After: json.RawMessage(`"raised"`)},
+		}, changes)
+	})
+
+	t.Run("should compare against null for a creation", func(t *testing.T) {
+		changes, err := diff(nil, &Expense{ID: 1, Title: "rent", LedgerID: new(int)})
+
+		assert.NoError(t, err)
+		assert.Equal(t, Change{Before: json.RawMessage("null"), After: json.RawMessage("0")}, changes["ledger_id"])
+		assert.NotContains(t, changes, "id")
+	})
+}
+
+func TestRecord(t *testing.T) {
+	t.Run("should skip updates that change nothing", func(t *testing.T) {
+		res, db, mock := arrange("")
+		e := Expense{ID: 1, Title: "apple smoothie", Amount: 89, Note: "no discount", Tags: []string{"beverage"}}
+		row := func() *sqlmock.Rows {
+			return sqlmock.NewRows(expenseColumns).AddRow(1, e.Title, e.Amount, e.Note, pq.Array(e.Tags), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft")
+		}
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockExpense).WillReturnRows(row())
+		expectPeriods(mock)
+		expectRules(mock)
+		mock.ExpectQuery("UPDATE expenses").WillReturnRows(row())
+		mock.ExpectCommit()
+		h := Handler{DB: db}
+
+		res.Serve(h.UpdateExpensesHandler)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestHistoryHandler(t *testing.T) {
+	res := util.RequestE(http.MethodGet, "/expenses/1/history", nil)
+	res.Context.SetParamNames("id")
+	res.Context.SetParamValues("1")
+	db, mock, _ := sqlmock.New()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectPrepare("SELECT id, version, action, actor, request_id, changes, snapshot, created_at FROM expense_events WHERE expense_id = \\$1 ORDER BY version").
+		ExpectQuery().WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(eventRow).
+			AddRow(10, 1, ActionCreated, "apikey:2", "req-1", `{"amount": {"before": null, "after": 100}}`, `{"id": 1, "title": "rent", "amount": 100}`, now).
+			AddRow(11, 2, ActionUpdated, "apikey:3", "req-2", `{"amount": {"before": 100, "after": 120}}`, `{"id": 1, "title": "rent", "amount": 120}`, now))
+	h := Handler{DB: db}
+
+	res.Serve(h.HistoryHandler)
+	var got []Event
+	res.Decode(&got)
+
+	assert.Equal(t, http.StatusOK, res.Recorder.Code)
+	assert.Len(t, got, 2)
+	assert.Equal(t, "apikey:3", got[1].Actor)
+	assert.Equal(t, "req-2", got[1].RequestID)
+	assert.JSONEq(t, "120", string(got[1].Changes["amount"].After))
+	assert.Equal(t, 120.0, got[1].Expense.Amount)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevertHandler(t *testing.T) {
+	request := func(body string) *util.Response {
+		res := util.RequestE(http.MethodPost, "/expenses/1/revert", strings.NewReader(body))
+		res.Context.SetParamNames("id")
+		res.Context.SetParamValues("1")
+		return res
+	}
+
+	t.Run("should restore the version and record the revert", func(t *testing.T) {
+		res := request(`{"version": 1}`)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT snapshot FROM expense_events WHERE expense_id = \\$1 AND version = \\$2").WithArgs(1, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"snapshot"}).AddRow(`{"id": 1, "title": "rent", "amount": 100, "note": "", "tags": ["home"]}`))
+		mock.ExpectQuery(lockExpense).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(expenseColumns).AddRow(1, "rent", 120, "", pq.Array([]string{"home"}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft"))
+		expectPeriods(mock)
+		mock.ExpectQuery("UPDATE expenses SET").WithArgs(1, "rent", 100.0, "", pq.Array([]string{"home"}), nil, "THB", "2023-01-02", nil, nil, nil).
+			WillReturnRows(sqlmock.NewRows(expenseColumns).AddRow(1, "rent", 100, "", pq.Array([]string{"home"}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft"))
+		expectEvent(mock, ActionReverted)
+		h := Handler{DB: db}
+
+		res.Serve(h.RevertHandler)
+		var got Expense
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Equal(t, 100.0, got.Amount)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 404 (NotFound) for an unknown version", func(t *testing.T) {
+		res := request(`{"version": 7}`)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT snapshot FROM expense_events").WillReturnRows(sqlmock.NewRows([]string{"snapshot"}))
+		mock.ExpectRollback()
+		h := Handler{DB: db}
+
+		res.Serve(h.RevertHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusNotFound, res.Recorder.Code)
+		assert.Equal(t, problem.CodeVersionNotFound, p.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 422 (UnprocessableEntity) without a version", func(t *testing.T) {
+		res := request(`{}`)
+		h := Handler{}
+
+		res.Serve(h.RevertHandler)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, res.Recorder.Code)
+	})
+}
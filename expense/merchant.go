@@ -0,0 +1,204 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/ledger"
+	"github.com/panudetjt/assessment/problem"
+)
+
+// Merchant is where money was spent. Like expenses, merchants belong to a
+// ledger and only admin keys may keep them outside one.
+type Merchant struct {
+	ID        int       `json:"id"`
+	LedgerID  *int      `json:"ledger_id,omitempty"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const maxNameLength = 100
+
+func validName(name string) []problem.FieldError {
+	switch {
+	case strings.TrimSpace(name) == "":
+		return []problem.FieldError{{Field: "name", Message: "must not be empty"}}
+	case utf8.RuneCountInString(name) > maxNameLength:
+		return []problem.FieldError{{Field: "name", Message: fmt.Sprintf("must be at most %d characters", maxNameLength)}}
+	}
+	return nil
+}
+
+func (h *Handler) CreateMerchantHandler(c echo.Context) error {
+	var mc Merchant
+	if err := c.Bind(&mc); err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "request body must be a merchant JSON object")
+	}
+	mc.Name = strings.TrimSpace(mc.Name)
+	if errs := validName(mc.Name); errs != nil {
+		return problem.Validation(errs...)
+	}
+	if err := h.accessNew(c, mc.LedgerID); err != nil {
+		return err
+	}
+
+	err := h.DB.QueryRowContext(c.Request().Context(),
+		"INSERT INTO merchants (ledger_id, name) VALUES ($1, $2) RETURNING id, created_at",
+		mc.LedgerID, mc.Name,
+	).Scan(&mc.ID, &mc.CreatedAt)
+	if err != nil {
+		return writeError("merchant", mc.Name, err)
+	}
+	return c.JSON(http.StatusCreated, mc)
+}
+
+// ListMerchantsHandler lists the merchants of the caller's ledgers, or of
+// ledger_id only, by name.
+func (h *Handler) ListMerchantsHandler(c echo.Context) error {
+	f, err := ledgerFilter(c)
+	if err != nil {
+		return err
+	}
+	stmt, err := h.prepare("SELECT id, ledger_id, name, created_at FROM merchants" + f.where() + " ORDER BY lower(name), id")
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't prepare merchants statment: %w", err))
+	}
+
+	ctx := c.Request().Context()
+	var merchants []Merchant
+	err = retryRead(ctx, func() error {
+		merchants = []Merchant{}
+		rows, err := stmt.QueryContext(ctx, f.args...)
+		if err != nil {
+			return fmt.Errorf("can't query merchants: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var mc Merchant
+			if err := rows.Scan(&mc.ID, &mc.LedgerID, &mc.Name, &mc.CreatedAt); err != nil {
+				return fmt.Errorf("can't scan merchants: %w", err)
+			}
+			merchants = append(merchants, mc)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return problem.Internal(err)
+	}
+	return c.JSON(http.StatusOK, merchants)
+}
+
+// UpdateMerchantHandler renames a merchant. Its ledger can't change.
+func (h *Handler) UpdateMerchantHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidID, "merchant id must be an integer")
+	}
+	var mc Merchant
+	if err := c.Bind(&mc); err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "request body must be a merchant JSON object")
+	}
+	mc.Name = strings.TrimSpace(mc.Name)
+	if errs := validName(mc.Name); errs != nil {
+		return problem.Validation(errs...)
+	}
+	if err := h.accessRow(c, "merchants", id, ledger.Editor, func() error { return merchantNotFound(id) }); err != nil {
+		return err
+	}
+
+	err = h.DB.QueryRowContext(c.Request().Context(),
+		"UPDATE merchants SET name = $2 WHERE id = $1 RETURNING id, ledger_id, name, created_at", id, mc.Name,
+	).Scan(&mc.ID, &mc.LedgerID, &mc.Name, &mc.CreatedAt)
+	if err == sql.ErrNoRows {
+		return merchantNotFound(id)
+	}
+	if err != nil {
+		return writeError("merchant", mc.Name, err)
+	}
+	return c.JSON(http.StatusOK, mc)
+}
+
+// DeleteMerchantHandler deletes a merchant no expense references anymore.
+func (h *Handler) DeleteMerchantHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidID, "merchant id must be an integer")
+	}
+	if err := h.accessRow(c, "merchants", id, ledger.Editor, func() error { return merchantNotFound(id) }); err != nil {
+		return err
+	}
+	return h.deleteRow(c, "merchants", "merchant", id, merchantNotFound)
+}
+
+func merchantNotFound(id int) error {
+	return problem.New(http.StatusNotFound, problem.CodeMerchantNotFound, fmt.Sprintf("merchant %d does not exist", id))
+}
+
+// writeError maps the constraint violations of an insert or update of a
+// merchant or payment method to problems.
+func writeError(kind, name string, err error) error {
+	if pe, ok := err.(*pq.Error); ok {
+		switch pe.Code {
+		case "23505":
+			return problem.New(http.StatusConflict, problem.CodeNameTaken, fmt.Sprintf("a %s named %q already exists", kind, name))
+		case "23503":
+			return problem.Validation(problem.FieldError{Field: "ledger_id", Message: "does not exist"})
+		}
+	}
+	return problem.Internal(fmt.Errorf("can't write %s: %w", kind, err))
+}
+
+// deleteRow deletes row id of table, a noun such as "merchant", which is in
+// use while other rows reference it.
+func (h *Handler) deleteRow(c echo.Context, table, noun string, id int, missing func(int) error) error {
+	result, err := h.DB.ExecContext(c.Request().Context(), "DELETE FROM "+table+" WHERE id = $1", id)
+	if pe, ok := err.(*pq.Error); ok && pe.Code == "23503" {
+		return problem.New(http.StatusConflict, problem.CodeInUse, fmt.Sprintf("%s %d is still in use", noun, id))
+	}
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't delete from %s: %w", table, err))
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't read deleted rows: %w", err))
+	}
+	if n == 0 {
+		return missing(id)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// checkRefs reports the merchant, payment method and category of e that don't
+// exist in ledgerID, the ledger of the expense.
+func checkRefs(ctx context.Context, tx *sql.Tx, ledgerID *int, e *Expense) ([]problem.FieldError, error) {
+	var errs []problem.FieldError
+	for _, r := range []struct {
+		field, table string
+		id           *int
+	}{
+		{"merchant_id", "merchants", e.MerchantID},
+		{"payment_method_id", "payment_methods", e.PaymentMethodID},
+		{"category_id", "categories", e.CategoryID},
+	} {
+		if r.id == nil {
+			continue
+		}
+		var ok bool
+		err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM "+r.table+" WHERE id = $1 AND ledger_id IS NOT DISTINCT FROM $2)", *r.id, ledgerID).Scan(&ok)
+		if err != nil {
+			return nil, fmt.Errorf("can't check %s: %w", r.field, err)
+		}
+		if !ok {
+			errs = append(errs, problem.FieldError{Field: r.field, Message: "does not exist in the expense's ledger"})
+		}
+	}
+	return errs, nil
+}
@@ -0,0 +1,203 @@
+package expense
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/date"
+	"github.com/panudetjt/assessment/problem"
+	"github.com/panudetjt/assessment/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerchants(t *testing.T) {
+	now := time.Date(2023, 1, 2, 9, 0, 0, 0, time.UTC)
+	byID := func(method, path string, body string) *util.Response {
+		res := util.RequestE(method, path, strings.NewReader(body))
+		res.Context.SetParamNames("id")
+		res.Context.SetParamValues("7")
+		return res
+	}
+
+	t.Run("should create a merchant in a ledger the caller edits", func(t *testing.T) {
+		res := asMember(util.RequestE(http.MethodPost, "/merchants", strings.NewReader(`{"ledger_id": 3, "name": " Foodland "}`)), "apikey:2")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery("SELECT role FROM ledger_members WHERE ledger_id = \\$1 AND subject = \\$2").WithArgs(3, "apikey:2").
+			WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("editor"))
+		mock.ExpectQuery("INSERT INTO merchants \\(ledger_id, name\\) VALUES \\(\\$1, \\$2\\) RETURNING id, created_at").WithArgs(3, "Foodland").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(7, now))
+		h := Handler{DB: db}
+
+		res.Serve(h.CreateMerchantHandler)
+		var got Merchant
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusCreated, res.Recorder.Code)
+		ledgerID := 3
+		assert.Equal(t, Merchant{ID: 7, LedgerID: &ledgerID, Name: "Foodland", CreatedAt: now}, got)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 409 (Conflict) when the name is taken", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/merchants", strings.NewReader(`{"name": "Foodland"}`))
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery("INSERT INTO merchants").WillReturnError(&pq.Error{Code: "23505"})
+		h := Handler{DB: db}
+
+		res.Serve(h.CreateMerchantHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusConflict, res.Recorder.Code)
+		assert.Equal(t, problem.CodeNameTaken, p.Code)
+	})
+
+	t.Run("should list the merchants of the caller's ledgers", func(t *testing.T) {
+		res := asMember(util.RequestE(http.MethodGet, "/merchants", nil), "apikey:2")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectPrepare("SELECT id, ledger_id, name, created_at FROM merchants WHERE ledger_id IN \\(SELECT ledger_id FROM ledger_members WHERE subject = \\$1\\) ORDER BY lower\\(name\\), id").
+			ExpectQuery().WithArgs("apikey:2").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "ledger_id", "name", "created_at"}).AddRow(7, 3, "Foodland", now))
+		h := Handler{DB: db}
+
+		res.Serve(h.ListMerchantsHandler)
+		var got []Merchant
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Equal(t, "Foodland", got[0].Name)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 404 (NotFound) renaming a merchant of another ledger", func(t *testing.T) {
+		res := asMember(byID(http.MethodPut, "/merchants/7", `{"name": "Tops"}`), "apikey:2")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectPrepare("SELECT m.role FROM merchants x JOIN ledger_members m ON m.ledger_id = x.ledger_id WHERE x.id = \\$1 AND m.subject = \\$2").
+			ExpectQuery().WithArgs(7, "apikey:2").WillReturnRows(sqlmock.NewRows([]string{"role"}))
+		h := Handler{DB: db}
+
+		res.Serve(h.UpdateMerchantHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusNotFound, res.Recorder.Code)
+		assert.Equal(t, problem.CodeMerchantNotFound, p.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 409 (Conflict) deleting a merchant expenses reference", func(t *testing.T) {
+		res := byID(http.MethodDelete, "/merchants/7", "")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectExec("DELETE FROM merchants WHERE id = \\$1").WithArgs(7).WillReturnError(&pq.Error{Code: "23503"})
+		h := Handler{DB: db}
+
+		res.Serve(h.DeleteMerchantHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusConflict, res.Recorder.Code)
+		assert.Equal(t, problem.CodeInUse, p.Code)
+		assert.Equal(t, "merchant 7 is still in use", p.Detail)
+	})
+}
+
+func TestPaymentMethods(t *testing.T) {
+	t.Run("should create a card with its last four digits", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/payment-methods", strings.NewReader(`{"name": "Visa", "type": "credit_card", "last4": "4242"}`))
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery("INSERT INTO payment_methods \\(ledger_id, name, type, last4\\)").WithArgs(nil, "Visa", PaymentCreditCard, "4242").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(4, time.Now()))
+		h := Handler{DB: db}
+
+		res.Serve(h.CreatePaymentMethodHandler)
+		var got PaymentMethod
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusCreated, res.Recorder.Code)
+		assert.Equal(t, "4242", got.Last4)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 422 (UnprocessableEntity) for an unknown type or digits on cash", func(t *testing.T) {
+		for body, field := range map[string]string{
+			`{"name": "Cheque", "type": "cheque"}`:                   "type",
+			`{"name": "Wallet", "type": "cash", "last4": "1234"}`:    "last4",
+			`{"name": "Visa", "type": "credit_card", "last4": "42"}`: "last4",
+		} {
+			res := util.RequestE(http.MethodPost, "/payment-methods", strings.NewReader(body))
+			h := Handler{}
+
+			res.Serve(h.CreatePaymentMethodHandler)
+			var p problem.Problem
+			res.Decode(&p)
+
+			assert.Equal(t, http.StatusUnprocessableEntity, res.Recorder.Code, body)
+			assert.Equal(t, field, p.Errors[0].Field, body)
+		}
+	})
+}
+
+func TestExpenseRefs(t *testing.T) {
+	t.Run("should return 422 (UnprocessableEntity) for a merchant of another ledger", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/expenses", strings.NewReader(`{"title": "rice", "amount": 60, "merchant_id": 7, "payment_method_id": 4}`))
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		expectPeriods(mock)
+		expectRules(mock)
+		mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM merchants WHERE id = \\$1 AND ledger_id IS NOT DISTINCT FROM \\$2\\)").WithArgs(7, nil).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM payment_methods").WithArgs(4, nil).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectRollback()
+		h := Handler{DB: db}
+
+		res.Serve(h.CreateExpensesHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, res.Recorder.Code)
+		assert.Equal(t, []problem.FieldError{{Field: "merchant_id", Message: "does not exist in the expense's ledger"}}, p.Errors)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should store the merchant", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/expenses", strings.NewReader(`{"title": "rice", "amount": 60, "merchant_id": 7}`))
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		expectPeriods(mock)
+		expectRules(mock)
+		mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM merchants").WithArgs(7, nil).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery("INSERT INTO expenses").
+			WithArgs("rice", 60.0, "", pq.Array([]string(nil)), nil, nil, "THB", date.Today(), 7, nil, nil).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		expectEvent(mock, ActionCreated)
+		h := Handler{DB: db}
+
+		res.Serve(h.CreateExpensesHandler)
+		var got Expense
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusCreated, res.Recorder.Code)
+		assert.Equal(t, 7, *got.MerchantID)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should filter the list by merchant and payment method", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses?merchant_id=7&payment_method_id=4", nil)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectPrepare("FROM expenses WHERE deleted_at IS NULL AND merchant_id = \\$1 AND payment_method_id = \\$2 ORDER BY id").ExpectQuery().
+			WithArgs(int64(7), int64(4), nil, int64(0)).
+			WillReturnRows(sqlmock.NewRows(expenseColumns))
+		h := Handler{DB: db}
+
+		res.Serve(h.GetAllExpenseHandler)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
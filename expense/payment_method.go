@@ -0,0 +1,168 @@
+package expense
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/panudetjt/assessment/ledger"
+	"github.com/panudetjt/assessment/problem"
+)
+
+// PaymentMethod is how an expense was paid, e.g. a credit card.
+type PaymentMethod struct {
+	ID       int    `json:"id"`
+	LedgerID *int   `json:"ledger_id,omitempty"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	// Last4 are the last four digits of a card or account, never cash.
+	Last4     string    `json:"last4,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const (
+	PaymentCash         = "cash"
+	PaymentCreditCard   = "credit_card"
+	PaymentDebitCard    = "debit_card"
+	PaymentEWallet      = "e_wallet"
+	PaymentBankTransfer = "bank_transfer"
+)
+
+var paymentTypes = []string{PaymentCash, PaymentCreditCard, PaymentDebitCard, PaymentEWallet, PaymentBankTransfer}
+
+func (p *PaymentMethod) validate() []problem.FieldError {
+	p.Name = strings.TrimSpace(p.Name)
+	errs := validName(p.Name)
+	valid := false
+	for _, t := range paymentTypes {
+		valid = valid || p.Type == t
+	}
+	if !valid {
+		errs = append(errs, problem.FieldError{Field: "type", Message: "must be one of " + strings.Join(paymentTypes, ", ")})
+	}
+	switch {
+	case p.Last4 == "":
+	case p.Type == PaymentCash:
+		errs = append(errs, problem.FieldError{Field: "last4", Message: "must be empty for cash"})
+	case len(p.Last4) != 4 || strings.Trim(p.Last4, "0123456789") != "":
+		errs = append(errs, problem.FieldError{Field: "last4", Message: "must be 4 digits"})
+	}
+	return errs
+}
+
+// last4 stores an empty Last4 as NULL.
+func (p *PaymentMethod) last4() sql.NullString {
+	return sql.NullString{String: p.Last4, Valid: p.Last4 != ""}
+}
+
+func (h *Handler) CreatePaymentMethodHandler(c echo.Context) error {
+	var p PaymentMethod
+	if err := c.Bind(&p); err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "request body must be a payment method JSON object")
+	}
+	if errs := p.validate(); errs != nil {
+		return problem.Validation(errs...)
+	}
+	if err := h.accessNew(c, p.LedgerID); err != nil {
+		return err
+	}
+
+	err := h.DB.QueryRowContext(c.Request().Context(),
+		"INSERT INTO payment_methods (ledger_id, name, type, last4) VALUES ($1, $2, $3, $4) RETURNING id, created_at",
+		p.LedgerID, p.Name, p.Type, p.last4(),
+	).Scan(&p.ID, &p.CreatedAt)
+	if err != nil {
+		return writeError("payment method", p.Name, err)
+	}
+	return c.JSON(http.StatusCreated, p)
+}
+
+// ListPaymentMethodsHandler lists the payment methods of the caller's
+// ledgers, or of ledger_id only, by name.
+func (h *Handler) ListPaymentMethodsHandler(c echo.Context) error {
+	f, err := ledgerFilter(c)
+	if err != nil {
+		return err
+	}
+	stmt, err := h.prepare("SELECT id, ledger_id, name, type, last4, created_at FROM payment_methods" + f.where() + " ORDER BY lower(name), id")
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't prepare payment methods statment: %w", err))
+	}
+
+	ctx := c.Request().Context()
+	var methods []PaymentMethod
+	err = retryRead(ctx, func() error {
+		methods = []PaymentMethod{}
+		rows, err := stmt.QueryContext(ctx, f.args...)
+		if err != nil {
+			return fmt.Errorf("can't query payment methods: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var p PaymentMethod
+			var last4 sql.NullString
+			if err := rows.Scan(&p.ID, &p.LedgerID, &p.Name, &p.Type, &last4, &p.CreatedAt); err != nil {
+				return fmt.Errorf("can't scan payment methods: %w", err)
+			}
+			p.Last4 = last4.String
+			methods = append(methods, p)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return problem.Internal(err)
+	}
+	return c.JSON(http.StatusOK, methods)
+}
+
+// UpdatePaymentMethodHandler replaces the name, type and last four digits of
+// a payment method. Its ledger can't change.
+func (h *Handler) UpdatePaymentMethodHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidID, "payment method id must be an integer")
+	}
+	var p PaymentMethod
+	if err := c.Bind(&p); err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "request body must be a payment method JSON object")
+	}
+	if errs := p.validate(); errs != nil {
+		return problem.Validation(errs...)
+	}
+	if err := h.accessRow(c, "payment_methods", id, ledger.Editor, func() error { return paymentMethodNotFound(id) }); err != nil {
+		return err
+	}
+
+	err = h.DB.QueryRowContext(c.Request().Context(),
+		"UPDATE payment_methods SET name = $2, type = $3, last4 = $4 WHERE id = $1 RETURNING id, ledger_id, created_at",
+		id, p.Name, p.Type, p.last4(),
+	).Scan(&p.ID, &p.LedgerID, &p.CreatedAt)
+	if err == sql.ErrNoRows {
+		return paymentMethodNotFound(id)
+	}
+	if err != nil {
+		return writeError("payment method", p.Name, err)
+	}
+	return c.JSON(http.StatusOK, p)
+}
+
+// DeletePaymentMethodHandler deletes a payment method no expense references
+// anymore.
+func (h *Handler) DeletePaymentMethodHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidID, "payment method id must be an integer")
+	}
+	if err := h.accessRow(c, "payment_methods", id, ledger.Editor, func() error { return paymentMethodNotFound(id) }); err != nil {
+		return err
+	}
+	return h.deleteRow(c, "payment_methods", "payment method", id, paymentMethodNotFound)
+}
+
+func paymentMethodNotFound(id int) error {
+	return problem.New(http.StatusNotFound, problem.CodePaymentNotFound, fmt.Sprintf("payment method %d does not exist", id))
+}
@@ -0,0 +1,326 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/date"
+	"github.com/panudetjt/assessment/ledger"
+	m "github.com/panudetjt/assessment/middleware"
+	"github.com/panudetjt/assessment/problem"
+)
+
+// Period is a month of a ledger's books. Once closed, the expenses spent in it
+// can't be created, updated or deleted, unless an admin overrides it with a
+// reason.
+type Period struct {
+	ID       int    `json:"id"`
+	LedgerID *int   `json:"ledger_id,omitempty"`
+	Month    string `json:"month"`
+	Closed   bool   `json:"closed"`
+	// ClosedBy and ClosedAt record the last close, ReopenedBy and ReopenedAt
+	// the reopen since, if any.
+	ClosedBy   string     `json:"closed_by"`
+	ClosedAt   time.Time  `json:"closed_at"`
+	ReopenedBy *string    `json:"reopened_by,omitempty"`
+	ReopenedAt *time.Time `json:"reopened_at,omitempty"`
+}
+
+// PeriodRequest is the body of POST /accounting-periods/close and
+// /accounting-periods/reopen.
+type PeriodRequest struct {
+	LedgerID *int   `json:"ledger_id,omitempty"`
+	Month    string `json:"month"`
+}
+
+// PeriodOverride audits a change an admin made to an expense of a closed
+// period.
+type PeriodOverride struct {
+	ID        int64     `json:"id"`
+	PeriodID  int       `json:"period_id"`
+	ExpenseID int       `json:"expense_id"`
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	RequestID string    `json:"request_id,omitempty"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// monthLayout is the format of Period.Month.
+const monthLayout = "2006-01"
+
+// periodLock is the class of the advisory locks ordering the writes to a
+// ledger's expenses and the closes of its periods. Writes check the periods
+// holding it shared and closes take it exclusively, so a write can't check a
+// month open while it is being closed and commit into the closed month.
+const periodLock = 0x70657269
+
+const periodColumns = "id, ledger_id, to_char(month, 'YYYY-MM'), closed, closed_by, closed_at, reopened_by, reopened_at"
+
+func (p *Period) fields() []any {
+	return []any{&p.ID, &p.LedgerID, &p.Month, &p.Closed, &p.ClosedBy, &p.ClosedAt, &p.ReopenedBy, &p.ReopenedAt}
+}
+
+// firstDay returns the first day of the month r names.
+func (r PeriodRequest) firstDay() (date.Date, error) {
+	t, err := time.Parse(monthLayout, strings.TrimSpace(r.Month))
+	if err != nil {
+		return date.Date{}, problem.Validation(problem.FieldError{Field: "month", Message: "must be a month such as 2023-01"})
+	}
+	return date.Of(t), nil
+}
+
+func (h *Handler) bindPeriod(c echo.Context) (PeriodRequest, date.Date, error) {
+	var r PeriodRequest
+	if err := c.Bind(&r); err != nil {
+		return r, date.Date{}, problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "request body must be a period JSON object")
+	}
+	month, err := r.firstDay()
+	if err != nil {
+		return r, month, err
+	}
+	// Only owners close and reopen their books, and only admins those of
+	// the expenses outside ledgers.
+	switch {
+	case r.LedgerID != nil:
+		err = h.accessLedger(c, *r.LedgerID, ledger.Owner)
+	case !ledger.IsAdmin(c):
+		err = problem.Validation(problem.FieldError{Field: "ledger_id", Message: "is required"})
+	}
+	return r, month, err
+}
+
+// ClosePeriodHandler closes a month of a ledger. Closing a closed month
+// changes nothing.
+func (h *Handler) ClosePeriodHandler(c echo.Context) error {
+	r, month, err := h.bindPeriod(c)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	var p Period
+	err = h.inTx(c, func(tx *sql.Tx) error {
+		if err := lockPeriods(ctx, tx, r.LedgerID, "pg_advisory_xact_lock"); err != nil {
+			return err
+		}
+		err := tx.QueryRowContext(ctx,
+			"INSERT INTO accounting_periods (ledger_id, month, closed_by) VALUES ($1, $2, $3) "+
+				"ON CONFLICT ((COALESCE(ledger_id, 0)), month) DO UPDATE SET closed = true, closed_by = EXCLUDED.closed_by, closed_at = now(), reopened_by = NULL, reopened_at = NULL "+
+				"WHERE NOT accounting_periods.closed RETURNING "+periodColumns,
+			r.LedgerID, month, m.Subject(c),
+		).Scan(p.fields()...)
+		if err == sql.ErrNoRows {
+			err = tx.QueryRowContext(ctx, "SELECT "+periodColumns+" FROM accounting_periods WHERE ledger_id IS NOT DISTINCT FROM $1 AND month = $2", r.LedgerID, month).
+				Scan(p.fields()...)
+		}
+		if pe, ok := err.(*pq.Error); ok && pe.Code == "23503" {
+			return problem.Validation(problem.FieldError{Field: "ledger_id", Message: "does not exist"})
+		}
+		if err != nil {
+			return problem.Internal(fmt.Errorf("can't close period: %w", err))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, p)
+}
+
+// ReopenPeriodHandler reopens a closed month of a ledger.
+func (h *Handler) ReopenPeriodHandler(c echo.Context) error {
+	r, month, err := h.bindPeriod(c)
+	if err != nil {
+		return err
+	}
+
+	var p Period
+	err = h.DB.QueryRowContext(c.Request().Context(),
+		"UPDATE accounting_periods SET closed = false, reopened_by = $3, reopened_at = now() WHERE ledger_id IS NOT DISTINCT FROM $1 AND month = $2 AND closed RETURNING "+periodColumns,
+		r.LedgerID, month, m.Subject(c),
+	).Scan(p.fields()...)
+	if err == sql.ErrNoRows {
+		return problem.New(http.StatusNotFound, problem.CodePeriodNotFound, fmt.Sprintf("period %s is not closed", month.Time().Format(monthLayout)))
+	}
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't reopen period: %w", err))
+	}
+	return c.JSON(http.StatusOK, p)
+}
+
+// ListPeriodsHandler lists the periods of the caller's ledgers, or of
+// ledger_id only, latest first.
+func (h *Handler) ListPeriodsHandler(c echo.Context) error {
+	f, err := ledgerFilter(c)
+	if err != nil {
+		return err
+	}
+	stmt, err := h.prepare("SELECT " + periodColumns + " FROM accounting_periods" + f.where() + " ORDER BY month DESC, id")
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't prepare periods statment: %w", err))
+	}
+
+	ctx := c.Request().Context()
+	var periods []Period
+	err = retryRead(ctx, func() error {
+		periods = []Period{}
+		rows, err := stmt.QueryContext(ctx, f.args...)
+		if err != nil {
+			return fmt.Errorf("can't query periods: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var p Period
+			if err := rows.Scan(p.fields()...); err != nil {
+				return fmt.Errorf("can't scan periods: %w", err)
+			}
+			periods = append(periods, p)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return problem.Internal(err)
+	}
+	return c.JSON(http.StatusOK, periods)
+}
+
+// PeriodOverridesHandler lists the overrides of a period, oldest first, to
+// the owners of its ledger.
+func (h *Handler) PeriodOverridesHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidID, "period id must be an integer")
+	}
+	missing := func() error {
+		return problem.New(http.StatusNotFound, problem.CodePeriodNotFound, fmt.Sprintf("period %d does not exist", id))
+	}
+	if err := h.accessRow(c, "accounting_periods", id, ledger.Owner, missing); err != nil {
+		return err
+	}
+
+	stmt, err := h.prepare("SELECT id, period_id, expense_id, action, actor, request_id, reason, created_at FROM period_overrides WHERE period_id = $1 ORDER BY id")
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't prepare overrides statment: %w", err))
+	}
+	ctx := c.Request().Context()
+	var overrides []PeriodOverride
+	err = retryRead(ctx, func() error {
+		overrides = []PeriodOverride{}
+		rows, err := stmt.QueryContext(ctx, id)
+		if err != nil {
+			return fmt.Errorf("can't query overrides: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var o PeriodOverride
+			if err := rows.Scan(&o.ID, &o.PeriodID, &o.ExpenseID, &o.Action, &o.Actor, &o.RequestID, &o.Reason, &o.CreatedAt); err != nil {
+				return fmt.Errorf("can't scan overrides: %w", err)
+			}
+			overrides = append(overrides, o)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return problem.Internal(err)
+	}
+	return c.JSON(http.StatusOK, overrides)
+}
+
+// overrideReason reads override_reason, with which admins change expenses of
+// closed periods. Other callers can't send it.
+func overrideReason(c echo.Context) (string, error) {
+	reason := strings.TrimSpace(c.QueryParam("override_reason"))
+	if reason == "" {
+		return "", nil
+	}
+	if !ledger.IsAdmin(c) {
+		return "", problem.New(http.StatusForbidden, problem.CodeInsufficientScope, "only admin keys may override a closed period")
+	}
+	if len(reason) > maxComment {
+		return "", problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, fmt.Sprintf("override_reason must be at most %d characters", maxComment))
+	}
+	return reason, nil
+}
+
+// checkPeriods fails with period_closed when one of days falls in a closed
+// period of ledgerID, unless reason overrides it. It returns the periods
+// overridden, for auditOverrides.
+func checkPeriods(ctx context.Context, tx *sql.Tx, ledgerID *int, reason string, days ...date.Date) ([]Period, error) {
+	closed, err := closedPeriods(ctx, tx, ledgerID, days...)
+	if err != nil {
+		return nil, err
+	}
+	if closed != nil && reason == "" {
+		return nil, problem.New(http.StatusConflict, problem.CodePeriodClosed,
+			fmt.Sprintf("period %s is closed, its expenses can't be changed", closed[0].Month))
+	}
+	return closed, nil
+}
+
+// closedPeriods returns the closed periods of ledgerID that days fall in,
+// ordered by month. The periods can't be closed until tx ends.
+func closedPeriods(ctx context.Context, tx *sql.Tx, ledgerID *int, days ...date.Date) ([]Period, error) {
+	// The lock is taken before the query, whose snapshot then sees any close
+	// that committed while waiting for it.
+	if err := lockPeriods(ctx, tx, ledgerID, "pg_advisory_xact_lock_shared"); err != nil {
+		return nil, err
+	}
+	months := make([]string, 0, len(days))
+	for _, d := range days {
+		months = append(months, d.Time().Format(monthLayout)+"-01")
+	}
+	rows, err := tx.QueryContext(ctx,
+		"SELECT "+periodColumns+" FROM accounting_periods WHERE ledger_id IS NOT DISTINCT FROM $1 AND closed AND month = ANY($2::date[]) ORDER BY month",
+		ledgerID, pq.Array(months))
+	if err != nil {
+		return nil, problem.Internal(fmt.Errorf("can't query closed periods: %w", err))
+	}
+	defer rows.Close()
+	var closed []Period
+	for rows.Next() {
+		var p Period
+		if err := rows.Scan(p.fields()...); err != nil {
+			return nil, problem.Internal(fmt.Errorf("can't scan closed periods: %w", err))
+		}
+		closed = append(closed, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, problem.Internal(fmt.Errorf("can't query closed periods: %w", err))
+	}
+	return closed, nil
+}
+
+// lockPeriods takes the periodLock of ledgerID with lock, a transaction-level
+// advisory lock function.
+func lockPeriods(ctx context.Context, tx *sql.Tx, ledgerID *int, lock string) error {
+	var key int
+	if ledgerID != nil {
+		key = *ledgerID
+	}
+	if _, err := tx.ExecContext(ctx, "SELECT "+lock+"($1, $2)", periodLock, key); err != nil {
+		return problem.Internal(fmt.Errorf("can't lock periods: %w", err))
+	}
+	return nil
+}
+
+// auditOverrides records that the caller overrode the closed periods to
+// apply action to expense id.
+func auditOverrides(c echo.Context, tx *sql.Tx, periods []Period, id int, action, reason string) error {
+	for _, p := range periods {
+		_, err := tx.ExecContext(c.Request().Context(),
+			"INSERT INTO period_overrides (period_id, expense_id, action, actor, request_id, reason) VALUES ($1, $2, $3, $4, $5, $6)",
+			p.ID, id, action, m.Subject(c), requestID(c), reason)
+		if err != nil {
+			return problem.Internal(fmt.Errorf("can't audit period override: %w", err))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package expense
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/problem"
+	"github.com/panudetjt/assessment/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeriods(t *testing.T) {
+	now := time.Date(2023, 2, 1, 9, 0, 0, 0, time.UTC)
+	closedRow := func() *sqlmock.Rows {
+		return sqlmock.NewRows(periodNames).AddRow(4, 3, "2023-01", true, "apikey:1", now, nil, nil)
+	}
+
+	t.Run("should close a month", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/accounting-periods/close", strings.NewReader(`{"ledger_id": 3, "month": "2023-01"}`))
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectExec("SELECT pg_advisory_xact_lock\\(\\$1, \\$2\\)").WithArgs(periodLock, 3).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("INSERT INTO accounting_periods \\(ledger_id, month, closed_by\\) VALUES \\(\\$1, \\$2, \\$3\\) ON CONFLICT (.+) WHERE NOT accounting_periods.closed RETURNING").
+			WithArgs(3, "2023-01-01", sqlmock.AnyArg()).
+			WillReturnRows(closedRow())
+		mock.ExpectCommit()
+		h := Handler{DB: db}
+
+		res.Serve(h.ClosePeriodHandler)
+		var got Period
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Equal(t, "2023-01", got.Month)
+		assert.True(t, got.Closed)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 422 (UnprocessableEntity) for an invalid month", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/accounting-periods/close", strings.NewReader(`{"ledger_id": 3, "month": "January"}`))
+		h := Handler{}
+
+		res.Serve(h.ClosePeriodHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, res.Recorder.Code)
+		assert.Equal(t, "month", p.Errors[0].Field)
+	})
+
+	t.Run("should return 403 (Forbidden) when an editor closes a month", func(t *testing.T) {
+		res := asMember(util.RequestE(http.MethodPost, "/accounting-periods/close", strings.NewReader(`{"ledger_id": 3, "month": "2023-01"}`)), "apikey:2")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery("SELECT role FROM ledger_members WHERE ledger_id = \\$1 AND subject = \\$2").WithArgs(3, "apikey:2").
+			WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("editor"))
+		h := Handler{DB: db}
+
+		res.Serve(h.ClosePeriodHandler)
+
+		assert.Equal(t, http.StatusForbidden, res.Recorder.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 404 (NotFound) reopening a month not closed", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/accounting-periods/reopen", strings.NewReader(`{"ledger_id": 3, "month": "2023-01"}`))
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery("UPDATE accounting_periods SET closed = false").WithArgs(3, "2023-01-01", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(periodNames))
+		h := Handler{DB: db}
+
+		res.Serve(h.ReopenPeriodHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusNotFound, res.Recorder.Code)
+		assert.Equal(t, problem.CodePeriodNotFound, p.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 409 (Conflict) creating an expense in a closed month", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/expenses", strings.NewReader(`{"title": "rent", "amount": 100, "ledger_id": 3, "spent_on": "2023-01-15"}`))
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectExec("SELECT pg_advisory_xact_lock_shared").WithArgs(periodLock, 3).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("FROM accounting_periods").WithArgs(3, pq.Array([]string{"2023-01-01"})).WillReturnRows(closedRow())
+		mock.ExpectRollback()
+		h := Handler{DB: db}
+
+		res.Serve(h.CreateExpensesHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusConflict, res.Recorder.Code)
+		assert.Equal(t, problem.CodePeriodClosed, p.Code)
+		assert.Equal(t, "period 2023-01 is closed, its expenses can't be changed", p.Detail)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should let admins override a closed month with an audited reason", func(t *testing.T) {
+		res := util.RequestE(http.MethodDelete, "/expenses/1?override_reason=entered+twice", nil)
+		res.Context.SetParamNames("id")
+		res.Context.SetParamValues("1")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("DELETE FROM expenses").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(expenseColumns).AddRow(1, "rent", 100, "", pq.Array([]string{}), 3, nil, "THB", "2023-01-15", nil, nil, nil, "draft"))
+		mock.ExpectExec("SELECT pg_advisory_xact_lock_shared").WithArgs(periodLock, 3).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("FROM accounting_periods").WithArgs(3, pq.Array([]string{"2023-01-01"})).
+			WillReturnRows(closedRow())
+		mock.ExpectExec("INSERT INTO period_overrides \\(period_id, expense_id, action, actor, request_id, reason\\)").
+			WithArgs(4, 1, ActionDeleted, sqlmock.AnyArg(), sqlmock.AnyArg(), "entered twice").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		expectEvent(mock, ActionDeleted)
+		h := Handler{DB: db}
+
+		res.Serve(h.DeleteExpenseHandler)
+
+		assert.Equal(t, http.StatusNoContent, res.Recorder.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 409 (Conflict) merging an expense of a closed month", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/expenses/merge", strings.NewReader(`{"keep": 1, "discard": 2}`))
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM expenses WHERE id IN").
+			WillReturnRows(sqlmock.NewRows(expenseColumns).
+				AddRow(1, "taxi", 120, "", pq.Array([]string{}), 3, nil, "THB", "2023-02-01", nil, nil, nil, "draft").
+				AddRow(2, "taxi", 120, "", pq.Array([]string{}), 3, nil, "THB", "2023-01-31", nil, nil, nil, "draft"))
+		mock.ExpectExec("SELECT pg_advisory_xact_lock_shared").WithArgs(periodLock, 3).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("FROM accounting_periods").WithArgs(3, pq.Array([]string{"2023-02-01"})).WillReturnRows(sqlmock.NewRows(periodNames))
+		mock.ExpectExec("SELECT pg_advisory_xact_lock_shared").WithArgs(periodLock, 3).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("FROM accounting_periods").WithArgs(3, pq.Array([]string{"2023-01-01"})).WillReturnRows(closedRow())
+		mock.ExpectRollback()
+		h := Handler{DB: db}
+
+		res.Serve(h.MergeExpensesHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusConflict, res.Recorder.Code)
+		assert.Equal(t, problem.CodePeriodClosed, p.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 409 (Conflict) merging a category with expenses of a closed month", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/categories/2/merge", strings.NewReader(`{"into": 5}`))
+		res.Context.SetParamNames("id")
+		res.Context.SetParamValues("2")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT ledger_id").WillReturnRows(sqlmock.NewRows([]string{"ledger_id"}).AddRow(3))
+		mock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT EXISTS").WillReturnRows(sqlmock.NewRows([]string{"exists", "inside"}).AddRow(true, false))
+		mock.ExpectExec("UPDATE categories SET parent_id").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("UPDATE rules SET category_id").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("UPDATE expenses SET category_id (.+) deleted_at IS NOT NULL").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("UPDATE expenses SET category_id (.+) deleted_at IS NULL RETURNING").
+			WillReturnRows(sqlmock.NewRows(expenseColumns).AddRow(1, "latte", 90, "", pq.Array([]string{}), 3, nil, "THB", "2023-01-15", nil, nil, 5, "draft"))
+		mock.ExpectExec("SELECT pg_advisory_xact_lock_shared").WithArgs(periodLock, 3).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("FROM accounting_periods").WithArgs(3, pq.Array([]string{"2023-01-01"})).WillReturnRows(closedRow())
+		mock.ExpectRollback()
+		h := Handler{DB: db}
+
+		res.Serve(h.MergeCategoryHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusConflict, res.Recorder.Code)
+		assert.Equal(t, problem.CodePeriodClosed, p.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	applyRules := func(mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM rules").
+			WillReturnRows(sqlmock.NewRows(strings.Split(ruleColumns, ", ")).AddRow(1, 3, "Coffee", 0, "latte", "", nil, nil, nil, pq.Array([]string{"coffee"}), nil, "", now))
+		mock.ExpectQuery("FROM expenses WHERE ledger_id IS NOT DISTINCT FROM \\$1").WithArgs(3).
+			WillReturnRows(sqlmock.NewRows(expenseColumns).
+				AddRow(1, "latte", 90, "", pq.Array([]string{}), 3, nil, "THB", "2023-01-15", nil, nil, nil, "draft").
+				AddRow(2, "latte", 90, "", pq.Array([]string{}), 3, nil, "THB", "2023-02-01", nil, nil, nil, "draft"))
+		mock.ExpectExec("SELECT pg_advisory_xact_lock_shared").WithArgs(periodLock, 3).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("FROM accounting_periods").WithArgs(3, pq.Array([]string{"2023-01-01", "2023-02-01"})).WillReturnRows(closedRow())
+	}
+
+	t.Run("should skip expenses of a closed month applying rules", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/rules/apply", strings.NewReader(`{"ledger_id": 3}`))
+		db, mock, _ := sqlmock.New()
+		applyRules(mock)
+		mock.ExpectExec("UPDATE expenses SET tags").WithArgs(2, pq.Array([]string{"coffee"}), nil, "").WillReturnResult(sqlmock.NewResult(0, 1))
+		expectEvent(mock, ActionUpdated)
+		h := Handler{DB: db}
+
+		res.Serve(h.ApplyRulesHandler)
+		var got ApplyResult
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Len(t, got.Changed, 1)
+		assert.Equal(t, 2, got.Changed[0].ExpenseID)
+		assert.Equal(t, []RuleSkip{{ExpenseID: 1, Code: problem.CodePeriodClosed}}, got.Skipped)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should apply rules to a closed month with an audited reason", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/rules/apply?override_reason=new+tags", strings.NewReader(`{"ledger_id": 3}`))
+		db, mock, _ := sqlmock.New()
+		applyRules(mock)
+		mock.ExpectExec("UPDATE expenses SET tags").WithArgs(1, pq.Array([]string{"coffee"}), nil, "").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO period_overrides").WithArgs(4, 1, ActionUpdated, sqlmock.AnyArg(), sqlmock.AnyArg(), "new tags").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery("INSERT INTO expense_events").
+			WithArgs(1, ActionUpdated, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"version", "created_at"}).AddRow(2, now))
+		mock.ExpectExec("INSERT INTO webhook_outbox").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE expenses SET tags").WithArgs(2, pq.Array([]string{"coffee"}), nil, "").WillReturnResult(sqlmock.NewResult(0, 1))
+		expectEvent(mock, ActionUpdated)
+		h := Handler{DB: db}
+
+		res.Serve(h.ApplyRulesHandler)
+		var got ApplyResult
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Len(t, got.Changed, 2)
+		assert.Empty(t, got.Skipped)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 403 (Forbidden) when a member sends an override reason", func(t *testing.T) {
+		res := asMember(util.RequestE(http.MethodDelete, "/expenses/1?override_reason=oops", nil), "apikey:2")
+		res.Context.SetParamNames("id")
+		res.Context.SetParamValues("1")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectPrepare("SELECT m.role FROM expenses e JOIN ledger_members m").ExpectQuery().
+			WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("owner"))
+		h := Handler{DB: db}
+
+		res.Serve(h.DeleteExpenseHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusForbidden, res.Recorder.Code)
+		assert.Equal(t, problem.CodeInsufficientScope, p.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
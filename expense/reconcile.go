@@ -0,0 +1,329 @@
+package expense
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/exchange"
+	"github.com/panudetjt/assessment/ledger"
+	m "github.com/panudetjt/assessment/middleware"
+	"github.com/panudetjt/assessment/problem"
+	"github.com/panudetjt/assessment/statement"
+)
+
+// Reconciliation compares a bank statement with the expenses of a ledger.
+type Reconciliation struct {
+	// Matched pairs the lines with the expense they most likely paid, to
+	// confirm with POST /reconciliations/confirm. Confirmed are the lines
+	// confirmed before, which aren't matched again.
+	Matched        []Match          `json:"matched"`
+	Confirmed      []Match          `json:"confirmed"`
+	UnmatchedLines []statement.Line `json:"unmatched_lines"`
+	// UnmatchedExpenses are the expenses in the statement's currency spent
+	// between its first and last day that no line matched and none was
+	// confirmed for.
+	UnmatchedExpenses []Expense `json:"unmatched_expenses"`
+}
+
+// Match is a statement line and its expense. Similarity is how much of the
+// expense's title the line's description holds, from 0 to 1 as pg_trgm
+// measures it.
+type Match struct {
+	Line       statement.Line `json:"line"`
+	Expense    Expense        `json:"expense"`
+	Similarity float64        `json:"similarity,omitempty"`
+}
+
+// ConfirmRequest is the body of POST /reconciliations/confirm.
+type ConfirmRequest struct {
+	LedgerID *int           `json:"ledger_id,omitempty"`
+	Matches  []ConfirmMatch `json:"matches"`
+}
+
+// ConfirmMatch confirms that Line paid expense ExpenseID.
+type ConfirmMatch struct {
+	Line      statement.Line `json:"line"`
+	ExpenseID int            `json:"expense_id"`
+}
+
+// ConfirmResult is the response of POST /reconciliations/confirm.
+type ConfirmResult struct {
+	Confirmed int `json:"confirmed"`
+}
+
+const (
+	maxStatement = 10000
+	// maxStatementBytes bounds the size of an uploaded statement, ample for
+	// maxStatement lines.
+	maxStatementBytes = 10 << 20
+	// A line matches expenses spent up to defaultWindowDays from the day it
+	// was posted, with titles at least defaultSimilarity alike, unless the
+	// caller asks otherwise.
+	defaultWindowDays = 3
+	defaultSimilarity = 0.3
+)
+
+// ReconcileHandler matches the debits of a CSV or OFX bank statement in
+// currency with the expenses of ledger_id: same currency and amount, spent
+// within window_days of the line and with a title at least min_similarity
+// alike its description. Each line and expense is matched once, the most alike
+// and closest pairs first.
+func (h *Handler) ReconcileHandler(c echo.Context) error {
+	ct, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	var parse func(io.Reader) ([]statement.Line, error)
+	switch ct {
+	case "text/csv":
+		var positive bool
+		switch c.QueryParam("debits") {
+		case "", "negative":
+		case "positive":
+			positive = true
+		default:
+			return problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, "debits must be negative or positive")
+		}
+		parse = func(r io.Reader) ([]statement.Line, error) { return statement.ParseCSV(r, positive, maxStatement) }
+	case "application/x-ofx", "application/ofx":
+		parse = func(r io.Reader) ([]statement.Line, error) { return statement.ParseOFX(r, maxStatement) }
+	default:
+		return problem.New(http.StatusUnsupportedMediaType, problem.CodeUnsupportedMedia, "bank statements must be text/csv or application/x-ofx")
+	}
+
+	id, err := queryInt(c, "ledger_id", 1, math.MaxInt32)
+	if err != nil {
+		return err
+	}
+	var ledgerID *int
+	switch {
+	case id.Valid:
+		n := int(id.Int64)
+		ledgerID = &n
+		if err := h.accessLedger(c, n, ledger.Viewer); err != nil {
+			return err
+		}
+	case !ledger.IsAdmin(c):
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, "ledger_id is required")
+	}
+	// Statements don't always name their currency, and amounts only match
+	// in the same one.
+	currency := strings.ToUpper(strings.TrimSpace(c.QueryParam("currency")))
+	if !exchange.ValidCurrency(currency) {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, "currency must be the 3-letter currency code of the statement")
+	}
+	window, err := queryInt(c, "window_days", 0, 31)
+	if err != nil {
+		return err
+	}
+	if !window.Valid {
+		window.Int64 = defaultWindowDays
+	}
+	similarity := defaultSimilarity
+	if v := c.QueryParam("min_similarity"); v != "" {
+		similarity, err = strconv.ParseFloat(v, 64)
+		if err != nil || similarity < 0 || similarity > 1 {
+			return problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, "min_similarity must be a number between 0 and 1")
+		}
+	}
+
+	lines, err := parse(http.MaxBytesReader(c.Response(), c.Request().Body, maxStatementBytes))
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		return problem.New(http.StatusRequestEntityTooLarge, problem.CodeStatementTooLarge, fmt.Sprintf("bank statements must not exceed %d bytes", maxStatementBytes))
+	}
+	if err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "can't read bank statement: "+err.Error())
+	}
+	if len(lines) == 0 {
+		return problem.Validation(problem.FieldError{Field: "lines", Message: "must not be empty"})
+	}
+	if len(lines) > maxStatement {
+		return problem.Validation(problem.FieldError{Field: "lines", Message: fmt.Sprintf("must be at most %d per statement", maxStatement)})
+	}
+
+	confirmedStmt, err := h.prepare("SELECT r.line_id, " + prefixed("e") + " FROM reconciled_lines r JOIN expenses e ON e.id = r.expense_id " +
+		"WHERE r.ledger_id IS NOT DISTINCT FROM $1 AND r.line_id = ANY($2)")
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't prepare reconciled lines statment: %w", err))
+	}
+	candidatesStmt, err := h.prepare("SELECT l.n, " + prefixed("e") + ", word_similarity(e.title, l.description) AS score " +
+		"FROM unnest($2::integer[], $3::date[], $4::float8[], $5::text[]) AS l (n, posted_on, amount, description) " +
+		"JOIN expenses e ON e.ledger_id IS NOT DISTINCT FROM $1 AND e." + live + " AND e.currency = $8 AND round(e.amount::numeric, 2) = round(l.amount::numeric, 2) " +
+		"AND e.spent_on BETWEEN l.posted_on - $6::integer AND l.posted_on + $6::integer AND word_similarity(e.title, l.description) >= $7 " +
+		"AND NOT EXISTS (SELECT 1 FROM reconciled_lines r WHERE r.expense_id = e.id) " +
+		"ORDER BY score DESC, abs(e.spent_on - l.posted_on), l.n, e.id")
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't prepare match statment: %w", err))
+	}
+	unmatchedStmt, err := h.prepare("SELECT " + columns + " FROM expenses WHERE ledger_id IS NOT DISTINCT FROM $1 AND " + live + " AND currency = $4 AND spent_on BETWEEN $2 AND $3 " +
+		"AND NOT EXISTS (SELECT 1 FROM reconciled_lines r WHERE r.expense_id = expenses.id) ORDER BY spent_on, id")
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't prepare unmatched expenses statment: %w", err))
+	}
+
+	ids := make([]string, len(lines))
+	from, to := lines[0].PostedOn, lines[0].PostedOn
+	for i, l := range lines {
+		ids[i] = l.ID
+		if l.PostedOn.Time().Before(from.Time()) {
+			from = l.PostedOn
+		}
+		if l.PostedOn.Time().After(to.Time()) {
+			to = l.PostedOn
+		}
+	}
+
+	ctx := c.Request().Context()
+	var rec Reconciliation
+	err = retryRead(ctx, func() error {
+		rec = Reconciliation{Matched: []Match{}, Confirmed: []Match{}, UnmatchedLines: []statement.Line{}, UnmatchedExpenses: []Expense{}}
+		confirmed := map[string]Expense{}
+		rows, err := confirmedStmt.QueryContext(ctx, ledgerID, pq.Array(ids))
+		if err != nil {
+			return fmt.Errorf("can't query reconciled lines: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var lineID string
+			var e Expense
+			if err := rows.Scan(append([]any{&lineID}, e.fields()...)...); err != nil {
+				return fmt.Errorf("can't scan reconciled lines: %w", err)
+			}
+			confirmed[lineID] = e
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		var ns []int64
+		var days, descriptions []string
+		var amounts []float64
+		for i, l := range lines {
+			if e, ok := confirmed[l.ID]; ok {
+				rec.Confirmed = append(rec.Confirmed, Match{Line: l, Expense: e})
+				continue
+			}
+			ns, days = append(ns, int64(i)), append(days, l.PostedOn.String())
+			amounts, descriptions = append(amounts, l.Amount), append(descriptions, l.Description)
+		}
+		rows, err = candidatesStmt.QueryContext(ctx, ledgerID, pq.Array(ns), pq.Array(days), pq.Array(amounts), pq.Array(descriptions), window.Int64, similarity, currency)
+		if err != nil {
+			return fmt.Errorf("can't query matches: %w", err)
+		}
+		defer rows.Close()
+		matched := map[int64]Match{}
+		used := map[int]bool{}
+		for rows.Next() {
+			var n int64
+			var mt Match
+			if err := rows.Scan(append(append([]any{&n}, mt.Expense.fields()...), &mt.Similarity)...); err != nil {
+				return fmt.Errorf("can't scan matches: %w", err)
+			}
+			if _, ok := matched[n]; ok || used[mt.Expense.ID] {
+				continue
+			}
+			mt.Line = lines[n]
+			matched[n], used[mt.Expense.ID] = mt, true
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, n := range ns {
+			if mt, ok := matched[n]; ok {
+				rec.Matched = append(rec.Matched, mt)
+			} else {
+				rec.UnmatchedLines = append(rec.UnmatchedLines, lines[n])
+			}
+		}
+
+		rows, err = unmatchedStmt.QueryContext(ctx, ledgerID, from, to, currency)
+		if err != nil {
+			return fmt.Errorf("can't query unmatched expenses: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var e Expense
+			if err := rows.Scan(e.fields()...); err != nil {
+				return fmt.Errorf("can't scan unmatched expenses: %w", err)
+			}
+			if !used[e.ID] {
+				rec.UnmatchedExpenses = append(rec.UnmatchedExpenses, e)
+			}
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return problem.Internal(err)
+	}
+	return c.JSON(http.StatusOK, rec)
+}
+
+// ConfirmReconciliationHandler records that statement lines paid expenses of
+// the ledger, so later reconciliations report them as confirmed.
+func (h *Handler) ConfirmReconciliationHandler(c echo.Context) error {
+	var r ConfirmRequest
+	if err := c.Bind(&r); err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "request body must be a confirmation JSON object")
+	}
+	if len(r.Matches) == 0 {
+		return problem.Validation(problem.FieldError{Field: "matches", Message: "must not be empty"})
+	}
+	if len(r.Matches) > maxStatement {
+		return problem.Validation(problem.FieldError{Field: "matches", Message: fmt.Sprintf("must be at most %d per confirmation", maxStatement)})
+	}
+	var errs []problem.FieldError
+	for i, mt := range r.Matches {
+		field := fmt.Sprintf("matches[%d]", i)
+		if mt.Line.ID == "" {
+			errs = append(errs, problem.FieldError{Field: field + ".line.id", Message: "is required"})
+		}
+		if mt.Line.PostedOn.IsZero() {
+			errs = append(errs, problem.FieldError{Field: field + ".line.posted_on", Message: "is required"})
+		}
+		if mt.ExpenseID <= 0 {
+			errs = append(errs, problem.FieldError{Field: field + ".expense_id", Message: "must be greater than 0"})
+		}
+	}
+	if errs != nil {
+		return problem.Validation(errs...)
+	}
+	if err := h.accessNew(c, r.LedgerID); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	err := h.inTx(c, func(tx *sql.Tx) error {
+		for i, mt := range r.Matches {
+			res, err := tx.ExecContext(ctx,
+				"INSERT INTO reconciled_lines (ledger_id, line_id, expense_id, posted_on, amount, description, confirmed_by) "+
+					"SELECT $1, $2, id, $4, $5, $6, $7 FROM expenses WHERE id = $3 AND ledger_id IS NOT DISTINCT FROM $1 AND "+live,
+				r.LedgerID, mt.Line.ID, mt.ExpenseID, mt.Line.PostedOn, mt.Line.Amount, mt.Line.Description, m.Subject(c))
+			if pe, ok := err.(*pq.Error); ok && pe.Code == "23505" {
+				return problem.New(http.StatusConflict, problem.CodeAlreadyReconciled,
+					fmt.Sprintf("statement line %s or expense %d is already reconciled", mt.Line.ID, mt.ExpenseID))
+			}
+			if err != nil {
+				return problem.Internal(fmt.Errorf("can't confirm reconciliation: %w", err))
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return problem.Internal(fmt.Errorf("can't confirm reconciliation: %w", err))
+			}
+			if n == 0 {
+				return problem.Validation(problem.FieldError{Field: fmt.Sprintf("matches[%d].expense_id", i), Message: "does not exist in the ledger"})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, ConfirmResult{Confirmed: len(r.Matches)})
+}
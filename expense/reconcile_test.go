@@ -0,0 +1,174 @@
+package expense
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/problem"
+	"github.com/panudetjt/assessment/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconciliation(t *testing.T) {
+	expense := func(id int, title string, amount float64, day string) []driver.Value {
+		return []driver.Value{id, title, amount, "", pq.Array([]string{}), 3, nil, "THB", day, nil, nil, nil, "draft"}
+	}
+
+	t.Run("should match the lines and report what is left on each side", func(t *testing.T) {
+		csv := "id,date,description,amount\nl1,2023-01-02,STARBUCKS SIAM,-60\nl2,2023-01-03,GRAB*TAXI,-120\nl3,2023-01-05,HOTEL,-2400\n"
+		res := util.RequestE(http.MethodPost, "/reconciliations?ledger_id=3&currency=thb", strings.NewReader(csv))
+		res.Context.Request().Header.Set(echo.HeaderContentType, "text/csv")
+		db, mock, _ := sqlmock.New()
+		confirmed := mock.ExpectPrepare("SELECT r.line_id, (.+) FROM reconciled_lines r JOIN expenses e")
+		candidates := mock.ExpectPrepare("FROM unnest\\(\\$2::integer\\[\\], \\$3::date\\[\\], \\$4::float8\\[\\], \\$5::text\\[\\]\\) (.+) AND e.currency = \\$8")
+		unmatched := mock.ExpectPrepare("SELECT " + columns + " FROM expenses WHERE ledger_id IS NOT DISTINCT FROM \\$1 AND deleted_at IS NULL AND currency = \\$4")
+		confirmed.ExpectQuery().WithArgs(3, pq.Array([]string{"l1", "l2", "l3"})).
+			WillReturnRows(sqlmock.NewRows(append([]string{"line_id"}, expenseColumns...)).AddRow(append([]driver.Value{"l3"}, expense(9, "hotel", 2400, "2023-01-04")...)...))
+		candidates.ExpectQuery().
+			WithArgs(3, pq.Array([]int64{0, 1}), pq.Array([]string{"2023-01-02", "2023-01-03"}), pq.Array([]float64{60, 120}), pq.Array([]string{"STARBUCKS SIAM", "GRAB*TAXI"}), int64(3), 0.3, "THB").
+			WillReturnRows(sqlmock.NewRows(append(append([]string{"n"}, expenseColumns...), "score")).
+				AddRow(append(append([]driver.Value{0}, expense(1, "starbucks", 60, "2023-01-02")...), 0.9)...).
+				AddRow(append(append([]driver.Value{0}, expense(2, "coffee at siam", 60, "2023-01-03")...), 0.4)...))
+		unmatched.ExpectQuery().WithArgs(3, sqlmock.AnyArg(), sqlmock.AnyArg(), "THB").
+			WillReturnRows(sqlmock.NewRows(expenseColumns).
+				AddRow(expense(1, "starbucks", 60, "2023-01-02")...).
+				AddRow(expense(2, "coffee at siam", 60, "2023-01-03")...))
+		h := Handler{DB: db}
+
+		res.Serve(h.ReconcileHandler)
+		var got Reconciliation
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Len(t, got.Matched, 1)
+		assert.Equal(t, "l1", got.Matched[0].Line.ID)
+		assert.Equal(t, 1, got.Matched[0].Expense.ID)
+		assert.Equal(t, 0.9, got.Matched[0].Similarity)
+		assert.Len(t, got.Confirmed, 1)
+		assert.Equal(t, 9, got.Confirmed[0].Expense.ID)
+		assert.Len(t, got.UnmatchedLines, 1)
+		assert.Equal(t, "l2", got.UnmatchedLines[0].ID)
+		assert.Len(t, got.UnmatchedExpenses, 1)
+		assert.Equal(t, 2, got.UnmatchedExpenses[0].ID)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 415 (UnsupportedMediaType) for a JSON statement", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/reconciliations?ledger_id=3&currency=thb", strings.NewReader(`[]`))
+		h := Handler{}
+
+		res.Serve(h.ReconcileHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusUnsupportedMediaType, res.Recorder.Code)
+		assert.Equal(t, problem.CodeUnsupportedMedia, p.Code)
+	})
+
+	t.Run("should return 413 (RequestEntityTooLarge) for a statement over the size limit", func(t *testing.T) {
+		csv := "date,description,amount\n2023-01-02," + strings.Repeat("x", maxStatementBytes) + ",-60\n"
+		res := util.RequestE(http.MethodPost, "/reconciliations?currency=THB", strings.NewReader(csv))
+		res.Context.Request().Header.Set(echo.HeaderContentType, "text/csv")
+		h := Handler{}
+
+		res.Serve(h.ReconcileHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, res.Recorder.Code)
+		assert.Equal(t, problem.CodeStatementTooLarge, p.Code)
+	})
+
+	t.Run("should return 400 (BadRequest) without the statement's currency", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/reconciliations?ledger_id=3", strings.NewReader(""))
+		res.Context.Request().Header.Set(echo.HeaderContentType, "text/csv")
+		h := Handler{}
+
+		res.Serve(h.ReconcileHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusBadRequest, res.Recorder.Code)
+		assert.Equal(t, problem.CodeInvalidQuery, p.Code)
+	})
+
+	t.Run("should return 400 (BadRequest) for an unknown sign of debits", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/reconciliations?ledger_id=3&currency=THB&debits=both", strings.NewReader(""))
+		res.Context.Request().Header.Set(echo.HeaderContentType, "text/csv")
+		h := Handler{}
+
+		res.Serve(h.ReconcileHandler)
+
+		assert.Equal(t, http.StatusBadRequest, res.Recorder.Code)
+	})
+
+	t.Run("should return 400 (BadRequest) for a min_similarity above 1", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/reconciliations?ledger_id=3&currency=THB&min_similarity=2", strings.NewReader(""))
+		res.Context.Request().Header.Set(echo.HeaderContentType, "text/csv")
+		h := Handler{}
+
+		res.Serve(h.ReconcileHandler)
+
+		assert.Equal(t, http.StatusBadRequest, res.Recorder.Code)
+	})
+
+	confirm := `{"ledger_id": 3, "matches": [{"line": {"id": "l1", "posted_on": "2023-01-02", "amount": 60, "description": "STARBUCKS SIAM"}, "expense_id": 1}]}`
+	insert := "INSERT INTO reconciled_lines \\(ledger_id, line_id, expense_id, posted_on, amount, description, confirmed_by\\) SELECT"
+
+	t.Run("should confirm matches", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/reconciliations/confirm", strings.NewReader(confirm))
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectExec(insert).WithArgs(3, "l1", 1, sqlmock.AnyArg(), 60.0, "STARBUCKS SIAM", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		h := Handler{DB: db}
+
+		res.Serve(h.ConfirmReconciliationHandler)
+		var got ConfirmResult
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Equal(t, 1, got.Confirmed)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 409 (Conflict) confirming a reconciled line", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/reconciliations/confirm", strings.NewReader(confirm))
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectExec(insert).WillReturnError(&pq.Error{Code: "23505"})
+		mock.ExpectRollback()
+		h := Handler{DB: db}
+
+		res.Serve(h.ConfirmReconciliationHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusConflict, res.Recorder.Code)
+		assert.Equal(t, problem.CodeAlreadyReconciled, p.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 422 (UnprocessableEntity) for an expense outside the ledger", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/reconciliations/confirm", strings.NewReader(confirm))
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectExec(insert).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+		h := Handler{DB: db}
+
+		res.Serve(h.ConfirmReconciliationHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, res.Recorder.Code)
+		assert.Equal(t, "matches[0].expense_id", p.Errors[0].Field)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,62 @@
+package expense
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	readAttempts   = 3
+	readRetryDelay = 50 * time.Millisecond
+)
+
+// transientCodes are PostgreSQL error codes raised when the server goes away
+// under a connection, after which a fresh connection usually succeeds.
+var transientCodes = map[pq.ErrorCode]bool{
+	"57P01": true, // admin_shutdown
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now
+	"08000": true, // connection_exception
+	"08003": true, // connection_does_not_exist
+	"08006": true, // connection_failure
+}
+
+func isTransient(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return transientCodes[pqErr.Code]
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// retryRead runs f again when it fails with a transient error. Only use it for
+// idempotent reads; writes may have been applied before the error surfaced.
+func retryRead(ctx context.Context, f func() error) error {
+	delay := readRetryDelay
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = f()
+		if err == nil || attempt >= readAttempts || !isTransient(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,76 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsTransient(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"admin shutdown", &pq.Error{Code: "57P01"}, true},
+		{"wrapped admin shutdown", fmt.Errorf("query: %w", &pq.Error{Code: "57P01"}), true},
+		{"bad connection", driver.ErrBadConn, true},
+		{"connection refused", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{"unique violation", &pq.Error{Code: "23505"}, false},
+		{"no rows", sql.ErrNoRows, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.want, isTransient(c.err))
+		})
+	}
+}
+
+func TestRetryRead(t *testing.T) {
+	readRetryDelay = time.Millisecond
+
+	t.Run("should retry transient errors", func(t *testing.T) {
+		calls := 0
+		err := retryRead(context.Background(), func() error {
+			calls++
+			if calls < readAttempts {
+				return &pq.Error{Code: "57P01"}
+			}
+			return nil
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, readAttempts, calls)
+	})
+
+	t.Run("should stop after max attempts", func(t *testing.T) {
+		calls := 0
+		err := retryRead(context.Background(), func() error {
+			calls++
+			return driver.ErrBadConn
+		})
+
+		assert.ErrorIs(t, err, driver.ErrBadConn)
+		assert.Equal(t, readAttempts, calls)
+	})
+
+	t.Run("should not retry other errors", func(t *testing.T) {
+		calls := 0
+		err := retryRead(context.Background(), func() error {
+			calls++
+			return errors.New("syntax error")
+		})
+
+		assert.Error(t, err)
+		assert.Equal(t, 1, calls)
+	})
+}
@@ -0,0 +1,502 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/date"
+	"github.com/panudetjt/assessment/ledger"
+	"github.com/panudetjt/assessment/problem"
+)
+
+// Rule tags and categorizes the expenses of its ledger that match all of its
+// conditions as they are created, updated or imported. Rules run by Position,
+// then id, each seeing what the previous ones changed.
+type Rule struct {
+	ID         int            `json:"id"`
+	LedgerID   *int           `json:"ledger_id,omitempty"`
+	Name       string         `json:"name"`
+	Position   int            `json:"position"`
+	Conditions RuleConditions `json:"conditions"`
+	Actions    RuleActions    `json:"actions"`
+	CreatedAt  time.Time      `json:"created_at"`
+
+	title, note *regexp.Regexp
+}
+
+// RuleConditions are matched against an expense. Title and Note are regular
+// expressions in RE2 syntax matched case-insensitively anywhere in the text.
+// Unset conditions match everything, but a rule needs at least one.
+type RuleConditions struct {
+	Title      string   `json:"title,omitempty"`
+	Note       string   `json:"note,omitempty"`
+	MinAmount  *float64 `json:"min_amount,omitempty"`
+	MaxAmount  *float64 `json:"max_amount,omitempty"`
+	MerchantID *int     `json:"merchant_id,omitempty"`
+}
+
+// RuleActions change a matching expense. They never override what was
+// already there: AddTags adds the missing tags, and CategoryID and Note are
+// only set on expenses without a category or note.
+type RuleActions struct {
+	AddTags    []string `json:"add_tags,omitempty"`
+	CategoryID *int     `json:"category_id,omitempty"`
+	Note       string   `json:"note,omitempty"`
+}
+
+// Rules are the rules of a ledger in the order they run.
+type Rules []Rule
+
+// Querier is what LoadRules needs of a *sql.DB or *sql.Tx.
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+const maxPatternLength = 200
+
+const ruleColumns = "id, ledger_id, name, position, title_pattern, note_pattern, min_amount, max_amount, merchant_id, add_tags, category_id, set_note, created_at"
+
+func (r *Rule) fields() []any {
+	return []any{&r.ID, &r.LedgerID, &r.Name, &r.Position, &r.Conditions.Title, &r.Conditions.Note, &r.Conditions.MinAmount, &r.Conditions.MaxAmount,
+		&r.Conditions.MerchantID, pq.Array(&r.Actions.AddTags), &r.Actions.CategoryID, &r.Actions.Note, &r.CreatedAt}
+}
+
+// compile compiles the patterns of r.
+func (r *Rule) compile() error {
+	var err error
+	r.title, err = compilePattern(r.Conditions.Title)
+	if err != nil {
+		return err
+	}
+	r.note, err = compilePattern(r.Conditions.Note)
+	return err
+}
+
+func compilePattern(p string) (*regexp.Regexp, error) {
+	if p == "" {
+		return nil, nil
+	}
+	return regexp.Compile("(?i)" + p)
+}
+
+// validate reports every invalid field of r.
+func (r *Rule) validate() []problem.FieldError {
+	r.Name = strings.TrimSpace(r.Name)
+	errs := validName(r.Name)
+	cond, act := r.Conditions, r.Actions
+	for _, p := range []struct{ field, pattern string }{{"conditions.title", cond.Title}, {"conditions.note", cond.Note}} {
+		if len(p.pattern) > maxPatternLength {
+			errs = append(errs, problem.FieldError{Field: p.field, Message: fmt.Sprintf("must be at most %d characters", maxPatternLength)})
+		} else if _, err := compilePattern(p.pattern); err != nil {
+			errs = append(errs, problem.FieldError{Field: p.field, Message: "must be a regular expression: " + err.Error()})
+		}
+	}
+	if cond.MinAmount != nil && cond.MaxAmount != nil && *cond.MinAmount > *cond.MaxAmount {
+		errs = append(errs, problem.FieldError{Field: "conditions.max_amount", Message: "must be at least min_amount"})
+	}
+	if cond.Title == "" && cond.Note == "" && cond.MinAmount == nil && cond.MaxAmount == nil && cond.MerchantID == nil {
+		errs = append(errs, problem.FieldError{Field: "conditions", Message: "must have at least one condition"})
+	}
+	for _, t := range act.AddTags {
+		if strings.TrimSpace(t) == "" {
+			errs = append(errs, problem.FieldError{Field: "actions.add_tags", Message: "must not contain empty tags"})
+			break
+		}
+	}
+	if len(act.AddTags) == 0 && act.CategoryID == nil && act.Note == "" {
+		errs = append(errs, problem.FieldError{Field: "actions", Message: "must have at least one action"})
+	}
+	return errs
+}
+
+func (r *Rule) matches(e *Expense) bool {
+	c := r.Conditions
+	switch {
+	case r.title != nil && !r.title.MatchString(e.Title),
+		r.note != nil && !r.note.MatchString(e.Note),
+		c.MinAmount != nil && e.Amount < *c.MinAmount,
+		c.MaxAmount != nil && e.Amount > *c.MaxAmount,
+		c.MerchantID != nil && (e.MerchantID == nil || *e.MerchantID != *c.MerchantID):
+		return false
+	}
+	return true
+}
+
+// apply runs the actions of r on e and reports whether they changed it.
+func (r *Rule) apply(e *Expense) bool {
+	changed := false
+	for _, t := range r.Actions.AddTags {
+		if !hasTag(e.Tags, t) {
+			e.Tags = append(e.Tags, t)
+			changed = true
+		}
+	}
+	if r.Actions.CategoryID != nil && e.CategoryID == nil {
+		id := *r.Actions.CategoryID
+		e.CategoryID = &id
+		changed = true
+	}
+	if r.Actions.Note != "" && e.Note == "" {
+		e.Note = r.Actions.Note
+		changed = true
+	}
+	return changed
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply runs every matching rule on e and returns the ids of those that
+// changed it.
+func (rs Rules) Apply(e *Expense) []int {
+	var ids []int
+	for i := range rs {
+		if rs[i].matches(e) && rs[i].apply(e) {
+			ids = append(ids, rs[i].ID)
+		}
+	}
+	return ids
+}
+
+// LoadRules returns the rules of ledgerID, those outside ledgers when nil.
+func LoadRules(ctx context.Context, q Querier, ledgerID *int) (Rules, error) {
+	rows, err := q.QueryContext(ctx, "SELECT "+ruleColumns+" FROM rules WHERE ledger_id IS NOT DISTINCT FROM $1 ORDER BY position, id", ledgerID)
+	if err != nil {
+		return nil, fmt.Errorf("can't query rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rs Rules
+	for rows.Next() {
+		var r Rule
+		if err := rows.Scan(r.fields()...); err != nil {
+			return nil, fmt.Errorf("can't scan rules: %w", err)
+		}
+		if err := r.compile(); err != nil {
+			return nil, fmt.Errorf("can't compile rule %d: %w", r.ID, err)
+		}
+		rs = append(rs, r)
+	}
+	return rs, rows.Err()
+}
+
+// applyRules runs the rules of ledgerID on e within tx.
+func applyRules(ctx context.Context, tx *sql.Tx, ledgerID *int, e *Expense) error {
+	rs, err := LoadRules(ctx, tx, ledgerID)
+	if err != nil {
+		return problem.Internal(err)
+	}
+	rs.Apply(e)
+	return nil
+}
+
+// checkRuleRefs reports the merchant and category of r that don't exist in
+// its ledger.
+func checkRuleRefs(ctx context.Context, tx *sql.Tx, ledgerID *int, r *Rule) error {
+	errs, err := checkRefs(ctx, tx, ledgerID, &Expense{MerchantID: r.Conditions.MerchantID, CategoryID: r.Actions.CategoryID})
+	for i := range errs {
+		if errs[i].Field == "merchant_id" {
+			errs[i].Field = "conditions.merchant_id"
+		} else {
+			errs[i].Field = "actions.category_id"
+		}
+		errs[i].Message = "does not exist in the rule's ledger"
+	}
+	if err != nil || errs != nil {
+		return refProblem(errs, err)
+	}
+	return nil
+}
+
+func (h *Handler) CreateRuleHandler(c echo.Context) error {
+	var r Rule
+	if err := c.Bind(&r); err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "request body must be a rule JSON object")
+	}
+	if errs := r.validate(); errs != nil {
+		return problem.Validation(errs...)
+	}
+	if err := h.accessNew(c, r.LedgerID); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	err := h.inTx(c, func(tx *sql.Tx) error {
+		if err := checkRuleRefs(ctx, tx, r.LedgerID, &r); err != nil {
+			return err
+		}
+		err := tx.QueryRowContext(ctx,
+			"INSERT INTO rules (ledger_id, name, position, title_pattern, note_pattern, min_amount, max_amount, merchant_id, add_tags, category_id, set_note) "+
+				"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id, created_at",
+			r.LedgerID, r.Name, r.Position, r.Conditions.Title, r.Conditions.Note, r.Conditions.MinAmount, r.Conditions.MaxAmount,
+			r.Conditions.MerchantID, pq.Array(r.Actions.AddTags), r.Actions.CategoryID, r.Actions.Note,
+		).Scan(&r.ID, &r.CreatedAt)
+		if err != nil {
+			return problem.Internal(fmt.Errorf("can't insert rule: %w", err))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, r)
+}
+
+// ListRulesHandler lists the rules of the caller's ledgers, or of ledger_id
+// only, in the order they run.
+func (h *Handler) ListRulesHandler(c echo.Context) error {
+	f, err := ledgerFilter(c)
+	if err != nil {
+		return err
+	}
+	stmt, err := h.prepare("SELECT " + ruleColumns + " FROM rules" + f.where() + " ORDER BY ledger_id, position, id")
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't prepare rules statment: %w", err))
+	}
+
+	ctx := c.Request().Context()
+	var rules []Rule
+	err = retryRead(ctx, func() error {
+		rules = []Rule{}
+		rows, err := stmt.QueryContext(ctx, f.args...)
+		if err != nil {
+			return fmt.Errorf("can't query rules: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var r Rule
+			if err := rows.Scan(r.fields()...); err != nil {
+				return fmt.Errorf("can't scan rules: %w", err)
+			}
+			rules = append(rules, r)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return problem.Internal(err)
+	}
+	return c.JSON(http.StatusOK, rules)
+}
+
+// UpdateRuleHandler replaces the name, position, conditions and actions of a
+// rule. Its ledger can't change.
+func (h *Handler) UpdateRuleHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidID, "rule id must be an integer")
+	}
+	var r Rule
+	if err := c.Bind(&r); err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "request body must be a rule JSON object")
+	}
+	if errs := r.validate(); errs != nil {
+		return problem.Validation(errs...)
+	}
+	if err := h.accessRow(c, "rules", id, ledger.Editor, func() error { return ruleNotFound(id) }); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	err = h.inTx(c, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, "SELECT ledger_id FROM rules WHERE id = $1 FOR UPDATE", id).Scan(&r.LedgerID)
+		if err == sql.ErrNoRows {
+			return ruleNotFound(id)
+		}
+		if err != nil {
+			return problem.Internal(fmt.Errorf("can't lock rule: %w", err))
+		}
+		if err := checkRuleRefs(ctx, tx, r.LedgerID, &r); err != nil {
+			return err
+		}
+		err = tx.QueryRowContext(ctx,
+			"UPDATE rules SET name = $2, position = $3, title_pattern = $4, note_pattern = $5, min_amount = $6, max_amount = $7, "+
+				"merchant_id = $8, add_tags = $9, category_id = $10, set_note = $11 WHERE id = $1 RETURNING id, created_at",
+			id, r.Name, r.Position, r.Conditions.Title, r.Conditions.Note, r.Conditions.MinAmount, r.Conditions.MaxAmount,
+			r.Conditions.MerchantID, pq.Array(r.Actions.AddTags), r.Actions.CategoryID, r.Actions.Note,
+		).Scan(&r.ID, &r.CreatedAt)
+		if err != nil {
+			return problem.Internal(fmt.Errorf("can't update rule: %w", err))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, r)
+}
+
+func (h *Handler) DeleteRuleHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidID, "rule id must be an integer")
+	}
+	if err := h.accessRow(c, "rules", id, ledger.Editor, func() error { return ruleNotFound(id) }); err != nil {
+		return err
+	}
+	return h.deleteRow(c, "rules", "rule", id, ruleNotFound)
+}
+
+func ruleNotFound(id int) error {
+	return problem.New(http.StatusNotFound, problem.CodeRuleNotFound, fmt.Sprintf("rule %d does not exist", id))
+}
+
+// ApplyRequest is the body of POST /rules/apply.
+type ApplyRequest struct {
+	LedgerID *int `json:"ledger_id"`
+	// DryRun previews the changes without making them.
+	DryRun bool `json:"dry_run"`
+}
+
+// ApplyResult lists the expenses the rules changed, or would change, and
+// those they would change but were left alone.
+type ApplyResult struct {
+	DryRun  bool         `json:"dry_run"`
+	Checked int          `json:"checked"`
+	Changed []RuleChange `json:"changed"`
+	Skipped []RuleSkip   `json:"skipped"`
+}
+
+type RuleChange struct {
+	ExpenseID int               `json:"expense_id"`
+	Rules     []int             `json:"rules"`
+	Changes   map[string]Change `json:"changes"`
+}
+
+// RuleSkip is an expense the rules would change but may not, with the code of
+// the problem changing it would be: expense_not_editable once it is submitted,
+// or period_closed when it is spent in a closed period.
+type RuleSkip struct {
+	ExpenseID int    `json:"expense_id"`
+	Code      string `json:"code"`
+}
+
+// ApplyRulesHandler runs the rules of a ledger on all of its existing
+// expenses. Each changed expense gets a new version in its history, unless
+// dry_run only previews the changes. Expenses that are no longer editable are
+// skipped, and so are those of closed periods unless override_reason
+// overrides them.
+func (h *Handler) ApplyRulesHandler(c echo.Context) error {
+	var req ApplyRequest
+	if err := c.Bind(&req); err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "request body must be an apply JSON object")
+	}
+	if err := h.accessNew(c, req.LedgerID); err != nil {
+		return err
+	}
+	reason, err := overrideReason(c)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	result := ApplyResult{DryRun: req.DryRun, Changed: []RuleChange{}, Skipped: []RuleSkip{}}
+	err = h.inTx(c, func(tx *sql.Tx) error {
+		rs, err := LoadRules(ctx, tx, req.LedgerID)
+		if err != nil {
+			return problem.Internal(err)
+		}
+		if len(rs) == 0 {
+			return nil
+		}
+
+		query := "SELECT " + columns + " FROM expenses WHERE ledger_id IS NOT DISTINCT FROM $1 AND " + live + " ORDER BY id"
+		if !req.DryRun {
+			query += " FOR UPDATE"
+		}
+		var befores, afters []Expense
+		var changed []RuleChange
+		err = func() error {
+			rows, err := tx.QueryContext(ctx, query, req.LedgerID)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var e Expense
+				if err := rows.Scan(e.fields()...); err != nil {
+					return err
+				}
+				result.Checked++
+				after := e
+				after.Tags = make([]string, len(e.Tags))
+				copy(after.Tags, e.Tags)
+				ids := rs.Apply(&after)
+				if ids == nil {
+					continue
+				}
+				if !editable(e.Status) {
+					result.Skipped = append(result.Skipped, RuleSkip{ExpenseID: e.ID, Code: problem.CodeNotEditable})
+					continue
+				}
+				changes, err := diff(&e, &after)
+				if err != nil {
+					return err
+				}
+				changed = append(changed, RuleChange{ExpenseID: e.ID, Rules: ids, Changes: changes})
+				befores, afters = append(befores, e), append(afters, after)
+			}
+			return rows.Err()
+		}()
+		if err != nil {
+			return problem.Internal(fmt.Errorf("can't apply rules: %w", err))
+		}
+		if len(afters) == 0 {
+			return nil
+		}
+
+		// Expenses of closed periods are skipped unless overridden.
+		days := make([]date.Date, len(afters))
+		for i := range afters {
+			days[i] = afters[i].SpentOn
+		}
+		closed, err := closedPeriods(ctx, tx, req.LedgerID, days...)
+		if err != nil {
+			return err
+		}
+		months := map[string][]Period{}
+		for _, p := range closed {
+			months[p.Month] = []Period{p}
+		}
+		for i := range afters {
+			e := &afters[i]
+			over := months[e.SpentOn.Time().Format(monthLayout)]
+			if over != nil && reason == "" {
+				result.Skipped = append(result.Skipped, RuleSkip{ExpenseID: e.ID, Code: problem.CodePeriodClosed})
+				continue
+			}
+			result.Changed = append(result.Changed, changed[i])
+			if req.DryRun {
+				continue
+			}
+			_, err := tx.ExecContext(ctx, "UPDATE expenses SET tags = $2, category_id = $3, note = $4 WHERE id = $1", e.ID, pq.Array(e.Tags), e.CategoryID, e.Note)
+			if err != nil {
+				return problem.Internal(fmt.Errorf("can't update expense %d: %w", e.ID, err))
+			}
+			if err := auditOverrides(c, tx, over, e.ID, ActionUpdated, reason); err != nil {
+				return err
+			}
+			if err := record(c, tx, e.ID, ActionUpdated, &befores[i], e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, result)
+}
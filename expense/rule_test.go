@@ -0,0 +1,230 @@
+package expense
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/date"
+	"github.com/panudetjt/assessment/problem"
+	"github.com/panudetjt/assessment/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuleApply(t *testing.T) {
+	min, merchant, coffee := 50.0, 7, 3
+	rules := Rules{
+		{ID: 1, Conditions: RuleConditions{Title: "^(latte|mocha)"}, Actions: RuleActions{AddTags: []string{"coffee"}, CategoryID: &coffee}},
+		{ID: 2, Conditions: RuleConditions{MinAmount: &min, MerchantID: &merchant}, Actions: RuleActions{Note: "work trip"}},
+	}
+	for i := range rules {
+		assert.NoError(t, rules[i].compile())
+	}
+
+	t.Run("should run every matching rule", func(t *testing.T) {
+		e := Expense{Title: "Latte large", Amount: 90, Tags: []string{"food"}, MerchantID: &merchant}
+
+		ids := rules.Apply(&e)
+
+		assert.Equal(t, []int{1, 2}, ids)
+		assert.Equal(t, []string{"food", "coffee"}, e.Tags)
+		assert.Equal(t, &coffee, e.CategoryID)
+		assert.Equal(t, "work trip", e.Note)
+	})
+
+	t.Run("should need all the conditions to match", func(t *testing.T) {
+		e := Expense{Title: "taxi", Amount: 90}
+
+		assert.Nil(t, rules.Apply(&e))
+		assert.Equal(t, Expense{Title: "taxi", Amount: 90}, e)
+	})
+
+	t.Run("should keep the category, note and tags already set", func(t *testing.T) {
+		other := 4
+		e := Expense{Title: "mocha", Amount: 90, Note: "with Ann", Tags: []string{"coffee"}, CategoryID: &other, MerchantID: &merchant}
+
+		assert.Nil(t, rules.Apply(&e))
+		assert.Equal(t, &other, e.CategoryID)
+		assert.Equal(t, "with Ann", e.Note)
+	})
+}
+
+func TestRules(t *testing.T) {
+	now := time.Date(2023, 1, 2, 9, 0, 0, 0, time.UTC)
+	ruleRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows(strings.Split(ruleColumns, ", "))
+	}
+
+	t.Run("should return 422 (UnprocessableEntity) for an invalid rule", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/rules", strings.NewReader(`{"name": "coffee", "conditions": {"title": "(latte", "min_amount": 10, "max_amount": 5}}`))
+		h := Handler{}
+
+		res.Serve(h.CreateRuleHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, res.Recorder.Code)
+		fields := []string{}
+		for _, e := range p.Errors {
+			fields = append(fields, e.Field)
+		}
+		assert.Equal(t, []string{"conditions.title", "conditions.max_amount", "actions"}, fields)
+	})
+
+	t.Run("should create a rule after checking its category", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/rules", strings.NewReader(`{"name": "Coffee", "conditions": {"title": "latte"}, "actions": {"add_tags": ["coffee"], "category_id": 3}}`))
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM categories WHERE id = \\$1").WithArgs(3, nil).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery("INSERT INTO rules (.+) RETURNING id, created_at").
+			WithArgs(nil, "Coffee", 0, "latte", "", nil, nil, nil, pq.Array([]string{"coffee"}), 3, "").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, now))
+		mock.ExpectCommit()
+		h := Handler{DB: db}
+
+		res.Serve(h.CreateRuleHandler)
+		var got Rule
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusCreated, res.Recorder.Code)
+		assert.Equal(t, 1, got.ID)
+		assert.Equal(t, "latte", got.Conditions.Title)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 422 (UnprocessableEntity) for a merchant of another ledger", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/rules", strings.NewReader(`{"name": "Foodland", "conditions": {"merchant_id": 7}, "actions": {"add_tags": ["food"]}}`))
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM merchants").WithArgs(7, nil).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		mock.ExpectRollback()
+		h := Handler{DB: db}
+
+		res.Serve(h.CreateRuleHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, res.Recorder.Code)
+		assert.Equal(t, []problem.FieldError{{Field: "conditions.merchant_id", Message: "does not exist in the rule's ledger"}}, p.Errors)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should tag a new expense matching a rule", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/expenses", strings.NewReader(`{"title": "latte", "amount": 90}`))
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		expectPeriods(mock)
+		mock.ExpectQuery("FROM rules WHERE ledger_id IS NOT DISTINCT FROM \\$1").WithArgs(nil).
+			WillReturnRows(ruleRows().AddRow(1, nil, "Coffee", 0, "latte", "", nil, nil, nil, pq.Array([]string{"coffee"}), nil, "", now))
+		mock.ExpectQuery("INSERT INTO expenses").
+			WithArgs("latte", 90.0, "", pq.Array([]string{"coffee"}), nil, nil, "THB", date.Today(), nil, nil, nil).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		expectEvent(mock, ActionCreated)
+		h := Handler{DB: db}
+
+		res.Serve(h.CreateExpensesHandler)
+		var got Expense
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusCreated, res.Recorder.Code)
+		assert.Equal(t, []string{"coffee"}, got.Tags)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should preview the changes on a dry run", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/rules/apply", strings.NewReader(`{"dry_run": true}`))
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM rules").
+			WillReturnRows(ruleRows().AddRow(1, nil, "Coffee", 0, "latte", "", nil, nil, nil, pq.Array([]string{"coffee"}), nil, "", now))
+		mock.ExpectQuery("SELECT " + columns + " FROM expenses WHERE ledger_id IS NOT DISTINCT FROM \\$1 AND deleted_at IS NULL ORDER BY id$").WithArgs(nil).
+			WillReturnRows(sqlmock.NewRows(expenseColumns).
+				AddRow(1, "latte", 90, "", pq.Array([]string{}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft").
+				AddRow(2, "taxi", 120, "", pq.Array([]string{}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft"))
+		expectPeriods(mock)
+		mock.ExpectCommit()
+		h := Handler{DB: db}
+
+		res.Serve(h.ApplyRulesHandler)
+		var got ApplyResult
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.True(t, got.DryRun)
+		assert.Equal(t, 2, got.Checked)
+		assert.Len(t, got.Changed, 1)
+		assert.Equal(t, 1, got.Changed[0].ExpenseID)
+		assert.Equal(t, []int{1}, got.Changed[0].Rules)
+		assert.JSONEq(t, `["coffee"]`, string(got.Changed[0].Changes["tags"].After))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should update and record the expenses the rules change", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/rules/apply", strings.NewReader(`{}`))
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM rules").
+			WillReturnRows(ruleRows().AddRow(1, nil, "Coffee", 0, "latte", "", nil, nil, nil, pq.Array([]string(nil)), 3, "", now))
+		mock.ExpectQuery("FROM expenses WHERE ledger_id IS NOT DISTINCT FROM \\$1 AND deleted_at IS NULL ORDER BY id FOR UPDATE").WithArgs(nil).
+			WillReturnRows(sqlmock.NewRows(expenseColumns).AddRow(1, "latte", 90, "", pq.Array([]string{}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft"))
+		expectPeriods(mock)
+		mock.ExpectExec("UPDATE expenses SET tags = \\$2, category_id = \\$3, note = \\$4 WHERE id = \\$1").
+			WithArgs(1, pq.Array([]string{}), 3, "").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectEvent(mock, ActionUpdated)
+		h := Handler{DB: db}
+
+		res.Serve(h.ApplyRulesHandler)
+		var got ApplyResult
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.False(t, got.DryRun)
+		assert.Len(t, got.Changed, 1)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should skip the expenses no longer editable", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/rules/apply", strings.NewReader(`{}`))
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM rules").
+			WillReturnRows(ruleRows().AddRow(1, nil, "Coffee", 0, "latte", "", nil, nil, nil, pq.Array([]string(nil)), 3, "", now))
+		mock.ExpectQuery("FROM expenses WHERE ledger_id IS NOT DISTINCT FROM \\$1").
+			WillReturnRows(sqlmock.NewRows(expenseColumns).AddRow(1, "latte", 90, "", pq.Array([]string{}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "approved"))
+		mock.ExpectCommit()
+		h := Handler{DB: db}
+
+		res.Serve(h.ApplyRulesHandler)
+		var got ApplyResult
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Empty(t, got.Changed)
+		assert.Equal(t, []RuleSkip{{ExpenseID: 1, Code: problem.CodeNotEditable}}, got.Skipped)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 404 (NotFound) deleting a rule of another ledger", func(t *testing.T) {
+		res := asMember(util.RequestE(http.MethodDelete, "/rules/1", nil), "apikey:2")
+		res.Context.SetParamNames("id")
+		res.Context.SetParamValues("1")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectPrepare("SELECT m.role FROM rules x JOIN ledger_members m").ExpectQuery().WithArgs(1, "apikey:2").
+			WillReturnRows(sqlmock.NewRows([]string{"role"}))
+		h := Handler{DB: db}
+
+		res.Serve(h.DeleteRuleHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusNotFound, res.Recorder.Code)
+		assert.Equal(t, problem.CodeRuleNotFound, p.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
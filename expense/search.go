@@ -0,0 +1,141 @@
+package expense
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+	"github.com/panudetjt/assessment/problem"
+)
+
+// SearchResult is an expense matching a search with how well it matches.
+type SearchResult struct {
+	Expense
+	Rank      float64   `json:"rank"`
+	Highlight Highlight `json:"highlight"`
+}
+
+// Highlight holds the title and an excerpt of the note as HTML: escaped, with
+// the matched words wrapped in <mark> and </mark>.
+type Highlight struct {
+	Title string `json:"title"`
+	Note  string `json:"note"`
+}
+
+const maxSearchLength = 200
+
+// markStart and markStop delimit the matches in a headline. They are
+// private-use characters, stripped from the text beforehand, so the text can
+// be escaped before they become <mark> tags.
+const (
+	markStart = "\uE000"
+	markStop  = "\uE001"
+)
+
+// headline options of the title and the note excerpt.
+const (
+	titleHeadline = "StartSel=" + markStart + ", StopSel=" + markStop + ", HighlightAll=true"
+	noteHeadline  = "StartSel=" + markStart + ", StopSel=" + markStop + ", MaxFragments=2, MaxWords=20, MinWords=5"
+)
+
+var marks = strings.NewReplacer(markStart, "<mark>", markStop, "</mark>")
+
+// highlight turns a headline into HTML.
+func highlight(headline string) string {
+	return marks.Replace(html.EscapeString(headline))
+}
+
+// SearchHandler ranks the expenses matching q among those matching the list
+// filters. Every word of q matches as a prefix of a title or note word; the
+// whole of q also matches as a substring or, through trigrams, with typos.
+func (h *Handler) SearchHandler(c echo.Context) error {
+	q := c.QueryParam("q")
+	if q == "" {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, "q is required")
+	}
+	if utf8.RuneCountInString(q) > maxSearchLength {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, fmt.Sprintf("q must be at most %d characters", maxSearchLength))
+	}
+	terms := searchTerms(q)
+	if len(terms) == 0 {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, "q must contain a letter or digit")
+	}
+	f, err := parseFilter(c)
+	if err != nil {
+		return err
+	}
+	limit, err := queryInt(c, "limit", 1, maxPageSize)
+	if err != nil {
+		return err
+	}
+	offset, err := queryInt(c, "offset", 0, math.MaxInt32)
+	if err != nil {
+		return err
+	}
+
+	text := strings.Join(terms, " ")
+	tsq := f.arg(prefixQuery(terms))
+	fuzzy := f.arg(text)
+	like := f.arg("%" + escapeLike(text) + "%")
+	f.conds = append(f.conds, fmt.Sprintf("(search @@ query OR %[1]s <%% title OR %[1]s <%% note OR title ILIKE %[2]s OR note ILIKE %[2]s)", fuzzy, like))
+
+	query := fmt.Sprintf("SELECT %s, ts_rank(search, query) + greatest(word_similarity(%[2]s, title), word_similarity(%[2]s, coalesce(note, ''))) AS rank, "+
+		"ts_headline('simple', translate(title, '%[6]s', ''), query, '%[3]s'), ts_headline('simple', translate(coalesce(note, ''), '%[6]s', ''), query, '%[4]s') "+
+		"FROM expenses, to_tsquery('simple', %[5]s) AS query",
+		columns, fuzzy, titleHeadline, noteHeadline, tsq, markStart+markStop) +
+		f.where() + fmt.Sprintf(" ORDER BY rank DESC, id LIMIT %s OFFSET %s", f.arg(limit), f.arg(offset.Int64))
+	stmt, err := h.prepare(query)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't prepare search statment: %w", err))
+	}
+
+	ctx := c.Request().Context()
+	results := []SearchResult{}
+	err = retryRead(ctx, func() error {
+		results = results[:0]
+		rows, err := stmt.QueryContext(ctx, f.args...)
+		if err != nil {
+			return fmt.Errorf("can't search expenses: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var r SearchResult
+			if err := rows.Scan(append(r.fields(), &r.Rank, &r.Highlight.Title, &r.Highlight.Note)...); err != nil {
+				return fmt.Errorf("can't scan search result: %w", err)
+			}
+			r.Highlight.Title, r.Highlight.Note = highlight(r.Highlight.Title), highlight(r.Highlight.Note)
+			results = append(results, r)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return problem.Internal(err)
+	}
+
+	return c.JSON(http.StatusOK, results)
+}
+
+// searchTerms splits q into its words, dropping punctuation. Thai vowel and
+// tone marks are kept as part of their word.
+func searchTerms(q string) []string {
+	return strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.In(r, unicode.Letter, unicode.Number, unicode.Mark)
+	})
+}
+
+// prefixQuery returns the tsquery matching documents with a word starting with
+// each of terms. Terms only hold letters, digits and marks, so they need no
+// quoting.
+func prefixQuery(terms []string) string {
+	q := make([]string, len(terms))
+	for i, t := range terms {
+		q[i] = t + ":*"
+	}
+	return strings.Join(q, " & ")
+}
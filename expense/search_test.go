@@ -0,0 +1,118 @@
+package expense
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/problem"
+	"github.com/panudetjt/assessment/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchHandler(t *testing.T) {
+	searchRow := append(append([]string{}, expenseColumns...), "rank", "title_headline", "note_headline")
+
+	t.Run("should return 200 (OK) with ranked and highlighted matches", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses/search?q=Smoo+night!&tag=food", nil)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectPrepare("SELECT id, title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id, status, ts_rank\\(search, query\\) (.+) FROM expenses, to_tsquery\\('simple', \\$2\\) AS query "+
+			"WHERE deleted_at IS NULL AND tags @> \\$1 AND \\(search @@ query OR \\$3 <% title OR \\$3 <% note OR title ILIKE \\$4 OR note ILIKE \\$4\\) ORDER BY rank DESC, id LIMIT \\$5 OFFSET \\$6").
+			ExpectQuery().
+			WithArgs(pq.Array([]string{"food"}), "smoo:* & night:*", "smoo night", "%smoo night%", nil, int64(0)).
+			WillReturnRows(sqlmock.NewRows(searchRow).
+				AddRow(1, "strawberry smoothie", 79, "night market", pq.Array([]string{"food"}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft", 0.5,
+					"strawberry \uE000smoothie\uE001", "\uE000night\uE001 market"))
+		h := Handler{DB: db}
+
+		res.Serve(h.SearchHandler)
+		var got []SearchResult
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+		assert.Equal(t, []SearchResult{{
+			Expense:   Expense{ID: 1, Title: "strawberry smoothie", Amount: 79, Note: "night market", Tags: []string{"food"}, Currency: "THB", SpentOn: day, Status: StatusDraft},
+			Rank:      0.5,
+			Highlight: Highlight{Title: "strawberry <mark>smoothie</mark>", Note: "<mark>night</mark> market"},
+		}}, got)
+	})
+
+	t.Run("should escape the text around highlighted matches", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses/search?q=cake", nil)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectPrepare("translate\\(title, '\uE000\uE001', ''\\)").ExpectQuery().
+			WillReturnRows(sqlmock.NewRows(searchRow).
+				AddRow(1, "<img src=x onerror=alert(1)> cake", 79, "", pq.Array([]string{}), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft", 0.5,
+					"<img src=x onerror=alert(1)> \uE000cake\uE001", ""))
+		h := Handler{DB: db}
+
+		res.Serve(h.SearchHandler)
+		var got []SearchResult
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Equal(t, "&lt;img src=x onerror=alert(1)&gt; <mark>cake</mark>", got[0].Highlight.Title)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return an empty list when nothing matches", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses/search?q=rent", nil)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectPrepare("FROM expenses, to_tsquery").ExpectQuery().WillReturnRows(sqlmock.NewRows(searchRow))
+		h := Handler{DB: db}
+
+		res.Serve(h.SearchHandler)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.JSONEq(t, "[]", res.Recorder.Body.String())
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	for name, url := range map[string]string{
+		"q is missing":         "/expenses/search",
+		"q has no word":        "/expenses/search?q=%26%21%3A%2A",
+		"a filter is invalid":  "/expenses/search?q=rent&min_amount=lots",
+		"the limit is invalid": "/expenses/search?q=rent&limit=0",
+	} {
+		t.Run("should return 400 (BadRequest) when "+name, func(t *testing.T) {
+			res := util.RequestE(http.MethodGet, url, nil)
+			db, mock, _ := sqlmock.New()
+			h := Handler{DB: db}
+
+			res.Serve(h.SearchHandler)
+			var p problem.Problem
+			res.Decode(&p)
+
+			assert.Equal(t, http.StatusBadRequest, res.Recorder.Code)
+			assert.Equal(t, problem.CodeInvalidQuery, p.Code)
+			assert.Nil(t, mock.ExpectationsWereMet())
+		})
+	}
+
+	t.Run("should return 500 (InternalServerError) when cannot query", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses/search?q=rent", nil)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectPrepare("FROM expenses, to_tsquery").ExpectQuery().WillReturnError(&pq.Error{})
+		h := Handler{DB: db}
+
+		res.Serve(h.SearchHandler)
+
+		assert.Equal(t, http.StatusInternalServerError, res.Recorder.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestSearchTerms(t *testing.T) {
+	t.Run("should drop tsquery operators", func(t *testing.T) {
+		terms := searchTerms("Apple's | !juice & (2023):*")
+
+		assert.Equal(t, []string{"apple", "s", "juice", "2023"}, terms)
+		assert.Equal(t, "apple:* & s:* & juice:* & 2023:*", prefixQuery(terms))
+	})
+
+	t.Run("should keep Thai words whole", func(t *testing.T) {
+		assert.Equal(t, []string{"น้ำปั่น", "สตรอว์เบอร์รี"}, searchTerms("น้ำปั่น สตรอว์เบอร์รี"))
+	})
+}
@@ -0,0 +1,143 @@
+package expense
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/panudetjt/assessment/exchange"
+	"github.com/panudetjt/assessment/ledger"
+	"github.com/panudetjt/assessment/problem"
+)
+
+// Settlement records that From paid To back Amount within a ledger. Currency
+// is DefaultCurrency when not given.
+type Settlement struct {
+	ID        int       `json:"id"`
+	LedgerID  int       `json:"ledger_id"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Amount    float64   `json:"amount"`
+	Currency  string    `json:"currency"`
+	Note      string    `json:"note"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (s Settlement) validate() []problem.FieldError {
+	var errs []problem.FieldError
+	if strings.TrimSpace(s.From) == "" {
+		errs = append(errs, problem.FieldError{Field: "from", Message: "must not be empty"})
+	}
+	if strings.TrimSpace(s.To) == "" {
+		errs = append(errs, problem.FieldError{Field: "to", Message: "must not be empty"})
+	} else if s.To == s.From {
+		errs = append(errs, problem.FieldError{Field: "to", Message: "must differ from from"})
+	}
+	if cents(s.Amount) <= 0 {
+		errs = append(errs, problem.FieldError{Field: "amount", Message: "must be at least 0.01"})
+	}
+	if s.Currency != "" && !exchange.ValidCurrency(s.Currency) {
+		errs = append(errs, problem.FieldError{Field: "currency", Message: "must be a 3-letter currency code"})
+	}
+	return errs
+}
+
+func (h *Handler) CreateSettlementHandler(c echo.Context) error {
+	id, err := ledger.ID(c)
+	if err != nil {
+		return err
+	}
+	var s Settlement
+	if err := c.Bind(&s); err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "request body must be a settlement JSON object")
+	}
+	s.Currency = strings.ToUpper(strings.TrimSpace(s.Currency))
+	if errs := s.validate(); errs != nil {
+		return problem.Validation(errs...)
+	}
+	if _, err := ledger.Authorize(c, h.DB, id, ledger.Editor); err != nil {
+		return err
+	}
+
+	s.LedgerID = id
+	s.Amount = float64(cents(s.Amount)) / 100
+	if s.Currency == "" {
+		s.Currency = DefaultCurrency
+	}
+	err = h.DB.QueryRowContext(c.Request().Context(),
+		"INSERT INTO settlements (ledger_id, from_participant, to_participant, amount, currency, note) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at",
+		id, s.From, s.To, s.Amount, s.Currency, s.Note,
+	).Scan(&s.ID, &s.CreatedAt)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't insert settlement: %w", err))
+	}
+	return c.JSON(http.StatusCreated, s)
+}
+
+func (h *Handler) ListSettlementsHandler(c echo.Context) error {
+	id, err := ledger.ID(c)
+	if err != nil {
+		return err
+	}
+	if _, err := ledger.Authorize(c, h.DB, id, ledger.Viewer); err != nil {
+		return err
+	}
+
+	stmt, err := h.prepare("SELECT id, ledger_id, from_participant, to_participant, amount, currency, note, created_at FROM settlements WHERE ledger_id = $1 ORDER BY id")
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't prepare settlements statment: %w", err))
+	}
+
+	ctx := c.Request().Context()
+	var settlements []Settlement
+	err = retryRead(ctx, func() error {
+		settlements = []Settlement{}
+		rows, err := stmt.QueryContext(ctx, id)
+		if err != nil {
+			return fmt.Errorf("can't query settlements: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var s Settlement
+			if err := rows.Scan(&s.ID, &s.LedgerID, &s.From, &s.To, &s.Amount, &s.Currency, &s.Note, &s.CreatedAt); err != nil {
+				return fmt.Errorf("can't scan settlements: %w", err)
+			}
+			settlements = append(settlements, s)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return problem.Internal(err)
+	}
+	return c.JSON(http.StatusOK, settlements)
+}
+
+func (h *Handler) DeleteSettlementHandler(c echo.Context) error {
+	id, err := ledger.ID(c)
+	if err != nil {
+		return err
+	}
+	sid, err := strconv.Atoi(c.Param("settlement_id"))
+	if err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidID, "settlement id must be an integer")
+	}
+	if _, err := ledger.Authorize(c, h.DB, id, ledger.Editor); err != nil {
+		return err
+	}
+
+	result, err := h.DB.ExecContext(c.Request().Context(), "DELETE FROM settlements WHERE id = $1 AND ledger_id = $2", sid, id)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't delete settlement: %w", err))
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't read deleted rows: %w", err))
+	}
+	if n == 0 {
+		return problem.New(http.StatusNotFound, problem.CodeSettlementNotFound, fmt.Sprintf("settlement %d does not exist in ledger %d", sid, id))
+	}
+	return c.NoContent(http.StatusNoContent)
+}
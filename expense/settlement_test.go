@@ -0,0 +1,118 @@
+package expense
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/panudetjt/assessment/problem"
+	"github.com/panudetjt/assessment/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func ledgerRequest(method, url string, body string, params ...string) *util.Response {
+	res := util.RequestE(method, url, strings.NewReader(body))
+	res.Context.SetParamNames("id", "settlement_id")
+	res.Context.SetParamValues(params...)
+	return res
+}
+
+func TestCreateSettlementHandler(t *testing.T) {
+	t.Run("should return 201 (Created) for editors", func(t *testing.T) {
+		res := asMember(ledgerRequest(http.MethodPost, "/ledgers/3/settlements", `{"from": "bob", "to": "alice", "amount": 20.004}`, "3"), "apikey:2")
+		db, mock, _ := sqlmock.New()
+		now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		mock.ExpectQuery("SELECT role FROM ledger_members").WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("editor"))
+		mock.ExpectQuery("INSERT INTO settlements").WithArgs(3, "bob", "alice", 20.0, "THB", "").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, now))
+		h := Handler{DB: db}
+
+		res.Serve(h.CreateSettlementHandler)
+		var got Settlement
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusCreated, res.Recorder.Code)
+		assert.Equal(t, Settlement{ID: 1, LedgerID: 3, From: "bob", To: "alice", Amount: 20, Currency: "THB", CreatedAt: now}, got)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should keep the currency given", func(t *testing.T) {
+		res := ledgerRequest(http.MethodPost, "/ledgers/3/settlements", `{"from": "bob", "to": "alice", "amount": 500, "currency": "jpy"}`, "3")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery("SELECT EXISTS").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery("INSERT INTO settlements").WithArgs(3, "bob", "alice", 500.0, "JPY", "").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+		h := Handler{DB: db}
+
+		res.Serve(h.CreateSettlementHandler)
+
+		assert.Equal(t, http.StatusCreated, res.Recorder.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 422 (UnprocessableEntity) when paying oneself", func(t *testing.T) {
+		res := ledgerRequest(http.MethodPost, "/ledgers/3/settlements", `{"from": "bob", "to": "bob", "amount": 0}`, "3")
+		db, mock, _ := sqlmock.New()
+		h := Handler{DB: db}
+
+		res.Serve(h.CreateSettlementHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, res.Recorder.Code)
+		assert.Len(t, p.Errors, 2)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestListSettlementsHandler(t *testing.T) {
+	res := ledgerRequest(http.MethodGet, "/ledgers/3/settlements", "", "3")
+	db, mock, _ := sqlmock.New()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT EXISTS").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectPrepare("SELECT id, ledger_id, from_participant, to_participant, amount, currency, note, created_at FROM settlements WHERE ledger_id = \\$1").
+		ExpectQuery().WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ledger_id", "from", "to", "amount", "currency", "note", "created_at"}).AddRow(1, 3, "bob", "alice", 20, "THB", "cash", now))
+	h := Handler{DB: db}
+
+	res.Serve(h.ListSettlementsHandler)
+	var got []Settlement
+	res.Decode(&got)
+
+	assert.Equal(t, http.StatusOK, res.Recorder.Code)
+	assert.Equal(t, []Settlement{{1, 3, "bob", "alice", 20, "THB", "cash", now}}, got)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteSettlementHandler(t *testing.T) {
+	t.Run("should return 404 (NotFound) when missing", func(t *testing.T) {
+		res := ledgerRequest(http.MethodDelete, "/ledgers/3/settlements/9", "", "3", "9")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery("SELECT EXISTS").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectExec("DELETE FROM settlements WHERE id = \\$1 AND ledger_id = \\$2").WithArgs(9, 3).WillReturnResult(sqlmock.NewResult(0, 0))
+		h := Handler{DB: db}
+
+		res.Serve(h.DeleteSettlementHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusNotFound, res.Recorder.Code)
+		assert.Equal(t, problem.CodeSettlementNotFound, p.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 204 (NoContent) when deleted", func(t *testing.T) {
+		res := ledgerRequest(http.MethodDelete, "/ledgers/3/settlements/9", "", "3", "9")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery("SELECT EXISTS").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectExec("DELETE FROM settlements").WillReturnResult(sqlmock.NewResult(0, 1))
+		h := Handler{DB: db}
+
+		res.Serve(h.DeleteSettlementHandler)
+
+		assert.Equal(t, http.StatusNoContent, res.Recorder.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,145 @@
+package expense
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/panudetjt/assessment/problem"
+)
+
+// Split methods. Share.Value means nothing for SplitEqual, an amount for
+// SplitExact, a percentage for SplitPercent and a weight for SplitShares.
+const (
+	SplitEqual   = "equal"
+	SplitExact   = "exact"
+	SplitPercent = "percent"
+	SplitShares  = "shares"
+)
+
+// Split records who paid an expense and how it is shared between
+// participants. It is stored as JSON in expenses.split.
+type Split struct {
+	Method string  `json:"method"`
+	PaidBy string  `json:"paid_by"`
+	Shares []Share `json:"shares"`
+}
+
+type Share struct {
+	Participant string  `json:"participant"`
+	Value       float64 `json:"value,omitempty"`
+	// Amount is what the participant owes, computed by the server.
+	Amount float64 `json:"amount"`
+}
+
+func (s *Split) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, s)
+	case string:
+		return json.Unmarshal([]byte(v), s)
+	}
+	return fmt.Errorf("can't scan %T into split", src)
+}
+
+func (s *Split) Value() (driver.Value, error) {
+	if s == nil {
+		return nil, nil
+	}
+	return json.Marshal(s)
+}
+
+// validate reports every invalid field of s as part of an expense.
+func (s *Split) validate() []problem.FieldError {
+	var errs []problem.FieldError
+	add := func(field, msg string) {
+		errs = append(errs, problem.FieldError{Field: "split." + field, Message: msg})
+	}
+
+	switch s.Method {
+	case SplitEqual, SplitExact, SplitPercent, SplitShares:
+	default:
+		add("method", "must be one of equal, exact, percent, shares")
+	}
+	if strings.TrimSpace(s.PaidBy) == "" {
+		add("paid_by", "must not be empty")
+	}
+	if len(s.Shares) == 0 {
+		add("shares", "must not be empty")
+	}
+	seen := map[string]bool{}
+	for _, sh := range s.Shares {
+		if strings.TrimSpace(sh.Participant) == "" || seen[sh.Participant] {
+			add("shares", "participants must be unique and not empty")
+			break
+		}
+		seen[sh.Participant] = true
+		if s.Method != SplitEqual && sh.Value < 0 {
+			add("shares", "values must not be negative")
+			break
+		}
+	}
+	return errs
+}
+
+// cents converts an amount to integer cents, the unit splits are computed in
+// so shares always add up to the total exactly.
+func cents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// allocate sets every share's Amount so they add up to total. Cents that
+// can't be divided evenly go to the first participants.
+func (s *Split) allocate(total float64) error {
+	t := cents(total)
+	amounts := make([]int64, len(s.Shares))
+
+	if s.Method == SplitExact {
+		var got int64
+		for i, sh := range s.Shares {
+			amounts[i] = cents(sh.Value)
+			got += amounts[i]
+		}
+		if got != t {
+			return fmt.Errorf("exact amounts add up to %.2f, not %.2f", float64(got)/100, float64(t)/100)
+		}
+	} else {
+		weights := make([]float64, len(s.Shares))
+		sum := 0.0
+		for i, sh := range s.Shares {
+			weights[i] = sh.Value
+			if s.Method == SplitEqual {
+				weights[i] = 1
+			}
+			sum += weights[i]
+		}
+		if s.Method == SplitPercent && math.Abs(sum-100) > 1e-9 {
+			return fmt.Errorf("percentages add up to %g, not 100", sum)
+		}
+		if sum <= 0 {
+			return errors.New("shares must not all be zero")
+		}
+
+		var allocated int64
+		for i, w := range weights {
+			amounts[i] = int64(math.Floor(float64(t) * w / sum))
+			allocated += amounts[i]
+		}
+		for i := 0; allocated < t; i = (i + 1) % len(amounts) {
+			if weights[i] > 0 {
+				amounts[i]++
+				allocated++
+			}
+		}
+	}
+
+	for i := range s.Shares {
+		s.Shares[i].Amount = float64(amounts[i]) / 100
+	}
+	return nil
+}
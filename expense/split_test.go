@@ -0,0 +1,82 @@
+package expense
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func shares(names ...string) []Share {
+	var s []Share
+	for _, n := range names {
+		s = append(s, Share{Participant: n})
+	}
+	return s
+}
+
+func amounts(s *Split) []float64 {
+	var a []float64
+	for _, sh := range s.Shares {
+		a = append(a, sh.Amount)
+	}
+	return a
+}
+
+func TestSplitAllocate(t *testing.T) {
+	t.Run("should give leftover cents of equal splits to the first participants", func(t *testing.T) {
+		s := &Split{Method: SplitEqual, PaidBy: "a", Shares: shares("a", "b", "c")}
+
+		assert.NoError(t, s.allocate(100))
+		assert.Equal(t, []float64{33.34, 33.33, 33.33}, amounts(s))
+	})
+
+	t.Run("should use exact amounts when they add up", func(t *testing.T) {
+		s := &Split{Method: SplitExact, PaidBy: "a", Shares: []Share{{Participant: "a", Value: 60.5}, {Participant: "b", Value: 39.5}}}
+
+		assert.NoError(t, s.allocate(100))
+		assert.Equal(t, []float64{60.5, 39.5}, amounts(s))
+
+		s.Shares[1].Value = 30
+		assert.ErrorContains(t, s.allocate(100), "add up to 90.50")
+	})
+
+	t.Run("should split by percent and require 100", func(t *testing.T) {
+		s := &Split{Method: SplitPercent, PaidBy: "a", Shares: []Share{{Participant: "a", Value: 25}, {Participant: "b", Value: 75}}}
+
+		assert.NoError(t, s.allocate(79))
+		assert.Equal(t, []float64{19.75, 59.25}, amounts(s))
+
+		s.Shares[1].Value = 70
+		assert.Error(t, s.allocate(79))
+	})
+
+	t.Run("should split by shares", func(t *testing.T) {
+		s := &Split{Method: SplitShares, PaidBy: "a", Shares: []Share{{Participant: "a", Value: 2}, {Participant: "b", Value: 1}, {Participant: "c", Value: 0}}}
+
+		assert.NoError(t, s.allocate(10))
+		assert.Equal(t, []float64{6.67, 3.33, 0}, amounts(s))
+	})
+}
+
+func TestSplitValidate(t *testing.T) {
+	s := &Split{Method: "thirds", Shares: shares("a", "a")}
+
+	var fields []string
+	for _, e := range s.validate() {
+		fields = append(fields, e.Field)
+	}
+
+	assert.Equal(t, []string{"split.method", "split.paid_by", "split.shares"}, fields)
+}
+
+func TestSplitScan(t *testing.T) {
+	var s Split
+
+	assert.NoError(t, s.Scan([]byte(`{"method": "equal", "paid_by": "a", "shares": [{"participant": "b", "amount": 5}]}`)))
+	assert.Equal(t, Split{Method: SplitEqual, PaidBy: "a", Shares: []Share{{Participant: "b", Amount: 5}}}, s)
+	assert.Error(t, s.Scan(1))
+
+	v, err := (*Split)(nil).Value()
+	assert.NoError(t, err)
+	assert.Nil(t, v)
+}
@@ -0,0 +1,42 @@
+package expense
+
+import (
+	"database/sql"
+)
+
+// prepare returns a cached prepared statement for query, preparing it on first
+// use. Statements live until Close is called so every request reuses the same
+// server-side statement instead of leaking a new one.
+func (h *Handler) prepare(query string) (*sql.Stmt, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if stmt, ok := h.stmts[query]; ok {
+		return stmt, nil
+	}
+
+	stmt, err := h.DB.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	if h.stmts == nil {
+		h.stmts = make(map[string]*sql.Stmt)
+	}
+	h.stmts[query] = stmt
+	return stmt, nil
+}
+
+// Close releases every cached prepared statement.
+func (h *Handler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var err error
+	for query, stmt := range h.stmts {
+		if cerr := stmt.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(h.stmts, query)
+	}
+	return err
+}
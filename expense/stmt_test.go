@@ -0,0 +1,38 @@
+package expense
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrepare(t *testing.T) {
+	t.Run("should prepare statement once and reuse it", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		mock.ExpectPrepare("SELECT id FROM expenses").WillBeClosed()
+		handler := Handler{DB: db}
+
+		first, err := handler.prepare("SELECT id FROM expenses")
+		assert.NoError(t, err)
+		second, err := handler.prepare("SELECT id FROM expenses")
+		assert.NoError(t, err)
+
+		assert.Same(t, first, second)
+		assert.NoError(t, handler.Close())
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should not cache statement when prepare fails", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		mock.ExpectPrepare("SELECT id FROM expenses").WillReturnError(sqlmock.ErrCancelled)
+		handler := Handler{DB: db}
+
+		stmt, err := handler.prepare("SELECT id FROM expenses")
+
+		assert.Error(t, err)
+		assert.Nil(t, stmt)
+		assert.Empty(t, handler.stmts)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,454 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/ledger"
+	m "github.com/panudetjt/assessment/middleware"
+	"github.com/panudetjt/assessment/problem"
+)
+
+// heartbeatInterval keeps idle streams alive through proxies and is when a
+// stream refreshes the caller's ledgers.
+var heartbeatInterval = 15 * time.Second
+
+// connKey keys the connection of a request in its context.
+type connKey struct{}
+
+// ConnContext is an http.Server ConnContext keeping the connection of every
+// request in its context, so that streams can lift the server's write
+// deadline, which would otherwise end them after the write timeout.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// maxReplay bounds the events read from the log at once; a stream further
+// behind replays page by page.
+const maxReplay = 1000
+
+// eventHoldback is how long the broker holds events back behind a missing id.
+// Event ids are taken when an event is written but only become visible when
+// its transaction commits, so a lower id may still show up after a higher one;
+// once the holdback is over, the missing event is taken to be rolled back.
+var eventHoldback = 5 * time.Second
+
+// subscriberBuffer is how many events a stream may fall behind before it is
+// closed, after which its client reconnects with Last-Event-ID.
+const subscriberBuffer = 64
+
+// streamEvent is an Event with its position in the global event log.
+type streamEvent struct {
+	id int64
+	Event
+}
+
+// Broker fans expense events out to the event streams of this instance. It
+// listens to the expense_events notification channel, so changes made through
+// any instance reach every stream. Events are published in id order: one
+// committed ahead of a lower id is held back until that id shows up or
+// eventHoldback passes, so a stream resuming after its last event id misses
+// nothing.
+type Broker struct {
+	DB *sql.DB
+
+	mu       sync.Mutex
+	subs     map[chan streamEvent]struct{}
+	lastID   int64
+	pending  map[int64]streamEvent
+	gapSince time.Time
+	done     chan struct{}
+	closed   sync.Once
+}
+
+func NewBroker(db *sql.DB) *Broker {
+	return &Broker{DB: db, subs: map[chan streamEvent]struct{}{}, pending: map[int64]streamEvent{}, done: make(chan struct{})}
+}
+
+// Listen publishes the event of every notification until ctx is done or
+// notifications is closed. A nil notification, sent by pq.Listener after a
+// reconnect, replays the events published while it was disconnected.
+func (b *Broker) Listen(ctx context.Context, notifications <-chan *pq.Notification, logger echo.Logger) {
+	var last int64
+	if err := b.DB.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM expense_events").Scan(&last); err != nil {
+		logger.Error("expense stream: can't read the last event id: ", err)
+	}
+	b.mu.Lock()
+	b.lastID = last
+	b.mu.Unlock()
+
+	tick := time.NewTicker(eventHoldback / 2)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-tick.C:
+			b.drain(now)
+		case n, ok := <-notifications:
+			if !ok {
+				return
+			}
+			if err := b.fetch(ctx, n); err != nil && ctx.Err() == nil {
+				logger.Error("expense stream: ", err)
+			}
+			b.drain(time.Now())
+		}
+	}
+}
+
+func (b *Broker) fetch(ctx context.Context, n *pq.Notification) error {
+	if n != nil {
+		id, err := strconv.ParseInt(n.Extra, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid expense event notification %q", n.Extra)
+		}
+		_, _, err = b.read(ctx, "SELECT "+eventColumns+" FROM expense_events WHERE id = $1", id)
+		return err
+	}
+
+	b.mu.Lock()
+	after := b.lastID
+	b.mu.Unlock()
+	for {
+		last, n, err := b.read(ctx, "SELECT "+eventColumns+" FROM expense_events WHERE id > $1 ORDER BY id LIMIT "+strconv.Itoa(maxReplay), after)
+		if err != nil || n < maxReplay {
+			return err
+		}
+		after = last
+	}
+}
+
+// read holds the events query returns, returning the last id and how many
+// there were.
+func (b *Broker) read(ctx context.Context, query string, arg int64) (int64, int, error) {
+	rows, err := b.DB.QueryContext(ctx, query, arg)
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't query expense events: %w", err)
+	}
+	defer rows.Close()
+	var last int64
+	var n int
+	for rows.Next() {
+		id, ev, err := scanEvent(rows)
+		if err != nil {
+			return 0, 0, err
+		}
+		b.hold(streamEvent{id: id, Event: ev})
+		last = id
+		n++
+	}
+	return last, n, rows.Err()
+}
+
+// hold queues ev until every lower id has been published or given up on. An
+// event given up on that commits after all is published straight away.
+func (b *Broker) hold(ev streamEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ev.id <= b.lastID {
+		b.send(ev)
+		return
+	}
+	b.pending[ev.id] = ev
+}
+
+// drain publishes the held events that follow the last published one, giving
+// up on a missing id once it has been missing for eventHoldback.
+func (b *Broker) drain(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(b.pending) > 0 {
+		ev, ok := b.pending[b.lastID+1]
+		if ok {
+			delete(b.pending, ev.id)
+			b.gapSince = time.Time{}
+			b.send(ev)
+			continue
+		}
+		if b.gapSince.IsZero() {
+			b.gapSince = now
+		}
+		if now.Sub(b.gapSince) < eventHoldback && len(b.pending) < maxReplay {
+			return
+		}
+		next := int64(0)
+		for id := range b.pending {
+			if next == 0 || id < next {
+				next = id
+			}
+		}
+		b.lastID = next - 1
+		b.gapSince = time.Time{}
+	}
+}
+
+// publish sends ev to every stream, closing those too far behind to take it.
+func (b *Broker) publish(ev streamEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.send(ev)
+}
+
+func (b *Broker) send(ev streamEvent) {
+	if ev.id > b.lastID {
+		b.lastID = ev.id
+	}
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe returns a channel of the events published from now on along with
+// the id of the last one published before, up to which a stream replays.
+func (b *Broker) subscribe() (chan streamEvent, int64) {
+	ch := make(chan streamEvent, subscriberBuffer)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[ch] = struct{}{}
+	return ch, b.lastID
+}
+
+func (b *Broker) unsubscribe(ch chan streamEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// Close ends every stream so a graceful shutdown isn't held up by them.
+func (b *Broker) Close() {
+	b.closed.Do(func() { close(b.done) })
+}
+
+// audience decides which events a stream's caller may see: all of them for
+// admins, otherwise those of its ledgers.
+type audience struct {
+	admin   bool
+	subject string
+	ledgers map[int]bool
+}
+
+func (a *audience) refresh(ctx context.Context, db *sql.DB) error {
+	if a.admin {
+		return nil
+	}
+	rows, err := db.QueryContext(ctx, "SELECT ledger_id FROM ledger_members WHERE subject = $1", a.subject)
+	if err != nil {
+		return fmt.Errorf("can't query stream ledgers: %w", err)
+	}
+	defer rows.Close()
+	ledgers := map[int]bool{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return fmt.Errorf("can't scan stream ledgers: %w", err)
+		}
+		ledgers[id] = true
+	}
+	a.ledgers = ledgers
+	return rows.Err()
+}
+
+// ids lists the caller's ledgers in order.
+func (a *audience) ids() []int {
+	ids := make([]int, 0, len(a.ledgers))
+	for id := range a.ledgers {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func (a *audience) sees(ev Event) bool {
+	if a.admin {
+		return true
+	}
+	return ev.Expense.LedgerID != nil && a.ledgers[*ev.Expense.LedgerID]
+}
+
+// StreamHandler pushes the caller's expense changes as Server-Sent Events,
+// first replaying those after Last-Event-ID. A stream ends after
+// StreamTimeout; clients such as EventSource reconnect and resume.
+func (h *Handler) StreamHandler(c echo.Context) error {
+	if h.Stream == nil {
+		return problem.Internal(errors.New("expense stream broker is not configured"))
+	}
+	lastID, err := lastEventID(c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	aud := &audience{admin: ledger.IsAdmin(c), subject: m.Subject(c)}
+	if err := aud.refresh(ctx, h.DB); err != nil {
+		return problem.Internal(err)
+	}
+
+	// Subscribe before replaying and replay up to the last event published
+	// before, so that nothing is missed or sent twice in between.
+	events, upTo := h.Stream.subscribe()
+	defer h.Stream.unsubscribe(events)
+	var replay []streamEvent
+	if lastID > 0 && lastID < upTo {
+		if replay, err = h.replay(ctx, lastID, upTo, aud); err != nil {
+			return problem.Internal(err)
+		}
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	fmt.Fprintf(res, "retry: %d\n\n", time.Second.Milliseconds())
+	for len(replay) > 0 {
+		for _, ev := range replay {
+			if err := writeEvent(res, ev); err != nil {
+				return nil
+			}
+		}
+		if len(replay) < maxReplay {
+			break
+		}
+		res.Flush()
+		if replay, err = h.replay(ctx, replay[len(replay)-1].id, upTo, aud); err != nil {
+			if ctx.Err() == nil {
+				c.Logger().Error(err)
+			}
+			return nil
+		}
+	}
+
+	// The server's write timeout covers the whole response, so the stream
+	// lifts it and gives every write its own instead. Without the connection,
+	// on a server without ConnContext, it must end before the timeout.
+	wt := c.Echo().Server.WriteTimeout
+	conn, _ := ctx.Value(connKey{}).(net.Conn)
+	lifetime := h.StreamTimeout
+	if conn == nil && wt > 0 && (lifetime == 0 || lifetime > wt*9/10) {
+		lifetime = wt * 9 / 10
+	}
+	extend := func() {
+		if conn != nil && wt > 0 {
+			conn.SetWriteDeadline(time.Now().Add(wt))
+		}
+	}
+	res.Flush()
+
+	// Beat at least twice per stream, so that short streams refresh the
+	// caller's ledgers too.
+	every := heartbeatInterval
+	if lifetime > 0 && lifetime/2 < every {
+		every = lifetime / 2
+	}
+	heartbeat := time.NewTicker(every)
+	defer heartbeat.Stop()
+	var deadline <-chan time.Time
+	if lifetime > 0 {
+		t := time.NewTimer(lifetime)
+		defer t.Stop()
+		deadline = t.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-h.Stream.done:
+			return nil
+		case <-deadline:
+			return nil
+		case <-heartbeat.C:
+			extend()
+			if _, err := fmt.Fprint(res, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+			if err := aud.refresh(ctx, h.DB); err != nil && ctx.Err() == nil {
+				c.Logger().Error(err)
+			}
+		case ev, ok := <-events:
+			if !ok {
+				// Too far behind: the client reconnects and replays.
+				return nil
+			}
+			// A client that resumes from another instance may be ahead of
+			// this one's broker and already have the event.
+			if (ev.id > upTo && ev.id <= lastID) || !aud.sees(ev.Event) {
+				continue
+			}
+			extend()
+			if err := writeEvent(res, ev); err != nil {
+				return nil
+			}
+			res.Flush()
+		}
+	}
+}
+
+// replay reads a page of the events after after, up to upTo, that the caller
+// may see.
+func (h *Handler) replay(ctx context.Context, after, upTo int64, aud *audience) ([]streamEvent, error) {
+	query, args := "SELECT "+eventColumns+" FROM expense_events WHERE id > $1 AND id <= $2", []any{after, upTo}
+	if !aud.admin {
+		query += " AND (snapshot->>'ledger_id')::int = ANY($3)"
+		args = append(args, pq.Array(aud.ids()))
+	}
+	rows, err := h.DB.QueryContext(ctx, query+" ORDER BY id LIMIT "+strconv.Itoa(maxReplay), args...)
+	if err != nil {
+		return nil, fmt.Errorf("can't query missed expense events: %w", err)
+	}
+	defer rows.Close()
+	var events []streamEvent
+	for rows.Next() {
+		id, ev, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, streamEvent{id: id, Event: ev})
+	}
+	return events, rows.Err()
+}
+
+// lastEventID reads the Last-Event-ID header EventSource sends on reconnect,
+// or the last_event_id query parameter for a first connection that resumes.
+func lastEventID(c echo.Context) (int64, error) {
+	v := c.Request().Header.Get("Last-Event-ID")
+	if v == "" {
+		v = c.QueryParam("last_event_id")
+	}
+	if v == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || id < 0 {
+		return 0, problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, "Last-Event-ID must be an event id")
+	}
+	return id, nil
+}
+
+func writeEvent(res *echo.Response, ev streamEvent) error {
+	data, err := json.Marshal(ev.Event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", ev.id, webhookEvents[ev.Action], data)
+	return err
+}
@@ -0,0 +1,252 @@
+package expense
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	m "github.com/panudetjt/assessment/middleware"
+	"github.com/panudetjt/assessment/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func eventRows() *sqlmock.Rows {
+	return sqlmock.NewRows(eventRow)
+}
+
+func addEvent(rows *sqlmock.Rows, id int64, action string, ledgerID int) *sqlmock.Rows {
+	snapshot := fmt.Sprintf(`{"id": 1, "title": "rent", "amount": 100, "ledger_id": %d}`, ledgerID)
+	return rows.AddRow(id, 1, action, "apikey:2", "", `{}`, snapshot, time.Now())
+}
+
+func TestBroker(t *testing.T) {
+	t.Run("should publish the event of a notification", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		b := NewBroker(db)
+		events, _ := b.subscribe()
+		mock.ExpectQuery("SELECT COALESCE\\(MAX\\(id\\), 0\\) FROM expense_events").WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(6))
+		mock.ExpectQuery("FROM expense_events WHERE id = \\$1").WithArgs(int64(7)).WillReturnRows(addEvent(eventRows(), 7, ActionCreated, 3))
+		notifications := make(chan *pq.Notification, 1)
+		notifications <- &pq.Notification{Channel: "expense_events", Extra: "7"}
+		close(notifications)
+
+		b.Listen(context.Background(), notifications, nil)
+
+		ev := <-events
+		assert.Equal(t, int64(7), ev.id)
+		assert.Equal(t, ActionCreated, ev.Action)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should replay missed events after a reconnect", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		b := NewBroker(db)
+		events, _ := b.subscribe()
+		mock.ExpectQuery("SELECT COALESCE").WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(6))
+		mock.ExpectQuery("FROM expense_events WHERE id > \\$1 ORDER BY id").WithArgs(int64(6)).
+			WillReturnRows(addEvent(addEvent(eventRows(), 7, ActionCreated, 3), 8, ActionDeleted, 3))
+		notifications := make(chan *pq.Notification, 1)
+		notifications <- nil
+		close(notifications)
+
+		b.Listen(context.Background(), notifications, nil)
+
+		assert.Equal(t, int64(7), (<-events).id)
+		assert.Equal(t, int64(8), (<-events).id)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should hold an event back until the lower ids show up", func(t *testing.T) {
+		b := NewBroker(nil)
+		b.lastID = 6
+		events, _ := b.subscribe()
+		now := time.Now()
+
+		b.hold(streamEvent{id: 8})
+		b.drain(now)
+		assert.Empty(t, events)
+		b.hold(streamEvent{id: 7})
+		b.drain(now.Add(time.Second))
+
+		assert.Equal(t, int64(7), (<-events).id)
+		assert.Equal(t, int64(8), (<-events).id)
+	})
+
+	t.Run("should give up on a missing id after the holdback", func(t *testing.T) {
+		b := NewBroker(nil)
+		b.lastID = 6
+		events, _ := b.subscribe()
+		now := time.Now()
+
+		b.hold(streamEvent{id: 8})
+		b.drain(now)
+		b.drain(now.Add(eventHoldback))
+		b.hold(streamEvent{id: 7})
+
+		assert.Equal(t, int64(8), (<-events).id)
+		assert.Equal(t, int64(7), (<-events).id)
+	})
+
+	t.Run("should close streams that fall behind", func(t *testing.T) {
+		b := NewBroker(nil)
+		events, _ := b.subscribe()
+
+		for i := 0; i <= subscriberBuffer; i++ {
+			b.publish(streamEvent{id: int64(i)})
+		}
+
+		n := 0
+		for range events {
+			n++
+		}
+		assert.Equal(t, subscriberBuffer, n)
+	})
+}
+
+func TestStreamHandler(t *testing.T) {
+	t.Run("should replay from Last-Event-ID then push live events of the caller's ledgers", func(t *testing.T) {
+		res := asMember(util.RequestE(http.MethodGet, "/expenses/stream", nil), "apikey:2")
+		ctx, cancel := context.WithCancel(context.Background())
+		req := res.Context.Request().WithContext(ctx)
+		req.Header.Set("Last-Event-ID", "5")
+		res.Context.SetRequest(req)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery("SELECT ledger_id FROM ledger_members WHERE subject = \\$1").WithArgs("apikey:2").
+			WillReturnRows(sqlmock.NewRows([]string{"ledger_id"}).AddRow(3))
+		mock.ExpectQuery("FROM expense_events WHERE id > \\$1 AND id <= \\$2 AND \\(snapshot->>'ledger_id'\\)::int = ANY\\(\\$3\\) ORDER BY id").
+			WithArgs(int64(5), int64(7), pq.Array([]int{3})).
+			WillReturnRows(addEvent(eventRows(), 6, ActionCreated, 3))
+		h := Handler{DB: db, Stream: NewBroker(db)}
+		h.Stream.lastID = 7
+
+		done := make(chan struct{})
+		go func() {
+			res.Serve(h.StreamHandler)
+			close(done)
+		}()
+		assert.Eventually(t, func() bool {
+			h.Stream.mu.Lock()
+			defer h.Stream.mu.Unlock()
+			return len(h.Stream.subs) == 1
+		}, time.Second, time.Millisecond)
+		h.Stream.publish(streamEvent{id: 8, Event: Event{Action: ActionUpdated, Expense: Expense{LedgerID: intPtr(3)}}})
+		h.Stream.publish(streamEvent{id: 9, Event: Event{Action: ActionDeleted, Expense: Expense{LedgerID: intPtr(4)}}})
+		// The handler picks between pending events and Close at random.
+		assert.Eventually(t, func() bool {
+			h.Stream.mu.Lock()
+			defer h.Stream.mu.Unlock()
+			for ch := range h.Stream.subs {
+				return len(ch) == 0
+			}
+			return true
+		}, time.Second, time.Millisecond)
+		h.Stream.Close()
+		<-done
+		cancel()
+		body, _ := io.ReadAll(res.Recorder.Body)
+
+		assert.Equal(t, "text/event-stream", res.Recorder.Header().Get("Content-Type"))
+		assert.Contains(t, string(body), "id: 6\nevent: expense.created\n")
+		assert.Contains(t, string(body), "id: 8\nevent: expense.updated\n")
+		assert.NotContains(t, string(body), "id: 7\n")
+		assert.NotContains(t, string(body), "id: 9\n")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should replay page by page up to the live events", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses/stream?last_event_id=1", nil)
+		m.SetScopes(res.Context, []string{m.ScopeAdmin})
+		db, mock, _ := sqlmock.New()
+		page := eventRows()
+		for id := int64(2); id <= maxReplay+1; id++ {
+			addEvent(page, id, ActionCreated, 3)
+		}
+		mock.ExpectQuery("FROM expense_events WHERE id > \\$1 AND id <= \\$2 ORDER BY id").WithArgs(int64(1), int64(maxReplay+2)).WillReturnRows(page)
+		mock.ExpectQuery("FROM expense_events WHERE id > \\$1 AND id <= \\$2 ORDER BY id").WithArgs(int64(maxReplay+1), int64(maxReplay+2)).
+			WillReturnRows(addEvent(eventRows(), maxReplay+2, ActionDeleted, 3))
+		h := Handler{DB: db, Stream: NewBroker(db)}
+		h.Stream.lastID = maxReplay + 2
+		h.Stream.Close()
+
+		res.Serve(h.StreamHandler)
+		body := res.Recorder.Body.String()
+
+		assert.Equal(t, maxReplay+1, strings.Count(body, "\nevent: "))
+		assert.Contains(t, body, fmt.Sprintf("id: %d\nevent: expense.deleted\n", maxReplay+2))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should outlive the server's write timeout", func(t *testing.T) {
+		h := Handler{Stream: NewBroker(nil), StreamTimeout: time.Minute}
+		e := echo.New()
+		e.Server.WriteTimeout = 200 * time.Millisecond
+		e.GET("/expenses/stream", h.StreamHandler, func(next echo.HandlerFunc) echo.HandlerFunc {
+			return func(c echo.Context) error {
+				m.SetScopes(c, []string{m.ScopeAdmin})
+				return next(c)
+			}
+		})
+		srv := httptest.NewUnstartedServer(e)
+		srv.Config.WriteTimeout = e.Server.WriteTimeout
+		srv.Config.ConnContext = ConnContext
+		srv.Start()
+		defer srv.Close()
+		defer h.Stream.Close()
+
+		res, err := http.Get(srv.URL + "/expenses/stream")
+		if err != nil {
+			t.Fatal("can't open stream:", err)
+		}
+		defer res.Body.Close()
+		lines := make(chan string)
+		go func() {
+			defer close(lines)
+			r := bufio.NewReader(res.Body)
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				lines <- line
+			}
+		}()
+		time.Sleep(3 * e.Server.WriteTimeout)
+		h.Stream.publish(streamEvent{id: 1, Event: Event{Action: ActionCreated, Expense: Expense{ID: 1}}})
+
+		got := false
+		timeout := time.After(time.Second)
+		for !got {
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					t.Fatal("the stream ended at the write timeout")
+				}
+				got = line == "id: 1\n"
+			case <-timeout:
+				t.Fatal("no event within a second")
+			}
+		}
+	})
+
+	t.Run("should return 400 (BadRequest) for an invalid Last-Event-ID", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses/stream?last_event_id=abc", nil)
+		h := Handler{Stream: NewBroker(nil)}
+
+		res.Serve(h.StreamHandler)
+
+		assert.Equal(t, http.StatusBadRequest, res.Recorder.Code)
+	})
+}
+
+func intPtr(i int) *int {
+	return &i
+}
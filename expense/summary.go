@@ -0,0 +1,235 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/panudetjt/assessment/problem"
+)
+
+type Summary struct {
+	// Currency is the currency of the totals: convert_to, or without it the
+	// one every expense is in. It is empty when no expense matches.
+	Currency string       `json:"currency,omitempty"`
+	Count    int          `json:"count"`
+	Total    float64      `json:"total"`
+	ByTag    []TagSummary `json:"by_tag"`
+	// ByMerchant, ByPaymentMethod and ByCategory are only there when asked
+	// for with group_by.
+	ByMerchant      []GroupSummary `json:"by_merchant,omitempty"`
+	ByPaymentMethod []GroupSummary `json:"by_payment_method,omitempty"`
+	ByCategory      []GroupSummary `json:"by_category,omitempty"`
+}
+
+type TagSummary struct {
+	Tag   string  `json:"tag"`
+	Count int     `json:"count"`
+	Total float64 `json:"total"`
+}
+
+// GroupSummary totals the expenses of a merchant, payment method or category.
+// Expenses without one are grouped under a nil ID. The totals of a category
+// include those of its descendants, and ParentID links it to its parent.
+type GroupSummary struct {
+	ID       *int    `json:"id"`
+	ParentID *int    `json:"parent_id,omitempty"`
+	Name     string  `json:"name"`
+	Count    int     `json:"count"`
+	Total    float64 `json:"total"`
+}
+
+// grouping is a group_by value, the query totalling amount per group of the
+// expenses matching where, and the Summary field of its totals.
+type grouping struct {
+	name  string
+	query func(amount, where string) string
+	field func(s *Summary) *[]GroupSummary
+}
+
+var groupings = []grouping{
+	{"merchant", groupByColumn("merchant_id", "merchants"), func(s *Summary) *[]GroupSummary { return &s.ByMerchant }},
+	{"payment_method", groupByColumn("payment_method_id", "payment_methods"), func(s *Summary) *[]GroupSummary { return &s.ByPaymentMethod }},
+	{"category", groupByCategory, func(s *Summary) *[]GroupSummary { return &s.ByCategory }},
+}
+
+func groupByColumn(column, table string) func(amount, where string) string {
+	return func(amount, where string) string {
+		return "SELECT g.id, NULL::integer, g.name, count(*), sum(e.amount) FROM (SELECT " + column + " AS group_id, " + amount + " AS amount FROM expenses" + where +
+			") AS e LEFT JOIN " + table + " AS g ON g.id = e.group_id GROUP BY g.id, g.name ORDER BY sum(e.amount) DESC, g.name"
+	}
+}
+
+// groupByCategory counts every expense for its category and each of its
+// ancestors, which up pairs every category with.
+func groupByCategory(amount, where string) string {
+	return "WITH RECURSIVE up AS (SELECT id, id AS ancestor FROM categories UNION ALL " +
+		"SELECT up.id, c.parent_id FROM up JOIN categories c ON c.id = up.ancestor WHERE c.parent_id IS NOT NULL) " +
+		"SELECT g.id, g.parent_id, g.name, count(*), sum(e.amount) FROM (SELECT category_id AS group_id, " + amount + " AS amount FROM expenses" + where +
+		") AS e LEFT JOIN up ON up.id = e.group_id LEFT JOIN categories AS g ON g.id = up.ancestor GROUP BY g.id, g.parent_id, g.name ORDER BY sum(e.amount) DESC, g.name"
+}
+
+// errMixedCurrencies fails a summary without convert_to of expenses in more
+// than one currency, whose amounts can't be added up.
+var errMixedCurrencies = errors.New("the expenses are in more than one currency")
+
+// SummaryHandler totals the expenses matching the list filters, overall and
+// per tag, and per merchant, payment method or category with group_by
+// (repeatable).
+// With convert_to every amount is converted first, and the summary
+// fails when a rate of one of them is missing. Without it the expenses must
+// all be in the same currency.
+func (h *Handler) SummaryHandler(c echo.Context) error {
+	f, err := parseFilter(c)
+	if err != nil {
+		return err
+	}
+	to, err := parseConvert(c)
+	if err != nil {
+		return err
+	}
+	groupBy := map[string]bool{}
+	for _, g := range c.QueryParams()["group_by"] {
+		groupBy[g] = true
+	}
+	known := 0
+	for _, g := range groupings {
+		if groupBy[g.name] {
+			known++
+		}
+	}
+	if known < len(groupBy) {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, "group_by must be merchant, payment_method or category")
+	}
+	ctx := c.Request().Context()
+
+	amount := "amount"
+	if to != "" {
+		rate := f.rate(to)
+		amount = "amount * " + rate
+		missing := &filter{conds: append(append([]string{}, f.conds...), "("+rate+") IS NULL")}
+		stmt, err := h.prepare("SELECT DISTINCT currency, spent_on FROM expenses" + missing.where() +
+			fmt.Sprintf(" ORDER BY spent_on, currency LIMIT %d", maxMissingRates))
+		if err != nil {
+			return problem.Internal(fmt.Errorf("can't prepare missing rates statment: %w", err))
+		}
+		var ms []missingRate
+		err = retryRead(ctx, func() error {
+			ms = nil
+			rows, err := stmt.QueryContext(ctx, f.args...)
+			if err != nil {
+				return fmt.Errorf("can't query missing rates: %w", err)
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var m missingRate
+				if err := rows.Scan(&m.currency, &m.on); err != nil {
+					return fmt.Errorf("can't scan missing rate: %w", err)
+				}
+				ms = append(ms, m)
+			}
+			return rows.Err()
+		})
+		if err != nil {
+			return problem.Internal(err)
+		}
+		if ms != nil {
+			return rateNotFound(to, ms)
+		}
+	}
+
+	totals, err := h.prepare("SELECT count(*), COALESCE(sum(" + amount + "), 0), min(currency), max(currency) FROM expenses" + f.where())
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't prepare summary statment: %w", err))
+	}
+	byTag, err := h.prepare("SELECT t.tag, count(*), sum(" + amount + ") FROM expenses, unnest(tags) AS t(tag)" + f.where() +
+		" GROUP BY t.tag ORDER BY sum(" + amount + ") DESC, t.tag")
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't prepare tag summary statment: %w", err))
+	}
+
+	groups := map[string]*sql.Stmt{}
+	for _, g := range groupings {
+		if !groupBy[g.name] {
+			continue
+		}
+		groups[g.name], err = h.prepare(g.query(amount, f.where()))
+		if err != nil {
+			return problem.Internal(fmt.Errorf("can't prepare %s summary statment: %w", g.name, err))
+		}
+	}
+
+	s := Summary{Currency: to, ByTag: []TagSummary{}}
+	err = retryRead(ctx, func() error {
+		var low, high sql.NullString
+		if err := totals.QueryRowContext(ctx, f.args...).Scan(&s.Count, &s.Total, &low, &high); err != nil {
+			return fmt.Errorf("can't query summary: %w", err)
+		}
+		if to == "" {
+			if low.String != high.String {
+				return errMixedCurrencies
+			}
+			s.Currency = low.String
+		}
+
+		rows, err := byTag.QueryContext(ctx, f.args...)
+		if err != nil {
+			return fmt.Errorf("can't query tag summary: %w", err)
+		}
+		defer rows.Close()
+
+		s.ByTag = s.ByTag[:0]
+		for rows.Next() {
+			var t TagSummary
+			if err := rows.Scan(&t.Tag, &t.Count, &t.Total); err != nil {
+				return fmt.Errorf("can't scan tag summary: %w", err)
+			}
+			s.ByTag = append(s.ByTag, t)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, g := range groupings {
+			stmt := groups[g.name]
+			if stmt == nil {
+				continue
+			}
+			if *g.field(&s), err = queryGroups(ctx, stmt, f.args); err != nil {
+				return fmt.Errorf("can't query %s summary: %w", g.name, err)
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errMixedCurrencies) {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, "the expenses are in more than one currency, total them with convert_to")
+	}
+	if err != nil {
+		return problem.Internal(err)
+	}
+
+	return c.JSON(http.StatusOK, s)
+}
+
+func queryGroups(ctx context.Context, stmt *sql.Stmt, args []any) ([]GroupSummary, error) {
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var gs []GroupSummary
+	for rows.Next() {
+		var g GroupSummary
+		var name sql.NullString
+		if err := rows.Scan(&g.ID, &g.ParentID, &name, &g.Count, &g.Total); err != nil {
+			return nil, err
+		}
+		g.Name = name.String
+		gs = append(gs, g)
+	}
+	return gs, rows.Err()
+}
@@ -0,0 +1,126 @@
+package expense
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/problem"
+	"github.com/panudetjt/assessment/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummaryHandler(t *testing.T) {
+	t.Run("should return 200 (OK) with totals per tag", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses/summary?tag=food&min_amount=10", nil)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectPrepare("SELECT count\\(\\*\\), COALESCE\\(sum\\(amount\\), 0\\), min\\(currency\\), max\\(currency\\) FROM expenses WHERE deleted_at IS NULL AND tags @> \\$1 AND amount >= \\$2")
+		mock.ExpectPrepare("SELECT t.tag, count\\(\\*\\), sum\\(amount\\) FROM expenses, unnest\\(tags\\) AS t\\(tag\\) WHERE deleted_at IS NULL AND tags @> \\$1 AND amount >= \\$2 GROUP BY t.tag")
+		mock.ExpectQuery("SELECT count").
+			WithArgs(pq.Array([]string{"food"}), 10.0).
+			WillReturnRows(sqlmock.NewRows([]string{"count", "sum", "min", "max"}).AddRow(2, 168, "THB", "THB"))
+		mock.ExpectQuery("SELECT t.tag").
+			WithArgs(pq.Array([]string{"food"}), 10.0).
+			WillReturnRows(sqlmock.NewRows([]string{"tag", "count", "sum"}).
+				AddRow("food", 2, 168).
+				AddRow("beverage", 1, 79))
+		handler := Handler{DB: db}
+
+		res.Serve(handler.SummaryHandler)
+		var s Summary
+		res.Decode(&s)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+		assert.Equal(t, Summary{
+			Currency: "THB",
+			Count:    2,
+			Total:    168,
+			ByTag:    []TagSummary{{"food", 2, 168}, {"beverage", 1, 79}},
+		}, s)
+	})
+
+	t.Run("should group by merchant, expenses without one under a null id", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses/summary?group_by=merchant&payment_method_id=4", nil)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectPrepare("SELECT count")
+		mock.ExpectPrepare("SELECT t.tag")
+		mock.ExpectPrepare("SELECT g.id, NULL::integer, g.name, count\\(\\*\\), sum\\(e.amount\\) FROM \\(SELECT merchant_id AS group_id, amount AS amount FROM expenses WHERE deleted_at IS NULL AND payment_method_id = \\$1\\) AS e " +
+			"LEFT JOIN merchants AS g ON g.id = e.group_id GROUP BY g.id, g.name")
+		mock.ExpectQuery("SELECT count").WithArgs(int64(4)).
+			WillReturnRows(sqlmock.NewRows([]string{"count", "sum", "min", "max"}).AddRow(3, 247, "THB", "THB"))
+		mock.ExpectQuery("SELECT t.tag").WithArgs(int64(4)).
+			WillReturnRows(sqlmock.NewRows([]string{"tag", "count", "sum"}))
+		mock.ExpectQuery("SELECT g.id").WithArgs(int64(4)).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "parent_id", "name", "count", "sum"}).
+				AddRow(7, nil, "Foodland", 2, 168).
+				AddRow(nil, nil, nil, 1, 79))
+		handler := Handler{DB: db}
+
+		res.Serve(handler.SummaryHandler)
+		var s Summary
+		res.Decode(&s)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+		merchant := 7
+		assert.Equal(t, []GroupSummary{{&merchant, nil, "Foodland", 2, 168}, {nil, nil, "", 1, 79}}, s.ByMerchant)
+		assert.Nil(t, s.ByPaymentMethod)
+	})
+
+	t.Run("should return 400 (BadRequest) when the expenses are in more than one currency", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses/summary", nil)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectPrepare("SELECT count")
+		mock.ExpectPrepare("SELECT t.tag")
+		mock.ExpectQuery("SELECT count").
+			WillReturnRows(sqlmock.NewRows([]string{"count", "sum", "min", "max"}).AddRow(2, 179, "THB", "USD"))
+		handler := Handler{DB: db}
+
+		res.Serve(handler.SummaryHandler)
+		var e problem.Problem
+		res.Decode(&e)
+
+		assert.Equal(t, http.StatusBadRequest, res.Recorder.Code)
+		assert.Equal(t, problem.CodeInvalidQuery, e.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 400 (BadRequest) for an unknown group_by", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses/summary?group_by=tag", nil)
+		handler := Handler{}
+
+		res.Serve(handler.SummaryHandler)
+
+		assert.Equal(t, http.StatusBadRequest, res.Recorder.Code)
+	})
+
+	t.Run("should return 400 (BadRequest) when amount filter is invalid", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses/summary?max_amount=lots", nil)
+		db, mock, _ := sqlmock.New()
+		handler := Handler{DB: db}
+
+		res.Serve(handler.SummaryHandler)
+		var e problem.Problem
+		res.Decode(&e)
+
+		assert.Equal(t, http.StatusBadRequest, res.Recorder.Code)
+		assert.Equal(t, problem.CodeInvalidQuery, e.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 500 (InternalServerError) when cannot query", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/expenses/summary", nil)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectPrepare("SELECT count")
+		mock.ExpectPrepare("SELECT t.tag")
+		mock.ExpectQuery("SELECT count").WillReturnError(&pq.Error{})
+		handler := Handler{DB: db}
+
+		res.Serve(handler.SummaryHandler)
+
+		assert.Equal(t, http.StatusInternalServerError, res.Recorder.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
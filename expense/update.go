@@ -2,38 +2,81 @@ package expense
 
 import (
 	"database/sql"
+	"fmt"
 	"net/http"
 	"strconv"
 
 	"github.com/labstack/echo/v4"
 	"github.com/lib/pq"
-	"github.com/panudetjt/assessment/util"
+	"github.com/panudetjt/assessment/ledger"
+	"github.com/panudetjt/assessment/problem"
 )
 
 func (h *Handler) UpdateExpensesHandler(c echo.Context) error {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, util.Error{Message: "invalid id"})
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidID, "expense id must be an integer")
 	}
 
 	e := Expense{}
 	err = c.Bind(&e)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, util.Error{Message: err.Error()})
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "request body must be an expense JSON object")
 	}
-
-	stmt, err := h.DB.Prepare("UPDATE expenses SET title = $2, amount = $3, note = $4, tags = $5 WHERE id = $1 RETURNING id, title, amount, note, tags")
+	e.normalize()
+	if errs := e.validate(); errs != nil {
+		return problem.Validation(errs...)
+	}
+	if err := h.access(c, id, ledger.Editor); err != nil {
+		return err
+	}
+	reason, err := overrideReason(c)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, util.Error{Message: "can't prepare update expense statment:" + err.Error()})
+		return err
 	}
 
-	row := stmt.QueryRow(id, e.Title, e.Amount, e.Note, pq.Array(e.Tags))
-	err = row.Scan(&e.ID, &e.Title, &e.Amount, &e.Note, pq.Array(&e.Tags))
-	if err == sql.ErrNoRows {
-		return c.JSON(http.StatusNotFound, util.Error{Message: "expense not found"})
-	}
+	ctx := c.Request().Context()
+	err = h.inTx(c, func(tx *sql.Tx) error {
+		var before Expense
+		err := tx.QueryRowContext(ctx, "SELECT "+columns+" FROM expenses WHERE id = $1 AND "+live+" FOR UPDATE", id).Scan(before.fields()...)
+		if err == sql.ErrNoRows {
+			return expenseNotFound(id)
+		}
+		if err != nil {
+			return problem.Internal(fmt.Errorf("can't lock expense: %w", err))
+		}
+		if !editable(before.Status) {
+			return notEditable(before)
+		}
+		e.inherit(before)
+		closed, err := checkPeriods(ctx, tx, before.LedgerID, reason, before.SpentOn, e.SpentOn)
+		if err != nil {
+			return err
+		}
+		if err := applyRules(ctx, tx, before.LedgerID, &e); err != nil {
+			return err
+		}
+		errs, err := checkRefs(ctx, tx, before.LedgerID, &e)
+		if err != nil {
+			return problem.Internal(err)
+		}
+		if errs != nil {
+			return problem.Validation(errs...)
+		}
+		err = tx.QueryRowContext(ctx,
+			"UPDATE expenses SET title = $2, amount = $3, note = $4, tags = $5, split = $6, currency = $7, spent_on = $8, merchant_id = $9, payment_method_id = $10, category_id = $11 WHERE id = $1 RETURNING "+columns,
+			id, e.Title, e.Amount, e.Note, pq.Array(e.Tags), e.Split, e.Currency, e.SpentOn, e.MerchantID, e.PaymentMethodID, e.CategoryID,
+		).Scan(e.fields()...)
+		if err != nil {
+			return problem.Internal(fmt.Errorf("can't execute update expense statment: %w", err))
+		}
+		if err := auditOverrides(c, tx, closed, id, ActionUpdated, reason); err != nil {
+			return err
+		}
+		return record(c, tx, id, ActionUpdated, &before, &e)
+	})
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, util.Error{Message: "can't execute update expense statment:" + err.Error()})
+		return err
 	}
 
 	return c.JSON(http.StatusOK, e)
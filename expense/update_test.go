@@ -10,6 +10,7 @@ import (
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/problem"
 	"github.com/panudetjt/assessment/util"
 	"github.com/stretchr/testify/assert"
 )
@@ -17,23 +18,31 @@ import (
 func TestUpdateExpenseHandler(t *testing.T) {
 	t.Run("should return 200 (OK) when request body is valid", func(t *testing.T) {
 		e := Expense{
-			ID:     1,
-			Title:  "apple smoothie",
-			Amount: 89,
-			Note:   "no discount",
-			Tags:   []string{"beverage"},
+			ID:       1,
+			Title:    "apple smoothie",
+			Amount:   89,
+			Note:     "no discount",
+			Tags:     []string{"beverage"},
+			Currency: "THB",
+			SpentOn:  day,
+			Status:   StatusDraft,
 		}
 		b, _ := json.Marshal(e)
 		res, db, mock := arrange(string(b))
 
-		mock.ExpectPrepare("UPDATE expenses SET title = \\$2, amount = \\$3, note = \\$4, tags = \\$5 WHERE id = \\$1 RETURNING id, title, amount, note, tags").
-			ExpectQuery().
-			WithArgs(e.ID, e.Title, e.Amount, e.Note, pq.Array(e.Tags)).
-			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).
-				AddRow("1", e.Title, fmt.Sprint(e.Amount), e.Note, pq.Array(e.Tags)))
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockExpense).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(expenseColumns).AddRow(1, "apple juice", 89, e.Note, pq.Array(e.Tags), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft"))
+		expectPeriods(mock)
+		expectRules(mock)
+		mock.ExpectQuery("UPDATE expenses SET title = \\$2, amount = \\$3, note = \\$4, tags = \\$5, split = \\$6, currency = \\$7, spent_on = \\$8, merchant_id = \\$9, payment_method_id = \\$10, category_id = \\$11 WHERE id = \\$1 RETURNING id, title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id, status").
+			WithArgs(e.ID, e.Title, e.Amount, e.Note, pq.Array(e.Tags), nil, "THB", "2023-01-02", nil, nil, nil).
+			WillReturnRows(sqlmock.NewRows(expenseColumns).
+				AddRow("1", e.Title, fmt.Sprint(e.Amount), e.Note, pq.Array(e.Tags), nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft"))
+		expectEvent(mock, ActionUpdated)
 		handler := Handler{DB: db}
 
-		handler.UpdateExpensesHandler(res.Context)
+		res.Serve(handler.UpdateExpensesHandler)
 		ee := Expense{}
 		res.Decode(&ee)
 
@@ -50,74 +59,78 @@ func TestUpdateExpenseHandler(t *testing.T) {
 		db, mock, _ := sqlmock.New()
 
 		handler := Handler{DB: db}
-		handler.UpdateExpensesHandler(res.Context)
-		ee := util.Error{}
+		res.Serve(handler.UpdateExpensesHandler)
+		ee := problem.Problem{}
 		res.Decode(&ee)
 
 		assert.Equal(t, "invalid", res.Context.Param("id"))
 		assert.Equal(t, http.StatusBadRequest, res.Recorder.Code)
 		assert.Nil(t, mock.ExpectationsWereMet())
-		assert.NotNil(t, ee.Message)
+		assert.NotNil(t, ee.Code)
 	})
 	t.Run("should return 400 (BadRequest) when request body is invalid", func(t *testing.T) {
 		res, db, mock := arrange("invalid body")
 
 		handler := Handler{DB: db}
-		handler.UpdateExpensesHandler(res.Context)
-		ee := util.Error{}
+		res.Serve(handler.UpdateExpensesHandler)
+		ee := problem.Problem{}
 		res.Decode(&ee)
 
 		assert.Equal(t, "1", res.Context.Param("id"))
 		assert.Equal(t, http.StatusBadRequest, res.Recorder.Code)
 		assert.Nil(t, mock.ExpectationsWereMet())
-		assert.NotNil(t, ee.Message)
+		assert.NotNil(t, ee.Code)
 	})
 	t.Run("should return 404 (NotFound) when not found row", func(t *testing.T) {
 		res, db, mock := arrange("")
-		mock.ExpectPrepare("UPDATE expenses SET title = \\$2, amount = \\$3, note = \\$4, tags = \\$5 WHERE id = \\$1 RETURNING id, title, amount, note, tags").
-			ExpectQuery().
-			WillReturnError(sql.ErrNoRows)
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockExpense).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
 
 		handler := Handler{DB: db}
-		handler.UpdateExpensesHandler(res.Context)
-		ee := util.Error{}
+		res.Serve(handler.UpdateExpensesHandler)
+		ee := problem.Problem{}
 		res.Decode(&ee)
 
 		assert.Equal(t, "1", res.Context.Param("id"))
 		assert.Equal(t, http.StatusNotFound, res.Recorder.Code)
 		assert.Nil(t, mock.ExpectationsWereMet())
-		assert.NotNil(t, ee.Message)
+		assert.NotNil(t, ee.Code)
 	})
-	t.Run("should return 500 (InternalServerError) when cannot prepare update", func(t *testing.T) {
+	t.Run("should return 500 (InternalServerError) when cannot begin transaction", func(t *testing.T) {
 		res, db, mock := arrange("")
-		mock.ExpectPrepare("UPDATE expenses SET title = \\$2, amount = \\$3, note = \\$4, tags = \\$5 WHERE id = \\$1 RETURNING id, title, amount, note, tags").
-			WillReturnError(&pq.Error{})
+		mock.ExpectBegin().WillReturnError(&pq.Error{})
 
 		handler := Handler{DB: db}
-		handler.UpdateExpensesHandler(res.Context)
-		ee := util.Error{}
+		res.Serve(handler.UpdateExpensesHandler)
+		ee := problem.Problem{}
 		res.Decode(&ee)
 
 		assert.Equal(t, "1", res.Context.Param("id"))
 		assert.Equal(t, http.StatusInternalServerError, res.Recorder.Code)
 		assert.Nil(t, mock.ExpectationsWereMet())
-		assert.NotNil(t, ee.Message)
+		assert.NotNil(t, ee.Code)
 	})
 	t.Run("should return 500 (InternalServerError) when cannot execute update", func(t *testing.T) {
 		res, db, mock := arrange("")
-		mock.ExpectPrepare("UPDATE expenses SET title = \\$2, amount = \\$3, note = \\$4, tags = \\$5 WHERE id = \\$1 RETURNING id, title, amount, note, tags").
-			ExpectQuery().
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockExpense).
+			WillReturnRows(sqlmock.NewRows(expenseColumns).AddRow(1, "apple juice", 89, "", nil, nil, nil, "THB", "2023-01-02", nil, nil, nil, "draft"))
+		expectPeriods(mock)
+		expectRules(mock)
+		mock.ExpectQuery("UPDATE expenses SET title = \\$2, amount = \\$3, note = \\$4, tags = \\$5, split = \\$6, currency = \\$7, spent_on = \\$8, merchant_id = \\$9, payment_method_id = \\$10, category_id = \\$11 WHERE id = \\$1 RETURNING id, title, amount, note, tags, ledger_id, split, currency, spent_on, merchant_id, payment_method_id, category_id, status").
 			WillReturnError(&pq.Error{})
+		mock.ExpectRollback()
 
 		handler := Handler{DB: db}
-		handler.UpdateExpensesHandler(res.Context)
-		ee := util.Error{}
+		res.Serve(handler.UpdateExpensesHandler)
+		ee := problem.Problem{}
 		res.Decode(&ee)
 
 		assert.Equal(t, "1", res.Context.Param("id"))
 		assert.Equal(t, http.StatusInternalServerError, res.Recorder.Code)
 		assert.Nil(t, mock.ExpectationsWereMet())
-		assert.NotNil(t, ee.Message)
+		assert.NotNil(t, ee.Code)
 	})
 }
 
@@ -0,0 +1,57 @@
+package expense
+
+import (
+	"strings"
+
+	"github.com/panudetjt/assessment/exchange"
+	"github.com/panudetjt/assessment/problem"
+)
+
+// normalize upper-cases the currency of a request body and drops what only
+// the server sets.
+func (e *Expense) normalize() {
+	e.Currency = strings.ToUpper(strings.TrimSpace(e.Currency))
+	e.Converted = nil
+	e.Status = ""
+}
+
+// inherit keeps the currency and day of before when e leaves them out.
+func (e *Expense) inherit(before Expense) {
+	if e.Currency == "" {
+		e.Currency = before.Currency
+	}
+	if e.SpentOn.IsZero() {
+		e.SpentOn = before.SpentOn
+	}
+}
+
+// validate reports every invalid field of e, or nil when e can be stored. It
+// also computes the amount every split participant owes.
+func (e Expense) validate() []problem.FieldError {
+	var errs []problem.FieldError
+	if strings.TrimSpace(e.Title) == "" {
+		errs = append(errs, problem.FieldError{Field: "title", Message: "must not be empty"})
+	}
+	if e.Amount <= 0 {
+		errs = append(errs, problem.FieldError{Field: "amount", Message: "must be greater than 0"})
+	}
+	for _, t := range e.Tags {
+		if strings.TrimSpace(t) == "" {
+			errs = append(errs, problem.FieldError{Field: "tags", Message: "must not contain empty tags"})
+			break
+		}
+	}
+	if e.Currency != "" && !exchange.ValidCurrency(e.Currency) {
+		errs = append(errs, problem.FieldError{Field: "currency", Message: "must be a 3-letter currency code"})
+	}
+	if e.Split != nil {
+		if serrs := e.Split.validate(); serrs != nil {
+			errs = append(errs, serrs...)
+		} else if e.Amount > 0 {
+			if err := e.Split.allocate(e.Amount); err != nil {
+				errs = append(errs, problem.FieldError{Field: "split.shares", Message: err.Error()})
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,124 @@
+package ledger
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	m "github.com/panudetjt/assessment/middleware"
+	"github.com/panudetjt/assessment/problem"
+)
+
+// CreateRequest is the body of POST /ledgers.
+type CreateRequest struct {
+	Name string `json:"name"`
+}
+
+// CreateHandler creates a ledger owned by the caller.
+func (h *Handler) CreateHandler(c echo.Context) error {
+	var r CreateRequest
+	if err := c.Bind(&r); err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "request body must be a ledger JSON object")
+	}
+	if strings.TrimSpace(r.Name) == "" {
+		return problem.Validation(problem.FieldError{Field: "name", Message: "must not be empty"})
+	}
+
+	ctx := c.Request().Context()
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't begin ledger transaction: %w", err))
+	}
+	defer tx.Rollback()
+
+	l := Ledger{Name: r.Name, Role: Owner}
+	err = tx.QueryRowContext(ctx, "INSERT INTO ledgers (name) VALUES ($1) RETURNING id, created_at", r.Name).Scan(&l.ID, &l.CreatedAt)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't insert ledger: %w", err))
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO ledger_members (ledger_id, subject, role) VALUES ($1, $2, $3)", l.ID, m.Subject(c), Owner)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't insert ledger owner: %w", err))
+	}
+	if err := tx.Commit(); err != nil {
+		return problem.Internal(fmt.Errorf("can't commit ledger: %w", err))
+	}
+
+	return c.JSON(http.StatusCreated, l)
+}
+
+// ListHandler lists the ledgers the caller is a member of, or every ledger
+// for admins.
+func (h *Handler) ListHandler(c echo.Context) error {
+	query, args := "SELECT l.id, l.name, l.created_at, m.role FROM ledgers l JOIN ledger_members m ON m.ledger_id = l.id WHERE m.subject = $1 ORDER BY l.id", []any{m.Subject(c)}
+	if IsAdmin(c) {
+		query, args = "SELECT id, name, created_at, 'owner' FROM ledgers ORDER BY id", nil
+	}
+
+	rows, err := h.DB.QueryContext(c.Request().Context(), query, args...)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't query ledgers: %w", err))
+	}
+	defer rows.Close()
+
+	ledgers := []Ledger{}
+	for rows.Next() {
+		var l Ledger
+		if err := rows.Scan(&l.ID, &l.Name, &l.CreatedAt, &l.Role); err != nil {
+			return problem.Internal(fmt.Errorf("can't scan ledgers: %w", err))
+		}
+		ledgers = append(ledgers, l)
+	}
+	if err := rows.Err(); err != nil {
+		return problem.Internal(fmt.Errorf("can't iterate ledgers: %w", err))
+	}
+	return c.JSON(http.StatusOK, ledgers)
+}
+
+// GetHandler returns a ledger with its members to any member.
+func (h *Handler) GetHandler(c echo.Context) error {
+	id, err := ID(c)
+	if err != nil {
+		return err
+	}
+	role, err := Authorize(c, h.DB, id, Viewer)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	l := Ledger{ID: id, Role: role, Members: []Member{}}
+	err = h.DB.QueryRowContext(ctx, "SELECT name, created_at FROM ledgers WHERE id = $1", id).Scan(&l.Name, &l.CreatedAt)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't query ledger: %w", err))
+	}
+
+	rows, err := h.DB.QueryContext(ctx, "SELECT subject, role, created_at FROM ledger_members WHERE ledger_id = $1 ORDER BY created_at, subject", id)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't query ledger members: %w", err))
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var mb Member
+		if err := rows.Scan(&mb.Subject, &mb.Role, &mb.CreatedAt); err != nil {
+			return problem.Internal(fmt.Errorf("can't scan ledger members: %w", err))
+		}
+		l.Members = append(l.Members, mb)
+	}
+	if err := rows.Err(); err != nil {
+		return problem.Internal(fmt.Errorf("can't iterate ledger members: %w", err))
+	}
+
+	return c.JSON(http.StatusOK, l)
+}
+
+// ID parses the :id path parameter of ledger routes.
+func ID(c echo.Context) (int, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return 0, problem.New(http.StatusBadRequest, problem.CodeInvalidID, "ledger id must be an integer")
+	}
+	return id, nil
+}
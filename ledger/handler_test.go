@@ -0,0 +1,132 @@
+package ledger
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	m "github.com/panudetjt/assessment/middleware"
+	"github.com/panudetjt/assessment/problem"
+	"github.com/panudetjt/assessment/util"
+	"github.com/stretchr/testify/assert"
+)
+
+var now = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// member makes res a request from a non-admin key with subject.
+func member(res *util.Response, subject string) *util.Response {
+	m.SetSubject(res.Context, subject)
+	m.SetScopes(res.Context, m.Scopes)
+	return res
+}
+
+func TestCreateHandler(t *testing.T) {
+	t.Run("should return 201 (Created) with the caller as owner", func(t *testing.T) {
+		res := member(util.RequestE(http.MethodPost, "/ledgers", strings.NewReader(`{"name": "home"}`)), "apikey:1")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO ledgers \\(name\\) VALUES \\(\\$1\\) RETURNING id, created_at").WithArgs("home").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(3, now))
+		mock.ExpectExec("INSERT INTO ledger_members").WithArgs(3, "apikey:1", Owner).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		h := Handler{DB: db}
+
+		res.Serve(h.CreateHandler)
+		var got Ledger
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusCreated, res.Recorder.Code)
+		assert.Equal(t, Ledger{ID: 3, Name: "home", CreatedAt: now, Role: Owner}, got)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 422 (UnprocessableEntity) without a name", func(t *testing.T) {
+		res := util.RequestE(http.MethodPost, "/ledgers", strings.NewReader(`{"name": " "}`))
+		db, mock, _ := sqlmock.New()
+		h := Handler{DB: db}
+
+		res.Serve(h.CreateHandler)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, res.Recorder.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestListHandler(t *testing.T) {
+	t.Run("should list only the caller's ledgers", func(t *testing.T) {
+		res := member(util.RequestE(http.MethodGet, "/ledgers", nil), "apikey:1")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery("SELECT l.id, l.name, l.created_at, m.role FROM ledgers l JOIN ledger_members m (.+) WHERE m.subject = \\$1").
+			WithArgs("apikey:1").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "role"}).AddRow(3, "home", now, "viewer"))
+		h := Handler{DB: db}
+
+		res.Serve(h.ListHandler)
+		var got []Ledger
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Equal(t, []Ledger{{ID: 3, Name: "home", CreatedAt: now, Role: Viewer}}, got)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should list every ledger for admins", func(t *testing.T) {
+		res := util.RequestE(http.MethodGet, "/ledgers", nil)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery("SELECT id, name, created_at, 'owner' FROM ledgers ORDER BY id").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "role"}))
+		h := Handler{DB: db}
+
+		res.Serve(h.ListHandler)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Equal(t, "[]\n", res.Recorder.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGetHandler(t *testing.T) {
+	get := func(subject string) (*util.Response, sqlmock.Sqlmock, *Handler) {
+		res := member(util.RequestE(http.MethodGet, "/ledgers/3", nil), subject)
+		res.Context.SetParamNames("id")
+		res.Context.SetParamValues("3")
+		db, mock, _ := sqlmock.New()
+		return res, mock, &Handler{DB: db}
+	}
+
+	t.Run("should return 200 (OK) with members", func(t *testing.T) {
+		res, mock, h := get("apikey:2")
+		mock.ExpectQuery("SELECT role FROM ledger_members WHERE ledger_id = \\$1 AND subject = \\$2").WithArgs(3, "apikey:2").
+			WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("viewer"))
+		mock.ExpectQuery("SELECT name, created_at FROM ledgers WHERE id = \\$1").WithArgs(3).
+			WillReturnRows(sqlmock.NewRows([]string{"name", "created_at"}).AddRow("home", now))
+		mock.ExpectQuery("SELECT subject, role, created_at FROM ledger_members WHERE ledger_id = \\$1").WithArgs(3).
+			WillReturnRows(sqlmock.NewRows([]string{"subject", "role", "created_at"}).
+				AddRow("apikey:1", "owner", now).
+				AddRow("apikey:2", "viewer", now))
+
+		res.Serve(h.GetHandler)
+		var got Ledger
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Equal(t, Viewer, got.Role)
+		assert.Equal(t, []Member{{"apikey:1", Owner, now}, {"apikey:2", Viewer, now}}, got.Members)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 404 (NotFound) to non-members", func(t *testing.T) {
+		res, mock, h := get("apikey:9")
+		mock.ExpectQuery("SELECT role FROM ledger_members").WillReturnRows(sqlmock.NewRows([]string{"role"}))
+
+		res.Serve(h.GetHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusNotFound, res.Recorder.Code)
+		assert.Equal(t, problem.CodeLedgerNotFound, p.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
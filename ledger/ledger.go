@@ -0,0 +1,115 @@
+// Package ledger shares expenses between callers. A ledger has members in the
+// owner, approver, editor or viewer role; expenses in a ledger are visible to
+// every member, editable by editors and above, and approved for reimbursement
+// by approvers and owners.
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	m "github.com/panudetjt/assessment/middleware"
+	"github.com/panudetjt/assessment/problem"
+)
+
+type Role string
+
+const (
+	Viewer   Role = "viewer"
+	Editor   Role = "editor"
+	Approver Role = "approver"
+	Owner    Role = "owner"
+)
+
+var ranks = map[Role]int{Viewer: 1, Editor: 2, Approver: 3, Owner: 4}
+
+// Valid reports whether r is a known role.
+func (r Role) Valid() bool {
+	return ranks[r] > 0
+}
+
+// AtLeast reports whether r grants everything min does.
+func (r Role) AtLeast(min Role) bool {
+	return ranks[r] >= ranks[min]
+}
+
+type Ledger struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	// Role is the caller's role in the ledger.
+	Role    Role     `json:"role"`
+	Members []Member `json:"members,omitempty"`
+}
+
+type Member struct {
+	Subject   string    `json:"subject"`
+	Role      Role      `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Handler struct {
+	DB *sql.DB
+}
+
+// IsAdmin reports whether the caller holds an admin key. Admins act as owner
+// of every ledger and may keep expenses outside ledgers.
+func IsAdmin(c echo.Context) bool {
+	return m.HasScope(c, m.ScopeAdmin)
+}
+
+// RoleOf returns subject's role in ledger id, or "" if it isn't a member.
+func RoleOf(ctx context.Context, db *sql.DB, id int, subject string) (Role, error) {
+	var r Role
+	err := db.QueryRowContext(ctx, "SELECT role FROM ledger_members WHERE ledger_id = $1 AND subject = $2", id, subject).Scan(&r)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("can't query ledger role: %w", err)
+	}
+	return r, nil
+}
+
+// Authorize returns the caller's role in ledger id, failing with 404 for
+// non-members so ledgers they can't see don't leak, and 403 below min.
+func Authorize(c echo.Context, db *sql.DB, id int, min Role) (Role, error) {
+	ctx := c.Request().Context()
+	if IsAdmin(c) {
+		var exists bool
+		err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM ledgers WHERE id = $1)", id).Scan(&exists)
+		if err != nil {
+			return "", problem.Internal(fmt.Errorf("can't query ledger: %w", err))
+		}
+		if !exists {
+			return "", notFound(id)
+		}
+		return Owner, nil
+	}
+
+	r, err := RoleOf(ctx, db, id, m.Subject(c))
+	if err != nil {
+		return "", problem.Internal(err)
+	}
+	return r, Require(r, min, func() error { return notFound(id) })
+}
+
+// Require checks that role grants min. A caller without a role gets the error
+// from missing, so handlers can answer with their own 404.
+func Require(role, min Role, missing func() error) error {
+	if role == "" {
+		return missing()
+	}
+	if !role.AtLeast(min) {
+		return problem.New(http.StatusForbidden, problem.CodeInsufficientRole, fmt.Sprintf("this needs the %s role, you are %s", min, role))
+	}
+	return nil
+}
+
+func notFound(id int) error {
+	return problem.New(http.StatusNotFound, problem.CodeLedgerNotFound, fmt.Sprintf("ledger %d does not exist", id))
+}
@@ -0,0 +1,31 @@
+package ledger
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/panudetjt/assessment/problem"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRole(t *testing.T) {
+	assert.True(t, Owner.AtLeast(Editor))
+	assert.True(t, Editor.AtLeast(Editor))
+	assert.False(t, Viewer.AtLeast(Editor))
+	assert.False(t, Role("admin").Valid())
+	assert.True(t, Viewer.Valid())
+}
+
+func TestRequire(t *testing.T) {
+	missing := errors.New("missing")
+	fail := func() error { return missing }
+
+	assert.NoError(t, Require(Owner, Editor, fail))
+	assert.Equal(t, missing, Require("", Viewer, fail))
+
+	var p *problem.Problem
+	assert.ErrorAs(t, Require(Viewer, Editor, fail), &p)
+	assert.Equal(t, http.StatusForbidden, p.Status)
+	assert.Equal(t, problem.CodeInsufficientRole, p.Code)
+}
@@ -0,0 +1,172 @@
+package ledger
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	m "github.com/panudetjt/assessment/middleware"
+	"github.com/panudetjt/assessment/problem"
+)
+
+// invitationTTL is how long an invitation code can be accepted.
+const invitationTTL = 7 * 24 * time.Hour
+
+// InviteRequest is the body of POST /ledgers/:id/invitations.
+type InviteRequest struct {
+	Role Role `json:"role"`
+}
+
+// Invitation is a new invitation. Code is shown only in this response; whoever
+// accepts it joins the ledger with Role.
+type Invitation struct {
+	LedgerID  int       `json:"ledger_id"`
+	Role      Role      `json:"role"`
+	Code      string    `json:"code"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// AcceptRequest is the body of POST /invitations/accept.
+type AcceptRequest struct {
+	Code string `json:"code"`
+}
+
+// InviteHandler lets an owner invite a new member with a one-time code.
+func (h *Handler) InviteHandler(c echo.Context) error {
+	id, err := ID(c)
+	if err != nil {
+		return err
+	}
+	var r InviteRequest
+	if err := c.Bind(&r); err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "request body must be an invitation JSON object")
+	}
+	if !r.Role.Valid() {
+		return problem.Validation(problem.FieldError{Field: "role", Message: "must be one of owner, approver, editor, viewer"})
+	}
+	if _, err := Authorize(c, h.DB, id, Owner); err != nil {
+		return err
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return problem.Internal(fmt.Errorf("can't generate invitation code: %w", err))
+	}
+	inv := Invitation{LedgerID: id, Role: r.Role, Code: base64.RawURLEncoding.EncodeToString(b)}
+	err = h.DB.QueryRowContext(c.Request().Context(),
+		"INSERT INTO ledger_invitations (ledger_id, role, code_hash, created_by, expires_at) VALUES ($1, $2, $3, $4, now() + $5 * interval '1 second') RETURNING expires_at",
+		id, r.Role, hashCode(inv.Code), m.Subject(c), invitationTTL.Seconds(),
+	).Scan(&inv.ExpiresAt)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't insert invitation: %w", err))
+	}
+
+	return c.JSON(http.StatusCreated, inv)
+}
+
+// AcceptHandler adds the caller to the ledger of an unused, unexpired code.
+// Existing members keep their role.
+func (h *Handler) AcceptHandler(c echo.Context) error {
+	var r AcceptRequest
+	if err := c.Bind(&r); err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "request body must be an invitation JSON object")
+	}
+
+	ctx := c.Request().Context()
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't begin invitation transaction: %w", err))
+	}
+	defer tx.Rollback()
+
+	var l Ledger
+	err = tx.QueryRowContext(ctx,
+		"UPDATE ledger_invitations SET accepted_by = $2, accepted_at = now() WHERE code_hash = $1 AND accepted_at IS NULL AND expires_at > now() RETURNING ledger_id, role",
+		hashCode(r.Code), m.Subject(c),
+	).Scan(&l.ID, &l.Role)
+	if err == sql.ErrNoRows {
+		return problem.New(http.StatusNotFound, problem.CodeInvitationNotFound, "the invitation does not exist, expired or was already used")
+	}
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't accept invitation: %w", err))
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO ledger_members (ledger_id, subject, role) VALUES ($1, $2, $3) ON CONFLICT (ledger_id, subject) DO NOTHING",
+		l.ID, m.Subject(c), l.Role)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't insert ledger member: %w", err))
+	}
+	err = tx.QueryRowContext(ctx, "SELECT l.name, l.created_at, m.role FROM ledgers l JOIN ledger_members m ON m.ledger_id = l.id WHERE l.id = $1 AND m.subject = $2", l.ID, m.Subject(c)).
+		Scan(&l.Name, &l.CreatedAt, &l.Role)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't query ledger: %w", err))
+	}
+	if err := tx.Commit(); err != nil {
+		return problem.Internal(fmt.Errorf("can't commit invitation: %w", err))
+	}
+
+	return c.JSON(http.StatusOK, l)
+}
+
+// RemoveMemberHandler removes a member. Owners may remove anyone and members
+// may leave, but a ledger never loses its last owner.
+func (h *Handler) RemoveMemberHandler(c echo.Context) error {
+	id, err := ID(c)
+	if err != nil {
+		return err
+	}
+	subject := c.Param("subject")
+	min := Owner
+	if subject == m.Subject(c) {
+		min = Viewer
+	}
+	if _, err := Authorize(c, h.DB, id, min); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't begin member transaction: %w", err))
+	}
+	defer tx.Rollback()
+
+	// Lock the ledger so two owners can't remove each other at once.
+	if _, err := tx.ExecContext(ctx, "SELECT id FROM ledgers WHERE id = $1 FOR UPDATE", id); err != nil {
+		return problem.Internal(fmt.Errorf("can't lock ledger: %w", err))
+	}
+	var role Role
+	err = tx.QueryRowContext(ctx, "DELETE FROM ledger_members WHERE ledger_id = $1 AND subject = $2 RETURNING role", id, subject).Scan(&role)
+	if err == sql.ErrNoRows {
+		return problem.New(http.StatusNotFound, problem.CodeMemberNotFound, fmt.Sprintf("%s is not a member of ledger %d", subject, id))
+	}
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't delete ledger member: %w", err))
+	}
+	if role == Owner {
+		var owners int
+		err := tx.QueryRowContext(ctx, "SELECT count(*) FROM ledger_members WHERE ledger_id = $1 AND role = $2", id, Owner).Scan(&owners)
+		if err != nil {
+			return problem.Internal(fmt.Errorf("can't count ledger owners: %w", err))
+		}
+		if owners == 0 {
+			return problem.New(http.StatusConflict, problem.CodeLastOwner, "the last owner can't leave, invite another owner first")
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return problem.Internal(fmt.Errorf("can't commit member removal: %w", err))
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,156 @@
+package ledger
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/panudetjt/assessment/problem"
+	"github.com/panudetjt/assessment/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInviteHandler(t *testing.T) {
+	invite := func(subject, body string) (*util.Response, sqlmock.Sqlmock, *Handler) {
+		res := member(util.RequestE(http.MethodPost, "/ledgers/3/invitations", strings.NewReader(body)), subject)
+		res.Context.SetParamNames("id")
+		res.Context.SetParamValues("3")
+		db, mock, _ := sqlmock.New()
+		return res, mock, &Handler{DB: db}
+	}
+
+	t.Run("should return 201 (Created) with a code for owners", func(t *testing.T) {
+		res, mock, h := invite("apikey:1", `{"role": "editor"}`)
+		mock.ExpectQuery("SELECT role FROM ledger_members").WithArgs(3, "apikey:1").
+			WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("owner"))
+		mock.ExpectQuery("INSERT INTO ledger_invitations").
+			WithArgs(3, Editor, sqlmock.AnyArg(), "apikey:1", invitationTTL.Seconds()).
+			WillReturnRows(sqlmock.NewRows([]string{"expires_at"}).AddRow(now))
+
+		res.Serve(h.InviteHandler)
+		var got Invitation
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusCreated, res.Recorder.Code)
+		assert.Equal(t, Editor, got.Role)
+		assert.NotEmpty(t, got.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 403 (Forbidden) for editors", func(t *testing.T) {
+		res, mock, h := invite("apikey:2", `{"role": "viewer"}`)
+		mock.ExpectQuery("SELECT role FROM ledger_members").
+			WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("editor"))
+
+		res.Serve(h.InviteHandler)
+
+		assert.Equal(t, http.StatusForbidden, res.Recorder.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 422 (UnprocessableEntity) for unknown roles", func(t *testing.T) {
+		res, mock, h := invite("apikey:1", `{"role": "admin"}`)
+
+		res.Serve(h.InviteHandler)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, res.Recorder.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestAcceptHandler(t *testing.T) {
+	t.Run("should add the caller to the ledger", func(t *testing.T) {
+		res := member(util.RequestE(http.MethodPost, "/invitations/accept", strings.NewReader(`{"code": "abc"}`)), "apikey:2")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("UPDATE ledger_invitations SET accepted_by = \\$2, accepted_at = now\\(\\) WHERE code_hash = \\$1 AND accepted_at IS NULL AND expires_at > now\\(\\)").
+			WithArgs(hashCode("abc"), "apikey:2").
+			WillReturnRows(sqlmock.NewRows([]string{"ledger_id", "role"}).AddRow(3, "editor"))
+		mock.ExpectExec("INSERT INTO ledger_members (.+) ON CONFLICT \\(ledger_id, subject\\) DO NOTHING").
+			WithArgs(3, "apikey:2", Editor).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("SELECT l.name, l.created_at, m.role FROM ledgers l").WithArgs(3, "apikey:2").
+			WillReturnRows(sqlmock.NewRows([]string{"name", "created_at", "role"}).AddRow("home", now, "editor"))
+		mock.ExpectCommit()
+		h := Handler{DB: db}
+
+		res.Serve(h.AcceptHandler)
+		var got Ledger
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Equal(t, Ledger{ID: 3, Name: "home", CreatedAt: now, Role: Editor}, got)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 404 (NotFound) for used or expired codes", func(t *testing.T) {
+		res := member(util.RequestE(http.MethodPost, "/invitations/accept", strings.NewReader(`{"code": "abc"}`)), "apikey:2")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("UPDATE ledger_invitations").WillReturnRows(sqlmock.NewRows([]string{"ledger_id", "role"}))
+		mock.ExpectRollback()
+		h := Handler{DB: db}
+
+		res.Serve(h.AcceptHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusNotFound, res.Recorder.Code)
+		assert.Equal(t, problem.CodeInvitationNotFound, p.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestRemoveMemberHandler(t *testing.T) {
+	remove := func(caller, subject string) (*util.Response, sqlmock.Sqlmock, *Handler) {
+		res := member(util.RequestE(http.MethodDelete, "/ledgers/3/members/"+subject, nil), caller)
+		res.Context.SetParamNames("id", "subject")
+		res.Context.SetParamValues("3", subject)
+		db, mock, _ := sqlmock.New()
+		return res, mock, &Handler{DB: db}
+	}
+
+	t.Run("should let a member leave", func(t *testing.T) {
+		res, mock, h := remove("apikey:2", "apikey:2")
+		mock.ExpectQuery("SELECT role FROM ledger_members").WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("viewer"))
+		mock.ExpectBegin()
+		mock.ExpectExec("SELECT id FROM ledgers WHERE id = \\$1 FOR UPDATE").WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("DELETE FROM ledger_members WHERE ledger_id = \\$1 AND subject = \\$2 RETURNING role").WithArgs(3, "apikey:2").
+			WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("viewer"))
+		mock.ExpectCommit()
+
+		res.Serve(h.RemoveMemberHandler)
+
+		assert.Equal(t, http.StatusNoContent, res.Recorder.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 403 (Forbidden) when a non-owner removes someone else", func(t *testing.T) {
+		res, mock, h := remove("apikey:2", "apikey:1")
+		mock.ExpectQuery("SELECT role FROM ledger_members").WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("editor"))
+
+		res.Serve(h.RemoveMemberHandler)
+
+		assert.Equal(t, http.StatusForbidden, res.Recorder.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 409 (Conflict) when the last owner leaves", func(t *testing.T) {
+		res, mock, h := remove("apikey:1", "apikey:1")
+		mock.ExpectQuery("SELECT role FROM ledger_members").WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("owner"))
+		mock.ExpectBegin()
+		mock.ExpectExec("SELECT id FROM ledgers").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("DELETE FROM ledger_members").WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("owner"))
+		mock.ExpectQuery("SELECT count\\(\\*\\) FROM ledger_members WHERE ledger_id = \\$1 AND role = \\$2").WithArgs(3, Owner).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectRollback()
+
+		res.Serve(h.RemoveMemberHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusConflict, res.Recorder.Code)
+		assert.Equal(t, problem.CodeLastOwner, p.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -1,29 +1,128 @@
 package middleware
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
+	"github.com/panudetjt/assessment/problem"
 )
 
 type AuthorizationValidator func(string, echo.Context) (bool, error)
 
+// Scopes a key can be granted. ScopeAdmin manages API keys and is only held
+// by the static keys from the configuration.
+const (
+	ScopeExpensesRead  = "expenses:read"
+	ScopeExpensesWrite = "expenses:write"
+	ScopeReportsRead   = "reports:read"
+	ScopeAdmin         = "admin"
+)
+
+// Scopes are the scopes an API key may be created with.
+var Scopes = []string{ScopeExpensesRead, ScopeExpensesWrite, ScopeReportsRead}
+
+// subjectKey and scopesKey are the echo.Context keys of the authenticated
+// caller's identity and granted scopes.
+const (
+	subjectKey = "auth.subject"
+	scopesKey  = "auth.scopes"
+)
+
+// SetSubject records who the caller is. Validators call it on success so later
+// middleware such as RateLimit can key on the caller.
+func SetSubject(c echo.Context, subject string) {
+	c.Set(subjectKey, subject)
+}
+
+// Subject returns the authenticated caller, or "" for anonymous requests.
+func Subject(c echo.Context) string {
+	s, _ := c.Get(subjectKey).(string)
+	return s
+}
+
+// SetScopes records what the caller is allowed to do.
+func SetScopes(c echo.Context, scopes []string) {
+	c.Set(scopesKey, scopes)
+}
+
+// HasScope reports whether the caller was granted scope.
+func HasScope(c echo.Context, scope string) bool {
+	scopes, _ := c.Get(scopesKey).([]string)
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireScope rejects callers without scope. It must run after Authorization.
+func RequireScope(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !HasScope(c, scope) {
+				return problem.New(http.StatusForbidden, problem.CodeInsufficientScope, fmt.Sprintf("the key lacks the %s scope", scope))
+			}
+			return next(c)
+		}
+	}
+}
+
+// Authorization rejects requests the validator f doesn't accept. f may return
+// a *problem.Problem, e.g. when its store is down, to report it as is.
 func Authorization(f AuthorizationValidator) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			auth := c.Request().Header.Get(echo.HeaderAuthorization)
 			if auth == "" {
-				return echo.NewHTTPError(http.StatusBadRequest, errors.New("missing authorization header"))
+				return problem.New(http.StatusBadRequest, problem.CodeMissingAuthorization, "missing authorization header")
 			}
 			ok, err := f(auth, c)
+			var p *problem.Problem
+			if errors.As(err, &p) {
+				return p
+			}
 			if err != nil {
-				return echo.NewHTTPError(http.StatusBadRequest, errors.New("invalid authorization header"))
+				return problem.New(http.StatusBadRequest, problem.CodeInvalidAuthorization, "invalid authorization header")
 			}
 			if !ok {
-				return echo.ErrUnauthorized
+				return problem.New(http.StatusUnauthorized, problem.CodeUnauthorized, "the authorization key is not valid")
 			}
 			return next(c)
 		}
 	}
 }
+
+// AnyOf accepts the header if any of vs does, trying them in order.
+func AnyOf(vs ...AuthorizationValidator) AuthorizationValidator {
+	return func(s string, c echo.Context) (bool, error) {
+		for _, v := range vs {
+			ok, err := v(s, c)
+			if ok || err != nil {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+}
+
+// StaticKeys validates the authorization header against a fixed set of keys.
+// They are admin keys and hold every scope.
+func StaticKeys(keys []string) AuthorizationValidator {
+	return func(s string, c echo.Context) (bool, error) {
+		for _, k := range keys {
+			if subtle.ConstantTimeCompare([]byte(s), []byte(k)) == 1 {
+				sum := sha256.Sum256([]byte(k))
+				SetSubject(c, "key:"+hex.EncodeToString(sum[:8]))
+				SetScopes(c, append([]string{ScopeAdmin}, Scopes...))
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
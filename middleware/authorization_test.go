@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/labstack/echo/v4"
+	"github.com/panudetjt/assessment/problem"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -28,10 +29,11 @@ func TestAuthorization(t *testing.T) {
 	t.Run("return 400 (BadRequest) when no authorization header", func(t *testing.T) {
 
 		h := Authorization(f)(handler)
-		got := h(c).(*echo.HTTPError)
+		got := h(c).(*problem.Problem)
 
 		assert.Error(t, got)
-		assert.Equal(t, http.StatusBadRequest, got.Code)
+		assert.Equal(t, http.StatusBadRequest, got.Status)
+		assert.Equal(t, problem.CodeMissingAuthorization, got.Code)
 	})
 
 	t.Run("return 400 (BadRequest) when authorization header is invalid", func(t *testing.T) {
@@ -40,10 +42,10 @@ func TestAuthorization(t *testing.T) {
 		h := Authorization(func(a string, c echo.Context) (bool, error) {
 			return false, errors.New("invalid authorization header")
 		})(handler)
-		got := h(c).(*echo.HTTPError)
+		got := h(c).(*problem.Problem)
 
 		assert.Error(t, got)
-		assert.Equal(t, http.StatusBadRequest, got.Code)
+		assert.Equal(t, http.StatusBadRequest, got.Status)
 	})
 
 	t.Run("return 401 (Unauthorized) when authorization key is invalid", func(t *testing.T) {
@@ -52,10 +54,21 @@ func TestAuthorization(t *testing.T) {
 		h := Authorization(func(a string, c echo.Context) (bool, error) {
 			return false, nil
 		})(handler)
-		got := h(c).(*echo.HTTPError)
+		got := h(c).(*problem.Problem)
 
 		assert.Error(t, got)
-		assert.Equal(t, http.StatusUnauthorized, got.Code)
+		assert.Equal(t, http.StatusUnauthorized, got.Status)
+	})
+
+	t.Run("return the validator's problem as is", func(t *testing.T) {
+		req.Header.Set(echo.HeaderAuthorization, "valid")
+
+		h := Authorization(func(a string, c echo.Context) (bool, error) {
+			return false, problem.Internal(errors.New("db down"))
+		})(handler)
+		got := h(c).(*problem.Problem)
+
+		assert.Equal(t, http.StatusInternalServerError, got.Status)
 	})
 
 	t.Run("return 200 (OK) when authorization key is valid", func(t *testing.T) {
@@ -68,3 +81,65 @@ func TestAuthorization(t *testing.T) {
 		assert.Equal(t, http.StatusOK, res.Code)
 	})
 }
+
+func TestStaticKeys(t *testing.T) {
+	f := StaticKeys([]string{"November 10, 2009", "other"})
+
+	for key, want := range map[string]bool{
+		"November 10, 2009": true,
+		"other":             true,
+		"November 10":       false,
+		"":                  false,
+	} {
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+		ok, err := f(key, c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, want, ok, key)
+		assert.Equal(t, want, Subject(c) != "", key)
+		if want {
+			assert.NotContains(t, Subject(c), key)
+			assert.True(t, HasScope(c, ScopeAdmin))
+			assert.True(t, HasScope(c, ScopeExpensesWrite))
+		}
+	}
+}
+
+func TestAnyOf(t *testing.T) {
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+	failing := func(string, echo.Context) (bool, error) { return false, errors.New("down") }
+
+	ok, err := AnyOf(StaticKeys([]string{"a"}), failing)("a", c)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	ok, err = AnyOf(StaticKeys([]string{"a"}), StaticKeys([]string{"b"}))("c", c)
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	_, err = AnyOf(StaticKeys([]string{"a"}), failing)("b", c)
+	assert.Error(t, err)
+}
+
+func TestRequireScope(t *testing.T) {
+	handler := func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}
+
+	t.Run("return 403 (Forbidden) when the scope is missing", func(t *testing.T) {
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+		SetScopes(c, []string{ScopeExpensesRead})
+
+		got := RequireScope(ScopeExpensesWrite)(handler)(c).(*problem.Problem)
+
+		assert.Equal(t, http.StatusForbidden, got.Status)
+		assert.Equal(t, problem.CodeInsufficientScope, got.Code)
+	})
+
+	t.Run("call the handler when the scope is granted", func(t *testing.T) {
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+		SetScopes(c, []string{ScopeExpensesRead})
+
+		assert.NoError(t, RequireScope(ScopeExpensesRead)(handler)(c))
+	})
+}
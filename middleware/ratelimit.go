@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/panudetjt/assessment/config"
+	"github.com/panudetjt/assessment/problem"
+)
+
+// RateLimitStore keeps token buckets. Take removes one token from the bucket
+// for key if one is available.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, limit config.Limit, now time.Time) (Decision, error)
+}
+
+// Decision is the outcome of one Take.
+type Decision struct {
+	Allowed   bool
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until the next token, zero when allowed.
+	RetryAfter time.Duration
+}
+
+type RateLimitConfig struct {
+	Store   RateLimitStore
+	Default config.Limit
+	// Routes overrides Default, keyed by "METHOD /path" as registered.
+	Routes map[string]config.Limit
+	// Now defaults to time.Now.
+	Now func() time.Time
+}
+
+// RateLimit limits requests per route and caller. Callers are identified by
+// the authenticated Subject, falling back to the client IP, so it must run
+// after Authorization on protected routes. When the store fails the request is
+// let through rather than taking the API down with it.
+func RateLimit(cfg RateLimitConfig) echo.MiddlewareFunc {
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			route := c.Request().Method + " " + c.Path()
+			limit, ok := cfg.Routes[route]
+			if !ok {
+				limit = cfg.Default
+			}
+
+			caller := Subject(c)
+			if caller == "" {
+				caller = "ip:" + c.RealIP()
+			}
+			return take(c, cfg.Store, route+" "+caller, limit, cfg.Now(), next)
+		}
+	}
+}
+
+// IPRateLimit limits every request, whatever its route, by client IP with one
+// bucket per IP. It runs before Authorization so that anonymous requests and
+// guessed keys are limited too; limit should be loose enough for the clients
+// sharing an address behind NAT.
+func IPRateLimit(store RateLimitStore, limit config.Limit, now func() time.Time) echo.MiddlewareFunc {
+	if now == nil {
+		now = time.Now
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			return take(c, store, "ip:"+c.RealIP(), limit, now(), next)
+		}
+	}
+}
+
+// take takes a token from the bucket for key, setting the RateLimit headers,
+// and calls next if one was available.
+func take(c echo.Context, store RateLimitStore, key string, limit config.Limit, now time.Time, next echo.HandlerFunc) error {
+	d, err := store.Take(c.Request().Context(), key, limit, now)
+	if err != nil {
+		c.Logger().Error("rate limit store: ", err)
+		return next(c)
+	}
+
+	h := c.Response().Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+	h.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(seconds(d.Reset)))
+	if !d.Allowed {
+		h.Set("Retry-After", strconv.Itoa(seconds(d.RetryAfter)))
+		return problem.New(http.StatusTooManyRequests, problem.CodeRateLimited, "too many requests, retry later")
+	}
+	return next(c)
+}
+
+// seconds rounds d up so clients never retry too early.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// bucket is a token bucket refilled continuously at limit.Rate per second.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func fullBucket(limit config.Limit, now time.Time) bucket {
+	return bucket{tokens: float64(limit.Burst), updated: now}
+}
+
+func (b *bucket) take(limit config.Limit, now time.Time) Decision {
+	b.refill(limit, now)
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	return b.decision(limit, allowed)
+}
+
+func (b *bucket) refill(limit config.Limit, now time.Time) {
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*limit.Rate)
+		b.updated = now
+	}
+}
+
+// decision describes b once a token was taken from it, or not.
+func (b *bucket) decision(limit config.Limit, allowed bool) Decision {
+	d := Decision{Allowed: allowed}
+	if !allowed {
+		d.RetryAfter = secondsDuration((1 - b.tokens) / limit.Rate)
+	}
+	d.Remaining = int(b.tokens)
+	d.Reset = secondsDuration((float64(limit.Burst) - b.tokens) / limit.Rate)
+	return d
+}
+
+func secondsDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
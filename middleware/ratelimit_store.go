@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/panudetjt/assessment/config"
+)
+
+// MemoryStore keeps buckets in process memory. Use it for a single instance.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+	swept   time.Time
+}
+
+// memoryBucket is a bucket and when it is full again if left alone.
+type memoryBucket struct {
+	bucket
+	full time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*memoryBucket{}}
+}
+
+// sweepInterval is how often full buckets are dropped. A full bucket is the
+// same as a missing one, so dropping it changes nothing, whatever the limit.
+const sweepInterval = time.Minute
+
+func (s *MemoryStore) Take(_ context.Context, key string, limit config.Limit, now time.Time) (Decision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.swept) > sweepInterval {
+		for k, b := range s.buckets {
+			if !now.Before(b.full) {
+				delete(s.buckets, k)
+			}
+		}
+		s.swept = now
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{bucket: fullBucket(limit, now)}
+		s.buckets[key] = b
+	}
+	d := b.take(limit, now)
+	b.full = now.Add(d.Reset)
+	return d, nil
+}
+
+// PostgresStore keeps buckets in the rate_limits table so every instance
+// behind a load balancer shares them. Like MemoryStore, it drops the buckets
+// that are full again every sweepInterval.
+type PostgresStore struct {
+	DB *sql.DB
+
+	mu    sync.Mutex
+	swept time.Time
+}
+
+// refilled is the bucket of a rate_limits row refilled up to $3, with $2 the
+// burst and $4 the rate.
+const refilled = "LEAST($2::float8, r.tokens + GREATEST(extract(epoch FROM $3::timestamptz - r.updated_at)::float8, 0) * $4::float8)"
+
+// takeToken takes a token from the bucket of $1 in one statement, creating it
+// full. A bucket without a token is left as it is and no row is returned.
+const takeToken = "INSERT INTO rate_limits AS r (key, tokens, updated_at, full_at) " +
+	"VALUES ($1, $2::float8 - 1, $3::timestamptz, $3::timestamptz + make_interval(secs => 1 / $4::float8)) " +
+	"ON CONFLICT (key) DO UPDATE SET tokens = " + refilled + " - 1, updated_at = GREATEST(r.updated_at, $3::timestamptz), " +
+	"full_at = GREATEST(r.updated_at, $3::timestamptz) + make_interval(secs => ($2::float8 - " + refilled + " + 1) / $4::float8) " +
+	"WHERE " + refilled + " >= 1 RETURNING tokens, updated_at"
+
+func (s *PostgresStore) Take(ctx context.Context, key string, limit config.Limit, now time.Time) (Decision, error) {
+	if err := s.sweep(ctx, now); err != nil {
+		return Decision{}, err
+	}
+
+	var b bucket
+	err := s.DB.QueryRowContext(ctx, takeToken, key, float64(limit.Burst), now, limit.Rate).Scan(&b.tokens, &b.updated)
+	if err == nil {
+		return b.decision(limit, true), nil
+	}
+	if err != sql.ErrNoRows {
+		return Decision{}, fmt.Errorf("can't take a rate limit token: %w", err)
+	}
+
+	// Denied: the bucket is only read to tell when to retry.
+	err = s.DB.QueryRowContext(ctx, "SELECT tokens, updated_at FROM rate_limits WHERE key = $1", key).Scan(&b.tokens, &b.updated)
+	if err != nil {
+		return Decision{}, fmt.Errorf("can't read rate limit bucket: %w", err)
+	}
+	b.refill(limit, now)
+	return b.decision(limit, false), nil
+}
+
+// sweep deletes the buckets that are full again, at most every sweepInterval.
+func (s *PostgresStore) sweep(ctx context.Context, now time.Time) error {
+	s.mu.Lock()
+	if now.Sub(s.swept) <= sweepInterval {
+		s.mu.Unlock()
+		return nil
+	}
+	s.swept = now
+	s.mu.Unlock()
+
+	if _, err := s.DB.ExecContext(ctx, "DELETE FROM rate_limits WHERE full_at <= $1", now); err != nil {
+		return fmt.Errorf("can't sweep rate limit buckets: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/panudetjt/assessment/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostgresStore(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	limit := config.Limit{Rate: 1, Burst: 5}
+
+	t.Run("should take a token in a single statement", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		mock.ExpectExec("DELETE FROM rate_limits WHERE full_at <= \\$1").WithArgs(now).WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectQuery("INSERT INTO rate_limits AS r (.+) ON CONFLICT \\(key\\) DO UPDATE SET (.+) RETURNING tokens, updated_at").WithArgs("k", 5.0, now, 1.0).
+			WillReturnRows(sqlmock.NewRows([]string{"tokens", "updated_at"}).AddRow(0.5, now))
+
+		d, err := (&PostgresStore{DB: db}).Take(context.Background(), "k", limit, now)
+
+		assert.NoError(t, err)
+		assert.True(t, d.Allowed)
+		assert.Equal(t, 0, d.Remaining)
+		assert.Equal(t, 4500*time.Millisecond, d.Reset)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should tell when to retry once the bucket is empty", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		mock.ExpectQuery("INSERT INTO rate_limits").WillReturnRows(sqlmock.NewRows([]string{"tokens", "updated_at"}))
+		mock.ExpectQuery("SELECT tokens, updated_at FROM rate_limits WHERE key = \\$1").WithArgs("k").
+			WillReturnRows(sqlmock.NewRows([]string{"tokens", "updated_at"}).AddRow(0.25, now.Add(-250*time.Millisecond)))
+
+		d, err := (&PostgresStore{DB: db, swept: now}).Take(context.Background(), "k", limit, now)
+
+		assert.NoError(t, err)
+		assert.False(t, d.Allowed)
+		assert.Equal(t, 500*time.Millisecond, d.RetryAfter)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return the error when the token can't be taken", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		mock.ExpectQuery("INSERT INTO rate_limits").WillReturnError(context.DeadlineExceeded)
+
+		_, err := (&PostgresStore{DB: db, swept: now}).Take(context.Background(), "k", limit, now)
+
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should sweep full buckets every sweepInterval", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		taken := func() *sqlmock.Rows { return sqlmock.NewRows([]string{"tokens", "updated_at"}).AddRow(4, now) }
+		mock.ExpectExec("DELETE FROM rate_limits").WithArgs(now).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("INSERT INTO rate_limits").WillReturnRows(taken())
+		mock.ExpectQuery("INSERT INTO rate_limits").WillReturnRows(taken())
+		mock.ExpectExec("DELETE FROM rate_limits").WithArgs(now.Add(2 * sweepInterval)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("INSERT INTO rate_limits").WillReturnRows(taken())
+		store := &PostgresStore{DB: db}
+
+		for _, at := range []time.Time{now, now.Add(sweepInterval / 2), now.Add(2 * sweepInterval)} {
+			_, err := store.Take(context.Background(), "k", limit, at)
+			assert.NoError(t, err)
+		}
+
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestMemoryStoreEvictsFullBuckets(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	// Refills in 20 minutes.
+	limit := config.Limit{Rate: 0.01, Burst: 12}
+
+	for i := 0; i < 12; i++ {
+		store.Take(context.Background(), "old", limit, now)
+	}
+	store.Take(context.Background(), "new", limit, now.Add(15*time.Minute))
+
+	assert.Contains(t, store.buckets, "old", "a bucket still refilling must be kept")
+	d, _ := store.Take(context.Background(), "old", limit, now.Add(15*time.Minute))
+	assert.Equal(t, 8, d.Remaining)
+
+	store.Take(context.Background(), "new", limit, now.Add(40*time.Minute))
+
+	assert.NotContains(t, store.buckets, "old")
+}
@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/panudetjt/assessment/config"
+	"github.com/panudetjt/assessment/problem"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingStore struct{}
+
+func (failingStore) Take(context.Context, string, config.Limit, time.Time) (Decision, error) {
+	return Decision{}, errors.New("down")
+}
+
+func TestRateLimit(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	handler := func(c echo.Context) error {
+		return c.String(http.StatusOK, "test")
+	}
+	serve := func(mw echo.MiddlewareFunc, method, path, ip, subject string) (*httptest.ResponseRecorder, error) {
+		e := echo.New()
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath(path)
+		if subject != "" {
+			SetSubject(c, subject)
+		}
+		return rec, mw(handler)(c)
+	}
+	limiter := func(routes map[string]config.Limit) echo.MiddlewareFunc {
+		return RateLimit(RateLimitConfig{
+			Store:   NewMemoryStore(),
+			Default: config.Limit{Rate: 1, Burst: 2},
+			Routes:  routes,
+			Now:     func() time.Time { return now },
+		})
+	}
+
+	t.Run("should allow the burst then return 429 with Retry-After", func(t *testing.T) {
+		mw := limiter(nil)
+
+		rec, err := serve(mw, http.MethodGet, "/expenses", "10.0.0.1", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "2", rec.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, "1", rec.Header().Get("RateLimit-Remaining"))
+		_, err = serve(mw, http.MethodGet, "/expenses", "10.0.0.1", "")
+		assert.NoError(t, err)
+
+		rec, err = serve(mw, http.MethodGet, "/expenses", "10.0.0.1", "")
+		p, ok := err.(*problem.Problem)
+		if assert.True(t, ok) {
+			assert.Equal(t, http.StatusTooManyRequests, p.Status)
+			assert.Equal(t, problem.CodeRateLimited, p.Code)
+		}
+		assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+		assert.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, "2", rec.Header().Get("RateLimit-Reset"))
+	})
+
+	t.Run("should refill over time", func(t *testing.T) {
+		store := NewMemoryStore()
+		limit := config.Limit{Rate: 1, Burst: 1}
+
+		d, _ := store.Take(context.Background(), "k", limit, now)
+		assert.True(t, d.Allowed)
+		d, _ = store.Take(context.Background(), "k", limit, now.Add(500*time.Millisecond))
+		assert.False(t, d.Allowed)
+		assert.Equal(t, 500*time.Millisecond, d.RetryAfter)
+		d, _ = store.Take(context.Background(), "k", limit, now.Add(time.Second))
+		assert.True(t, d.Allowed)
+	})
+
+	t.Run("should limit each subject and each IP separately", func(t *testing.T) {
+		mw := limiter(nil)
+		for i := 0; i < 2; i++ {
+			serve(mw, http.MethodGet, "/expenses", "10.0.0.1", "key:a")
+		}
+
+		_, err := serve(mw, http.MethodGet, "/expenses", "10.0.0.1", "key:a")
+		assert.Error(t, err)
+		_, err = serve(mw, http.MethodGet, "/expenses", "10.0.0.1", "key:b")
+		assert.NoError(t, err)
+		_, err = serve(mw, http.MethodGet, "/expenses", "10.0.0.1", "")
+		assert.NoError(t, err)
+	})
+
+	t.Run("should use per-route limits", func(t *testing.T) {
+		mw := limiter(map[string]config.Limit{"POST /expenses": {Rate: 1, Burst: 1}})
+
+		_, err := serve(mw, http.MethodPost, "/expenses", "10.0.0.1", "")
+		assert.NoError(t, err)
+		_, err = serve(mw, http.MethodPost, "/expenses", "10.0.0.1", "")
+		assert.Error(t, err)
+		_, err = serve(mw, http.MethodGet, "/expenses", "10.0.0.1", "")
+		assert.NoError(t, err)
+	})
+
+	t.Run("should let requests through when the store fails", func(t *testing.T) {
+		mw := RateLimit(RateLimitConfig{Store: failingStore{}, Default: config.Limit{Rate: 1, Burst: 1}})
+
+		rec, err := serve(mw, http.MethodGet, "/expenses", "10.0.0.1", "")
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("should limit by IP before authorization, across routes", func(t *testing.T) {
+		mw := IPRateLimit(NewMemoryStore(), config.Limit{Rate: 1, Burst: 2}, func() time.Time { return now })
+
+		_, err := serve(mw, http.MethodGet, "/health", "10.0.0.1", "")
+		assert.NoError(t, err)
+		_, err = serve(mw, http.MethodGet, "/expenses", "10.0.0.1", "")
+		assert.NoError(t, err)
+
+		rec, err := serve(mw, http.MethodGet, "/openapi.json", "10.0.0.1", "")
+		assert.Error(t, err)
+		assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+		_, err = serve(mw, http.MethodGet, "/health", "10.0.0.2", "")
+		assert.NoError(t, err)
+	})
+}
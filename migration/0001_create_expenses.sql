@@ -3,5 +3,5 @@ CREATE TABLE IF NOT EXISTS "expenses" (
     title TEXT,
     amount FLOAT,
     note TEXT,
-    tags TEXT[] 
-);
\ No newline at end of file
+    tags TEXT[]
+);
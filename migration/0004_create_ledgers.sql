@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS ledgers (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS ledger_members (
+    ledger_id INTEGER NOT NULL REFERENCES ledgers (id) ON DELETE CASCADE,
+    subject TEXT NOT NULL,
+    role TEXT NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (ledger_id, subject)
+);
+
+CREATE INDEX IF NOT EXISTS ledger_members_subject ON ledger_members (subject);
+
+CREATE TABLE IF NOT EXISTS ledger_invitations (
+    id SERIAL PRIMARY KEY,
+    ledger_id INTEGER NOT NULL REFERENCES ledgers (id) ON DELETE CASCADE,
+    role TEXT NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+    code_hash TEXT NOT NULL UNIQUE,
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    accepted_by TEXT,
+    accepted_at TIMESTAMPTZ
+);
+
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS ledger_id INTEGER REFERENCES ledgers (id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS expenses_ledger_id ON expenses (ledger_id);
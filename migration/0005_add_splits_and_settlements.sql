@@ -0,0 +1,13 @@
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS split JSONB;
+
+CREATE TABLE IF NOT EXISTS settlements (
+    id SERIAL PRIMARY KEY,
+    ledger_id INTEGER NOT NULL REFERENCES ledgers (id) ON DELETE CASCADE,
+    from_participant TEXT NOT NULL,
+    to_participant TEXT NOT NULL,
+    amount NUMERIC(12, 2) NOT NULL CHECK (amount > 0),
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS settlements_ledger_id ON settlements (ledger_id);
@@ -0,0 +1,25 @@
+-- expense_events is the audit log of every change to an expense. It has no
+-- foreign key so the history of a deleted expense is kept.
+CREATE TABLE IF NOT EXISTS expense_events (
+    id BIGSERIAL PRIMARY KEY,
+    expense_id INTEGER NOT NULL,
+    version INTEGER NOT NULL,
+    action TEXT NOT NULL,
+    actor TEXT NOT NULL DEFAULT '',
+    request_id TEXT NOT NULL DEFAULT '',
+    changes JSONB NOT NULL,
+    snapshot JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (expense_id, version)
+);
+
+CREATE OR REPLACE FUNCTION expense_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'expense_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS expense_events_append_only ON expense_events;
+CREATE TRIGGER expense_events_append_only
+    BEFORE UPDATE OR DELETE ON expense_events
+    FOR EACH ROW EXECUTE FUNCTION expense_events_append_only();
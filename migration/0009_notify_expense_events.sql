@@ -0,0 +1,13 @@
+-- Wake every server instance's event stream when an expense changes. The
+-- payload is the event id; listeners read the event from expense_events.
+CREATE OR REPLACE FUNCTION expense_events_notify() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('expense_events', NEW.id::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS expense_events_notify ON expense_events;
+CREATE TRIGGER expense_events_notify
+    AFTER INSERT ON expense_events
+    FOR EACH ROW EXECUTE FUNCTION expense_events_notify();
@@ -0,0 +1,14 @@
+-- search indexes the title and note of an expense for GET /expenses/search.
+-- The simple configuration neither stems nor drops stop words, so Thai, which
+-- isn't separated by spaces, stays whole; the trigram indexes match inside it
+-- and absorb typos.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', coalesce(title, '')), 'A') ||
+    setweight(to_tsvector('simple', coalesce(note, '')), 'B')
+) STORED;
+
+CREATE INDEX IF NOT EXISTS expenses_search ON expenses USING GIN (search);
+CREATE INDEX IF NOT EXISTS expenses_title_trgm ON expenses USING GIN (title gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS expenses_note_trgm ON expenses USING GIN (note gin_trgm_ops);
@@ -0,0 +1,14 @@
+-- Expenses record the currency they were paid in and the day they were
+-- spent; existing expenses are taken as baht spent today.
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT 'THB';
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS spent_on DATE NOT NULL DEFAULT CURRENT_DATE;
+
+-- exchange_rates holds the price of one unit of base in quote on a day.
+CREATE TABLE IF NOT EXISTS exchange_rates (
+    date DATE NOT NULL,
+    base TEXT NOT NULL,
+    quote TEXT NOT NULL,
+    rate DOUBLE PRECISION NOT NULL CHECK (rate > 0),
+    PRIMARY KEY (date, base, quote),
+    CHECK (base <> quote)
+);
@@ -0,0 +1,27 @@
+-- Merchants and payment methods belong to a ledger like expenses; only admin
+-- keys keep them outside ledgers. Names are unique per ledger.
+CREATE TABLE IF NOT EXISTS merchants (
+    id SERIAL PRIMARY KEY,
+    ledger_id INTEGER REFERENCES ledgers (id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS merchants_name ON merchants (coalesce(ledger_id, 0), lower(name));
+
+CREATE TABLE IF NOT EXISTS payment_methods (
+    id SERIAL PRIMARY KEY,
+    ledger_id INTEGER REFERENCES ledgers (id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    type TEXT NOT NULL CHECK (type IN ('cash', 'credit_card', 'debit_card', 'e_wallet', 'bank_transfer')),
+    last4 TEXT CHECK (last4 ~ '^[0-9]{4}$'),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS payment_methods_name ON payment_methods (coalesce(ledger_id, 0), lower(name));
+
+-- A merchant or payment method still used by an expense can't be deleted.
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS merchant_id INTEGER REFERENCES merchants (id);
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS payment_method_id INTEGER REFERENCES payment_methods (id);
+CREATE INDEX IF NOT EXISTS expenses_merchant_id ON expenses (merchant_id);
+CREATE INDEX IF NOT EXISTS expenses_payment_method_id ON expenses (payment_method_id);
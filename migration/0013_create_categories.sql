@@ -0,0 +1,16 @@
+-- Categories form a tree per ledger through parent_id. Siblings have unique
+-- names, and a category with children or expenses can't be deleted.
+CREATE TABLE IF NOT EXISTS categories (
+    id SERIAL PRIMARY KEY,
+    ledger_id INTEGER REFERENCES ledgers (id) ON DELETE CASCADE,
+    parent_id INTEGER REFERENCES categories (id),
+    name TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CHECK (parent_id <> id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS categories_name ON categories (coalesce(ledger_id, 0), coalesce(parent_id, 0), lower(name));
+CREATE INDEX IF NOT EXISTS categories_parent_id ON categories (parent_id);
+
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS category_id INTEGER REFERENCES categories (id);
+CREATE INDEX IF NOT EXISTS expenses_category_id ON expenses (category_id);
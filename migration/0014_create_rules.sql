@@ -0,0 +1,20 @@
+-- Rules tag and categorize the expenses of their ledger as they are written.
+-- The conditions that are set must all match; the actions add tags and fill
+-- in the category and note. Empty patterns and notes are unset.
+CREATE TABLE IF NOT EXISTS rules (
+    id SERIAL PRIMARY KEY,
+    ledger_id INTEGER REFERENCES ledgers (id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0,
+    title_pattern TEXT NOT NULL DEFAULT '',
+    note_pattern TEXT NOT NULL DEFAULT '',
+    min_amount FLOAT,
+    max_amount FLOAT,
+    merchant_id INTEGER REFERENCES merchants (id),
+    add_tags TEXT[] NOT NULL DEFAULT '{}',
+    category_id INTEGER REFERENCES categories (id),
+    set_note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS rules_ledger_id ON rules (ledger_id, position, id);
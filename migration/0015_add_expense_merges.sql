@@ -0,0 +1,7 @@
+-- Merging a duplicate soft-deletes it: the row stays, pointing at the expense
+-- it was merged into, but reads skip it. Deleting that expense deletes it too.
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS merged_into INTEGER REFERENCES expenses (id) ON DELETE CASCADE;
+
+-- Duplicate candidates share the amount and are spent within a few days.
+CREATE INDEX IF NOT EXISTS expenses_duplicates ON expenses (amount, spent_on) WHERE deleted_at IS NULL;
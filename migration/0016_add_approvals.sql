@@ -0,0 +1,22 @@
+-- Approvers approve, reject and reimburse submitted expenses, on top of what
+-- editors do.
+ALTER TABLE ledger_members DROP CONSTRAINT IF EXISTS ledger_members_role_check;
+ALTER TABLE ledger_members ADD CONSTRAINT ledger_members_role_check CHECK (role IN ('owner', 'approver', 'editor', 'viewer'));
+ALTER TABLE ledger_invitations DROP CONSTRAINT IF EXISTS ledger_invitations_role_check;
+ALTER TABLE ledger_invitations ADD CONSTRAINT ledger_invitations_role_check CHECK (role IN ('owner', 'approver', 'editor', 'viewer'));
+
+-- Existing expenses start as drafts, editable as before.
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'draft'
+    CHECK (status IN ('draft', 'submitted', 'approved', 'rejected', 'reimbursed'));
+
+CREATE TABLE IF NOT EXISTS expense_transitions (
+    id SERIAL PRIMARY KEY,
+    expense_id INTEGER NOT NULL REFERENCES expenses (id) ON DELETE CASCADE,
+    from_status TEXT NOT NULL,
+    to_status TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    comment TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS expense_transitions_expense_id ON expense_transitions (expense_id, id);
@@ -0,0 +1,40 @@
+-- A closed month locks the expenses of its ledger spent in it. Reopening keeps
+-- the row, so closing again updates it.
+CREATE TABLE IF NOT EXISTS accounting_periods (
+    id SERIAL PRIMARY KEY,
+    ledger_id INTEGER REFERENCES ledgers (id) ON DELETE CASCADE,
+    month DATE NOT NULL CHECK (month = date_trunc('month', month)),
+    closed BOOLEAN NOT NULL DEFAULT true,
+    closed_by TEXT NOT NULL,
+    closed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    reopened_by TEXT,
+    reopened_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS accounting_periods_month ON accounting_periods ((COALESCE(ledger_id, 0)), month);
+
+-- period_overrides audits every change an admin forced into a closed period.
+-- Like expense_events it has no foreign keys and can't be changed.
+CREATE TABLE IF NOT EXISTS period_overrides (
+    id BIGSERIAL PRIMARY KEY,
+    period_id INTEGER NOT NULL,
+    expense_id INTEGER NOT NULL,
+    action TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    request_id TEXT NOT NULL DEFAULT '',
+    reason TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS period_overrides_period_id ON period_overrides (period_id, id);
+
+CREATE OR REPLACE FUNCTION period_overrides_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'period_overrides is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS period_overrides_append_only ON period_overrides;
+CREATE TRIGGER period_overrides_append_only
+    BEFORE UPDATE OR DELETE ON period_overrides
+    FOR EACH ROW EXECUTE FUNCTION period_overrides_append_only();
@@ -0,0 +1,16 @@
+-- A confirmed match of a bank statement line with the expense it paid, so the
+-- line isn't offered for review again. A line and an expense are reconciled
+-- at most once.
+CREATE TABLE IF NOT EXISTS reconciled_lines (
+    id SERIAL PRIMARY KEY,
+    ledger_id INTEGER REFERENCES ledgers (id) ON DELETE CASCADE,
+    line_id TEXT NOT NULL,
+    expense_id INTEGER NOT NULL UNIQUE REFERENCES expenses (id) ON DELETE CASCADE,
+    posted_on DATE NOT NULL,
+    amount FLOAT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    confirmed_by TEXT NOT NULL,
+    confirmed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS reconciled_lines_line_id ON reconciled_lines ((COALESCE(ledger_id, 0)), line_id);
@@ -0,0 +1,3 @@
+-- Settlements are repaid in a currency, so that balances, which are kept per
+-- currency, net them against the expenses in the same one.
+ALTER TABLE settlements ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT 'THB';
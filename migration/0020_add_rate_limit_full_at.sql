@@ -0,0 +1,4 @@
+-- full_at is when a bucket is full again if left alone. A full bucket is the
+-- same as a missing one, so the buckets past it are swept.
+ALTER TABLE rate_limits ADD COLUMN IF NOT EXISTS full_at TIMESTAMPTZ NOT NULL DEFAULT now();
+CREATE INDEX IF NOT EXISTS rate_limits_full_at ON rate_limits (full_at);
@@ -0,0 +1,4 @@
+-- Deleting an expense keeps the duplicates merged into it, still soft-deleted,
+-- instead of deleting them with it, so a merge can always be traced back.
+ALTER TABLE expenses DROP CONSTRAINT IF EXISTS expenses_merged_into_fkey;
+ALTER TABLE expenses ADD CONSTRAINT expenses_merged_into_fkey FOREIGN KEY (merged_into) REFERENCES expenses (id) ON DELETE SET NULL;
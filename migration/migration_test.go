@@ -0,0 +1,82 @@
+package migration
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAll(t *testing.T) {
+	ms, err := All()
+
+	assert.NoError(t, err)
+	assert.NotEmpty(t, ms)
+	assert.Equal(t, 1, ms[0].Version)
+	assert.Equal(t, "create_expenses", ms[0].Name)
+	for i := 1; i < len(ms); i++ {
+		assert.Less(t, ms[i-1].Version, ms[i].Version)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("should reject bad names", func(t *testing.T) {
+		_, err := load(fstest.MapFS{"create.sql": {Data: []byte("")}})
+
+		assert.ErrorContains(t, err, "<version>_<name>.sql")
+	})
+
+	t.Run("should reject duplicate versions", func(t *testing.T) {
+		_, err := load(fstest.MapFS{
+			"0002_a.sql": {Data: []byte("")},
+			"0002_b.sql": {Data: []byte("")},
+		})
+
+		assert.ErrorContains(t, err, "already used")
+	})
+}
+
+func TestUp(t *testing.T) {
+	ms := []Migration{
+		{Version: 1, Name: "create_expenses", SQL: "CREATE TABLE expenses ()"},
+		{Version: 2, Name: "add_index", SQL: "CREATE INDEX expenses_idx ON expenses (id)"},
+	}
+
+	t.Run("should apply only pending migrations", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		mock.ExpectExec("SELECT pg_advisory_lock").WithArgs(lockID).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT version FROM schema_migrations").
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(1))
+		mock.ExpectBegin()
+		mock.ExpectExec("CREATE INDEX expenses_idx").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO schema_migrations").WithArgs(2, "add_index").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectExec("SELECT pg_advisory_unlock").WithArgs(lockID).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		done, err := up(context.Background(), db, ms)
+
+		assert.NoError(t, err)
+		assert.Equal(t, ms[1:], done)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should roll back failed migration", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		mock.ExpectExec("SELECT pg_advisory_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT version FROM schema_migrations").WillReturnRows(sqlmock.NewRows([]string{"version"}))
+		mock.ExpectBegin()
+		mock.ExpectExec("CREATE TABLE expenses").WillReturnError(sqlmock.ErrCancelled)
+		mock.ExpectRollback()
+		mock.ExpectExec("SELECT pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		done, err := up(context.Background(), db, ms)
+
+		assert.ErrorContains(t, err, "migration 0001_create_expenses")
+		assert.Empty(t, done)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
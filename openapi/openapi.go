@@ -0,0 +1,236 @@
+package openapi
+
+import (
+	_ "embed"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/panudetjt/assessment/problem"
+)
+
+//go:embed docs.html
+var docsHTML []byte
+
+// Operation describes one route. Request and Response are sample values whose
+// types are reflected into JSON schemas.
+type Operation struct {
+	Method  string
+	Path    string
+	Summary string
+	Tag     string
+	Secured bool
+	// Scope is the scope the caller's key needs. It implies Secured.
+	Scope   string
+	Query   []Param
+	Request any
+	// Files names the file fields of a multipart/form-data request body.
+	Files []string
+	// Bodies names the media types of a request body sent as is, such as a
+	// file in a format of its own.
+	Bodies      []string
+	Response    any
+	ContentType string
+	Status      int
+	Errors      []int
+}
+
+// Param is a query string parameter.
+type Param struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+// Spec collects operations and renders them as an OpenAPI 3.1 document.
+type Spec struct {
+	Title   string
+	Version string
+
+	ops []Operation
+}
+
+func New(title, version string) *Spec {
+	return &Spec{Title: title, Version: version}
+}
+
+// Register adds op to the spec and routes it on e, so a route can't be served
+// without being documented.
+func (s *Spec) Register(e *echo.Echo, op Operation, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	s.ops = append(s.ops, op)
+	return e.Add(op.Method, op.Path, h, m...)
+}
+
+// Has reports whether the echo route method and path are documented.
+func (s *Spec) Has(method, path string) bool {
+	for _, op := range s.ops {
+		if op.Method == method && op.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// Document renders the OpenAPI document.
+func (s *Spec) Document() map[string]any {
+	b := &builder{schemas: map[string]map[string]any{}}
+	paths := map[string]map[string]any{}
+	for _, op := range s.ops {
+		path, params := convertPath(op.Path)
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(op.Method)] = b.operation(op, params)
+	}
+
+	schemas := map[string]any{}
+	for name, schema := range b.schemas {
+		schemas[name] = schema
+	}
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info":    map[string]any{"title": s.Title, "version": s.Version},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"apiKey": map[string]any{"type": "apiKey", "in": "header", "name": echo.HeaderAuthorization},
+			},
+		},
+	}
+}
+
+func (b *builder) operation(op Operation, params []any) map[string]any {
+	for _, q := range op.Query {
+		params = append(params, map[string]any{
+			"name":        q.Name,
+			"in":          "query",
+			"description": q.Description,
+			"schema":      map[string]any{"type": q.Type},
+		})
+	}
+
+	o := map[string]any{
+		"summary":     op.Summary,
+		"operationId": operationID(op),
+		"responses":   b.responses(op),
+	}
+	if op.Tag != "" {
+		o["tags"] = []string{op.Tag}
+	}
+	if len(params) > 0 {
+		o["parameters"] = params
+	}
+	if op.Request != nil {
+		o["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				echo.MIMEApplicationJSON: map[string]any{"schema": b.schemaOf(reflect.TypeOf(op.Request))},
+			},
+		}
+	}
+	if len(op.Files) > 0 {
+		props := map[string]any{}
+		for _, f := range op.Files {
+			props[f] = map[string]any{"type": "string", "format": "binary"}
+		}
+		o["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				echo.MIMEMultipartForm: map[string]any{"schema": map[string]any{"type": "object", "properties": props, "required": op.Files}},
+			},
+		}
+	}
+	if len(op.Bodies) > 0 {
+		content := map[string]any{}
+		for _, ct := range op.Bodies {
+			content[ct] = map[string]any{"schema": map[string]any{"type": "string"}}
+		}
+		o["requestBody"] = map[string]any{"required": true, "content": content}
+	}
+	if op.Secured || op.Scope != "" {
+		scopes := []string{}
+		if op.Scope != "" {
+			scopes = append(scopes, op.Scope)
+		}
+		o["security"] = []any{map[string]any{"apiKey": scopes}}
+	}
+	return o
+}
+
+func (b *builder) responses(op Operation) map[string]any {
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	ok := map[string]any{"description": http.StatusText(status)}
+	if op.Response != nil {
+		ct := op.ContentType
+		if ct == "" {
+			ct = echo.MIMEApplicationJSON
+		}
+		ok["content"] = map[string]any{ct: map[string]any{"schema": b.schemaOf(reflect.TypeOf(op.Response))}}
+	}
+
+	res := map[string]any{strconv.Itoa(status): ok}
+	errs := append([]int{}, op.Errors...)
+	if op.Secured || op.Scope != "" {
+		errs = append(errs, http.StatusBadRequest, http.StatusUnauthorized)
+	}
+	if op.Scope != "" {
+		errs = append(errs, http.StatusForbidden)
+	}
+	errs = append(errs, http.StatusInternalServerError)
+	for _, code := range errs {
+		res[strconv.Itoa(code)] = map[string]any{
+			"description": http.StatusText(code),
+			"content": map[string]any{
+				problem.ContentType: map[string]any{"schema": b.schemaOf(reflect.TypeOf(problem.Problem{}))},
+			},
+		}
+	}
+	return res
+}
+
+// convertPath turns echo's /expenses/:id into OpenAPI's /expenses/{id}.
+func convertPath(path string) (string, []any) {
+	var params []any
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		if strings.HasPrefix(seg, ":") {
+			name := seg[1:]
+			segments[i] = "{" + name + "}"
+			params = append(params, map[string]any{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "integer"},
+			})
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+func operationID(op Operation) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(op.Method))
+	for _, seg := range strings.FieldsFunc(op.Path, func(r rune) bool { return r == '/' || r == '-' || r == '.' }) {
+		if strings.HasPrefix(seg, ":") {
+			seg = "by" + strings.ToUpper(seg[1:2]) + seg[2:]
+		}
+		b.WriteString(strings.ToUpper(seg[:1]) + seg[1:])
+	}
+	return b.String()
+}
+
+// Handler serves the OpenAPI document as JSON.
+func (s *Spec) Handler(c echo.Context) error {
+	return c.JSON(http.StatusOK, s.Document())
+}
+
+// DocsHandler serves a Swagger UI page that renders /openapi.json.
+func DocsHandler(c echo.Context) error {
+	return c.HTMLBlob(http.StatusOK, docsHTML)
+}
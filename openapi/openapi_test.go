@@ -0,0 +1,147 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type item struct {
+	ID       int      `json:"id"`
+	Name     string   `json:"name"`
+	Tags     []string `json:"tags,omitempty"`
+	Internal string   `json:"-"`
+	hidden   string
+}
+
+func noop(c echo.Context) error { return nil }
+
+func TestRegister(t *testing.T) {
+	e := echo.New()
+	spec := New("test", "1")
+
+	spec.Register(e, Operation{Method: http.MethodGet, Path: "/items/:id", Response: item{}}, noop)
+
+	assert.True(t, spec.Has(http.MethodGet, "/items/:id"))
+	assert.False(t, spec.Has(http.MethodPut, "/items/:id"))
+	assert.Len(t, e.Routes(), 1)
+}
+
+func TestDocument(t *testing.T) {
+	spec := New("test", "1")
+	spec.Register(echo.New(), Operation{
+		Method:   http.MethodPut,
+		Path:     "/items/:id",
+		Summary:  "Update an item",
+		Secured:  true,
+		Request:  item{},
+		Response: item{},
+		Errors:   []int{http.StatusNotFound},
+	}, noop)
+
+	doc := spec.Document()
+	b, err := json.Marshal(doc)
+	assert.NoError(t, err)
+
+	var got struct {
+		OpenAPI string `json:"openapi"`
+		Paths   map[string]map[string]struct {
+			OperationID string           `json:"operationId"`
+			Parameters  []map[string]any `json:"parameters"`
+			Responses   map[string]any   `json:"responses"`
+			Security    []any            `json:"security"`
+		} `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]any `json:"properties"`
+				Required   []string       `json:"required"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	assert.NoError(t, json.Unmarshal(b, &got))
+
+	op := got.Paths["/items/{id}"]["put"]
+	assert.Equal(t, "3.1.0", got.OpenAPI)
+	assert.Equal(t, "putItemsById", op.OperationID)
+	assert.Equal(t, "id", op.Parameters[0]["name"])
+	assert.NotEmpty(t, op.Security)
+	for _, code := range []string{"200", "400", "401", "404", "500"} {
+		assert.Contains(t, op.Responses, code)
+	}
+
+	schema := got.Components.Schemas["item"]
+	assert.ElementsMatch(t, []string{"id", "name", "tags"}, keys(schema.Properties))
+	assert.ElementsMatch(t, []string{"id", "name"}, schema.Required)
+	assert.Contains(t, got.Components.Schemas, "Problem")
+	assert.Contains(t, got.Components.Schemas, "FieldError")
+}
+
+func TestDocumentScope(t *testing.T) {
+	spec := New("test", "1")
+	spec.Register(echo.New(), Operation{
+		Method: http.MethodDelete,
+		Path:   "/items/:id",
+		Scope:  "items:write",
+	}, noop)
+
+	op := spec.Document()["paths"].(map[string]map[string]any)["/items/{id}"]["delete"].(map[string]any)
+
+	assert.Equal(t, []any{map[string]any{"apiKey": []string{"items:write"}}}, op["security"])
+	for _, code := range []string{"400", "401", "403"} {
+		assert.Contains(t, op["responses"], code)
+	}
+}
+
+func TestDocumentFiles(t *testing.T) {
+	spec := New("test", "1")
+	spec.Register(echo.New(), Operation{
+		Method: http.MethodPost,
+		Path:   "/items/:id/files",
+		Files:  []string{"file"},
+	}, noop)
+
+	op := spec.Document()["paths"].(map[string]map[string]any)["/items/{id}/files"]["post"].(map[string]any)
+	content := op["requestBody"].(map[string]any)["content"].(map[string]any)
+
+	assert.Contains(t, content, echo.MIMEMultipartForm)
+}
+
+func TestDocumentBodies(t *testing.T) {
+	spec := New("test", "1")
+	spec.Register(echo.New(), Operation{
+		Method: http.MethodPost,
+		Path:   "/imports",
+		Bodies: []string{"text/csv", "application/x-ofx"},
+	}, noop)
+
+	op := spec.Document()["paths"].(map[string]map[string]any)["/imports"]["post"].(map[string]any)
+	content := op["requestBody"].(map[string]any)["content"].(map[string]any)
+
+	assert.ElementsMatch(t, []string{"text/csv", "application/x-ofx"}, keys(content))
+}
+
+func TestHandlers(t *testing.T) {
+	spec := New("test", "1")
+	e := echo.New()
+
+	rec := httptest.NewRecorder()
+	assert.NoError(t, spec.Handler(e.NewContext(httptest.NewRequest(http.MethodGet, "/openapi.json", nil), rec)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"openapi":"3.1.0"`)
+
+	rec = httptest.NewRecorder()
+	assert.NoError(t, DocsHandler(e.NewContext(httptest.NewRequest(http.MethodGet, "/docs", nil), rec)))
+	assert.Contains(t, rec.Body.String(), "/openapi.json")
+}
+
+func keys(m map[string]any) []string {
+	var ks []string
+	for k := range m {
+		ks = append(ks, k)
+	}
+	return ks
+}
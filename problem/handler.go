@@ -0,0 +1,80 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ErrorHandler renders every error returned by handlers and middleware as
+// application/problem+json. Install it as echo.Echo.HTTPErrorHandler.
+func ErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	p := From(err)
+	if p.cause != nil || p.Status >= http.StatusInternalServerError {
+		c.Logger().Error(err)
+	}
+
+	rendered := *p
+	rendered.Instance = c.Request().URL.Path
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(p.Status)
+	} else {
+		err = write(c, &rendered)
+	}
+	if err != nil {
+		c.Logger().Error(err)
+	}
+}
+
+// From converts any error into a Problem. Errors that aren't already problems
+// or echo HTTP errors become opaque internal errors.
+func From(err error) *Problem {
+	var p *Problem
+	if errors.As(err, &p) {
+		return p
+	}
+
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		return fromHTTPError(he)
+	}
+
+	return Internal(err)
+}
+
+func fromHTTPError(he *echo.HTTPError) *Problem {
+	switch he.Code {
+	case http.StatusNotFound:
+		return New(he.Code, CodeNotFound, "the requested resource does not exist")
+	case http.StatusMethodNotAllowed:
+		return New(he.Code, CodeMethodNotAllowed, "the method is not allowed for the requested resource")
+	case http.StatusUnauthorized:
+		return New(he.Code, CodeUnauthorized, "the credentials are not valid")
+	case http.StatusUnsupportedMediaType:
+		return New(he.Code, CodeUnsupportedMedia, "the request content type is not supported")
+	case http.StatusTooManyRequests:
+		return New(he.Code, CodeRateLimited, "too many requests, retry later")
+	case http.StatusBadRequest:
+		return New(he.Code, CodeInvalidBody, "the request could not be parsed")
+	}
+	if he.Code >= http.StatusInternalServerError {
+		return Internal(he)
+	}
+	code := strings.ReplaceAll(strings.ToLower(http.StatusText(he.Code)), " ", "_")
+	return New(he.Code, code, "")
+}
+
+func write(c echo.Context, p *Problem) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return c.Blob(p.Status, ContentType, b)
+}
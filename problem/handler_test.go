@@ -0,0 +1,84 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func serve(err error, method string) (*httptest.ResponseRecorder, Problem) {
+	e := echo.New()
+	e.Logger.SetOutput(io.Discard)
+	req := httptest.NewRequest(method, "/expenses/1", nil)
+	rec := httptest.NewRecorder()
+
+	ErrorHandler(err, e.NewContext(req, rec))
+
+	var p Problem
+	json.NewDecoder(rec.Body).Decode(&p)
+	return rec, p
+}
+
+func TestErrorHandler(t *testing.T) {
+	t.Run("should render problem as problem+json", func(t *testing.T) {
+		rec, p := serve(New(http.StatusNotFound, CodeExpenseNotFound, "expense 1 does not exist"), http.MethodGet)
+
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Equal(t, ContentType, rec.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, Problem{
+			Code:     CodeExpenseNotFound,
+			Title:    "Expense not found",
+			Status:   http.StatusNotFound,
+			Detail:   "expense 1 does not exist",
+			Instance: "/expenses/1",
+		}, p)
+	})
+
+	t.Run("should hide internal error details", func(t *testing.T) {
+		rec, p := serve(errors.New("pq: relation \"expenses\" does not exist"), http.MethodGet)
+
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Equal(t, CodeInternal, p.Code)
+		assert.NotContains(t, rec.Body.String(), "relation")
+	})
+
+	t.Run("should convert echo errors", func(t *testing.T) {
+		rec, p := serve(echo.ErrMethodNotAllowed, http.MethodGet)
+
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+		assert.Equal(t, CodeMethodNotAllowed, p.Code)
+	})
+
+	t.Run("should derive code for unmapped echo errors", func(t *testing.T) {
+		_, p := serve(echo.ErrRequestTimeout, http.MethodGet)
+
+		assert.Equal(t, "request_timeout", p.Code)
+	})
+
+	t.Run("should map too many requests to rate_limited", func(t *testing.T) {
+		rec, p := serve(echo.ErrTooManyRequests, http.MethodGet)
+
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		assert.Equal(t, CodeRateLimited, p.Code)
+	})
+
+	t.Run("should render validation errors", func(t *testing.T) {
+		rec, p := serve(Validation(FieldError{Field: "title", Message: "must not be empty"}), http.MethodGet)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		assert.Equal(t, []FieldError{{Field: "title", Message: "must not be empty"}}, p.Errors)
+	})
+
+	t.Run("should not write body for HEAD", func(t *testing.T) {
+		rec, _ := serve(New(http.StatusNotFound, CodeNotFound, ""), http.MethodHead)
+
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Empty(t, rec.Body.String())
+	})
+}
@@ -0,0 +1,150 @@
+package problem
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const ContentType = "application/problem+json"
+
+// Stable, machine-readable error codes. Clients may switch on them, so never
+// rename one; add a new code instead. Keep the README table in sync.
+const (
+	CodeInvalidBody          = "invalid_body"
+	CodeInvalidID            = "invalid_id"
+	CodeInvalidQuery         = "invalid_query"
+	CodeValidationFailed     = "validation_failed"
+	CodeMissingAuthorization = "missing_authorization"
+	CodeInvalidAuthorization = "invalid_authorization"
+	CodeUnauthorized         = "unauthorized"
+	CodeInsufficientScope    = "insufficient_scope"
+	CodeInsufficientRole     = "insufficient_role"
+	CodeSelfApproval         = "self_approval"
+	CodeExpenseNotFound      = "expense_not_found"
+	CodeAPIKeyNotFound       = "api_key_not_found"
+	CodeLedgerNotFound       = "ledger_not_found"
+	CodeMemberNotFound       = "member_not_found"
+	CodeInvitationNotFound   = "invitation_not_found"
+	CodeSettlementNotFound   = "settlement_not_found"
+	CodeAttachmentNotFound   = "attachment_not_found"
+	CodeVersionNotFound      = "version_not_found"
+	CodeWebhookNotFound      = "webhook_not_found"
+	CodeDeliveryNotFound     = "delivery_not_found"
+	CodeRateNotFound         = "exchange_rate_not_found"
+	CodeMerchantNotFound     = "merchant_not_found"
+	CodePaymentNotFound      = "payment_method_not_found"
+	CodeCategoryNotFound     = "category_not_found"
+	CodeRuleNotFound         = "rule_not_found"
+	CodePeriodNotFound       = "accounting_period_not_found"
+	CodeNameTaken            = "name_taken"
+	CodeInUse                = "in_use"
+	CodePossibleDuplicate    = "possible_duplicate"
+	CodeInvalidTransition    = "invalid_transition"
+	CodeNotEditable          = "expense_not_editable"
+	CodePeriodClosed         = "period_closed"
+	CodeAlreadyReconciled    = "already_reconciled"
+	CodeLastOwner            = "last_owner"
+	CodeAttachmentTooLarge   = "attachment_too_large"
+	CodeStatementTooLarge    = "statement_too_large"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeUnsupportedMedia     = "unsupported_media_type"
+	CodeRateLimited          = "rate_limited"
+	CodeInternal             = "internal_error"
+)
+
+var titles = map[string]string{
+	CodeInvalidBody:          "Invalid request body",
+	CodeInvalidID:            "Invalid identifier",
+	CodeInvalidQuery:         "Invalid query parameter",
+	CodeValidationFailed:     "Validation failed",
+	CodeMissingAuthorization: "Missing authorization",
+	CodeInvalidAuthorization: "Invalid authorization",
+	CodeUnauthorized:         "Unauthorized",
+	CodeInsufficientScope:    "Insufficient scope",
+	CodeInsufficientRole:     "Insufficient role",
+	CodeSelfApproval:         "Self approval",
+	CodeExpenseNotFound:      "Expense not found",
+	CodeAPIKeyNotFound:       "API key not found",
+	CodeLedgerNotFound:       "Ledger not found",
+	CodeMemberNotFound:       "Member not found",
+	CodeInvitationNotFound:   "Invitation not found",
+	CodeSettlementNotFound:   "Settlement not found",
+	CodeAttachmentNotFound:   "Attachment not found",
+	CodeVersionNotFound:      "Version not found",
+	CodeWebhookNotFound:      "Webhook not found",
+	CodeDeliveryNotFound:     "Delivery not found",
+	CodeRateNotFound:         "Exchange rate not found",
+	CodeMerchantNotFound:     "Merchant not found",
+	CodePaymentNotFound:      "Payment method not found",
+	CodeCategoryNotFound:     "Category not found",
+	CodeRuleNotFound:         "Rule not found",
+	CodePeriodNotFound:       "Accounting period not found",
+	CodeNameTaken:            "Name taken",
+	CodeInUse:                "Still in use",
+	CodePossibleDuplicate:    "Possible duplicate",
+	CodeInvalidTransition:    "Invalid status transition",
+	CodeNotEditable:          "Expense not editable",
+	CodePeriodClosed:         "Period closed",
+	CodeAlreadyReconciled:    "Already reconciled",
+	CodeLastOwner:            "Last owner",
+	CodeAttachmentTooLarge:   "Attachment too large",
+	CodeStatementTooLarge:    "Statement too large",
+	CodeNotFound:             "Not found",
+	CodeMethodNotAllowed:     "Method not allowed",
+	CodeUnsupportedMedia:     "Unsupported media type",
+	CodeRateLimited:          "Too many requests",
+	CodeInternal:             "Internal server error",
+}
+
+// Problem is an RFC 7807 problem details object.
+type Problem struct {
+	Type     string       `json:"type,omitempty"`
+	Code     string       `json:"code"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+
+	// cause is logged by the error handler but never rendered.
+	cause error
+}
+
+// FieldError describes one invalid field of a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func New(status int, code, detail string) *Problem {
+	title, ok := titles[code]
+	if !ok {
+		title = http.StatusText(status)
+	}
+	return &Problem{Code: code, Title: title, Status: status, Detail: detail}
+}
+
+// Internal hides err from the client behind a generic 500 problem.
+func Internal(err error) *Problem {
+	p := New(http.StatusInternalServerError, CodeInternal, "an unexpected error occurred")
+	p.cause = err
+	return p
+}
+
+func Validation(errs ...FieldError) *Problem {
+	p := New(http.StatusUnprocessableEntity, CodeValidationFailed, "one or more fields are invalid")
+	p.Errors = errs
+	return p
+}
+
+func (p *Problem) Error() string {
+	if p.cause != nil {
+		return fmt.Sprintf("%s: %s: %v", p.Code, p.Detail, p.cause)
+	}
+	return fmt.Sprintf("%s: %s", p.Code, p.Detail)
+}
+
+func (p *Problem) Unwrap() error {
+	return p.cause
+}
@@ -2,47 +2,142 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"sort"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/api"
 	"github.com/panudetjt/assessment/expense"
-	"github.com/panudetjt/assessment/health"
-	m "github.com/panudetjt/assessment/middleware"
+	"github.com/panudetjt/assessment/migration"
+	"github.com/panudetjt/assessment/storage"
+	"github.com/panudetjt/assessment/webhook"
 )
 
+type command struct {
+	summary string
+	run     func(args []string) error
+}
+
+var commands = map[string]command{
+	"serve":        {"run the HTTP API (default)", serve},
+	"migrate":      {"apply pending database migrations", migrate},
+	"seed":         {"insert fake expenses for demos and load tests", seed},
+	"export":       {"write every expense to a JSON file", export},
+	"import":       {"load expenses from a JSON file written by export", importExpenses},
+	"create-token": {"create an API key in the database", createToken},
+	"check-config": {"validate the configuration and database connection", checkConfig},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [command] [flags]\n\ncommands:\n", os.Args[0])
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-13s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nrun '%s <command> --help' for its flags\n", os.Args[0])
+}
+
 func main() {
-	port := os.Getenv("PORT")
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && (args[0] == "help" || args[0] == "-h" || args[0] == "--help") {
+		usage()
+		return
+	}
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
 
-	db, err := expense.InitDB("postgres")
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) || errors.Is(err, errPrinted) {
+			return
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func serve(args []string) error {
+	cfg, err := loadConfig("serve", args, nil)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	e := echo.New()
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	if cfg.Database.AutoMigrate {
+		if _, err := migration.Up(context.Background(), db); err != nil {
+			return err
+		}
+	}
 
-	e.Use(middleware.Logger())
-	e.Use(middleware.Recover())
+	blobs, err := storage.New(cfg.Storage)
+	if err != nil {
+		return err
+	}
 
-	e.GET("/health", health.HealthHandler)
+	eh := &expense.Handler{
+		DB:            db,
+		Blobs:         blobs,
+		Limits:        expense.AttachmentLimits{MaxSize: int64(cfg.Attachments.MaxSize), Types: cfg.Attachments.Types},
+		Stream:        expense.NewBroker(db),
+		StreamTimeout: cfg.Server.StreamTimeout,
+		Duplicates: expense.DuplicatePolicy{
+			Warn:       cfg.Duplicates.Warn,
+			WindowDays: cfg.Duplicates.WindowDays,
+			Similarity: cfg.Duplicates.Similarity,
+		},
+	}
+	e := api.New(cfg, eh)
 
-	eh := &expense.Handler{DB: db}
+	listener := pq.NewListener(cfg.Database.URL, time.Second, time.Minute, nil)
+	if err := listener.Listen("expense_events"); err != nil {
+		return fmt.Errorf("can't listen for expense events: %w", err)
+	}
+	listening, stopListening := context.WithCancel(context.Background())
+	go eh.Stream.Listen(listening, listener.Notify, e.Logger)
 
-	e.GET("/expenses", eh.GetAllExpenseHandler, m.Authorization(func(s string, ctx echo.Context) (bool, error) {
-		return s == "November 10, 2009", nil
-	}))
-	e.POST("/expenses", eh.CreateExpensesHandler)
-	e.GET("/expenses/:id", eh.GetExpenseByIdHandler)
-	e.PUT("/expenses/:id", eh.UpdateExpensesHandler)
+	dispatching, stopDispatching := context.WithCancel(context.Background())
+	dispatched := make(chan struct{})
+	go func() {
+		defer close(dispatched)
+		if !cfg.Webhooks.Enabled {
+			return
+		}
+		d := &webhook.Dispatcher{
+			DB:           db,
+			Client:       &http.Client{},
+			Logger:       e.Logger,
+			PollInterval: cfg.Webhooks.PollInterval,
+			Timeout:      cfg.Webhooks.Timeout,
+			MaxAttempts:  cfg.Webhooks.MaxAttempts,
+			Backoff:      cfg.Webhooks.Backoff,
+		}
+		d.Run(dispatching)
+	}()
 
 	go func() {
-		e.Logger.Info("Server started at ", port)
-		if err := e.Start(port); err != nil && err != http.ErrServerClosed {
+		e.Logger.Info("Server started at ", cfg.Port)
+		if err := e.Start(cfg.Port); err != nil && err != http.ErrServerClosed {
 			e.Logger.Fatal("shutting down the server")
 		}
 	}()
@@ -51,16 +146,32 @@ func main() {
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 	<-shutdown
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
 	defer cancel()
 
-	e.Logger.Info("closing the database connection")
-	if err := db.Close(); err != nil {
-		e.Logger.Fatal(err)
-	}
+	// Event streams never end on their own, so they are closed as soon as
+	// the server stops accepting connections rather than after Shutdown has
+	// waited them out.
+	e.Server.RegisterOnShutdown(eh.Stream.Close)
 	e.Logger.Info("shutting down the server")
 	if err := e.Shutdown(ctx); err != nil {
+		e.Logger.Error(err)
+	}
+	stopListening()
+	if err := listener.Close(); err != nil {
+		e.Logger.Error(err)
+	}
+	e.Logger.Info("stopping the webhook dispatcher")
+	stopDispatching()
+	<-dispatched
+	e.Logger.Info("closing prepared statements")
+	if err := eh.Close(); err != nil {
+		e.Logger.Error(err)
+	}
+	e.Logger.Info("closing the database connection")
+	if err := db.Close(); err != nil {
 		e.Logger.Fatal(err)
 	}
 	e.Logger.Info("bye bye!")
+	return nil
 }
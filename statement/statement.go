@@ -0,0 +1,171 @@
+// Package statement reads the bank statements expenses are reconciled
+// against, exported as CSV or OFX.
+package statement
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/panudetjt/assessment/date"
+)
+
+// Line is one payment of a statement. ID identifies it across uploads of the
+// same statement: the bank's transaction id when it has one, a fingerprint of
+// the line otherwise.
+type Line struct {
+	ID          string    `json:"id"`
+	PostedOn    date.Date `json:"posted_on"`
+	Amount      float64   `json:"amount"`
+	Description string    `json:"description"`
+}
+
+var csvColumns = []string{"date", "description", "amount"}
+
+// ParseCSV reads the debits of CSV with a date, description and amount header,
+// in any order, and an optional id column; other columns are ignored. Debits
+// are negative amounts, as in OFX, or positive ones with positiveDebits for
+// banks that write them so. Credits and lines of zero are skipped. It stops
+// after max+1 debits, so that a longer statement is told apart without being
+// read whole.
+func ParseCSV(r io.Reader, positiveDebits bool, max int) ([]Line, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("the file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	index := map[string]int{}
+	for i, h := range header {
+		index[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, c := range csvColumns {
+		if _, ok := index[c]; !ok {
+			return nil, fmt.Errorf("the header has no %s column", c)
+		}
+	}
+	id, hasID := index["id"]
+
+	var lines []Line
+	for n := 2; ; n++ {
+		rec, err := cr.Read()
+		if err == io.EOF || len(lines) > max {
+			return fingerprint(lines), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var l Line
+		if l.PostedOn, err = date.Parse(strings.TrimSpace(rec[index["date"]])); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		if l.Amount, err = parseAmount(rec[index["amount"]]); err != nil {
+			return nil, fmt.Errorf("line %d: amount must be a number", n)
+		}
+		if !positiveDebits {
+			l.Amount = -l.Amount
+		}
+		if l.Amount <= 0 {
+			continue
+		}
+		l.Description = strings.TrimSpace(rec[index["description"]])
+		if hasID {
+			l.ID = strings.TrimSpace(rec[id])
+		}
+		lines = append(lines, l)
+	}
+}
+
+var (
+	ofxTransaction = regexp.MustCompile(`(?is)<STMTTRN>(.*?)</STMTTRN>`)
+	ofxField       = regexp.MustCompile(`<(\w+)>([^<\r\n]*)`)
+)
+
+// ParseOFX reads the debits of an OFX statement, SGML or XML. The bank's
+// FITID identifies each line, and its description is the NAME then the MEMO.
+// Like ParseCSV, it stops after max+1 debits.
+func ParseOFX(r io.Reader, max int) ([]Line, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(strings.ToUpper(string(b)), "<OFX>") {
+		return nil, errors.New("the file has no OFX element")
+	}
+
+	var lines []Line
+	for n := 0; len(lines) <= max; n++ {
+		m := ofxTransaction.FindSubmatchIndex(b)
+		if m == nil {
+			break
+		}
+		trn := b[m[2]:m[3]]
+		b = b[m[1]:]
+		fields := map[string]string{}
+		for _, f := range ofxField.FindAllSubmatch(trn, -1) {
+			fields[strings.ToUpper(string(f[1]))] = strings.TrimSpace(html.UnescapeString(string(f[2])))
+		}
+		posted := fields["DTPOSTED"]
+		if len(posted) > 8 {
+			posted = posted[:8]
+		}
+		t, err := time.Parse("20060102", posted)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %d: DTPOSTED must be a YYYYMMDD date", n+1)
+		}
+		amount, err := parseAmount(fields["TRNAMT"])
+		if err != nil {
+			return nil, fmt.Errorf("transaction %d: TRNAMT must be a number", n+1)
+		}
+		if amount >= 0 {
+			continue
+		}
+		lines = append(lines, Line{
+			ID:          fields["FITID"],
+			PostedOn:    date.Of(t),
+			Amount:      -amount,
+			Description: strings.TrimSpace(fields["NAME"] + " " + fields["MEMO"]),
+		})
+	}
+	return fingerprint(lines), nil
+}
+
+// parseAmount reads an amount, ignoring thousands separators.
+func parseAmount(s string) (float64, error) {
+	s = strings.NewReplacer(",", "", " ", "").Replace(s)
+	v, err := strconv.ParseFloat(s, 64)
+	if err == nil && (math.IsInf(v, 0) || math.IsNaN(v)) {
+		err = errors.New("not a finite number")
+	}
+	return v, err
+}
+
+// fingerprint gives the lines without an id one derived from their day,
+// amount, description and how many identical lines precede them, so that
+// uploading the statement again gives the same ids.
+func fingerprint(lines []Line) []Line {
+	seen := map[string]int{}
+	for i := range lines {
+		l := &lines[i]
+		if l.ID != "" {
+			continue
+		}
+		key := fmt.Sprintf("%s|%.2f|%s", l.PostedOn, l.Amount, l.Description)
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d", key, seen[key])))
+		seen[key]++
+		l.ID = hex.EncodeToString(sum[:16])
+	}
+	return lines
+}
@@ -0,0 +1,135 @@
+package statement
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/panudetjt/assessment/date"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCSV(t *testing.T) {
+	t.Run("should read lines with the header in any order", func(t *testing.T) {
+		lines, err := ParseCSV(strings.NewReader("Amount,Date,Description,Balance\n\"-1,200.50\",2023-01-02,GRAB*TAXI BKK,100\n0,2023-01-03,fee waived,100\n"), false, 100)
+		day, _ := date.Parse("2023-01-02")
+
+		assert.NoError(t, err)
+		assert.Len(t, lines, 1)
+		assert.Equal(t, day, lines[0].PostedOn)
+		assert.Equal(t, 1200.50, lines[0].Amount)
+		assert.Equal(t, "GRAB*TAXI BKK", lines[0].Description)
+		assert.Len(t, lines[0].ID, 32)
+	})
+
+	t.Run("should skip credits", func(t *testing.T) {
+		csv := "date,description,amount\n2023-01-02,coffee,-60\n2023-01-03,refund,60\n"
+
+		debits, err := ParseCSV(strings.NewReader(csv), false, 100)
+		assert.NoError(t, err)
+		assert.Len(t, debits, 1)
+		assert.Equal(t, "coffee", debits[0].Description)
+		assert.Equal(t, 60.0, debits[0].Amount)
+
+		debits, err = ParseCSV(strings.NewReader(csv), true, 100)
+		assert.NoError(t, err)
+		assert.Len(t, debits, 1)
+		assert.Equal(t, "refund", debits[0].Description)
+	})
+
+	t.Run("should give identical lines different but stable ids", func(t *testing.T) {
+		csv := "date,description,amount\n2023-01-02,coffee,-60\n2023-01-02,coffee,-60\n"
+		a, _ := ParseCSV(strings.NewReader(csv), false, 100)
+		b, _ := ParseCSV(strings.NewReader(csv), false, 100)
+
+		assert.NotEqual(t, a[0].ID, a[1].ID)
+		assert.Equal(t, a, b)
+	})
+
+	t.Run("should keep the id column", func(t *testing.T) {
+		lines, err := ParseCSV(strings.NewReader("id,date,description,amount\ntx-1,2023-01-02,coffee,-60\n"), false, 100)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "tx-1", lines[0].ID)
+	})
+
+	t.Run("should report a missing column", func(t *testing.T) {
+		_, err := ParseCSV(strings.NewReader("date,amount\n"), false, 100)
+
+		assert.EqualError(t, err, "the header has no description column")
+	})
+
+	t.Run("should stop after max+1 debits", func(t *testing.T) {
+		csv := "date,description,amount\n2023-01-02,coffee,-60\n2023-01-02,refund,60\n2023-01-03,tea,-40\n2023-01-04,cake,-90\n2023-01-05,never read,sixty\n"
+
+		lines, err := ParseCSV(strings.NewReader(csv), false, 2)
+
+		assert.NoError(t, err)
+		assert.Len(t, lines, 3)
+	})
+
+	t.Run("should report the line of a bad amount", func(t *testing.T) {
+		_, err := ParseCSV(strings.NewReader("date,description,amount\n2023-01-02,coffee,sixty\n"), false, 100)
+
+		assert.EqualError(t, err, "line 2: amount must be a number")
+	})
+}
+
+func TestParseOFX(t *testing.T) {
+	t.Run("should read the debits of an SGML statement", func(t *testing.T) {
+		ofx := `OFXHEADER:100
+<OFX><BANKMSGSRSV1><STMTTRNRS><STMTRS><BANKTRANLIST>
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20230102120000[+7:ICT]
+<TRNAMT>-79.00
+<FITID>20230102-1
+<NAME>STARBUCKS &amp; CO
+<MEMO>card 1234
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>CREDIT
+<DTPOSTED>20230103
+<TRNAMT>5000.00
+<FITID>20230103-1
+<NAME>SALARY
+</STMTTRN>
+</BANKTRANLIST></STMTRS></STMTTRNRS></BANKMSGSRSV1></OFX>`
+		lines, err := ParseOFX(strings.NewReader(ofx), 100)
+		day, _ := date.Parse("2023-01-02")
+
+		assert.NoError(t, err)
+		assert.Equal(t, []Line{{ID: "20230102-1", PostedOn: day, Amount: 79, Description: "STARBUCKS & CO card 1234"}}, lines)
+	})
+
+	t.Run("should read an XML statement", func(t *testing.T) {
+		ofx := `<?xml version="1.0"?><OFX><STMTTRN><DTPOSTED>20230102</DTPOSTED><TRNAMT>-10</TRNAMT><NAME>bus</NAME></STMTTRN></OFX>`
+		lines, err := ParseOFX(strings.NewReader(ofx), 100)
+
+		assert.NoError(t, err)
+		assert.Len(t, lines, 1)
+		assert.Equal(t, "bus", lines[0].Description)
+		assert.NotEmpty(t, lines[0].ID)
+	})
+
+	t.Run("should stop after max+1 debits", func(t *testing.T) {
+		trn := "<STMTTRN><DTPOSTED>20230102<TRNAMT>-10<NAME>bus</STMTTRN>"
+		ofx := "<OFX>" + strings.Repeat(trn, 3) + "<STMTTRN><TRNAMT>-10</STMTTRN></OFX>"
+
+		lines, err := ParseOFX(strings.NewReader(ofx), 2)
+
+		assert.NoError(t, err)
+		assert.Len(t, lines, 3)
+	})
+
+	t.Run("should report a transaction without a date", func(t *testing.T) {
+		_, err := ParseOFX(strings.NewReader("<OFX><STMTTRN><TRNAMT>-10</STMTTRN></OFX>"), 100)
+
+		assert.EqualError(t, err, "transaction 1: DTPOSTED must be a YYYYMMDD date")
+	})
+
+	t.Run("should reject a file that isn't OFX", func(t *testing.T) {
+		_, err := ParseOFX(strings.NewReader("date,description,amount"), 100)
+
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocal(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should read back what was put", func(t *testing.T) {
+		l, _ := NewLocal(t.TempDir())
+
+		err := l.Put(ctx, "attachments/1/a", strings.NewReader("receipt"), 7, "text/plain")
+		assert.NoError(t, err)
+		f, err := l.Open(ctx, "attachments/1/a")
+		assert.NoError(t, err)
+		defer f.Close()
+		f.Seek(3, io.SeekStart)
+		b, _ := io.ReadAll(f)
+
+		assert.Equal(t, "eipt", string(b))
+	})
+
+	t.Run("should return ErrNotFound after delete", func(t *testing.T) {
+		l, _ := NewLocal(t.TempDir())
+		l.Put(ctx, "a", strings.NewReader("x"), 1, "text/plain")
+
+		assert.NoError(t, l.Delete(ctx, "a"))
+		assert.NoError(t, l.Delete(ctx, "a"))
+		_, err := l.Open(ctx, "a")
+
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("should reject keys outside the directory", func(t *testing.T) {
+		l, _ := NewLocal(t.TempDir())
+
+		err := l.Put(ctx, "../escape", strings.NewReader("x"), 1, "text/plain")
+
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,35 @@
+// Package storage keeps attachment contents out of the database, on the local
+// filesystem or in an S3-compatible bucket.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/panudetjt/assessment/config"
+)
+
+// ErrNotFound is returned by Open when no blob is stored under the key.
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore stores blobs under slash separated keys such as
+// "attachments/1/5f0c". Put replaces any blob already under the key and
+// Delete succeeds when there is nothing to delete.
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// New returns the store selected by cfg.Driver.
+func New(cfg config.Storage) (BlobStore, error) {
+	switch cfg.Driver {
+	case "local":
+		return NewLocal(cfg.Dir)
+	case "s3":
+		return NewS3(cfg.S3)
+	}
+	return nil, fmt.Errorf("unknown storage driver %q", cfg.Driver)
+}
@@ -1,5 +0,0 @@
-package util
-
-type Error struct {
-	Message string `json:"message"`
-}
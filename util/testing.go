@@ -9,6 +9,8 @@ import (
 	"strings"
 
 	"github.com/labstack/echo/v4"
+	"github.com/panudetjt/assessment/middleware"
+	"github.com/panudetjt/assessment/problem"
 )
 
 type HttpResponse struct {
@@ -33,6 +35,13 @@ func (r *Response) Decode(v any) error {
 	return json.NewDecoder(r.Recorder.Body).Decode(v)
 }
 
+// Serve runs h and renders any returned error the way the server does.
+func (r *Response) Serve(h echo.HandlerFunc) {
+	if err := h(r.Context); err != nil {
+		r.Context.Echo().HTTPErrorHandler(err, r.Context)
+	}
+}
+
 func Uri(paths ...string) string {
 	var host string
 	if h := os.Getenv("HOST"); h != "" {
@@ -62,6 +71,12 @@ func RequestE(method, url string, body io.Reader) *Response {
 	req := httptest.NewRequest(method, url, body)
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	rr := httptest.NewRecorder()
-	c := echo.New().NewContext(req, rr)
+	e := echo.New()
+	e.Logger.SetOutput(io.Discard)
+	e.HTTPErrorHandler = problem.ErrorHandler
+	c := e.NewContext(req, rr)
+	// Authenticate as an admin key, as the integration tests do. Tests of
+	// other callers override it with middleware.SetSubject and SetScopes.
+	middleware.SetScopes(c, []string{middleware.ScopeAdmin})
 	return &Response{Context: c, Recorder: rr}
 }
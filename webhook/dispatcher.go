@@ -0,0 +1,234 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxBackoff caps the delay between two attempts of a delivery.
+const maxBackoff = 6 * time.Hour
+
+// batchSize is how many outbox events or deliveries one tick handles.
+const batchSize = 50
+
+// Dispatcher fans outbox events out to the subscribed webhooks and delivers
+// them. Several instances may run side by side: rows are claimed with SKIP
+// LOCKED, and a claimed delivery is leased for twice Timeout so a crashed
+// instance's deliveries are retried by another. The lease is renewed right
+// before each send, so the deliveries at the end of a batch aren't taken over
+// while the ones before them are sent.
+type Dispatcher struct {
+	DB           *sql.DB
+	Client       *http.Client
+	Logger       echo.Logger
+	PollInterval time.Duration
+	Timeout      time.Duration
+	MaxAttempts  int
+	// Backoff is the delay before the first retry, doubled for each further
+	// retry up to maxBackoff.
+	Backoff time.Duration
+
+	now func() time.Time
+}
+
+// Run dispatches every PollInterval until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	t := time.NewTicker(d.PollInterval)
+	defer t.Stop()
+	for {
+		if err := d.Tick(ctx); err != nil && ctx.Err() == nil {
+			d.Logger.Error("webhook dispatcher: ", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Tick fans out pending outbox events, then sends the deliveries that are due.
+func (d *Dispatcher) Tick(ctx context.Context) error {
+	if err := d.fanOut(ctx); err != nil {
+		return err
+	}
+	return d.deliver(ctx)
+}
+
+// fanOut turns each outbox event into one delivery per subscribed webhook,
+// in a single statement so an event is never lost or fanned out twice.
+func (d *Dispatcher) fanOut(ctx context.Context) error {
+	_, err := d.DB.ExecContext(ctx, `WITH batch AS (
+		DELETE FROM webhook_outbox WHERE id IN (SELECT id FROM webhook_outbox ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED)
+		RETURNING id, event, payload
+	)
+	INSERT INTO webhook_deliveries (webhook_id, event, payload)
+	SELECT w.id, b.event, b.payload FROM batch b
+	JOIN webhooks w ON cardinality(w.events) = 0 OR b.event = ANY (w.events)
+	ORDER BY b.id`, batchSize)
+	if err != nil {
+		return fmt.Errorf("can't fan out webhook events: %w", err)
+	}
+	return nil
+}
+
+type claimed struct {
+	id       int64
+	event    string
+	payload  []byte
+	attempts int
+	url      string
+	secret   string
+	// leased is when the lease ends, which only this claim knows.
+	leased time.Time
+}
+
+func (d *Dispatcher) deliver(ctx context.Context) error {
+	rows, err := d.DB.QueryContext(ctx, `WITH claimed AS (
+		UPDATE webhook_deliveries SET next_attempt_at = now() + make_interval(secs => $1)
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries WHERE state = 'pending' AND next_attempt_at <= now()
+			ORDER BY next_attempt_at LIMIT $2 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, webhook_id, event, payload, attempts, next_attempt_at
+	)
+	SELECT c.id, c.event, c.payload, c.attempts, w.url, w.secret, c.next_attempt_at FROM claimed c JOIN webhooks w ON w.id = c.webhook_id`, d.lease(), batchSize)
+	if err != nil {
+		return fmt.Errorf("can't claim webhook deliveries: %w", err)
+	}
+	var batch []claimed
+	for rows.Next() {
+		var c claimed
+		if err := rows.Scan(&c.id, &c.event, &c.payload, &c.attempts, &c.url, &c.secret, &c.leased); err != nil {
+			rows.Close()
+			return fmt.Errorf("can't scan webhook deliveries: %w", err)
+		}
+		batch = append(batch, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("can't read webhook deliveries: %w", err)
+	}
+
+	for i, c := range batch {
+		if i > 0 {
+			ok, err := d.renew(ctx, &c)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+		}
+		status, err := d.send(ctx, c)
+		if ctx.Err() != nil {
+			// Shutting down: the lease expires and the delivery is retried.
+			return nil
+		}
+		if err := d.finish(ctx, c, status, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lease is how long a claimed delivery is held, in seconds.
+func (d *Dispatcher) lease() float64 {
+	return (2 * d.Timeout).Seconds()
+}
+
+// renew extends the lease of c if it still holds it; false means the lease
+// ran out and another instance may have claimed the delivery since.
+func (d *Dispatcher) renew(ctx context.Context, c *claimed) (bool, error) {
+	err := d.DB.QueryRowContext(ctx,
+		"UPDATE webhook_deliveries SET next_attempt_at = now() + make_interval(secs => $3) WHERE id = $1 AND state = 'pending' AND next_attempt_at = $2 RETURNING next_attempt_at",
+		c.id, c.leased, d.lease()).Scan(&c.leased)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("can't renew the lease of webhook delivery %d: %w", c.id, err)
+	}
+	return true, nil
+}
+
+// send posts the delivery; any status outside 2xx is a failure.
+func (d *Dispatcher) send(ctx context.Context, c claimed) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(c.payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(HeaderID, fmt.Sprint(c.id))
+	req.Header.Set(HeaderEvent, c.event)
+	req.Header.Set(HeaderSignature, Sign(c.secret, d.clock(), c.payload))
+
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("receiver responded %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+// finish records the attempt: delivered, retried after a backoff, or dead
+// once MaxAttempts is reached.
+func (d *Dispatcher) finish(ctx context.Context, c claimed, status int, sendErr error) error {
+	attempts := c.attempts + 1
+	var lastStatus *int
+	if status != 0 {
+		lastStatus = &status
+	}
+	var err error
+	switch {
+	case sendErr == nil:
+		_, err = d.DB.ExecContext(ctx,
+			"UPDATE webhook_deliveries SET state = 'delivered', attempts = $2, last_status = $3, last_error = '', delivered_at = now() WHERE id = $1",
+			c.id, attempts, lastStatus)
+	case attempts >= d.MaxAttempts:
+		_, err = d.DB.ExecContext(ctx,
+			"UPDATE webhook_deliveries SET state = 'dead', attempts = $2, last_status = $3, last_error = $4 WHERE id = $1",
+			c.id, attempts, lastStatus, sendErr.Error())
+	default:
+		_, err = d.DB.ExecContext(ctx,
+			"UPDATE webhook_deliveries SET attempts = $2, last_status = $3, last_error = $4, next_attempt_at = $5 WHERE id = $1",
+			c.id, attempts, lastStatus, sendErr.Error(), d.clock().Add(d.backoff(attempts)))
+	}
+	if err != nil {
+		return fmt.Errorf("can't record webhook delivery %d: %w", c.id, err)
+	}
+	return nil
+}
+
+// backoff is the delay after the given number of failed attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.Backoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
+
+func (d *Dispatcher) clock() time.Time {
+	if d.now != nil {
+		return d.now()
+	}
+	return time.Now()
+}
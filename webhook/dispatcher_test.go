@@ -0,0 +1,119 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatcher(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	payload := `{"event":"expense.created","data":{}}`
+	type delivery struct {
+		header http.Header
+		body   string
+	}
+	setup := func(status int) (*Dispatcher, sqlmock.Sqlmock, *delivery) {
+		received := &delivery{}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			received.header, received.body = r.Header, string(body)
+			w.WriteHeader(status)
+		}))
+		t.Cleanup(srv.Close)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectExec("WITH batch AS \\(\\s+DELETE FROM webhook_outbox").WithArgs(batchSize).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("WITH claimed AS \\(\\s+UPDATE webhook_deliveries").WithArgs(20.0, batchSize).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "event", "payload", "attempts", "url", "secret", "next_attempt_at"}).
+				AddRow(5, ExpenseCreated, payload, 2, srv.URL, "whsec_test", now.Add(20*time.Second)))
+		d := &Dispatcher{DB: db, Client: srv.Client(), Timeout: 10 * time.Second, MaxAttempts: 3, Backoff: time.Minute, now: func() time.Time { return now }}
+		return d, mock, received
+	}
+
+	t.Run("should deliver signed events", func(t *testing.T) {
+		d, mock, received := setup(http.StatusNoContent)
+		mock.ExpectExec("UPDATE webhook_deliveries SET state = 'delivered'").WithArgs(int64(5), 3, http.StatusNoContent).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err := d.Tick(context.Background())
+
+		assert.NoError(t, err)
+		assert.Equal(t, "5", received.header.Get(HeaderID))
+		assert.Equal(t, ExpenseCreated, received.header.Get(HeaderEvent))
+		assert.Equal(t, echo.MIMEApplicationJSON, received.header.Get(echo.HeaderContentType))
+		assert.Equal(t, Sign("whsec_test", now, []byte(payload)), received.header.Get(HeaderSignature))
+		assert.Equal(t, payload, received.body)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should dead-letter after the last attempt", func(t *testing.T) {
+		d, mock, _ := setup(http.StatusInternalServerError)
+		mock.ExpectExec("UPDATE webhook_deliveries SET state = 'dead'").
+			WithArgs(int64(5), 3, http.StatusInternalServerError, "receiver responded 500 Internal Server Error").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err := d.Tick(context.Background())
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should schedule a retry with backoff", func(t *testing.T) {
+		d, mock, _ := setup(http.StatusBadGateway)
+		d.MaxAttempts = 8
+		mock.ExpectExec("UPDATE webhook_deliveries SET attempts = \\$2").
+			WithArgs(int64(5), 3, http.StatusBadGateway, sqlmock.AnyArg(), now.Add(4*time.Minute)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err := d.Tick(context.Background())
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should renew the lease before each send and skip a delivery whose lease ran out", func(t *testing.T) {
+		var sent []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sent = append(sent, r.Header.Get(HeaderID))
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer srv.Close()
+		db, mock, _ := sqlmock.New()
+		leased := now.Add(20 * time.Second)
+		mock.ExpectExec("WITH batch AS").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("WITH claimed AS").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "event", "payload", "attempts", "url", "secret", "next_attempt_at"}).
+				AddRow(5, ExpenseCreated, payload, 0, srv.URL, "whsec_test", leased).
+				AddRow(6, ExpenseCreated, payload, 0, srv.URL, "whsec_test", leased).
+				AddRow(7, ExpenseCreated, payload, 0, srv.URL, "whsec_test", leased))
+		mock.ExpectExec("SET state = 'delivered'").WithArgs(int64(5), 1, http.StatusNoContent).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("UPDATE webhook_deliveries SET next_attempt_at = now\\(\\) \\+ make_interval\\(secs => \\$3\\) WHERE id = \\$1 AND state = 'pending' AND next_attempt_at = \\$2").
+			WithArgs(int64(6), leased, 20.0).
+			WillReturnRows(sqlmock.NewRows([]string{"next_attempt_at"}).AddRow(now.Add(30 * time.Second)))
+		mock.ExpectExec("SET state = 'delivered'").WithArgs(int64(6), 1, http.StatusNoContent).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("UPDATE webhook_deliveries SET next_attempt_at").WithArgs(int64(7), leased, 20.0).
+			WillReturnRows(sqlmock.NewRows([]string{"next_attempt_at"}))
+		d := &Dispatcher{DB: db, Client: srv.Client(), Timeout: 10 * time.Second, MaxAttempts: 3, Backoff: time.Minute, now: func() time.Time { return now }}
+
+		err := d.Tick(context.Background())
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"5", "6"}, sent)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	d := Dispatcher{Backoff: 30 * time.Second}
+
+	assert.Equal(t, 30*time.Second, d.backoff(1))
+	assert.Equal(t, 2*time.Minute, d.backoff(3))
+	assert.Equal(t, maxBackoff, d.backoff(30))
+}
@@ -0,0 +1,237 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/problem"
+)
+
+type Handler struct {
+	DB *sql.DB
+}
+
+// Webhook is a subscription. Events lists the events it receives, all of
+// them when empty.
+type Webhook struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateRequest is the body of POST /webhooks. A secret is generated when
+// none is given.
+type CreateRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+// Created is a new webhook. Secret is shown only in this response.
+type Created struct {
+	Webhook
+	Secret string `json:"secret"`
+}
+
+// Delivery is one event sent, or to be sent, to a webhook.
+type Delivery struct {
+	ID            int64           `json:"id"`
+	Event         string          `json:"event"`
+	Payload       json.RawMessage `json:"payload"`
+	State         string          `json:"state"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	LastStatus    *int            `json:"last_status,omitempty"`
+	LastError     string          `json:"last_error,omitempty"`
+	DeliveredAt   *time.Time      `json:"delivered_at,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+func (r CreateRequest) validate() []problem.FieldError {
+	var errs []problem.FieldError
+	if u, err := url.Parse(r.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, problem.FieldError{Field: "url", Message: "must be an absolute http or https URL"})
+	}
+	for _, e := range r.Events {
+		if !known(e) {
+			errs = append(errs, problem.FieldError{Field: "events", Message: fmt.Sprintf("unknown event %q, use one of %s", e, strings.Join(Events, ", "))})
+			break
+		}
+	}
+	if r.Secret != "" && len(r.Secret) < 16 {
+		errs = append(errs, problem.FieldError{Field: "secret", Message: "must be at least 16 characters"})
+	}
+	return errs
+}
+
+func known(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) CreateHandler(c echo.Context) error {
+	var r CreateRequest
+	if err := c.Bind(&r); err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "request body must be a webhook JSON object")
+	}
+	if errs := r.validate(); errs != nil {
+		return problem.Validation(errs...)
+	}
+	if r.Secret == "" {
+		b := make([]byte, 24)
+		if _, err := rand.Read(b); err != nil {
+			return problem.Internal(fmt.Errorf("can't generate webhook secret: %w", err))
+		}
+		r.Secret = "whsec_" + base64.RawURLEncoding.EncodeToString(b)
+	}
+	if r.Events == nil {
+		r.Events = []string{}
+	}
+
+	w := Created{Webhook: Webhook{URL: r.URL, Events: r.Events}, Secret: r.Secret}
+	err := h.DB.QueryRowContext(c.Request().Context(),
+		"INSERT INTO webhooks (url, secret, events) VALUES ($1, $2, $3) RETURNING id, created_at",
+		r.URL, r.Secret, pq.Array(r.Events),
+	).Scan(&w.ID, &w.CreatedAt)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't insert webhook: %w", err))
+	}
+	return c.JSON(http.StatusCreated, w)
+}
+
+func (h *Handler) ListHandler(c echo.Context) error {
+	rows, err := h.DB.QueryContext(c.Request().Context(), "SELECT id, url, events, created_at FROM webhooks ORDER BY id")
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't query webhooks: %w", err))
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		var w Webhook
+		if err := rows.Scan(&w.ID, &w.URL, pq.Array(&w.Events), &w.CreatedAt); err != nil {
+			return problem.Internal(fmt.Errorf("can't scan webhooks: %w", err))
+		}
+		webhooks = append(webhooks, w)
+	}
+	if err := rows.Err(); err != nil {
+		return problem.Internal(fmt.Errorf("can't read webhooks: %w", err))
+	}
+	return c.JSON(http.StatusOK, webhooks)
+}
+
+func (h *Handler) DeleteHandler(c echo.Context) error {
+	id, err := webhookID(c)
+	if err != nil {
+		return err
+	}
+	result, err := h.DB.ExecContext(c.Request().Context(), "DELETE FROM webhooks WHERE id = $1", id)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't delete webhook: %w", err))
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't read deleted rows: %w", err))
+	}
+	if n == 0 {
+		return webhookNotFound(id)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// DeliveriesHandler is the delivery log of a webhook, newest first. The state
+// query parameter filters it, e.g. state=dead for the dead letters.
+func (h *Handler) DeliveriesHandler(c echo.Context) error {
+	id, err := webhookID(c)
+	if err != nil {
+		return err
+	}
+	state := c.QueryParam("state")
+	if state != "" && state != StatePending && state != StateDelivered && state != StateDead {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, "state must be pending, delivered or dead")
+	}
+	ctx := c.Request().Context()
+	var exists bool
+	if err := h.DB.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM webhooks WHERE id = $1)", id).Scan(&exists); err != nil {
+		return problem.Internal(fmt.Errorf("can't query webhook: %w", err))
+	}
+	if !exists {
+		return webhookNotFound(id)
+	}
+
+	rows, err := h.DB.QueryContext(ctx,
+		`SELECT id, event, payload, state, attempts, next_attempt_at, last_status, last_error, delivered_at, created_at
+		FROM webhook_deliveries WHERE webhook_id = $1 AND ($2 = '' OR state = $2) ORDER BY id DESC LIMIT 100`, id, state)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't query deliveries: %w", err))
+	}
+	defer rows.Close()
+
+	deliveries := []Delivery{}
+	for rows.Next() {
+		var d Delivery
+		var payload []byte
+		if err := rows.Scan(&d.ID, &d.Event, &payload, &d.State, &d.Attempts, &d.NextAttemptAt, &d.LastStatus, &d.LastError, &d.DeliveredAt, &d.CreatedAt); err != nil {
+			return problem.Internal(fmt.Errorf("can't scan deliveries: %w", err))
+		}
+		d.Payload = payload
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return problem.Internal(fmt.Errorf("can't read deliveries: %w", err))
+	}
+	return c.JSON(http.StatusOK, deliveries)
+}
+
+// RetryHandler sends a delivery again from its first attempt, typically a
+// dead letter once the receiver is fixed.
+func (h *Handler) RetryHandler(c echo.Context) error {
+	id, err := webhookID(c)
+	if err != nil {
+		return err
+	}
+	did, err := strconv.ParseInt(c.Param("delivery_id"), 10, 64)
+	if err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidID, "delivery id must be an integer")
+	}
+	result, err := h.DB.ExecContext(c.Request().Context(),
+		"UPDATE webhook_deliveries SET state = 'pending', attempts = 0, next_attempt_at = now() WHERE id = $1 AND webhook_id = $2", did, id)
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't retry delivery: %w", err))
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return problem.Internal(fmt.Errorf("can't read updated rows: %w", err))
+	}
+	if n == 0 {
+		return problem.New(http.StatusNotFound, problem.CodeDeliveryNotFound, fmt.Sprintf("delivery %d does not exist on webhook %d", did, id))
+	}
+	return c.NoContent(http.StatusAccepted)
+}
+
+func webhookID(c echo.Context) (int, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return 0, problem.New(http.StatusBadRequest, problem.CodeInvalidID, "webhook id must be an integer")
+	}
+	return id, nil
+}
+
+func webhookNotFound(id int) error {
+	return problem.New(http.StatusNotFound, problem.CodeWebhookNotFound, fmt.Sprintf("webhook %d does not exist", id))
+}
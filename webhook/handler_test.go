@@ -0,0 +1,117 @@
+package webhook
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/panudetjt/assessment/problem"
+	"github.com/panudetjt/assessment/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func request(method, url, body string, params ...string) *util.Response {
+	res := util.RequestE(method, url, strings.NewReader(body))
+	res.Context.SetParamNames("id", "delivery_id")
+	res.Context.SetParamValues(params...)
+	return res
+}
+
+func TestCreateHandler(t *testing.T) {
+	t.Run("should return 201 (Created) with a generated secret", func(t *testing.T) {
+		res := request(http.MethodPost, "/webhooks", `{"url": "https://example.com/hook", "events": ["expense.created"]}`)
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery("INSERT INTO webhooks").
+			WithArgs("https://example.com/hook", sqlmock.AnyArg(), pq.Array([]string{ExpenseCreated})).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+		h := Handler{DB: db}
+
+		res.Serve(h.CreateHandler)
+		var got Created
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusCreated, res.Recorder.Code)
+		assert.Equal(t, 1, got.ID)
+		assert.True(t, strings.HasPrefix(got.Secret, "whsec_"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 422 (UnprocessableEntity) when fields are invalid", func(t *testing.T) {
+		res := request(http.MethodPost, "/webhooks", `{"url": "ftp://example.com", "events": ["expense.exploded"], "secret": "short"}`)
+		db, mock, _ := sqlmock.New()
+		h := Handler{DB: db}
+
+		res.Serve(h.CreateHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, res.Recorder.Code)
+		assert.Len(t, p.Errors, 3)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDeliveriesHandler(t *testing.T) {
+	t.Run("should list the delivery log", func(t *testing.T) {
+		res := request(http.MethodGet, "/webhooks/1/deliveries?state=dead", "", "1")
+		db, mock, _ := sqlmock.New()
+		now := time.Now()
+		mock.ExpectQuery("SELECT EXISTS").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery("SELECT (.+) FROM webhook_deliveries WHERE webhook_id = \\$1").WithArgs(1, StateDead).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "event", "payload", "state", "attempts", "next_attempt_at", "last_status", "last_error", "delivered_at", "created_at"}).
+				AddRow(5, ExpenseCreated, `{"event": "expense.created"}`, StateDead, 8, now, 500, "receiver responded 500", nil, now))
+		h := Handler{DB: db}
+
+		res.Serve(h.DeliveriesHandler)
+		var got []Delivery
+		res.Decode(&got)
+
+		assert.Equal(t, http.StatusOK, res.Recorder.Code)
+		assert.Len(t, got, 1)
+		assert.Equal(t, 500, *got[0].LastStatus)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 404 (NotFound) for an unknown webhook", func(t *testing.T) {
+		res := request(http.MethodGet, "/webhooks/9/deliveries", "", "9")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery("SELECT EXISTS").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		h := Handler{DB: db}
+
+		res.Serve(h.DeliveriesHandler)
+		var p problem.Problem
+		res.Decode(&p)
+
+		assert.Equal(t, http.StatusNotFound, res.Recorder.Code)
+		assert.Equal(t, problem.CodeWebhookNotFound, p.Code)
+	})
+}
+
+func TestRetryHandler(t *testing.T) {
+	t.Run("should reset the delivery to pending", func(t *testing.T) {
+		res := request(http.MethodPost, "/webhooks/1/deliveries/5/retry", "", "1", "5")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectExec("UPDATE webhook_deliveries SET state = 'pending'").WithArgs(int64(5), 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		h := Handler{DB: db}
+
+		res.Serve(h.RetryHandler)
+
+		assert.Equal(t, http.StatusAccepted, res.Recorder.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return 404 (NotFound) for another webhook's delivery", func(t *testing.T) {
+		res := request(http.MethodPost, "/webhooks/2/deliveries/5/retry", "", "2", "5")
+		db, mock, _ := sqlmock.New()
+		mock.ExpectExec("UPDATE webhook_deliveries").WillReturnResult(sqlmock.NewResult(0, 0))
+		h := Handler{DB: db}
+
+		res.Serve(h.RetryHandler)
+
+		assert.Equal(t, http.StatusNotFound, res.Recorder.Code)
+	})
+}
@@ -0,0 +1,72 @@
+// Package webhook notifies subscribed URLs of expense changes. Changes enqueue
+// events into an outbox in their own transaction, and a Dispatcher delivers
+// them with signed, retried requests.
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Events a webhook can subscribe to.
+const (
+	ExpenseCreated = "expense.created"
+	ExpenseUpdated = "expense.updated"
+	ExpenseDeleted = "expense.deleted"
+)
+
+var Events = []string{ExpenseCreated, ExpenseUpdated, ExpenseDeleted}
+
+// Headers of every delivery. Receivers should ignore a delivery id they have
+// already processed, since a delivery can arrive more than once.
+const (
+	HeaderID        = "Webhook-Id"
+	HeaderEvent     = "Webhook-Event"
+	HeaderSignature = "Webhook-Signature"
+)
+
+// Delivery states. A delivery is dead once it has failed MaxAttempts times.
+const (
+	StatePending   = "pending"
+	StateDelivered = "delivered"
+	StateDead      = "dead"
+)
+
+// Payload is the JSON body of a delivery.
+type Payload struct {
+	Event      string    `json:"event"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Data       any       `json:"data"`
+}
+
+// Enqueue writes event to the outbox within tx, so it is delivered if and only
+// if tx commits. Events no webhook subscribes to are not written, so the outbox
+// stays empty until a webhook is created.
+func Enqueue(ctx context.Context, tx *sql.Tx, event string, data any) error {
+	b, err := json.Marshal(Payload{Event: event, OccurredAt: time.Now().UTC(), Data: data})
+	if err != nil {
+		return fmt.Errorf("can't encode webhook payload: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO webhook_outbox (event, payload) SELECT $1, $2 WHERE EXISTS (SELECT 1 FROM webhooks WHERE cardinality(events) = 0 OR $1 = ANY (events))", event, b); err != nil {
+		return fmt.Errorf("can't enqueue webhook event: %w", err)
+	}
+	return nil
+}
+
+// Sign returns the Webhook-Signature header for body sent at t:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">". Receivers recompute
+// it with their secret and reject old timestamps to stop replays.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
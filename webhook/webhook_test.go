@@ -0,0 +1,16 @@
+package webhook
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSign(t *testing.T) {
+	t.Run("should sign the timestamp and body", func(t *testing.T) {
+		got := Sign("whsec_test", time.Unix(1700000000, 0), []byte(`{"event":"expense.created"}`))
+
+		assert.Equal(t, "t=1700000000,v1=6d0b7363b062cb4a33f5ea3b2fac0679b1d965b20eecc781380b406d26035eef", got)
+	})
+}